@@ -28,13 +28,20 @@ import (
 
 // accountsCreateCmd represents the "accounts create" command
 var accountsCreateCmd = &cobra.Command{
-	Use:               "create <stack_name>",
-	Short:             "Create a new account in the FireFly stack",
-	Long:              `Create a new account in the FireFly stack`,
+	Use:   "create <stack_name>",
+	Short: "Create a new account in the FireFly stack",
+	Long: `Create a new account in the FireFly stack
+
+For Ethereum stacks, an existing private key can be imported instead of
+generating a new one by passing privateKey=<hex_private_key>, and the new
+account can be added to the signing keys of a member by passing member=<member_id>.
+For demos, addressPrefix=<hex_prefix> generates a key whose address starts with
+the given prefix of up to 4 hex digits, such as addressPrefix=0xaaa`,
 	Args:              cobra.MinimumNArgs(1),
 	ValidArgsFunction: listStacks,
 	PreRunE: func(cmd *cobra.Command, args []string) error {
 		ctx := log.WithVerbosity(context.Background(), verbose)
+		ctx = log.WithDockerVerbosity(ctx, verboseDocker)
 		ctx = log.WithLogger(ctx, logger)
 
 		version, err := docker.CheckDockerConfig()
@@ -37,6 +37,7 @@ var accountsListCmd = &cobra.Command{
 	Aliases:           []string{"ls"},
 	PreRunE: func(cmd *cobra.Command, args []string) error {
 		ctx := log.WithVerbosity(context.Background(), verbose)
+		ctx = log.WithDockerVerbosity(ctx, verboseDocker)
 		ctx = log.WithLogger(ctx, logger)
 
 		version, err := docker.CheckDockerConfig()
@@ -0,0 +1,97 @@
+// Copyright © 2024 Kaleido, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/hyperledger/firefly-cli/internal/docker"
+	"github.com/hyperledger/firefly-cli/internal/log"
+	"github.com/hyperledger/firefly-cli/internal/stacks"
+	"github.com/spf13/cobra"
+)
+
+// bundleCmd represents the "bundle" command
+var bundleCmd = &cobra.Command{
+	Use:   "bundle",
+	Short: "Move the images of a FireFly stack to an air-gapped host",
+	Long: `Move the images of a FireFly stack to an air-gapped host
+
+Export the images a stack needs to a tar archive on a host with access to a
+registry, copy the archive across, and import it on the air-gapped host before
+starting the stack there.`,
+}
+
+func bundlePreRun(cmd *cobra.Command, args []string) error {
+	ctx := log.WithVerbosity(context.Background(), verbose)
+	ctx = log.WithDockerVerbosity(ctx, verboseDocker)
+	ctx = log.WithLogger(ctx, logger)
+
+	version, err := docker.CheckDockerConfig()
+	ctx = context.WithValue(ctx, docker.CtxComposeVersionKey{}, version)
+	cmd.SetContext(ctx)
+	return err
+}
+
+// bundleExportCmd represents the "bundle export" command
+var bundleExportCmd = &cobra.Command{
+	Use:               "export <stack_name> <file>",
+	Short:             "Save every image a stack needs to a tar archive",
+	Long:              `Save every image a stack needs to a tar archive, pulling any that are not local`,
+	Args:              cobra.ExactArgs(2),
+	ValidArgsFunction: listStacks,
+	PreRunE:           bundlePreRun,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		stackManager := stacks.NewStackManager(cmd.Context())
+		if err := stackManager.LoadStack(args[0]); err != nil {
+			return err
+		}
+		if err := stackManager.ExportImages(args[1]); err != nil {
+			return err
+		}
+		fmt.Printf("Images of stack '%s' saved to '%s'\n", args[0], args[1])
+		return nil
+	},
+}
+
+// bundleImportCmd represents the "bundle import" command
+var bundleImportCmd = &cobra.Command{
+	Use:               "import <stack_name> <file>",
+	Short:             "Load the images of a stack from a tar archive",
+	Long:              `Load the images of a stack from a tar archive written by bundle export, and check that none are missing`,
+	Args:              cobra.ExactArgs(2),
+	ValidArgsFunction: listStacks,
+	PreRunE:           bundlePreRun,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		stackManager := stacks.NewStackManager(cmd.Context())
+		if err := stackManager.LoadStack(args[0]); err != nil {
+			return err
+		}
+		if err := stackManager.ImportImages(args[1]); err != nil {
+			return err
+		}
+		fmt.Printf("Images of stack '%s' loaded from '%s'\n", args[0], args[1])
+		return nil
+	},
+}
+
+func init() {
+	bundleCmd.AddCommand(bundleExportCmd)
+	bundleCmd.AddCommand(bundleImportCmd)
+	rootCmd.AddCommand(bundleCmd)
+}
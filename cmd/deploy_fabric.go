@@ -35,6 +35,7 @@ var deployFabricCmd = &cobra.Command{
 	Args:              cobra.ExactArgs(5),
 	PreRunE: func(cmd *cobra.Command, args []string) error {
 		ctx := log.WithVerbosity(context.Background(), verbose)
+		ctx = log.WithDockerVerbosity(ctx, verboseDocker)
 		ctx = log.WithLogger(ctx, logger)
 
 		version, err := docker.CheckDockerConfig()
@@ -0,0 +1,70 @@
+// Copyright © 2024 Kaleido, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/hyperledger/firefly-cli/internal/docker"
+	"github.com/hyperledger/firefly-cli/internal/log"
+	"github.com/spf13/cobra"
+)
+
+var doctorCmd = &cobra.Command{
+	Use:   "doctor",
+	Short: "Check that this machine is ready to run a stack",
+	Long: `Check that this machine is ready to run a stack
+
+Checks that docker is installed and its daemon is reachable, that docker compose
+is installed, and that there is enough disk space for images and the default
+ports are free, and says how to fix anything that is not.`,
+	Args: cobra.NoArgs,
+	// A failed check is reported with how to fix it, which the usage would only bury
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx := log.WithVerbosity(context.Background(), verbose)
+		ctx = log.WithDockerVerbosity(ctx, verboseDocker)
+		ctx = log.WithLogger(ctx, logger)
+
+		report, err := docker.Doctor(ctx)
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(w, "STATUS\tCHECK\tDETAIL")
+		for _, check := range report.Checks {
+			fmt.Fprintf(w, "%s\t%s\t%s\n", check.Status, check.Name, check.Detail)
+		}
+		w.Flush()
+		for _, check := range report.Checks {
+			if check.Remediation != "" {
+				fmt.Printf("\n%s: %s\n", check.Name, check.Remediation)
+			}
+		}
+		if failed := report.Failed(); len(failed) > 0 {
+			return fmt.Errorf("%d of %d checks failed", len(failed), len(report.Checks))
+		}
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(doctorCmd)
+}
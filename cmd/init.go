@@ -29,6 +29,9 @@ import (
 
 	"github.com/spf13/cobra"
 
+	"github.com/hyperledger/firefly-cli/internal/blockchain/ethereum/ethsigner"
+	"github.com/hyperledger/firefly-cli/internal/blockchain/ethereum/geth"
+	"github.com/hyperledger/firefly-cli/internal/docker"
 	"github.com/hyperledger/firefly-cli/internal/log"
 	"github.com/hyperledger/firefly-cli/internal/stacks"
 	"github.com/hyperledger/firefly-cli/pkg/types"
@@ -49,6 +52,7 @@ var initCmd = &cobra.Command{
 	Args:  cobra.MaximumNArgs(2),
 	RunE: func(cmd *cobra.Command, args []string) error {
 		ctx := log.WithVerbosity(context.Background(), verbose)
+		ctx = log.WithDockerVerbosity(ctx, verboseDocker)
 		ctx = log.WithLogger(ctx, logger)
 		stackManager := stacks.NewStackManager(ctx)
 		if err := initCommon(args); err != nil {
@@ -67,6 +71,10 @@ var initCmd = &cobra.Command{
 }
 
 func initCommon(args []string) error {
+	// Fail before prompting for anything if the stack could never be run
+	if err := docker.CheckDockerAvailable(context.Background()); err != nil {
+		return err
+	}
 	if err := validateDatabaseProvider(initOptions.DatabaseProvider); err != nil {
 		return err
 	}
@@ -82,6 +90,12 @@ func initCommon(args []string) error {
 	if err := validateIPFSMode(initOptions.IPFSMode); err != nil {
 		return err
 	}
+	if err := validateSignerType(initOptions.SignerType); err != nil {
+		return err
+	}
+	if err := validateHealthCheck(); err != nil {
+		return err
+	}
 
 	fmt.Println("initializing new FireFly stack...")
 
@@ -235,6 +249,26 @@ func validateIPFSMode(input string) error {
 	return err
 }
 
+func validateSignerType(input string) error {
+	_, err := fftypes.FFEnumParseString(context.Background(), types.SignerType, input)
+	return err
+}
+
+func validateHealthCheck() error {
+	if initOptions.HealthCheckRetries == 0 {
+		return errors.New("health check retries must be greater than zero")
+	}
+	healthCheck := &types.HealthCheckConfig{
+		Interval:    initOptions.HealthCheckInterval,
+		Timeout:     initOptions.HealthCheckTimeout,
+		StartPeriod: initOptions.HealthCheckStartPeriod,
+	}
+	if initOptions.HealthCheckRetries > 0 {
+		healthCheck.Retries = initOptions.HealthCheckRetries
+	}
+	return healthCheck.Validate()
+}
+
 func randomHexString(length int) (string, error) {
 	bytes := make([]byte, length)
 	if _, err := rand.Read(bytes); err != nil {
@@ -244,8 +278,8 @@ func randomHexString(length int) (string, error) {
 }
 
 func init() {
-	initCmd.PersistentFlags().IntVarP(&initOptions.FireFlyBasePort, "firefly-base-port", "p", 5000, "Mapped port base of FireFly core API (1 added for each member)")
-	initCmd.PersistentFlags().IntVarP(&initOptions.ServicesBasePort, "services-base-port", "s", 5100, "Mapped port base of services (100 added for each member)")
+	initCmd.PersistentFlags().IntVarP(&initOptions.FireFlyBasePort, "firefly-base-port", "p", 5000, "Mapped port base of FireFly core API (1 added for each member, and ports already in use are skipped)")
+	initCmd.PersistentFlags().IntVarP(&initOptions.ServicesBasePort, "services-base-port", "s", 5100, "Mapped port base of services (100 added for each member, and ports already in use are skipped)")
 	initCmd.PersistentFlags().StringVarP(&initOptions.DatabaseProvider, "database", "d", "sqlite3", fmt.Sprintf("Database type to use. Options are: %v", fftypes.FFEnumValues(types.DatabaseSelection)))
 	initCmd.Flags().StringVarP(&initOptions.BlockchainConnector, "blockchain-connector", "c", "evmconnect", fmt.Sprintf("Blockchain connector to use. Options are: %v", fftypes.FFEnumValues(types.BlockchainConnector)))
 	initCmd.Flags().StringVarP(&initOptions.BlockchainProvider, "blockchain-provider", "b", "ethereum", fmt.Sprintf("Blockchain to use. Options are: %v", fftypes.FFEnumValues(types.BlockchainProvider)))
@@ -263,7 +297,50 @@ func init() {
 	initCmd.Flags().IntVar(&initOptions.BlockPeriod, "block-period", -1, "Block period in seconds. Default is variable based on selected blockchain provider.")
 	initCmd.Flags().StringVar(&initOptions.ContractAddress, "contract-address", "", "Do not automatically deploy a contract, instead use a pre-configured address")
 	initCmd.Flags().StringVar(&initOptions.RemoteNodeURL, "remote-node-url", "", "For cases where the node is pre-existing and running remotely")
+	initCmd.Flags().BoolVar(&initOptions.SkipRemoteNodeCheck, "skip-remote-node-check", false, "Do not check that the remote node answers and is on the chain of the stack (Ethereum only). The check is always skipped with --offline")
 	initCmd.Flags().Int64Var(&initOptions.ChainID, "chain-id", 2021, "The chain ID (Ethereum only) - also used as the network ID")
+	initCmd.Flags().StringVar(&initOptions.SignerType, "signer-type", "firefly-signer", fmt.Sprintf("The signer to run in front of the blockchain node (Ethereum only). Options are: %v", fftypes.FFEnumValues(types.SignerType)))
+	initCmd.Flags().StringVar(&initOptions.SignerImage, "signer-image", "", "Override the signer image (Ethereum only) with a specific tag or digest, e.g. ghcr.io/hyperledger/firefly-signer@sha256:<digest>")
+	initCmd.Flags().StringVar(&initOptions.GethImage, "geth-image", "", "Override the geth image (Ethereum only) with a specific tag or digest")
+	initCmd.Flags().StringVar(&initOptions.PrefundBalance, "prefund-balance", "", "The balance in wei given to each account in the geth genesis block (Ethereum only). Default is a large dev balance")
+	initCmd.Flags().Uint64Var(&initOptions.GasLimit, "gas-limit", 0, fmt.Sprintf("The gas limit of the geth genesis block, and of the blocks geth mines (Ethereum only). Default is %d", geth.DefaultGasLimit))
+	initCmd.Flags().StringVar(&initOptions.SignerBindAddress, "signer-bind-address", ethsigner.DefaultBindAddress, "The host interface the signer (Ethereum only) port is published on. Use 0.0.0.0 to make the signer reachable from other machines")
+	initCmd.Flags().StringVar(&initOptions.SignerKeystoreDirectory, "signer-keystore-dir", "", "The absolute path of the keystore inside the signer container (Ethereum only), for custom signer images. Must be below a directory other than the root. Default is /data/keystore")
+	initCmd.Flags().StringVar(&initOptions.SignerLogLevel, "signer-log-level", "", fmt.Sprintf("The level the signer (Ethereum only) logs at. Options are: %v. Default is info", types.SignerLogLevels))
+	initCmd.Flags().StringVar(&initOptions.SignerLogFormat, "signer-log-format", "", fmt.Sprintf("The format of the logs of firefly-signer (Ethereum only). Options are: %v. Default is text", types.SignerLogFormats))
+	initCmd.Flags().StringVar(&initOptions.SignerEntrypoint, "signer-entrypoint", "", "Override the entrypoint of the signer container (Ethereum only), as a space separated command")
+	initCmd.Flags().StringArrayVar(&initOptions.SignerExtraArgs, "signer-arg", []string{}, "An extra argument for the signer (Ethereum only), passed after the options the CLI generates and before any subcommand. Can be repeated")
+	initCmd.Flags().StringVar(&initOptions.KeystoreKDF, "keystore-kdf", "", fmt.Sprintf("The cost of the scrypt key derivation the keys of the stack (Ethereum only) are encrypted with. Options are: %v. Default is standard. fast speeds up creating accounts but makes the keystore much easier to brute force, so only use it for throwaway keys, while strong is slower and takes 256MB of memory to decrypt each key", types.KeystoreKDFs))
+	initCmd.Flags().StringVar(&initOptions.KeystorePasswordSource, "keystore-password-source", "", fmt.Sprintf("Where the password the keys of the stack (Ethereum only) are encrypted with comes from. Options are: %v. Default is plaintext, which writes a random password for each key to disk. The other sources use a single password that is passed to the signer as a docker secret, and is never written to disk by the CLI", types.KeystorePasswordSources))
+	initCmd.Flags().StringVar(&initOptions.KeystorePasswordEnv, "keystore-password-env", "", fmt.Sprintf("The environment variable that holds the keystore password, with the env and prompt keystore password sources. Default is %s", types.DefaultKeystorePasswordEnv))
+	initCmd.Flags().StringVar(&initOptions.KeystorePasswordFile, "keystore-password-file", "", "The file that holds the keystore password, with the file keystore password source")
+	initCmd.Flags().BoolVar(&initOptions.VerifySigner, "verify-signer", false, "Check that the signer (Ethereum only) holds the account of each member and signs a test transaction for the chain ID of the stack, every time the stack starts")
+	initCmd.Flags().StringVar(&initOptions.SharedSigner, "shared-signer", "", "The name of a signer (Ethereum only) to share with the other stacks on this host that use the same name and chain ID, rather than running a signer for each stack. The first stack to use the name runs the signer, so must be started before and removed after the others, and every stack must use the same --external-network to reach it")
+	initCmd.Flags().StringVar(&initOptions.SignerHealthCheck, "signer-healthcheck", "", fmt.Sprintf("How the health check of the signer (Ethereum only) decides that it is ready. Options are: %v. Default is net_version, while accounts waits until the signer holds the key of every member", types.SignerHealthChecks))
+	initCmd.Flags().BoolVar(&initOptions.SignerMetricsEnabled, "signer-metrics", false, "Enable the Prometheus metrics of the Java signer (Ethereum only, with --signer-type java), which are scraped by the Prometheus server of the stack if it is enabled")
+	initCmd.Flags().IntVar(&initOptions.SignerMetricsPort, "signer-metrics-port", 6000, "Port the metrics of the signer (Ethereum only) are published on, when enabled")
+	initCmd.Flags().BoolVar(&initOptions.PrintSignerConfig, "print-signer-config", false, "Print the generated signer config (Ethereum only), with any credentials redacted")
+	initCmd.Flags().StringVar(&initOptions.RPCUsername, "rpc-username", "", "Username for HTTP basic auth from the signer (Ethereum only) to the blockchain JSON/RPC endpoint")
+	initCmd.Flags().StringVar(&initOptions.RPCPassword, "rpc-password", "", "Password for HTTP basic auth from the signer (Ethereum only) to the blockchain JSON/RPC endpoint")
+	initCmd.Flags().StringVar(&initOptions.RPCBearerToken, "rpc-bearer-token", "", "Bearer token the signer (Ethereum only) sends in the Authorization header to the blockchain JSON/RPC endpoint")
+	initCmd.Flags().StringVar(&initOptions.RPCConnectionTimeout, "rpc-connection-timeout", "", "How long the signer (Ethereum only) waits to connect to the blockchain JSON/RPC endpoint, e.g. 30s. Default is the signer's own default")
+	initCmd.Flags().IntVar(&initOptions.RPCRetries, "rpc-retries", 0, "How many times the signer (Ethereum only) retries a failed request to the blockchain JSON/RPC endpoint. Default is no retries")
+	initCmd.Flags().StringVar(&initOptions.RPCCACert, "rpc-ca-cert", "", "Path of a PEM encoded CA bundle the signer (Ethereum only) trusts, in addition to the system roots, when connecting to an https remote node")
+	initCmd.Flags().StringVar(&initOptions.Mnemonic, "mnemonic", "", "A BIP-39 mnemonic to derive the member accounts (Ethereum only) from, using the path m/44'/60'/0'/0/<member index>. Default is a random key for each member")
+	initCmd.Flags().BoolVar(&initOptions.ReuseKeystore, "reuse-keystore", false, "Reuse the member accounts in the signer (Ethereum only) volume left behind by an earlier stack with the same name, rather than generating new ones. A stack without such a volume starts with new accounts")
+	initCmd.Flags().IntSliceVar(&initOptions.NonSigningMembers, "non-signing-member", []int{}, "Index of a member that only queries the chain, and so is not given a signing account in the signer (Ethereum only). Can be repeated, but at least one member must sign")
+	initCmd.Flags().StringVar(&initOptions.RemoteSignerURL, "remote-signer-url", "", "Use an existing signer (Ethereum only) at this JSON/RPC URL rather than deploying ethsigner. Keys are managed by the remote signer")
+	initCmd.Flags().StringArrayVar(&initOptions.RemoteSignerAddresses, "remote-signer-address", []string{}, "Address of a key held by the remote signer to use for each member, in order. Required for every member when using --remote-signer-url")
+	initCmd.Flags().StringVar(&initOptions.GasOracleMode, "gas-oracle-mode", "", fmt.Sprintf("How the blockchain connector (Ethereum only) prices gas. Options are: [%s %s]. Default is a fixed gas price of zero", types.GasOracleModeFixed, types.GasOracleModeConnector))
+	initCmd.Flags().StringVar(&initOptions.MaxFeePerGas, "max-fee-per-gas", "", "Max fee per gas in wei for EIP-1559 transactions. Must be set with --max-priority-fee-per-gas")
+	initCmd.Flags().StringVar(&initOptions.MaxPriorityFeePerGas, "max-priority-fee-per-gas", "", "Max priority fee per gas in wei for EIP-1559 transactions. Must be set with --max-fee-per-gas")
+	initCmd.PersistentFlags().StringVar(&initOptions.ImageMirror, "image-mirror", "", "A registry prefix, such as registry.internal/mirror, to pull every image in the stack from instead of its public registry")
+	initCmd.PersistentFlags().BoolVar(&initOptions.Offline, "offline", false, "Never contact an image registry. Image digests and labels are read from images already loaded into docker, and missing images fail rather than being pulled. Requires --manifest")
+	initCmd.PersistentFlags().StringVar(&initOptions.ExternalNetwork, "external-network", "", "The name of an existing docker network to connect every container in the stack to, as well as the default network of the stack")
+	initCmd.PersistentFlags().StringVar(&initOptions.CPULimit, "cpu-limit", "", "The number of CPUs each container in the stack can use, e.g. 0.5. Default is unlimited, except for the Ethereum node (2) and signer (1)")
+	initCmd.PersistentFlags().StringVar(&initOptions.MemoryLimit, "memory-limit", "", "The memory each container in the stack can use, e.g. 512m. Default is unlimited, except for the Ethereum node (4g) and signer (1g)")
+	initCmd.PersistentFlags().StringArrayVar(&initOptions.Mounts, "mount", []string{}, "Bind mount a host directory or file into a service of the stack, as <service>:<host path>:<container path>[:ro|rw], e.g. ethsigner:./keys:/keys:ro. Can be repeated")
+	initCmd.PersistentFlags().StringVar(&initOptions.RestartPolicy, "restart-policy", "", "The restart policy of each container in the stack. Options are: no, always, unless-stopped, on-failure and on-failure:<max retries>. Default is unless-stopped")
 	initCmd.PersistentFlags().IntVar(&initOptions.RequestTimeout, "request-timeout", 0, "Custom request timeout (in seconds) - useful for registration to public chains")
 	initCmd.PersistentFlags().StringVar(&initOptions.ReleaseChannel, "channel", "stable", fmt.Sprintf("Select the FireFly release channel to use. Options are: %v", fftypes.FFEnumValues(types.ReleaseChannelSelection)))
 	initCmd.PersistentFlags().BoolVar(&initOptions.MultipartyEnabled, "multiparty", true, "Enable or disable multiparty mode")
@@ -271,5 +348,9 @@ func init() {
 	initCmd.PersistentFlags().StringArrayVar(&initOptions.OrgNames, "org-name", []string{}, "Organization name")
 	initCmd.PersistentFlags().StringArrayVar(&initOptions.NodeNames, "node-name", []string{}, "Node name")
 	initCmd.PersistentFlags().BoolVar(&initOptions.RemoteNodeDeploy, "remote-node-deploy", false, "Enable or disable deployment of FireFly contracts on remote nodes")
+	initCmd.PersistentFlags().StringVar(&initOptions.HealthCheckInterval, "healthcheck-interval", "", "Interval between service health checks, e.g. 15s. Default is variable based on the service.")
+	initCmd.PersistentFlags().StringVar(&initOptions.HealthCheckTimeout, "healthcheck-timeout", "", "Timeout for each service health check, e.g. 5s. Default is variable based on the service.")
+	initCmd.PersistentFlags().StringVar(&initOptions.HealthCheckStartPeriod, "healthcheck-start-period", "", "Grace period before failed health checks count towards the retries, e.g. 30s")
+	initCmd.PersistentFlags().IntVar(&initOptions.HealthCheckRetries, "healthcheck-retries", -1, "Number of failed health checks before a service is considered unhealthy. Default is variable based on the service.")
 	rootCmd.AddCommand(initCmd)
 }
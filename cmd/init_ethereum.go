@@ -23,6 +23,8 @@ import (
 
 	"github.com/spf13/cobra"
 
+	"github.com/hyperledger/firefly-cli/internal/blockchain/ethereum/ethsigner"
+	"github.com/hyperledger/firefly-cli/internal/blockchain/ethereum/geth"
 	"github.com/hyperledger/firefly-cli/internal/log"
 	"github.com/hyperledger/firefly-cli/internal/stacks"
 	"github.com/hyperledger/firefly-cli/pkg/types"
@@ -36,6 +38,7 @@ var initEthereumCmd = &cobra.Command{
 	Args:  cobra.MaximumNArgs(2),
 	RunE: func(cmd *cobra.Command, args []string) error {
 		ctx := log.WithVerbosity(context.Background(), verbose)
+		ctx = log.WithDockerVerbosity(ctx, verboseDocker)
 		ctx = log.WithLogger(ctx, logger)
 		stackManager := stacks.NewStackManager(ctx)
 		if err := initCommon(args); err != nil {
@@ -57,7 +60,43 @@ func init() {
 	initEthereumCmd.Flags().IntVar(&initOptions.BlockPeriod, "block-period", -1, "Block period in seconds. Default is variable based on selected blockchain provider.")
 	initEthereumCmd.Flags().StringVar(&initOptions.ContractAddress, "contract-address", "", "Do not automatically deploy a contract, instead use a pre-configured address")
 	initEthereumCmd.Flags().StringVar(&initOptions.RemoteNodeURL, "remote-node-url", "", "For cases where the node is pre-existing and running remotely")
+	initEthereumCmd.Flags().BoolVar(&initOptions.SkipRemoteNodeCheck, "skip-remote-node-check", false, "Do not check that the remote node answers and is on the chain of the stack. The check is always skipped with --offline")
 	initEthereumCmd.Flags().Int64Var(&initOptions.ChainID, "chain-id", 2021, "The chain ID - also used as the network ID")
+	initEthereumCmd.Flags().StringVar(&initOptions.SignerType, "signer-type", "firefly-signer", fmt.Sprintf("The signer to run in front of the blockchain node. Options are: %v", fftypes.FFEnumValues(types.SignerType)))
+	initEthereumCmd.Flags().StringVar(&initOptions.SignerImage, "signer-image", "", "Override the signer image with a specific tag or digest, e.g. ghcr.io/hyperledger/firefly-signer@sha256:<digest>")
+	initEthereumCmd.Flags().StringVar(&initOptions.GethImage, "geth-image", "", "Override the geth image with a specific tag or digest")
+	initEthereumCmd.Flags().StringVar(&initOptions.PrefundBalance, "prefund-balance", "", "The balance in wei given to each account in the geth genesis block. Default is a large dev balance")
+	initEthereumCmd.Flags().Uint64Var(&initOptions.GasLimit, "gas-limit", 0, fmt.Sprintf("The gas limit of the geth genesis block, and of the blocks geth mines. Default is %d", geth.DefaultGasLimit))
+	initEthereumCmd.Flags().StringVar(&initOptions.SignerBindAddress, "signer-bind-address", ethsigner.DefaultBindAddress, "The host interface the signer port is published on. Use 0.0.0.0 to make the signer reachable from other machines")
+	initEthereumCmd.Flags().StringVar(&initOptions.SignerKeystoreDirectory, "signer-keystore-dir", "", "The absolute path of the keystore inside the signer container, for custom signer images. Must be below a directory other than the root. Default is /data/keystore")
+	initEthereumCmd.Flags().StringVar(&initOptions.SignerLogLevel, "signer-log-level", "", fmt.Sprintf("The level the signer logs at. Options are: %v. Default is info", types.SignerLogLevels))
+	initEthereumCmd.Flags().StringVar(&initOptions.SignerLogFormat, "signer-log-format", "", fmt.Sprintf("The format of the logs of firefly-signer. Options are: %v. Default is text", types.SignerLogFormats))
+	initEthereumCmd.Flags().StringVar(&initOptions.SignerEntrypoint, "signer-entrypoint", "", "Override the entrypoint of the signer container, as a space separated command")
+	initEthereumCmd.Flags().StringArrayVar(&initOptions.SignerExtraArgs, "signer-arg", []string{}, "An extra argument for the signer, passed after the options the CLI generates and before any subcommand. Can be repeated")
+	initEthereumCmd.Flags().StringVar(&initOptions.KeystoreKDF, "keystore-kdf", "", fmt.Sprintf("The cost of the scrypt key derivation the keys of the stack are encrypted with. Options are: %v. Default is standard. fast speeds up creating accounts but makes the keystore much easier to brute force, so only use it for throwaway keys, while strong is slower and takes 256MB of memory to decrypt each key", types.KeystoreKDFs))
+	initEthereumCmd.Flags().StringVar(&initOptions.KeystorePasswordSource, "keystore-password-source", "", fmt.Sprintf("Where the password the keys of the stack are encrypted with comes from. Options are: %v. Default is plaintext, which writes a random password for each key to disk. The other sources use a single password that is passed to the signer as a docker secret, and is never written to disk by the CLI", types.KeystorePasswordSources))
+	initEthereumCmd.Flags().StringVar(&initOptions.KeystorePasswordEnv, "keystore-password-env", "", fmt.Sprintf("The environment variable that holds the keystore password, with the env and prompt keystore password sources. Default is %s", types.DefaultKeystorePasswordEnv))
+	initEthereumCmd.Flags().StringVar(&initOptions.KeystorePasswordFile, "keystore-password-file", "", "The file that holds the keystore password, with the file keystore password source")
+	initEthereumCmd.Flags().BoolVar(&initOptions.VerifySigner, "verify-signer", false, "Check that the signer holds the account of each member and signs a test transaction for the chain ID of the stack, every time the stack starts")
+	initEthereumCmd.Flags().StringVar(&initOptions.SharedSigner, "shared-signer", "", "The name of a signer to share with the other stacks on this host that use the same name and chain ID, rather than running a signer for each stack. The first stack to use the name runs the signer, so must be started before and removed after the others, and every stack must use the same --external-network to reach it")
+	initEthereumCmd.Flags().StringVar(&initOptions.SignerHealthCheck, "signer-healthcheck", "", fmt.Sprintf("How the health check of the signer decides that it is ready. Options are: %v. Default is net_version, while accounts waits until the signer holds the key of every member", types.SignerHealthChecks))
+	initEthereumCmd.Flags().BoolVar(&initOptions.SignerMetricsEnabled, "signer-metrics", false, "Enable the Prometheus metrics of the Java signer, with --signer-type java, which are scraped by the Prometheus server of the stack if it is enabled")
+	initEthereumCmd.Flags().IntVar(&initOptions.SignerMetricsPort, "signer-metrics-port", 6000, "Port the metrics of the signer are published on, when enabled")
+	initEthereumCmd.Flags().BoolVar(&initOptions.PrintSignerConfig, "print-signer-config", false, "Print the generated signer config, with any credentials redacted")
+	initEthereumCmd.Flags().StringVar(&initOptions.RPCUsername, "rpc-username", "", "Username for HTTP basic auth from the signer to the blockchain JSON/RPC endpoint")
+	initEthereumCmd.Flags().StringVar(&initOptions.RPCPassword, "rpc-password", "", "Password for HTTP basic auth from the signer to the blockchain JSON/RPC endpoint")
+	initEthereumCmd.Flags().StringVar(&initOptions.RPCBearerToken, "rpc-bearer-token", "", "Bearer token the signer sends in the Authorization header to the blockchain JSON/RPC endpoint")
+	initEthereumCmd.Flags().StringVar(&initOptions.RPCConnectionTimeout, "rpc-connection-timeout", "", "How long the signer waits to connect to the blockchain JSON/RPC endpoint, e.g. 30s. Default is the signer's own default")
+	initEthereumCmd.Flags().IntVar(&initOptions.RPCRetries, "rpc-retries", 0, "How many times the signer retries a failed request to the blockchain JSON/RPC endpoint. Default is no retries")
+	initEthereumCmd.Flags().StringVar(&initOptions.RPCCACert, "rpc-ca-cert", "", "Path of a PEM encoded CA bundle the signer trusts, in addition to the system roots, when connecting to an https remote node")
+	initEthereumCmd.Flags().StringVar(&initOptions.Mnemonic, "mnemonic", "", "A BIP-39 mnemonic to derive the member accounts from, using the path m/44'/60'/0'/0/<member index>. Default is a random key for each member")
+	initEthereumCmd.Flags().BoolVar(&initOptions.ReuseKeystore, "reuse-keystore", false, "Reuse the member accounts in the signer volume left behind by an earlier stack with the same name, rather than generating new ones. A stack without such a volume starts with new accounts")
+	initEthereumCmd.Flags().IntSliceVar(&initOptions.NonSigningMembers, "non-signing-member", []int{}, "Index of a member that only queries the chain, and so is not given a signing account in the signer. Can be repeated, but at least one member must sign")
+	initEthereumCmd.Flags().StringVar(&initOptions.RemoteSignerURL, "remote-signer-url", "", "Use an existing signer at this JSON/RPC URL rather than deploying ethsigner. Keys are managed by the remote signer")
+	initEthereumCmd.Flags().StringArrayVar(&initOptions.RemoteSignerAddresses, "remote-signer-address", []string{}, "Address of a key held by the remote signer to use for each member, in order. Required for every member when using --remote-signer-url")
+	initEthereumCmd.Flags().StringVar(&initOptions.GasOracleMode, "gas-oracle-mode", "", fmt.Sprintf("How the blockchain connector prices gas. Options are: [%s %s]. Default is a fixed gas price of zero", types.GasOracleModeFixed, types.GasOracleModeConnector))
+	initEthereumCmd.Flags().StringVar(&initOptions.MaxFeePerGas, "max-fee-per-gas", "", "Max fee per gas in wei for EIP-1559 transactions. Must be set with --max-priority-fee-per-gas")
+	initEthereumCmd.Flags().StringVar(&initOptions.MaxPriorityFeePerGas, "max-priority-fee-per-gas", "", "Max priority fee per gas in wei for EIP-1559 transactions. Must be set with --max-fee-per-gas")
 	initEthereumCmd.Flags().StringVarP(&initOptions.BlockchainConnector, "blockchain-connector", "c", "evmconnect", "Blockchain connector to use. Options are: [evmconnect ethconnect]")
 	initEthereumCmd.Flags().StringVarP(&initOptions.BlockchainNodeProvider, "blockchain-node", "n", "geth", fmt.Sprintf("Blockchain node type to use. Options are: %v", fftypes.FFEnumValues(types.BlockchainNodeProvider)))
 
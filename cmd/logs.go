@@ -39,6 +39,7 @@ The most recent logs can be viewed, or you can follow the
 output with the -f flag.`,
 	RunE: func(cmd *cobra.Command, args []string) error {
 		ctx := log.WithVerbosity(context.Background(), verbose)
+		ctx = log.WithDockerVerbosity(ctx, verboseDocker)
 		ctx = context.WithValue(ctx, docker.CtxIsLogCmdKey{}, true)
 		ctx = log.WithLogger(ctx, logger)
 
@@ -69,11 +70,11 @@ output with the -f flag.`,
 			if fancyFeatures {
 				commandLine = append(commandLine, "--ansi", "always")
 			}
-			commandLine = append(commandLine, "-p", stackName, "logs")
+			commandLine = append(commandLine, "logs")
 			if follow {
 				commandLine = append(commandLine, "-f")
 			}
-			if err := docker.RunDockerComposeCommand(ctx, stackManager.Stack.RuntimeDir, commandLine...); err != nil {
+			if err := docker.RunDockerComposeCommand(docker.WithComposeProject(ctx, stackName), stackManager.Stack.RuntimeDir, commandLine...); err != nil {
 				return err
 			}
 		} else {
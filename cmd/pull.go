@@ -46,6 +46,7 @@ Pull the images for a stack .
 			logger = log.NewSpinnerLogger(spin)
 		}
 		ctx := log.WithVerbosity(context.Background(), verbose)
+		ctx = log.WithDockerVerbosity(ctx, verboseDocker)
 		ctx = log.WithLogger(ctx, logger)
 
 		version, err := docker.CheckDockerConfig()
@@ -29,6 +29,10 @@ import (
 	"github.com/spf13/cobra"
 )
 
+var removeImages bool
+var removeNoBackup bool
+var removeKeepBackups int
+
 var removeCmd = &cobra.Command{
 	Use:               "remove <stack_name>",
 	Aliases:           []string{"rm"},
@@ -37,9 +41,21 @@ var removeCmd = &cobra.Command{
 	Long: `Completely remove a stack
 
 This command will completely delete a stack, including all of its data
-and configuration.`,
+and configuration.
+
+Before anything is removed, a snapshot of the stack directory and of each of its
+volumes, including the keystore and config of the signer, is written to a new
+directory under the backups directory next to the stacks directory. The volume
+snapshots are gzipped tar archives that keep the owner and mode of every file,
+and are owned by, and only readable by, the user running the command. Only the
+newest --keep-backups snapshots of the stack are kept, older ones being removed
+once the new one is taken. Use --no-backup to skip the snapshot.
+
+With --remove-images, the images the stack has used are removed as well,
+except for those that are also used by another stack.`,
 	RunE: func(cmd *cobra.Command, args []string) error {
 		ctx := log.WithVerbosity(context.Background(), verbose)
+		ctx = log.WithDockerVerbosity(ctx, verboseDocker)
 		ctx = log.WithLogger(ctx, logger)
 
 		version, err := docker.CheckDockerConfig()
@@ -49,6 +65,9 @@ and configuration.`,
 		ctx = context.WithValue(ctx, docker.CtxComposeVersionKey{}, version)
 
 		stackManager := stacks.NewStackManager(ctx)
+		stackManager.ShutdownTimeout = shutdownTimeout
+		stackManager.RemoveImages = removeImages
+		stackManager.BackupRetention = removeKeepBackups
 		if len(args) == 0 {
 			return fmt.Errorf("no stack specified")
 		}
@@ -58,6 +77,10 @@ and configuration.`,
 			return err
 		}
 
+		if err := stackManager.CheckSharedSignerUsers(); err != nil {
+			return err
+		}
+
 		if !force {
 			fmt.Println("WARNING: This will completely remove your stack and all of its data. Are you sure this is what you want to do?")
 			if err := confirm(fmt.Sprintf("completely delete FireFly stack '%s'", stackName)); err != nil {
@@ -72,16 +95,29 @@ and configuration.`,
 		if err := stackManager.StopStack(); err != nil {
 			return err
 		}
+		backupDir := ""
+		if !removeNoBackup {
+			if backupDir, err = stackManager.SnapshotStack(); err != nil {
+				return err
+			}
+		}
 		if err := stackManager.RemoveStack(); err != nil {
 			return err
 		}
 		os.RemoveAll(filepath.Join(constants.StacksDir, stackName))
 		fmt.Println("done")
+		if backupDir != "" {
+			fmt.Printf("a snapshot of the stack was saved to: %s\n", backupDir)
+		}
 		return nil
 	},
 }
 
 func init() {
 	removeCmd.Flags().BoolVarP(&force, "force", "f", false, "Remove the stack without prompting for confirmation")
+	removeCmd.Flags().DurationVar(&shutdownTimeout, "shutdown-timeout", stacks.DefaultShutdownTimeout, "How long to wait for each service to shut down cleanly before it is killed")
+	removeCmd.Flags().BoolVar(&removeImages, "remove-images", false, "Also remove the docker images used by the stack that no other stack uses")
+	removeCmd.Flags().BoolVar(&removeNoBackup, "no-backup", false, "Remove the stack without first taking a snapshot of its directory and volumes")
+	removeCmd.Flags().IntVar(&removeKeepBackups, "keep-backups", stacks.DefaultBackupRetention, "How many snapshots of the stack to keep in the backups directory, or 0 to keep them all")
 	rootCmd.AddCommand(removeCmd)
 }
@@ -39,6 +39,7 @@ Note: this will also stop the stack if it is running.
 `,
 	RunE: func(cmd *cobra.Command, args []string) error {
 		ctx := log.WithVerbosity(context.Background(), verbose)
+		ctx = log.WithDockerVerbosity(ctx, verboseDocker)
 		ctx = log.WithLogger(ctx, logger)
 
 		version, err := docker.CheckDockerConfig()
@@ -48,6 +49,7 @@ Note: this will also stop the stack if it is running.
 		ctx = context.WithValue(ctx, docker.CtxComposeVersionKey{}, version)
 
 		stackManager := stacks.NewStackManager(ctx)
+		stackManager.ShutdownTimeout = shutdownTimeout
 		if len(args) == 0 {
 			return fmt.Errorf("no stack specified")
 		}
@@ -83,5 +85,6 @@ Note: this will also stop the stack if it is running.
 
 func init() {
 	resetCmd.Flags().BoolVarP(&force, "force", "f", false, "Reset the stack without prompting for confirmation")
+	resetCmd.Flags().DurationVar(&shutdownTimeout, "shutdown-timeout", stacks.DefaultShutdownTimeout, "How long to wait for each service to shut down cleanly before it is killed")
 	rootCmd.AddCommand(resetCmd)
 }
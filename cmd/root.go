@@ -20,6 +20,7 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"time"
 
 	"github.com/mattn/go-isatty"
 	"github.com/spf13/cobra"
@@ -27,14 +28,25 @@ import (
 	homedir "github.com/mitchellh/go-homedir"
 	"github.com/spf13/viper"
 
+	"github.com/hyperledger/firefly-cli/internal/blockchain/ethereum/ethsigner"
 	"github.com/hyperledger/firefly-cli/internal/log"
 )
 
+// The exit codes of the CLI, which let scripts tell the failures they can act on apart from any other error
+const (
+	exitError               = 1
+	exitDockerUnavailable   = 3
+	exitAccountExists       = 4
+	exitSignerConfigInvalid = 5
+)
+
 var cfgFile string
 var ansi string
 var fancyFeatures bool
 var verbose bool
+var verboseDocker bool
 var force bool
+var shutdownTimeout time.Duration
 var logger log.Logger = &log.StdoutLogger{
 	LogLevel: log.Debug,
 }
@@ -64,6 +76,9 @@ commands to manage the lifecycle of stacks.
 
 To get started run: ff init
 Optional: Set FIREFLY_HOME env variable for FireFly stack configuration path.
+
+A command that fails exits with 3 if docker is unavailable, 4 if an account
+already exists, 5 if the signer config is invalid, and 1 for any other error.
 	`,
 	PersistentPreRun: func(cmd *cobra.Command, args []string) {
 		if ansi == "always" {
@@ -84,7 +99,25 @@ Optional: Set FIREFLY_HOME env variable for FireFly stack configuration path.
 func Execute() {
 	rootCmd.PersistentFlags().StringVarP(&ansi, "ansi", "", "auto", "control when to print ANSI control characters (\"never\"|\"always\"|\"auto\")")
 	rootCmd.PersistentFlags().BoolVarP(&verbose, "verbose", "v", false, "verbose log output")
-	cobra.CheckErr(rootCmd.Execute())
+	rootCmd.PersistentFlags().BoolVar(&verboseDocker, "verbose-docker", false, "print the docker commands run by the CLI and their output")
+	if err := rootCmd.Execute(); err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(exitCode(err))
+	}
+}
+
+// exitCode returns the exit code for an error returned by a command
+func exitCode(err error) int {
+	switch {
+	case errors.Is(err, ethsigner.ErrDockerUnavailable):
+		return exitDockerUnavailable
+	case errors.Is(err, ethsigner.ErrAccountExists):
+		return exitAccountExists
+	case errors.Is(err, ethsigner.ErrSignerConfigInvalid):
+		return exitSignerConfigInvalid
+	default:
+		return exitError
+	}
 }
 
 func init() {
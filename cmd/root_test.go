@@ -0,0 +1,16 @@
+package cmd
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/hyperledger/firefly-cli/internal/blockchain/ethereum/ethsigner"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExitCode(t *testing.T) {
+	assert.Equal(t, exitError, exitCode(fmt.Errorf("pop")))
+	assert.Equal(t, exitDockerUnavailable, exitCode(fmt.Errorf("failed to start: %w", ethsigner.ErrDockerUnavailable)))
+	assert.Equal(t, exitAccountExists, exitCode(ethsigner.ErrAccountExists))
+	assert.Equal(t, exitSignerConfigInvalid, exitCode(&ethsigner.Error{Kind: ethsigner.ErrSignerConfigInvalid, Err: fmt.Errorf("bad chain ID")}))
+}
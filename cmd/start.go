@@ -31,6 +31,7 @@ import (
 )
 
 var startOptions types.StartOptions
+var pullConcurrency int
 
 var startCmd = &cobra.Command{
 	Use:               "start <stack_name>",
@@ -47,7 +48,12 @@ This command will start a stack and run it in the background.
 			logger = log.NewSpinnerLogger(spin)
 		}
 		ctx := log.WithVerbosity(context.Background(), verbose)
+		ctx = log.WithDockerVerbosity(ctx, verboseDocker)
 		ctx = log.WithLogger(ctx, logger)
+		if spin != nil {
+			// Show each step of the first time setup on the spinner, rather than the output of every docker command
+			ctx = log.WithProgress(ctx, func(progress log.Progress) { logger.Info(progress.String()) })
+		}
 
 		version, err := docker.CheckDockerConfig()
 		if err != nil {
@@ -56,6 +62,7 @@ This command will start a stack and run it in the background.
 		ctx = context.WithValue(ctx, docker.CtxComposeVersionKey{}, version)
 
 		stackManager := stacks.NewStackManager(ctx)
+		stackManager.PullConcurrency = pullConcurrency
 		if len(args) == 0 {
 			return errors.New("no stack specified")
 		}
@@ -104,5 +111,7 @@ This command will start a stack and run it in the background.
 
 func init() {
 	startCmd.Flags().BoolVarP(&startOptions.NoRollback, "no-rollback", "b", false, "Do not automatically rollback changes if first time setup fails")
+	startCmd.Flags().BoolVar(&startOptions.VerifySigner, "verify-signer", false, "Once the signer is healthy, check that it holds the account of each member and signs a test transaction for the chain ID of the stack (Ethereum only)")
+	startCmd.Flags().IntVar(&pullConcurrency, "pull-concurrency", docker.DefaultPullConcurrency, "How many images to pull at once before starting the stack")
 	rootCmd.AddCommand(startCmd)
 }
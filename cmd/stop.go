@@ -34,6 +34,7 @@ var stopCmd = &cobra.Command{
 	ValidArgsFunction: listStacks,
 	RunE: func(cmd *cobra.Command, args []string) error {
 		ctx := log.WithVerbosity(context.Background(), verbose)
+		ctx = log.WithDockerVerbosity(ctx, verboseDocker)
 		ctx = log.WithLogger(ctx, logger)
 
 		version, err := docker.CheckDockerConfig()
@@ -43,6 +44,7 @@ var stopCmd = &cobra.Command{
 		ctx = context.WithValue(ctx, docker.CtxComposeVersionKey{}, version)
 
 		stackManager := stacks.NewStackManager(ctx)
+		stackManager.ShutdownTimeout = shutdownTimeout
 		if len(args) == 0 {
 			return fmt.Errorf("no stack specified")
 		}
@@ -62,5 +64,6 @@ var stopCmd = &cobra.Command{
 }
 
 func init() {
+	stopCmd.Flags().DurationVar(&shutdownTimeout, "shutdown-timeout", stacks.DefaultShutdownTimeout, "How long to wait for each service to shut down cleanly before it is killed")
 	rootCmd.AddCommand(stopCmd)
 }
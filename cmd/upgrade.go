@@ -46,6 +46,7 @@ var upgradeCmd = &cobra.Command{
 			logger = log.NewSpinnerLogger(spin)
 		}
 		ctx := log.WithVerbosity(context.Background(), verbose)
+		ctx = log.WithDockerVerbosity(ctx, verboseDocker)
 		ctx = log.WithLogger(ctx, logger)
 
 		dockerVersion, err := docker.CheckDockerConfig()
@@ -18,37 +18,161 @@ package ethereum
 
 import (
 	"context"
+	"encoding/hex"
 	"fmt"
 	"os"
 	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
 
+	"github.com/hyperledger/firefly-cli/internal/constants"
 	"github.com/hyperledger/firefly-cli/internal/docker"
-	"github.com/hyperledger/firefly-signer/pkg/keystorev3"
+	"github.com/hyperledger/firefly-cli/pkg/types"
 	"github.com/hyperledger/firefly-signer/pkg/secp256k1"
 )
 
-func CreateWalletFile(outputDirectory, prefix, password string) (*secp256k1.KeyPair, string, error) {
+func CreateWalletFile(outputDirectory, prefix, password, kdf string) (*secp256k1.KeyPair, string, error) {
 	keyPair, err := secp256k1.GenerateSecp256k1KeyPair()
 	if err != nil {
 		return nil, "", err
 	}
-	wallet := keystorev3.NewWalletFileStandard(password, keyPair)
-
-	if err := os.MkdirAll(outputDirectory, 0755); err != nil {
+	filename, err := WriteWalletFile(outputDirectory, prefix, password, kdf, keyPair)
+	if err != nil {
 		return nil, "", err
 	}
+	return keyPair, filename, nil
+}
+
+// ParsePrivateKey parses a hex encoded secp256k1 private key, with or without a 0x prefix
+func ParsePrivateKey(privateKey string) (*secp256k1.KeyPair, error) {
+	b, err := hex.DecodeString(strings.TrimPrefix(privateKey, "0x"))
+	if err != nil || len(b) != 32 {
+		return nil, fmt.Errorf("invalid private key: must be a 32 byte hex encoded secp256k1 private key")
+	}
+	return secp256k1.NewSecp256k1KeyPair(b)
+}
+
+const (
+	// maxAddressPrefixLength bounds the hex digits of an address prefix, as each digit takes 16 times as many
+	// attempts to find, so that the 65536 attempts a prefix of 4 digits takes on average fit well within the timeout
+	maxAddressPrefixLength = 4
+	// addressPrefixAttempts and addressPrefixTimeout bound the search for an address prefix, so that an unlucky
+	// search fails rather than hanging
+	addressPrefixAttempts = 1 << 24
+	addressPrefixTimeout  = 30 * time.Second
+)
+
+var addressPrefixRegex = regexp.MustCompile(`^[0-9a-f]+$`)
 
-	var filename string
+// generateKeyPair is replaced in tests so that the outcome of a prefix search is deterministic
+var generateKeyPair = secp256k1.GenerateSecp256k1KeyPair
+
+// GenerateKeyPairWithAddressPrefix generates key pairs until one has an address that starts with the given hex
+// prefix, such as 0xaaa, which makes the accounts of each member easy to tell apart in demos and tests
+func GenerateKeyPairWithAddressPrefix(prefix string) (*secp256k1.KeyPair, error) {
+	return generateKeyPairWithAddressPrefix(prefix, addressPrefixAttempts, addressPrefixTimeout)
+}
+
+func generateKeyPairWithAddressPrefix(prefix string, maxAttempts int, timeout time.Duration) (*secp256k1.KeyPair, error) {
+	digits := strings.ToLower(strings.TrimPrefix(strings.TrimPrefix(prefix, "0x"), "0X"))
+	if !addressPrefixRegex.MatchString(digits) || len(digits) > maxAddressPrefixLength {
+		return nil, fmt.Errorf("invalid address prefix '%s': must be between 1 and %d hex digits", prefix, maxAddressPrefixLength)
+	}
+	deadline := time.Now().Add(timeout)
+	for attempt := 1; attempt <= maxAttempts; attempt++ {
+		keyPair, err := generateKeyPair()
+		if err != nil {
+			return nil, err
+		}
+		if strings.HasPrefix(hex.EncodeToString(keyPair.Address[:]), digits) {
+			return keyPair, nil
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("no address starting with 0x%s was found within %s (%d attempts), try a shorter prefix", digits, timeout, attempt)
+		}
+	}
+	return nil, fmt.Errorf("no address starting with 0x%s was found within %d attempts, try a shorter prefix", digits, maxAttempts)
+}
+
+// ArgValue returns the value of a "name=value" argument passed to CreateAccount, if present
+func ArgValue(args []string, name string) string {
+	for _, arg := range args {
+		if strings.HasPrefix(arg, name+"=") {
+			return strings.TrimPrefix(arg, name+"=")
+		}
+	}
+	return ""
+}
+
+// ValidateUniqueAddresses checks that no address is used for more than one account of the stack. The keys
+// of every member are imported into the same signer, which misbehaves if the same key is imported twice.
+func ValidateUniqueAddresses(members []*types.Organization) error {
+	owners := make(map[string]*types.Organization)
+	for _, member := range members {
+		for _, account := range member.Accounts() {
+			a, ok := account.(*Account)
+			if !ok {
+				continue
+			}
+			address := strings.ToLower(a.Address)
+			owner, ok := owners[address]
+			switch {
+			case !ok:
+				owners[address] = member
+			case owner == member:
+				return fmt.Errorf("address %s is used more than once by member %s ('%s')", a.Address, member.ID, member.OrgName)
+			default:
+				return fmt.Errorf("address %s is used by both member %s ('%s') and member %s ('%s')", a.Address, owner.ID, owner.OrgName, member.ID, member.OrgName)
+			}
+		}
+	}
+	return nil
+}
+
+// ValidateSigningMembers checks that at least one member of the stack has a signing account, to deploy the
+// contracts of the stack, and that no member that only queries the chain has been given one
+func ValidateSigningMembers(members []*types.Organization) error {
+	signing := false
+	for _, member := range members {
+		if !member.NonSigning {
+			signing = true
+		} else if len(member.Accounts()) > 0 {
+			return fmt.Errorf("member %s ('%s') does not sign, so it cannot have accounts", member.ID, member.OrgName)
+		}
+	}
+	if len(members) > 0 && !signing {
+		return fmt.Errorf("at least one member of the stack must sign, to deploy the contracts of the stack")
+	}
+	return nil
+}
+
+// WalletFileName returns the name of the keystore file used for the given key pair
+func WalletFileName(outputDirectory, prefix string, keyPair *secp256k1.KeyPair) string {
 	if prefix != "" {
-		filename = filepath.Join(outputDirectory, fmt.Sprintf("%v_%s", prefix, keyPair.Address.String()[2:]))
-	} else {
-		filename = filepath.Join(outputDirectory, keyPair.Address.String()[2:])
+		return filepath.Join(outputDirectory, fmt.Sprintf("%v_%s", prefix, keyPair.Address.String()[2:]))
 	}
-	err = os.WriteFile(filename, wallet.JSON(), 0755)
+	return filepath.Join(outputDirectory, keyPair.Address.String()[2:])
+}
+
+// WriteWalletFile encrypts the key pair with the password into a Web3 Secret Storage (keystore v3) file, which
+// is the format the signer reads its keystore in. The key is encrypted in process, without running a container,
+// with the scrypt cost of the given keystore KDF preset, which is types.KeystoreKDFStandard if empty.
+func WriteWalletFile(outputDirectory, prefix, password, kdf string, keyPair *secp256k1.KeyPair) (string, error) {
+	wallet, err := encryptKeystore(password, kdf, keyPair)
 	if err != nil {
-		return nil, "", err
+		return "", err
 	}
-	return keyPair, filename, nil
+
+	if err := os.MkdirAll(outputDirectory, constants.KeyDirectoryMode); err != nil {
+		return "", err
+	}
+
+	filename := WalletFileName(outputDirectory, prefix, keyPair)
+	if err := os.WriteFile(filename, wallet, constants.KeyFileMode); err != nil {
+		return "", err
+	}
+	return filename, nil
 }
 
 func CopyWalletFileToVolume(ctx context.Context, walletFilePath, volumeName string) error {
@@ -1,9 +1,16 @@
 package ethereum
 
 import (
+	"encoding/json"
+	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/hyperledger/firefly-cli/pkg/types"
+	"github.com/hyperledger/firefly-signer/pkg/keystorev3"
+	"github.com/hyperledger/firefly-signer/pkg/secp256k1"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -14,7 +21,7 @@ func TestCreateWalletFile(t *testing.T) {
 	outputDirectory := filepath.Join(dir + "wallet.json")
 	password := "26371628355334###"
 	t.Run("TestCreateWalletFile", func(t *testing.T) {
-		keypair, filename, err := CreateWalletFile(outputDirectory, prefix, password)
+		keypair, filename, err := CreateWalletFile(outputDirectory, prefix, password, "")
 		if err != nil {
 			t.Logf("unable to create wallet file %v: ", err)
 		}
@@ -22,3 +29,137 @@ func TestCreateWalletFile(t *testing.T) {
 		assert.NotNil(t, filename)
 	})
 }
+
+func TestWriteWalletFileDecrypts(t *testing.T) {
+	keyPair, err := ParsePrivateKey("0x8d5e5f1fcb0c2d7a4c1e7b1a7a9d3f2b6c4e8a0d9f1b3c5e7a9b1d3f5a7c9e1b")
+	assert.NoError(t, err)
+	password := "member password"
+
+	filename, err := WriteWalletFile(t.TempDir(), "", password, "", keyPair)
+	assert.NoError(t, err)
+	assert.Equal(t, keyPair.Address.String()[2:], filepath.Base(filename))
+	walletJSON, err := os.ReadFile(filename)
+	assert.NoError(t, err)
+
+	var wallet map[string]interface{}
+	assert.NoError(t, json.Unmarshal(walletJSON, &wallet))
+	assert.Equal(t, float64(3), wallet["version"])
+	assert.Equal(t, keyPair.Address.String()[2:], wallet["address"])
+
+	decrypted, err := keystorev3.ReadWalletFile(walletJSON, []byte(password))
+	assert.NoError(t, err)
+	assert.Equal(t, keyPair.PrivateKeyBytes(), decrypted.KeyPair().PrivateKeyBytes())
+	_, err = keystorev3.ReadWalletFile(walletJSON, []byte("wrong password"))
+	assert.Error(t, err)
+}
+
+func TestWriteWalletFileKeystoreKDF(t *testing.T) {
+	keyPair, err := ParsePrivateKey("0x8d5e5f1fcb0c2d7a4c1e7b1a7a9d3f2b6c4e8a0d9f1b3c5e7a9b1d3f5a7c9e1b")
+	assert.NoError(t, err)
+	password := "member password"
+
+	testcases := []struct {
+		KDF string
+		N   float64
+	}{
+		{KDF: "", N: 1 << 10},
+		{KDF: types.KeystoreKDFFast, N: 1 << 8},
+		{KDF: types.KeystoreKDFStandard, N: 1 << 10},
+		{KDF: types.KeystoreKDFStrong, N: 1 << 18},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.KDF, func(t *testing.T) {
+			filename, err := WriteWalletFile(t.TempDir(), "", password, tc.KDF, keyPair)
+			assert.NoError(t, err)
+			walletJSON, err := os.ReadFile(filename)
+			assert.NoError(t, err)
+
+			var wallet struct {
+				Crypto struct {
+					KDFParams map[string]interface{} `json:"kdfparams"`
+				} `json:"crypto"`
+			}
+			assert.NoError(t, json.Unmarshal(walletJSON, &wallet))
+			assert.Equal(t, tc.N, wallet.Crypto.KDFParams["n"])
+			assert.Equal(t, float64(8), wallet.Crypto.KDFParams["r"])
+			assert.Equal(t, float64(1), wallet.Crypto.KDFParams["p"])
+
+			decrypted, err := keystorev3.ReadWalletFile(walletJSON, []byte(password))
+			assert.NoError(t, err)
+			assert.Equal(t, keyPair.PrivateKeyBytes(), decrypted.KeyPair().PrivateKeyBytes())
+		})
+	}
+
+	_, err = WriteWalletFile(t.TempDir(), "", password, "weak", keyPair)
+	assert.Regexp(t, `invalid keystore KDF 'weak': must be one of \[fast standard strong\]`, err)
+}
+
+func TestValidateSigningMembers(t *testing.T) {
+	signing := &types.Organization{ID: "0", OrgName: "org_0", Account: &Account{Address: "0x1111111111111111111111111111111111111111"}}
+	querying := &types.Organization{ID: "1", OrgName: "org_1", NonSigning: true}
+
+	assert.NoError(t, ValidateSigningMembers([]*types.Organization{signing, querying}))
+	assert.NoError(t, ValidateSigningMembers([]*types.Organization{}))
+
+	err := ValidateSigningMembers([]*types.Organization{querying})
+	assert.Regexp(t, "at least one member of the stack must sign", err)
+
+	err = ValidateSigningMembers([]*types.Organization{signing, {ID: "1", OrgName: "org_1", NonSigning: true, Account: &Account{Address: "0x2222222222222222222222222222222222222222"}}})
+	assert.Regexp(t, `member 1 \('org_1'\) does not sign, so it cannot have accounts`, err)
+}
+
+func TestValidateUniqueAddresses(t *testing.T) {
+	member := func(id string, addresses ...string) *types.Organization {
+		org := &types.Organization{ID: id, OrgName: "org_" + id}
+		for i, address := range addresses {
+			if i == 0 {
+				org.Account = &Account{Address: address}
+			} else {
+				org.AdditionalAccounts = append(org.AdditionalAccounts, &Account{Address: address})
+			}
+		}
+		return org
+	}
+
+	assert.NoError(t, ValidateUniqueAddresses([]*types.Organization{
+		member("0", "0x1111111111111111111111111111111111111111", "0x2222222222222222222222222222222222222222"),
+		member("1", "0x3333333333333333333333333333333333333333"),
+		{ID: "2", OrgName: "org_2"},
+	}))
+
+	// Addresses differing only in their checksum case are the same key
+	err := ValidateUniqueAddresses([]*types.Organization{
+		member("0", "0xabcdef0000000000000000000000000000000000"),
+		member("1", "0x3333333333333333333333333333333333333333"),
+		member("2", "0xABCDEF0000000000000000000000000000000000"),
+	})
+	assert.Regexp(t, `address 0xABCDEF0000000000000000000000000000000000 is used by both member 0 \('org_0'\) and member 2 \('org_2'\)`, err)
+
+	err = ValidateUniqueAddresses([]*types.Organization{
+		member("0", "0x1111111111111111111111111111111111111111", "0x1111111111111111111111111111111111111111"),
+	})
+	assert.Regexp(t, `address 0x1111111111111111111111111111111111111111 is used more than once by member 0 \('org_0'\)`, err)
+}
+
+func TestGenerateKeyPairWithAddressPrefix(t *testing.T) {
+	keyPair, err := generateKeyPairWithAddressPrefix("0xA", 10000, 10*time.Second)
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(keyPair.Address.String(), "0xa"))
+
+	// The key with private key 1 has address 0x7e5f4552091a69125d5dfcb7b8c2659029395bdf
+	fixedKey, err := secp256k1.NewSecp256k1KeyPair(append(make([]byte, 31), 1))
+	assert.NoError(t, err)
+	defer func(original func() (*secp256k1.KeyPair, error)) { generateKeyPair = original }(generateKeyPair)
+	generateKeyPair = func() (*secp256k1.KeyPair, error) { return fixedKey, nil }
+
+	_, err = generateKeyPairWithAddressPrefix("0x0000", 1<<30, 0)
+	assert.Regexp(t, "no address starting with 0x0000 was found within 0s", err)
+
+	_, err = generateKeyPairWithAddressPrefix("0000", 3, time.Minute)
+	assert.Regexp(t, "no address starting with 0x0000 was found within 3 attempts", err)
+
+	for _, prefix := range []string{"", "0x", "0xzz", "0x12345"} {
+		_, err = GenerateKeyPairWithAddressPrefix(prefix)
+		assert.Regexp(t, "invalid address prefix", err)
+	}
+}
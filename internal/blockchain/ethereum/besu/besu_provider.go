@@ -35,6 +35,9 @@ import (
 
 var besuImage = "hyperledger/besu:22.4"
 
+// resourceLimits is the default limit of the besu container, which keeps it from starving the rest of the stack
+var resourceLimits = &docker.ResourceLimits{CPUs: "2", Memory: "4g"}
+
 type BesuProvider struct {
 	ctx       context.Context
 	stack     *types.Stack
@@ -69,8 +72,8 @@ func (p *BesuProvider) WriteConfig(options *types.InitOptions) error {
 
 		// Generate the connector config for each member
 		connectorConfigPath := filepath.Join(initDir, "config", fmt.Sprintf("%s_%v.yaml", p.connector.Name(), i))
-		if err := p.connector.GenerateConfig(p.stack, member, "ethsigner").WriteConfig(connectorConfigPath, options.ExtraConnectorConfigPath); err != nil {
-			return nil
+		if err := p.connector.GenerateConfig(p.stack, member, p.signer.URL()).WriteConfig(connectorConfigPath, options.ExtraConnectorConfigPath); err != nil {
+			return err
 		}
 
 	}
@@ -79,7 +82,7 @@ func (p *BesuProvider) WriteConfig(options *types.InitOptions) error {
 	// Generate node key
 	nodeAddress, nodeKey := ethereum.GenerateAddressAndPrivateKey()
 	// Write the node key to disk
-	if err := os.WriteFile(filepath.Join(initDir, "blockchain", "nodeKey"), []byte(nodeKey), 0755); err != nil {
+	if err := os.WriteFile(filepath.Join(initDir, "blockchain", "nodeKey"), []byte(nodeKey), constants.KeyFileMode); err != nil {
 		return err
 	}
 	// Drop the 0x on the front of the address here because that's what is expected in the genesis.json
@@ -104,7 +107,7 @@ func (p *BesuProvider) FirstTimeSetup() error {
 		return err
 	}
 
-	if err := docker.CreateVolume(p.ctx, besuVolumeName); err != nil {
+	if err := docker.CreateVolume(p.ctx, besuVolumeName, docker.StackLabel(p.stack.Name)); err != nil {
 		return err
 	}
 
@@ -135,11 +138,16 @@ func (p *BesuProvider) FirstTimeSetup() error {
 }
 
 func (p *BesuProvider) PreStart() error {
-	return nil
+	return p.signer.PreStart()
 }
 
 func (p *BesuProvider) PostStart(firstTimeSetup bool) error {
-	return nil
+	return p.signer.WaitUntilHealthy()
+}
+
+// VerifySigner checks that the signer of the running stack can sign for each member
+func (p *BesuProvider) VerifySigner() error {
+	return p.signer.VerifySigner()
 }
 
 func (p *BesuProvider) DeployFireFlyContract() (*types.ContractDeploymentResult, error) {
@@ -147,21 +155,22 @@ func (p *BesuProvider) DeployFireFlyContract() (*types.ContractDeploymentResult,
 	if err != nil {
 		return nil, err
 	}
-	return p.connector.DeployContract(contract, "FireFly", p.stack.Members[0], nil)
+	return p.connector.DeployContract(contract, "FireFly", p.stack.SigningMember(), nil)
 }
 
-func (p *BesuProvider) GetDockerServiceDefinitions() []*docker.ServiceDefinition {
+func (p *BesuProvider) GetDockerServiceDefinitions() ([]*docker.ServiceDefinition, error) {
 	addresses := ""
-	for i, member := range p.stack.Members {
-		account := member.Account.(*ethereum.Account)
-		addresses += account.Address
-		if i+1 < len(p.stack.Members) {
-			addresses += ","
+	for _, member := range p.stack.Members {
+		for _, account := range member.SigningAccounts() {
+			if addresses != "" {
+				addresses += ","
+			}
+			addresses += account.(*ethereum.Account).Address
 		}
 	}
 	besuCommand := fmt.Sprintf(`--genesis-file=/data/genesis.json --network-id %d --rpc-http-enabled --rpc-http-api=ETH,NET,CLIQUE --host-allowlist="*" --rpc-http-cors-origins="all" --sync-mode=FULL --discovery-enabled=false --node-private-key-file=/data/nodeKey --min-gas-price=0`, p.stack.ChainID())
 
-	serviceDefinitions := make([]*docker.ServiceDefinition, 2)
+	serviceDefinitions := make([]*docker.ServiceDefinition, 1)
 	serviceDefinitions[0] = &docker.ServiceDefinition{
 		ServiceName: "besu",
 		Service: &docker.Service{
@@ -175,11 +184,18 @@ func (p *BesuProvider) GetDockerServiceDefinitions() []*docker.ServiceDefinition
 			Logging: docker.StandardLogOptions,
 		},
 
-		VolumeNames: []string{"besu"},
+		VolumeNames:    []string{"besu"},
+		ResourceLimits: resourceLimits,
+	}
+	signer, err := p.signer.GetDockerServiceDefinition("http://besu:8545")
+	if err != nil {
+		return nil, err
+	}
+	if signer != nil {
+		serviceDefinitions = append(serviceDefinitions, signer)
 	}
-	serviceDefinitions[1] = p.signer.GetDockerServiceDefinition("http://besu:8545")
-	serviceDefinitions = append(serviceDefinitions, p.connector.GetServiceDefinitions(p.stack, map[string]string{"ethsigner": "service_healthy"})...)
-	return serviceDefinitions
+	serviceDefinitions = append(serviceDefinitions, p.connector.GetServiceDefinitions(p.stack, p.signer.DependentServices())...)
+	return serviceDefinitions, nil
 }
 
 func (p *BesuProvider) GetBlockchainPluginConfig(stack *types.Stack, m *types.Organization) (blockchainConfig *types.BlockchainConfig) {
@@ -203,10 +219,12 @@ func (p *BesuProvider) GetBlockchainPluginConfig(stack *types.Stack, m *types.Or
 }
 
 func (p *BesuProvider) GetOrgConfig(stack *types.Stack, m *types.Organization) (orgConfig *types.OrgConfig) {
-	account := m.Account.(*ethereum.Account)
 	orgConfig = &types.OrgConfig{
 		Name: m.OrgName,
-		Key:  account.Address,
+	}
+	// A member that only queries the chain has no key to sign with
+	if account, ok := m.Account.(*ethereum.Account); ok {
+		orgConfig.Key = account.Address
 	}
 	return
 }
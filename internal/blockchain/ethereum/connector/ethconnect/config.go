@@ -17,7 +17,6 @@
 package ethconnect
 
 import (
-	"fmt"
 	"os"
 
 	"github.com/hyperledger/firefly-cli/internal/blockchain/ethereum/connector"
@@ -77,13 +76,13 @@ func (e *Config) WriteConfig(filename string, extraConnectorConfigPath string) e
 	return nil
 }
 
-func (e *Ethconnect) GenerateConfig(stack *types.Stack, member *types.Organization, blockchainServiceName string) connector.Config {
+func (e *Ethconnect) GenerateConfig(stack *types.Stack, member *types.Organization, rpcURL string) connector.Config {
 	return &Config{
 		Rest: &Rest{
 			RestGateway: &RestGateway{
 				MaxTXWaitTime: 60,
 				MaxInFlight:   10,
-				RPC:           &RPC{URL: fmt.Sprintf("http://%s:8545", blockchainServiceName)},
+				RPC:           &RPC{URL: rpcURL},
 				OpenAPI: &OpenAPI{
 					EventPollingIntervalSec: 1,
 					StoragePath:             "./data/abis",
@@ -65,10 +65,16 @@ type ConfirmationsConfig struct {
 }
 
 type PolicyEngineSimpleConfig struct {
-	FixedGasPrice *int             `yaml:"fixedGasPrice,omitempty"`
+	// FixedGasPrice is either a legacy gas price, or an EIP-1559 FixedGasFees
+	FixedGasPrice interface{}      `yaml:"fixedGasPrice,omitempty"`
 	GasOracle     *GasOracleConfig `yaml:"gasOracle,omitempty"`
 }
 
+type FixedGasFees struct {
+	MaxFeePerGas         string `yaml:"maxFeePerGas"`
+	MaxPriorityFeePerGas string `yaml:"maxPriorityFeePerGas"`
+}
+
 type GasOracleConfig struct {
 	Mode string `yaml:"mode,omitempty"`
 }
@@ -94,11 +100,9 @@ func (e *Config) WriteConfig(filename string, extraEvmconnectConfigPath string)
 	return nil
 }
 
-func (e *Evmconnect) GenerateConfig(stack *types.Stack, org *types.Organization, blockchainServiceName string) connector.Config {
+func (e *Evmconnect) GenerateConfig(stack *types.Stack, org *types.Organization, rpcURL string) connector.Config {
 	confirmations := new(int)
 	*confirmations = 0
-	fixedGasPrice := new(int)
-	*fixedGasPrice = 0
 	var metrics *types.MetricsServerConfig
 
 	if stack.PrometheusEnabled {
@@ -125,7 +129,7 @@ func (e *Evmconnect) GenerateConfig(stack *types.Stack, org *types.Organization,
 			PublicURL: fmt.Sprintf("http://127.0.0.1:%v", org.ExposedConnectorPort),
 		},
 		Connector: &ConnectorConfig{
-			URL: fmt.Sprintf("http://%s:8545", blockchainServiceName),
+			URL: rpcURL,
 		},
 		Persistence: &PersistenceConfig{
 			LevelDB: &LevelDBConfig{
@@ -140,11 +144,31 @@ func (e *Evmconnect) GenerateConfig(stack *types.Stack, org *types.Organization,
 		Confirmations: &ConfirmationsConfig{
 			Required: confirmations,
 		},
-		PolicyEngine: &PolicyEngineSimpleConfig{
-			FixedGasPrice: fixedGasPrice,
+		PolicyEngine: generatePolicyEngineConfig(stack.Gas),
+	}
+}
+
+// generatePolicyEngineConfig submits transactions with a fixed gas price of zero, unless the stack has its
+// own gas configuration
+func generatePolicyEngineConfig(gas *types.GasConfig) *PolicyEngineSimpleConfig {
+	if gas != nil && gas.OracleMode == types.GasOracleModeConnector {
+		return &PolicyEngineSimpleConfig{
 			GasOracle: &GasOracleConfig{
-				Mode: "fixed",
+				Mode: types.GasOracleModeConnector,
 			},
+		}
+	}
+	var fixedGasPrice interface{} = new(int)
+	if gas != nil && gas.MaxFeePerGas != "" {
+		fixedGasPrice = &FixedGasFees{
+			MaxFeePerGas:         gas.MaxFeePerGas,
+			MaxPriorityFeePerGas: gas.MaxPriorityFeePerGas,
+		}
+	}
+	return &PolicyEngineSimpleConfig{
+		FixedGasPrice: fixedGasPrice,
+		GasOracle: &GasOracleConfig{
+			Mode: types.GasOracleModeFixed,
 		},
 	}
 }
@@ -3,6 +3,10 @@ package evmconnect
 import (
 	"path/filepath"
 	"testing"
+
+	"github.com/hyperledger/firefly-cli/pkg/types"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v3"
 )
 
 func TestWriteConfig(t *testing.T) {
@@ -17,3 +21,50 @@ func TestWriteConfig(t *testing.T) {
 		}
 	})
 }
+
+func TestGenerateConfigGas(t *testing.T) {
+	org := &types.Organization{ID: "0", ExposedConnectorPort: 5102}
+	testcases := []struct {
+		Name     string
+		Gas      *types.GasConfig
+		Expected string
+	}{
+		{
+			Name: "default",
+			Gas:  nil,
+			Expected: `policyengine.simple:
+    fixedGasPrice: 0
+    gasOracle:
+        mode: fixed
+`,
+		},
+		{
+			Name: "connector",
+			Gas:  &types.GasConfig{OracleMode: types.GasOracleModeConnector},
+			Expected: `policyengine.simple:
+    gasOracle:
+        mode: connector
+`,
+		},
+		{
+			Name: "eip1559",
+			Gas:  &types.GasConfig{MaxFeePerGas: "30000000000", MaxPriorityFeePerGas: "1500000000"},
+			Expected: `policyengine.simple:
+    fixedGasPrice:
+        maxFeePerGas: "30000000000"
+        maxPriorityFeePerGas: "1500000000"
+    gasOracle:
+        mode: fixed
+`,
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.Name, func(t *testing.T) {
+			e := &Evmconnect{}
+			config := e.GenerateConfig(&types.Stack{Gas: tc.Gas}, org, "http://ethsigner:8545").(*Config)
+			b, err := yaml.Marshal(map[string]interface{}{"policyengine.simple": config.PolicyEngine})
+			assert.NoError(t, err)
+			assert.Equal(t, tc.Expected, string(b))
+		})
+	}
+}
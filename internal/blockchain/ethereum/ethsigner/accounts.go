@@ -20,32 +20,101 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"path"
 	"path/filepath"
 
+	"github.com/hyperledger/firefly-cli/internal/constants"
 	"github.com/hyperledger/firefly-cli/internal/docker"
 )
 
-func (p *EthSignerProvider) writeTomlKeyFile(walletFilePath string) (string, error) {
-	outputDirectory := filepath.Dir(walletFilePath)
-	keyFile := filepath.Base(walletFilePath)
+func (p *EthSignerProvider) writePasswordFile(blockchainDirectory, walletFilePath, password string) (string, error) {
+	filename := filepath.Join(blockchainDirectory, fmt.Sprintf("%s.password", filepath.Base(walletFilePath)))
+	return filename, os.WriteFile(filename, []byte(password), constants.KeyFileMode)
+}
+
+// readPasswordFile reads the password of an account, falling back to the single shared password file of
+// stacks initialized by older versions of the CLI
+func readPasswordFile(blockchainDirectory, keyFile string) ([]byte, error) {
+	password, err := os.ReadFile(filepath.Join(blockchainDirectory, fmt.Sprintf("%s.password", keyFile)))
+	if os.IsNotExist(err) {
+		password, err = os.ReadFile(filepath.Join(blockchainDirectory, legacyPasswordFile))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the password for account %s: %s", keyFile, err)
+	}
+	return password, nil
+}
+
+// KeyType is the kind of key an account signs with, which is the signer type of its toml key file
+type KeyType string
+
+const (
+	// KeyTypeFile is a key in an encrypted keystore file, which is what the CLI creates
+	KeyTypeFile KeyType = "file-based-signer"
+	// KeyTypeHSM is reserved for keys held in a hardware security module, which are not supported yet
+	KeyTypeHSM KeyType = "hsm-signer"
+)
+
+// KeySpec is how the signer loads the key of an account, as written to the toml key file of the account. The
+// paths are inside the signer container.
+type KeySpec struct {
+	Type         KeyType
+	KeyFile      string
+	PasswordFile string
+}
+
+// fileKeySpec returns the spec of a key in the keystore of the signer, whose password is in passwordFileName
+// alongside the keystore, or in the keystore password secret unless the stack uses plaintext passwords
+func (p *EthSignerProvider) fileKeySpec(walletFilePath, passwordFileName string) KeySpec {
+	passwordFile := path.Join(p.dataDirectory(), passwordFileName)
+	if !p.plaintextPasswords() {
+		passwordFile = secretPasswordFile()
+	}
+	return KeySpec{
+		Type:         KeyTypeFile,
+		KeyFile:      path.Join(p.keystoreDirectory(), filepath.Base(walletFilePath)),
+		PasswordFile: passwordFile,
+	}
+}
+
+// signingSection returns the [signing] section of a toml key file for the key
+func (k KeySpec) signingSection() (string, error) {
+	switch k.Type {
+	case KeyTypeFile, "":
+		if k.KeyFile == "" || k.PasswordFile == "" {
+			return "", fmt.Errorf("a key file and a password file are required for a '%s' key", KeyTypeFile)
+		}
+		return fmt.Sprintf(`[signing]
+type = "%s"
+key-file = "%s"
+password-file = "%s"
+`, KeyTypeFile, k.KeyFile, k.PasswordFile), nil
+	default:
+		return "", fmt.Errorf("key type '%s' is not supported", k.Type)
+	}
+}
+
+// writeTomlKeyFile writes the toml key file of the wallet file, which tells the signer how to load its key
+func (p *EthSignerProvider) writeTomlKeyFile(walletFilePath string, key KeySpec) (string, error) {
+	signing, err := key.signingSection()
+	if err != nil {
+		return "", err
+	}
 	toml := fmt.Sprintf(`[metadata]
 createdAt = 2019-11-05T08:15:30-05:00
 description = "File based configuration"
 
-[signing]
-type = "file-based-signer"
-key-file = "/data/keystore/%s"
-password-file = "/data/password"
-`, keyFile)
-	filename := filepath.Join(outputDirectory, fmt.Sprintf("%s.toml", keyFile))
-	return filename, os.WriteFile(filename, []byte(toml), 0755)
+%s`, signing)
+	filename := filepath.Join(filepath.Dir(walletFilePath), fmt.Sprintf("%s.toml", filepath.Base(walletFilePath)))
+	return filename, os.WriteFile(filename, []byte(toml), constants.KeyFileMode)
 }
 
-func (p *EthSignerProvider) copyTomlFileToVolume(ctx context.Context, tomlFilePath, volumeName string) error {
-	if err := docker.MkdirInVolume(ctx, volumeName, "/keystore"); err != nil {
+// copyToVolumeKeystore copies a wallet or toml key file into the keystore directory of the signer volume
+func (p *EthSignerProvider) copyToVolumeKeystore(ctx context.Context, filePath, volumeName string) error {
+	if err := docker.MkdirInVolume(ctx, volumeName, p.volumeKeystoreDirectory()); err != nil {
 		return err
 	}
-	if err := docker.CopyFileToVolume(ctx, volumeName, tomlFilePath, "/keystore"); err != nil {
+	if err := docker.CopyFileToVolume(ctx, volumeName, filePath, p.volumeKeystoreDirectory()); err != nil {
 		return err
 	}
 	return nil
@@ -1,6 +1,7 @@
 package ethsigner
 
 import (
+	"os"
 	"path/filepath"
 	"testing"
 
@@ -14,11 +15,64 @@ func TestWriteTomlKeyFile(t *testing.T) {
 
 		p := &EthSignerProvider{}
 
-		File, err := p.writeTomlKeyFile(FilePath)
+		File, err := p.writeTomlKeyFile(FilePath, p.fileKeySpec(FilePath, "wallet.toml.password"))
 		if err != nil {
 			t.Fatalf("unable to write file: %v", err)
 		}
 		assert.NotNil(t, File)
+		toml, err := os.ReadFile(File)
+		assert.NoError(t, err)
+		assert.Contains(t, string(toml), `password-file = "/data/wallet.toml.password"`)
 	})
 
 }
+
+func TestWriteTomlKeyFileKeySpec(t *testing.T) {
+	keyFile := "1f8ad7b4ea06e3a4cd2a6ae7ef2e3c78a7a6bd2c"
+	walletFile := filepath.Join(t.TempDir(), "keystore", keyFile)
+	assert.NoError(t, os.MkdirAll(filepath.Dir(walletFile), 0700))
+	p := &EthSignerProvider{}
+
+	tomlFile, err := p.writeTomlKeyFile(walletFile, p.fileKeySpec(walletFile, keyFile+".password"))
+	assert.NoError(t, err)
+	assert.Equal(t, walletFile+".toml", tomlFile)
+	toml, err := os.ReadFile(tomlFile)
+	assert.NoError(t, err)
+	assert.Equal(t, `[metadata]
+createdAt = 2019-11-05T08:15:30-05:00
+description = "File based configuration"
+
+[signing]
+type = "file-based-signer"
+key-file = "/data/keystore/1f8ad7b4ea06e3a4cd2a6ae7ef2e3c78a7a6bd2c"
+password-file = "/data/1f8ad7b4ea06e3a4cd2a6ae7ef2e3c78a7a6bd2c.password"
+`, string(toml))
+
+	// A spec without a type is file based
+	_, err = p.writeTomlKeyFile(walletFile, KeySpec{KeyFile: "/data/keystore/key", PasswordFile: "/data/key.password"})
+	assert.NoError(t, err)
+	_, err = p.writeTomlKeyFile(walletFile, KeySpec{Type: KeyTypeFile, KeyFile: "/data/keystore/key"})
+	assert.Regexp(t, "a key file and a password file are required for a 'file-based-signer' key", err)
+	_, err = p.writeTomlKeyFile(walletFile, KeySpec{Type: KeyTypeHSM})
+	assert.Regexp(t, "key type 'hsm-signer' is not supported", err)
+}
+
+func TestWritePasswordFile(t *testing.T) {
+	directory := t.TempDir()
+	p := &EthSignerProvider{}
+
+	password, err := generatePassword()
+	assert.NoError(t, err)
+	assert.Len(t, password, 64)
+
+	otherPassword, err := generatePassword()
+	assert.NoError(t, err)
+	assert.NotEqual(t, password, otherPassword)
+
+	passwordFile, err := p.writePasswordFile(directory, filepath.Join(directory, "keystore", "1234abcd"), password)
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join(directory, "1234abcd.password"), passwordFile)
+	b, err := os.ReadFile(passwordFile)
+	assert.NoError(t, err)
+	assert.Equal(t, password, string(b))
+}
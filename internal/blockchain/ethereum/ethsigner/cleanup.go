@@ -0,0 +1,77 @@
+// Copyright © 2024 Kaleido, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ethsigner
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// createdPaths records the files and directories that are created while writing the config of a stack, so that
+// they can be removed again if writing fails partway through. Anything that already existed is never recorded.
+type createdPaths struct {
+	paths []string
+}
+
+// mkdirAll creates the directory along with any missing parents, recording the outermost one it created
+func (c *createdPaths) mkdirAll(dir string, mode os.FileMode) error {
+	created := ""
+	for d := filepath.Clean(dir); ; d = filepath.Dir(d) {
+		if _, err := os.Stat(d); err == nil {
+			break
+		} else if !os.IsNotExist(err) {
+			return err
+		}
+		created = d
+		if filepath.Dir(d) == d {
+			break
+		}
+	}
+	if err := os.MkdirAll(dir, mode); err != nil {
+		return err
+	}
+	if created != "" {
+		c.paths = append(c.paths, created)
+	}
+	return nil
+}
+
+// track records a file that is about to be written, unless it already exists. It is recorded before it is written
+// so that a write that fails halfway is removed as well.
+func (c *createdPaths) track(filename string) error {
+	if _, err := os.Stat(filename); err == nil {
+		return nil
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	c.paths = append(c.paths, filename)
+	return nil
+}
+
+// remove removes everything that has been created, newest first, and reports any of it that could not be removed
+func (c *createdPaths) remove() error {
+	var errs []error
+	for i := len(c.paths) - 1; i >= 0; i-- {
+		if err := os.RemoveAll(c.paths[i]); err != nil {
+			errs = append(errs, fmt.Errorf("failed to clean up '%s': %s", c.paths[i], err))
+		}
+	}
+	c.paths = nil
+	return errors.Join(errs...)
+}
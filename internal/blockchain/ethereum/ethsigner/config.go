@@ -17,8 +17,17 @@
 package ethsigner
 
 import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
 	"os"
+	"path"
+	"strconv"
+	"strings"
 
+	"github.com/hyperledger/firefly-cli/internal/constants"
+	"github.com/hyperledger/firefly-cli/pkg/types"
 	"gopkg.in/yaml.v2"
 )
 
@@ -46,15 +55,62 @@ type ServerConfig struct {
 	Address string `yaml:"address,omitempty"`
 }
 
+type BackendTLSConfig struct {
+	Enabled bool   `yaml:"enabled,omitempty"`
+	CAFile  string `yaml:"caFile,omitempty"`
+}
+
+type BackendAuthConfig struct {
+	Username string `yaml:"username,omitempty"`
+	Password string `yaml:"password,omitempty"`
+}
+
+type BackendRetryConfig struct {
+	Enabled bool `yaml:"enabled,omitempty"`
+	Count   int  `yaml:"count,omitempty"`
+}
+
 type BackendConfig struct {
-	ChainID *int64 `yaml:"chainId,omitempty"`
-	URL     string `yaml:"url,omitempty"`
+	ChainID           *int64              `yaml:"chainId,omitempty"`
+	URL               string              `yaml:"url,omitempty"`
+	TLS               *BackendTLSConfig   `yaml:"tls,omitempty"`
+	Auth              *BackendAuthConfig  `yaml:"auth,omitempty"`
+	Headers           map[string]string   `yaml:"headers,omitempty"`
+	ConnectionTimeout string              `yaml:"connectionTimeout,omitempty"`
+	Retry             *BackendRetryConfig `yaml:"retry,omitempty"`
+}
+
+type LogJSONConfig struct {
+	Enabled bool `yaml:"enabled"`
 }
 
 type LogConfig struct {
-	Level string `yaml:"level,omitempty"`
+	Level string         `yaml:"level,omitempty"`
+	JSON  *LogJSONConfig `yaml:"json,omitempty"`
+}
+
+// DefaultLogLevel is the level the signer logs at, unless the stack overrides it
+const DefaultLogLevel = "info"
+
+// generateLogConfig returns the log config of firefly-signer for the log settings of a stack, which may be nil
+func generateLogConfig(signerLog *types.SignerLogConfig) LogConfig {
+	config := LogConfig{Level: DefaultLogLevel}
+	if signerLog == nil {
+		return config
+	}
+	if signerLog.Level != "" {
+		config.Level = signerLog.Level
+	}
+	if signerLog.Format == "json" {
+		config.JSON = &LogJSONConfig{Enabled: true}
+	}
+	return config
 }
 
+// MetricsPort is the port in the container of the Java signer that its Prometheus metrics are served on.
+// firefly-signer does not serve metrics.
+const MetricsPort = 6000
+
 type Config struct {
 	Server     ServerConfig     `yaml:"server"`
 	Backend    BackendConfig    `yaml:"backend"`
@@ -62,23 +118,167 @@ type Config struct {
 	Log        LogConfig        `yaml:"log"`
 }
 
+// Validate checks the fields the signer cannot run without, returning every problem found
+func (e *Config) Validate() error {
+	var errs []error
+	if e.Backend.ChainID == nil || *e.Backend.ChainID <= 0 {
+		errs = append(errs, fmt.Errorf("invalid signer config: chain ID must be greater than zero"))
+	}
+	if _, err := parseDownstreamRPC(e.Backend.URL); err != nil {
+		errs = append(errs, fmt.Errorf("invalid signer config: RPC URL '%s': %s", e.Backend.URL, err))
+	}
+	if e.Backend.TLS != nil && e.Backend.TLS.CAFile != "" && !path.IsAbs(e.Backend.TLS.CAFile) {
+		errs = append(errs, fmt.Errorf("invalid signer config: CA file '%s' must be an absolute path", e.Backend.TLS.CAFile))
+	}
+	if !path.IsAbs(e.FileWallet.Path) {
+		errs = append(errs, fmt.Errorf("invalid signer config: keystore directory '%s' must be an absolute path", e.FileWallet.Path))
+	}
+	return errors.Join(errs...)
+}
+
 func (e *Config) WriteConfig(filename string) error {
-	configYamlBytes, _ := yaml.Marshal(e)
-	return os.WriteFile(filename, configYamlBytes, 0755)
+	if err := e.Validate(); err != nil {
+		return err
+	}
+	configYamlBytes, err := e.Marshal()
+	if err != nil {
+		return err
+	}
+	// The config holds the credentials of the backend, so it is only readable by the current user, even if it was
+	// written before with a wider mode
+	if err := os.WriteFile(filename, configYamlBytes, constants.KeyFileMode); err != nil {
+		return err
+	}
+	return os.Chmod(filename, constants.KeyFileMode)
+}
+
+// Marshal returns the config as the YAML that the signer reads
+func (e *Config) Marshal() ([]byte, error) {
+	return yaml.Marshal(e)
 }
 
-func GenerateSignerConfig(chainID int64, rpcURL string) *Config {
+// redacted returns a copy of the config without the credentials for the downstream RPC endpoint, which is
+// safe to print. A password in the URL of the endpoint is masked too.
+func (e *Config) redacted() *Config {
+	c := *e
+	if u, err := url.Parse(c.Backend.URL); err == nil {
+		c.Backend.URL = u.Redacted()
+	}
+	if c.Backend.Auth != nil {
+		c.Backend.Auth = &BackendAuthConfig{Username: c.Backend.Auth.Username, Password: redactedValue}
+	}
+	if c.Backend.Headers != nil {
+		c.Backend.Headers = make(map[string]string, len(e.Backend.Headers))
+		for name := range e.Backend.Headers {
+			c.Backend.Headers[name] = redactedValue
+		}
+	}
+	return &c
+}
+
+const redactedValue = "<redacted>"
+
+// downstreamRPC is the parsed form of the RPC endpoint the signer forwards requests to
+type downstreamRPC struct {
+	URL  *url.URL
+	Host string
+	Port string
+	Path string
+	TLS  bool
+}
+
+// parseDownstreamRPC parses the URL of the RPC endpoint the signer forwards requests to. The host has no
+// brackets if it is an IPv6 address, and no user info, and the port defaults to the one of the scheme.
+func parseDownstreamRPC(rpcURL string) (*downstreamRPC, error) {
+	u, err := url.Parse(rpcURL)
+	if err != nil {
+		return nil, err
+	}
+	if rpcURL == "" || u.Hostname() == "" {
+		return nil, fmt.Errorf("no host in URL")
+	}
+	// Without brackets, the last group of an IPv6 address would be taken as its port
+	if !strings.HasPrefix(u.Host, "[") && strings.Count(u.Host, ":") > 1 {
+		return nil, fmt.Errorf("an IPv6 host must be in brackets, such as http://[::1]:8545")
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("scheme must be http or https")
+	}
+	d := &downstreamRPC{
+		URL:  u,
+		Host: u.Hostname(),
+		Port: u.Port(),
+		TLS:  u.Scheme == "https",
+	}
+	if d.Port == "" {
+		if d.TLS {
+			d.Port = "443"
+		} else {
+			d.Port = "80"
+		}
+	} else if port, err := strconv.Atoi(d.Port); err != nil || port < 1 || port > 65535 {
+		return nil, fmt.Errorf("invalid port '%s'", d.Port)
+	}
+	if u.Path != "" && u.Path != "/" {
+		d.Path = u.Path
+	}
+	return d, nil
+}
+
+// String returns the URL of the downstream RPC endpoint, with the port made explicit
+func (d *downstreamRPC) String() string {
+	u := *d.URL
+	u.Host = net.JoinHostPort(d.Host, d.Port)
+	return u.String()
+}
+
+// GenerateSignerConfig returns the config of firefly-signer. caFile is the path inside the signer container of
+// a CA bundle to trust for an https RPC URL, in addition to the system roots, or empty if there is none.
+func GenerateSignerConfig(chainID int64, rpcURL, keystoreDirectory string, auth *types.RPCAuthConfig, connection *types.RPCConnectionConfig, caFile string) *Config {
+	backend := BackendConfig{
+		URL:     rpcURL,
+		ChainID: &chainID,
+	}
+	if auth != nil {
+		if auth.Username != "" {
+			backend.Auth = &BackendAuthConfig{
+				Username: auth.Username,
+				Password: auth.Password,
+			}
+		}
+		if auth.BearerToken != "" {
+			backend.Headers = map[string]string{
+				"Authorization": fmt.Sprintf("Bearer %s", auth.BearerToken),
+			}
+		}
+	}
+	if connection != nil {
+		// Only the settings that were asked for are written, so the signer keeps its own defaults otherwise
+		backend.ConnectionTimeout = connection.ConnectionTimeout
+		if connection.Retries > 0 {
+			backend.Retry = &BackendRetryConfig{
+				Enabled: true,
+				Count:   connection.Retries,
+			}
+		}
+	}
+	if downstream, err := parseDownstreamRPC(rpcURL); err == nil {
+		backend.URL = downstream.String()
+		if downstream.TLS {
+			backend.TLS = &BackendTLSConfig{
+				Enabled: true,
+				CAFile:  caFile,
+			}
+		}
+	}
 	return &Config{
 		Server: ServerConfig{
 			Port:    8545,
 			Address: "0.0.0.0",
 		},
-		Backend: BackendConfig{
-			URL:     rpcURL,
-			ChainID: &chainID,
-		},
+		Backend: backend,
 		FileWallet: FileWalletConfig{
-			Path: "/data/keystore",
+			Path: keystoreDirectory,
 			Filenames: &FileWalletFilenamesConfig{
 				PrimaryExt: ".toml",
 			},
@@ -87,8 +287,6 @@ func GenerateSignerConfig(chainID int64, rpcURL string) *Config {
 				PasswordFileProperty: `{{ index .signing "password-file" }}`,
 			},
 		},
-		Log: LogConfig{
-			Level: "debug",
-		},
+		Log: generateLogConfig(nil),
 	}
 }
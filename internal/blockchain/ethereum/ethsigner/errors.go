@@ -0,0 +1,65 @@
+// Copyright © 2024 Kaleido, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ethsigner
+
+import (
+	"errors"
+
+	"github.com/hyperledger/firefly-cli/internal/docker"
+)
+
+var (
+	// ErrAccountExists is returned by CreateAccount when the keystore already holds the key
+	ErrAccountExists = errors.New("account already exists")
+	// ErrSignerConfigInvalid is returned when the signer config, or the stack config it is generated from, is
+	// invalid
+	ErrSignerConfigInvalid = errors.New("invalid signer config")
+	// ErrDockerUnavailable is returned when docker is not installed, or its daemon could not be reached
+	ErrDockerUnavailable = errors.New("docker unavailable")
+)
+
+// Error is an error of the signer provider that matches the sentinel error of its Kind with errors.Is, while
+// keeping the message of the underlying error, which errors.Is and errors.As also see through to
+type Error struct {
+	Kind error
+	Err  error
+}
+
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+func (e *Error) Unwrap() []error {
+	return []error{e.Kind, e.Err}
+}
+
+// withKind marks err as an error of the given kind, unless it is nil or already has a kind
+func withKind(kind, err error) error {
+	var signerErr *Error
+	if err == nil || errors.As(err, &signerErr) {
+		return err
+	}
+	return &Error{Kind: kind, Err: err}
+}
+
+// dockerError marks err as ErrDockerUnavailable if it was caused by docker being unavailable
+func dockerError(err error) error {
+	if docker.IsDaemonUnavailable(err) {
+		return withKind(ErrDockerUnavailable, err)
+	}
+	return err
+}
@@ -18,159 +18,937 @@ package ethsigner
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/x509"
 	"encoding/hex"
+	"encoding/pem"
+	"errors"
 	"fmt"
+	"io"
+	"net"
+	"net/http"
 	"net/url"
 	"os"
 	"path"
 	"path/filepath"
+	"slices"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/hyperledger/firefly-cli/internal/blockchain/ethereum"
 	"github.com/hyperledger/firefly-cli/internal/constants"
 	"github.com/hyperledger/firefly-cli/internal/docker"
+	"github.com/hyperledger/firefly-cli/internal/log"
 	"github.com/hyperledger/firefly-cli/pkg/types"
+	"github.com/hyperledger/firefly-signer/pkg/ethtypes"
+	"github.com/hyperledger/firefly-signer/pkg/keystorev3"
+	"github.com/hyperledger/firefly-signer/pkg/secp256k1"
+	"github.com/otiai10/copy"
+	"golang.org/x/sync/errgroup"
 )
 
-// TODO: Probably randomize this and make it different per member?
-var keyPassword = "correcthorsebatterystaple"
+// MinimumImageVersion is the oldest firefly-signer release that supports the keystore layout written by this CLI
+const MinimumImageVersion = "v1.0.0"
 
-const useJavaSigner = false // also need to change the image appropriately if you recompile to use the Java signer
+// legacyPasswordFile is the single shared password file written by older versions of the CLI,
+// before a separate random password was generated for each account
+const legacyPasswordFile = "password"
+
+// importWorkers bounds the number of accounts that are imported into the signer volume concurrently
+const importWorkers = 4
+
+// DefaultKeystoreDirectory is where the signer container reads keys from, unless the stack overrides it for
+// a custom signer image. The signer volume is mounted at the parent directory, which also holds the passwords.
+const DefaultKeystoreDirectory = "/data/keystore"
+
+// signerUID and signerGID are the user the signer container runs as, which must own the password files
+const (
+	signerUID = 0
+	signerGID = 0
+)
+
+// downstreamCAFile is the name of the CA bundle for the downstream RPC endpoint, both in the config directory
+// of the stack and in the signer config volume, which is mounted at signerConfigDirectory
+const (
+	downstreamCAFile      = "downstream-ca.pem"
+	signerConfigDirectory = "/etc/firefly"
+)
+
+// DefaultBindAddress is the host interface the signer port is published on, unless the stack overrides it.
+// The signer does not authenticate its callers, so by default it is only reachable from the local machine.
+const DefaultBindAddress = "127.0.0.1"
+
+// JavaSignerImage is the image of the Java based EthSigner, used by stacks with the java-ethsigner signer
+// type unless the signer image is overridden. It is pinned to a release, as the flags the CLI passes differ
+// between releases.
+const JavaSignerImage = "consensys/ethsigner:22.1.3"
+
+// resourceLimits is the default limit of the signer container, which is enough for the JVM of the Java signer
+var resourceLimits = &docker.ResourceLimits{CPUs: "1", Memory: "1g"}
 
 type EthSignerProvider struct {
 	ctx   context.Context
 	stack *types.Stack
+	// DryRun makes WriteConfig and FirstTimeSetup print the files they would write and the docker commands
+	// they would run, rather than changing anything
+	DryRun bool
+	// out is where dry run output and the printed signer config are written, which is stdout unless
+	// overridden in tests
+	out io.Writer
+	// KeystoreDirectory is the absolute path of the keystore inside the signer container, or
+	// DefaultKeystoreDirectory if empty
+	KeystoreDirectory string
+	// HTTPClient sends the requests of VerifySigner, or a client with a timeout of verifyTimeout if nil
+	HTTPClient *http.Client
 }
 
 func NewEthSignerProvider(ctx context.Context, stack *types.Stack) *EthSignerProvider {
 	return &EthSignerProvider{
-		ctx:   ctx,
-		stack: stack,
+		ctx:               ctx,
+		stack:             stack,
+		KeystoreDirectory: stack.SignerKeystoreDirectory,
+	}
+}
+
+// ValidateKeystoreDirectory checks that a keystore directory override is an absolute path in the signer
+// container, at least two levels below the root, as the signer volume is mounted at its parent, which would hide
+// the root directory of the image if it were the root directory
+func ValidateKeystoreDirectory(directory string) error {
+	if !path.IsAbs(directory) {
+		return fmt.Errorf("invalid signer keystore directory '%s': must be an absolute path", directory)
+	}
+	directory = path.Clean(directory)
+	if directory == "/" {
+		return fmt.Errorf("invalid signer keystore directory '%s': cannot be the root directory", directory)
+	}
+	if path.Dir(directory) == "/" {
+		return fmt.Errorf("invalid signer keystore directory '%s': must be below a directory other than the root, such as /data%s", directory, directory)
+	}
+	return nil
+}
+
+// ValidateBindAddress checks that the host interface to publish the signer port on is an IP address
+func ValidateBindAddress(address string) error {
+	if net.ParseIP(address) == nil {
+		return fmt.Errorf("invalid signer bind address '%s': must be an IP address such as %s or 0.0.0.0", address, DefaultBindAddress)
+	}
+	return nil
+}
+
+// portMapping returns the docker port mapping that publishes the signer on the bind address of the stack
+func (p *EthSignerProvider) portMapping() string {
+	return p.bindPortMapping(p.stack.ExposedBlockchainPort, 8545)
+}
+
+// bindPortMapping returns the docker port mapping that publishes a port of the signer container on the bind
+// address of the stack
+func (p *EthSignerProvider) bindPortMapping(hostPort, containerPort int) string {
+	address := p.stack.SignerBindAddress
+	if address == "" {
+		address = DefaultBindAddress
+	}
+	if ip := net.ParseIP(address); ip != nil && ip.To4() == nil {
+		address = "[" + address + "]"
+	}
+	return fmt.Sprintf("%s:%d:%d", address, hostPort, containerPort)
+}
+
+// ports returns the port mappings of the signer service, which publish its metrics too if they are enabled
+func (p *EthSignerProvider) ports() []string {
+	ports := []string{p.portMapping()}
+	if p.stack.SignerMetricsEnabled {
+		ports = append(ports, p.bindPortMapping(p.stack.ExposedSignerMetricsPort, MetricsPort))
+	}
+	return ports
+}
+
+// ValidateCACertificate checks that a CA bundle for the downstream RPC endpoint is a readable PEM file
+// containing at least one certificate, and that every certificate in it can be parsed
+func ValidateCACertificate(filename string) error {
+	b, err := os.ReadFile(filename)
+	if err != nil {
+		return fmt.Errorf("invalid downstream RPC CA certificate '%s': %s", filename, err)
+	}
+	certificates := 0
+	for block, rest := pem.Decode(b); block != nil; block, rest = pem.Decode(rest) {
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		if _, err := x509.ParseCertificate(block.Bytes); err != nil {
+			return fmt.Errorf("invalid downstream RPC CA certificate '%s': %s", filename, err)
+		}
+		certificates++
 	}
+	if certificates == 0 {
+		return fmt.Errorf("invalid downstream RPC CA certificate '%s': no PEM encoded certificates found", filename)
+	}
+	return nil
 }
 
-func (p *EthSignerProvider) WriteConfig(options *types.InitOptions, rpcURL string) error {
+// caFile returns the path of the downstream RPC CA bundle inside the signer container, or empty if the stack
+// does not have one
+func (p *EthSignerProvider) caFile() string {
+	if p.stack.DownstreamRPCCACert == "" {
+		return ""
+	}
+	return path.Join(signerConfigDirectory, downstreamCAFile)
+}
+
+func (p *EthSignerProvider) keystoreDirectory() string {
+	if p.KeystoreDirectory != "" {
+		return path.Clean(p.KeystoreDirectory)
+	}
+	return DefaultKeystoreDirectory
+}
+
+// dataDirectory is where the signer volume is mounted in the container
+func (p *EthSignerProvider) dataDirectory() string {
+	return path.Dir(p.keystoreDirectory())
+}
+
+// volumeKeystoreDirectory is the location of the keystore within the signer volume
+func (p *EthSignerProvider) volumeKeystoreDirectory() string {
+	return "/" + path.Base(p.keystoreDirectory())
+}
+
+func (p *EthSignerProvider) dryRunOutput() io.Writer {
+	if p.out != nil {
+		return p.out
+	}
+	return os.Stdout
+}
+
+// dockerContext returns the context for docker commands, which are printed instead of run in dry run mode
+func (p *EthSignerProvider) dockerContext() context.Context {
+	if p.DryRun {
+		return docker.WithDryRun(p.ctx, p.dryRunOutput())
+	}
+	return p.ctx
+}
+
+// ValidateRemoteSignerURL checks that the URL of an externally provided signer looks like a JSON/RPC endpoint
+func ValidateRemoteSignerURL(remoteSignerURL string) error {
+	u, err := url.Parse(remoteSignerURL)
+	if err != nil {
+		return fmt.Errorf("invalid remote signer URL '%s': %s", remoteSignerURL, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("invalid remote signer URL '%s': scheme must be http or https", remoteSignerURL)
+	}
+	if u.Host == "" {
+		return fmt.Errorf("invalid remote signer URL '%s': missing host", remoteSignerURL)
+	}
+	return nil
+}
+
+// IsRemote returns whether the stack uses an externally provided signer, in which case no ethsigner is deployed
+func (p *EthSignerProvider) IsRemote() bool {
+	return p.stack.RemoteSignerURL != ""
+}
+
+// URL returns the JSON/RPC endpoint of the signer that the blockchain connectors submit transactions to
+func (p *EthSignerProvider) URL() string {
+	if p.IsRemote() {
+		return p.stack.RemoteSignerURL
+	}
+	if p.IsShared() {
+		// The stacks that share the signer reach it by its container name on their external network
+		return fmt.Sprintf("http://%s:8545", p.containerName())
+	}
+	return "http://ethsigner:8545"
+}
+
+// DependentServices returns the services that the blockchain connectors must wait for before starting
+func (p *EthSignerProvider) DependentServices() map[string]string {
+	if !p.runsSigner() {
+		return map[string]string{}
+	}
+	return map[string]string{"ethsigner": "service_healthy"}
+}
+
+func (p *EthSignerProvider) WriteConfig(options *types.InitOptions, rpcURL string) (err error) {
+	if err := ethereum.ValidateUniqueAddresses(p.stack.Members); err != nil {
+		return withKind(ErrSignerConfigInvalid, err)
+	}
+	if err := ethereum.ValidateSigningMembers(p.stack.Members); err != nil {
+		return withKind(ErrSignerConfigInvalid, err)
+	}
+	if p.IsRemote() {
+		// The remote signer holds the keys, so there is nothing to write
+		return nil
+	}
+
+	// The chain ID of the stack is used for both the signer config and the signer command, so check
+	// that it has not diverged from the options the stack is being initialized with
+	chainID := p.stack.ChainID()
+	if options.ChainID != chainID {
+		return withKind(ErrSignerConfigInvalid, fmt.Errorf("chain ID %d does not match the chain ID %d of stack '%s'", options.ChainID, chainID, p.stack.Name))
+	}
+	generatedArgs, err := p.generatedArgs(rpcURL)
+	if err != nil {
+		return withKind(ErrSignerConfigInvalid, err)
+	}
+	for _, flag := range ConflictingArgs(generatedArgs, p.stack.SignerExtraArgs) {
+		log.LoggerFromContext(p.ctx).Warn(fmt.Sprintf("the extra signer argument %s is already set by the CLI, so the signer is passed it twice", flag))
+	}
 
-	// Write the password that will be used to encrypt the private key
+	// Anything written here is removed again if writing fails partway through, so that a failed init does not
+	// leave a half written config or keystore directory behind to confuse the next run
+	created := &createdPaths{}
+	defer func() {
+		if err != nil {
+			if cleanupErr := created.remove(); cleanupErr != nil {
+				err = errors.Join(err, cleanupErr)
+			}
+		}
+	}()
+
+	// The passwords used to encrypt each private key are written alongside the keys in CreateAccount
 	initDir := filepath.Join(constants.StacksDir, p.stack.Name, "init")
 	blockchainDirectory := filepath.Join(initDir, "blockchain")
-	if err := os.MkdirAll(blockchainDirectory, 0755); err != nil {
+	caFilePath := filepath.Join(initDir, "config", downstreamCAFile)
+	if p.stack.DownstreamRPCCACert != "" && !p.DryRun {
+		if err := created.track(caFilePath); err != nil {
+			return err
+		}
+	}
+	if err := p.writeCAFile(caFilePath); err != nil {
 		return err
 	}
-	if err := os.WriteFile(filepath.Join(initDir, "blockchain", "password"), []byte(keyPassword), 0755); err != nil {
+	if p.useJavaSigner() {
+		// The Java signer is configured entirely by its command, so only the keystore is needed
+		if p.DryRun {
+			_, err := fmt.Fprintf(p.dryRunOutput(), "mkdir -p %s\n", blockchainDirectory)
+			return err
+		}
+		return created.mkdirAll(blockchainDirectory, constants.KeyDirectoryMode)
+	}
+	signerConfigPath := filepath.Join(initDir, "config", "ethsigner.yaml")
+	signerConfig, err := p.SignerConfig(rpcURL)
+	if err != nil {
 		return err
 	}
 
-	signerConfigPath := filepath.Join(initDir, "config", "ethsigner.yaml")
-	if err := GenerateSignerConfig(options.ChainID, rpcURL).WriteConfig(signerConfigPath); err != nil {
+	if p.DryRun {
+		configYamlBytes, err := signerConfig.redacted().Marshal()
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(p.dryRunOutput(), "mkdir -p %s\nwrite %s\n%s", blockchainDirectory, signerConfigPath, configYamlBytes)
+		return err
+	}
+	if options.PrintSignerConfig {
+		configYamlBytes, err := signerConfig.redacted().Marshal()
+		if err != nil {
+			return err
+		}
+		if _, err := p.dryRunOutput().Write(configYamlBytes); err != nil {
+			return err
+		}
+	}
+
+	if err := created.mkdirAll(blockchainDirectory, constants.KeyDirectoryMode); err != nil {
+		return err
+	}
+	if err := created.track(signerConfigPath); err != nil {
+		return err
+	}
+	return signerConfig.WriteConfig(signerConfigPath)
+}
+
+// SignerConfig returns the config of firefly-signer for the stack, forwarding to rpcURL, without writing it
+// anywhere. It can be marshaled with Config.Marshal, or written to any path with Config.WriteConfig.
+func (p *EthSignerProvider) SignerConfig(rpcURL string) (*Config, error) {
+	signerConfig := GenerateSignerConfig(p.stack.ChainID(), rpcURL, p.keystoreDirectory(), p.stack.DownstreamRPCAuth, p.stack.DownstreamRPCConnection, p.caFile())
+	signerConfig.Log = generateLogConfig(p.stack.SignerLog)
+	if err := signerConfig.Validate(); err != nil {
+		return nil, withKind(ErrSignerConfigInvalid, err)
+	}
+	return signerConfig, nil
+}
+
+// writeCAFile copies the downstream RPC CA bundle of the stack into its config directory, so that the stack
+// keeps working if the original file is moved, and it can be copied into the signer config volume later
+func (p *EthSignerProvider) writeCAFile(filename string) error {
+	if p.stack.DownstreamRPCCACert == "" {
 		return nil
 	}
+	if p.DryRun {
+		_, err := fmt.Fprintf(p.dryRunOutput(), "copy %s %s\n", p.stack.DownstreamRPCCACert, filename)
+		return err
+	}
+	b, err := os.ReadFile(p.stack.DownstreamRPCCACert)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filename, b, 0644)
+}
+
+// runtimeFile is a file that FirstTimeSetup reads from the runtime directory of the stack. Its names are
+// alternatives relative to the init and runtime directories, any one of which will do.
+type runtimeFile struct {
+	names    []string
+	optional bool
+}
+
+// runtimeFiles returns the files that FirstTimeSetup copies into the signer volumes
+func (p *EthSignerProvider) runtimeFiles() []runtimeFile {
+	files := []runtimeFile{}
+	if !p.useJavaSigner() {
+		files = append(files, runtimeFile{names: []string{filepath.Join("config", "ethsigner.yaml")}})
+	}
+	if p.stack.DownstreamRPCCACert != "" {
+		files = append(files, runtimeFile{names: []string{filepath.Join("config", downstreamCAFile)}})
+	}
+	for _, member := range p.stack.Members {
+		for _, account := range member.SigningAccounts() {
+			a, ok := account.(*ethereum.Account)
+			if !ok {
+				continue
+			}
+			keyFile := strings.ToLower(strings.TrimPrefix(a.Address, "0x"))
+			// Stacks initialized by older versions of the CLI have no toml key files, and a shared password
+			files = append(files,
+				runtimeFile{names: []string{filepath.Join("blockchain", "keystore", keyFile)}},
+				runtimeFile{names: []string{filepath.Join("blockchain", "keystore", fmt.Sprintf("%s.toml", keyFile))}, optional: true},
+			)
+			if p.plaintextPasswords() {
+				files = append(files, runtimeFile{names: []string{filepath.Join("blockchain", fmt.Sprintf("%s.password", keyFile)), filepath.Join("blockchain", legacyPasswordFile)}})
+			}
+		}
+	}
+	return files
+}
 
+// ensureRuntimeFiles checks that the files FirstTimeSetup needs are in the runtime directory of the stack,
+// restoring any that are missing from the init directory, which is where WriteConfig created them
+func (p *EthSignerProvider) ensureRuntimeFiles() error {
+	var missing []string
+	for _, file := range p.runtimeFiles() {
+		name, err := firstExistingFile(p.stack.RuntimeDir, file.names)
+		if err != nil {
+			return err
+		}
+		if name != "" {
+			continue
+		}
+		if name, err = firstExistingFile(p.stack.InitDir, file.names); err != nil {
+			return err
+		}
+		if name == "" {
+			if !file.optional {
+				missing = append(missing, file.names[0])
+			}
+			continue
+		}
+		log.LoggerFromContext(p.ctx).Info(fmt.Sprintf("restoring %s to the runtime directory of stack '%s'", name, p.stack.Name))
+		dest := filepath.Join(p.stack.RuntimeDir, name)
+		if err := os.MkdirAll(filepath.Dir(dest), constants.KeyDirectoryMode); err != nil {
+			return err
+		}
+		if err := copy.Copy(filepath.Join(p.stack.InitDir, name), dest); err != nil {
+			return err
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("stack '%s' is missing %s from both its init and runtime directories. Remove the stack and run 'ff init' to create it again", p.stack.Name, strings.Join(missing, ", "))
+	}
 	return nil
 }
 
-func (p *EthSignerProvider) FirstTimeSetup() error {
-	ethsignerVolumeName := fmt.Sprintf("%s_ethsigner", p.stack.Name)
+// firstExistingFile returns the first of names that exists in dir, or empty if none of them do
+func firstExistingFile(dir string, names []string) (string, error) {
+	for _, name := range names {
+		if _, err := os.Stat(filepath.Join(dir, name)); err == nil {
+			return name, nil
+		} else if !os.IsNotExist(err) {
+			return "", err
+		}
+	}
+	return "", nil
+}
+
+func (p *EthSignerProvider) FirstTimeSetup() (err error) {
+	defer func() { err = dockerError(err) }()
+	// The stack config may have been edited by hand since it was initialized
+	if err := ethereum.ValidateUniqueAddresses(p.stack.Members); err != nil {
+		return withKind(ErrSignerConfigInvalid, err)
+	}
+	if err := ethereum.ValidateSigningMembers(p.stack.Members); err != nil {
+		return withKind(ErrSignerConfigInvalid, err)
+	}
+	if p.IsRemote() {
+		return nil
+	}
+	// Nothing is written to the init directory in dry run mode, so there is nothing to check
+	if !p.DryRun {
+		if err := p.ensureRuntimeFiles(); err != nil {
+			return err
+		}
+	}
+	ctx := p.dockerContext()
+
+	ethsignerVolumeName := p.volumeName()
 	blockchainDir := filepath.Join(p.stack.RuntimeDir, "blockchain")
+	keystoreDir := filepath.Join(blockchainDir, "keystore")
 	contractsDir := filepath.Join(p.stack.RuntimeDir, "contracts")
 
-	if err := docker.CreateVolume(p.ctx, ethsignerVolumeName); err != nil {
+	volumeExists, err := docker.VolumeExists(ctx, ethsignerVolumeName)
+	if err != nil {
 		return err
 	}
+	if !volumeExists {
+		// A shared signer volume outlives the stack that created it, so it is not labelled as part of the stack
+		var labels []string
+		if !p.IsShared() {
+			labels = append(labels, docker.StackLabel(p.stack.Name))
+		}
+		done := log.ProgressStep(ctx, "creating signer volume", 0, 0)
+		if err := docker.CreateVolume(ctx, ethsignerVolumeName, labels...); err != nil {
+			return err
+		}
+		done()
+	}
+	if p.IsShared() {
+		// Compose only references the volumes of a shared signer, so they must exist before the stack starts
+		done := log.ProgressStep(ctx, "creating signer config volume", 0, 0)
+		if err := docker.CreateVolume(ctx, p.configVolumeName()); err != nil {
+			return err
+		}
+		done()
+		if err := p.checkSharedChainID(ctx, ethsignerVolumeName); err != nil {
+			return err
+		}
+	}
 
-	if err := os.MkdirAll(contractsDir, 0755); err != nil {
+	if p.DryRun {
+		if _, err := fmt.Fprintf(p.dryRunOutput(), "mkdir -p %s\n", contractsDir); err != nil {
+			return err
+		}
+	} else if err := os.MkdirAll(contractsDir, 0755); err != nil {
 		return err
 	}
 
-	// Copy the signer config to the volume
-	signerConfigPath := filepath.Join(p.stack.StackDir, "runtime", "config", "ethsigner.yaml")
-	signerConfigVolumeName := fmt.Sprintf("%s_ethsigner_config", p.stack.Name)
-	if err := docker.CopyFileToVolume(p.ctx, signerConfigVolumeName, signerConfigPath, "firefly.ffsigner"); err != nil {
+	// Copy the signer config to the volume. The signer is configured by the stack that runs it, and the
+	// other stacks that share it only import their accounts.
+	if p.runsSigner() {
+		done := log.ProgressStep(ctx, "copying signer config", 0, 0)
+		if err := p.copyConfigToVolume(ctx); err != nil {
+			return err
+		}
+		done()
+	}
+
+	// A previous setup that was interrupted may have already imported some of the accounts
+	imported := map[string]bool{}
+	if volumeExists {
+		entries, err := docker.ListFilesInVolume(ctx, ethsignerVolumeName, p.volumeKeystoreDirectory())
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			imported[entry] = true
+		}
+	}
+	walletFiles, err := missingWalletFiles(keystoreDir, imported)
+	if err != nil {
 		return err
 	}
+	if len(walletFiles) == 0 {
+		return nil
+	}
 
-	// Copy the wallet files all members to the blockchain volume
-	if err := docker.CopyFileToVolume(p.ctx, ethsignerVolumeName, filepath.Join(blockchainDir, "keystore"), "/"); err != nil {
+	// Copy the passwords (to be used for decrypting private keys) before the keystore entries, so that
+	// an account is only considered imported once everything it needs is in the volume
+	passwordFiles := []string{}
+	for _, walletFile := range walletFiles {
+		passwordFile := filepath.Join(blockchainDir, fmt.Sprintf("%s.password", filepath.Base(walletFile)))
+		if _, err := os.Stat(passwordFile); err == nil {
+			passwordFiles = append(passwordFiles, passwordFile)
+		} else if !os.IsNotExist(err) {
+			return err
+		}
+	}
+	done := log.ProgressStep(ctx, "importing passwords", 0, 0)
+	if err := p.importPasswordFiles(ctx, ethsignerVolumeName, passwordFiles); err != nil {
 		return err
 	}
 
-	// Copy the password (to be used for decrypting private keys)
-	if err := docker.CopyFileToVolume(p.ctx, ethsignerVolumeName, path.Join(blockchainDir, "password"), "password"); err != nil {
+	// Stacks initialized by older versions of the CLI share a single password file for all keys
+	legacyPasswordPath := path.Join(blockchainDir, legacyPasswordFile)
+	if _, err := os.Stat(legacyPasswordPath); err == nil {
+		if err := docker.CopyFileToVolume(ctx, ethsignerVolumeName, legacyPasswordPath, legacyPasswordFile, passwordFileOptions()...); err != nil {
+			return err
+		}
+	} else if !os.IsNotExist(err) {
 		return err
 	}
+	done()
 
+	// Copy the wallet files all members to the blockchain volume. When nothing has been imported yet
+	// the whole keystore is copied at once, otherwise only the missing accounts are copied. The keystore can
+	// only be copied at once when its name in the volume matches the local directory.
+	if len(imported) == 0 && p.volumeKeystoreDirectory() == "/"+filepath.Base(keystoreDir) {
+		done := log.ProgressStep(ctx, fmt.Sprintf("importing %d accounts", len(walletFiles)), 0, 0)
+		if err := docker.CopyFileToVolume(ctx, ethsignerVolumeName, keystoreDir, "/"); err != nil {
+			return err
+		}
+		done()
+		return nil
+	}
+	return p.importConcurrently(walletFiles, func(i int, walletFile string) error {
+		done := log.ProgressStep(ctx, "importing account", i+1, len(walletFiles))
+		if err := p.copyToVolumeKeystore(ctx, walletFile, ethsignerVolumeName); err != nil {
+			return fmt.Errorf("failed to import account '%s': %s", filepath.Base(walletFile), err)
+		}
+		tomlFile := fmt.Sprintf("%s.toml", walletFile)
+		if _, err := os.Stat(tomlFile); err == nil {
+			if err := p.copyToVolumeKeystore(ctx, tomlFile, ethsignerVolumeName); err != nil {
+				return fmt.Errorf("failed to import account '%s': %s", filepath.Base(walletFile), err)
+			}
+		} else if !os.IsNotExist(err) {
+			return err
+		}
+		done()
+		return nil
+	})
+}
+
+// copyConfigToVolume copies the config files of the signer into its config volume
+func (p *EthSignerProvider) copyConfigToVolume(ctx context.Context) error {
+	signerConfigVolumeName := p.configVolumeName()
+	if !p.useJavaSigner() {
+		signerConfigPath := filepath.Join(p.stack.StackDir, "runtime", "config", "ethsigner.yaml")
+		if err := docker.CopyFileToVolume(ctx, signerConfigVolumeName, signerConfigPath, "firefly.ffsigner"); err != nil {
+			return err
+		}
+	}
+	if p.stack.DownstreamRPCCACert != "" {
+		caFilePath := filepath.Join(p.stack.StackDir, "runtime", "config", downstreamCAFile)
+		if err := docker.CopyFileToVolume(ctx, signerConfigVolumeName, caFilePath, downstreamCAFile); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
-func (p *EthSignerProvider) getCommand(rpcURL string) string {
-	if !useJavaSigner {
-		return ""
+// missingWalletFiles returns the paths of the wallet files in the keystore directory that have not
+// been imported yet. A wallet is imported once both it and its toml key file (if any) are in the volume.
+func missingWalletFiles(keystoreDir string, imported map[string]bool) ([]string, error) {
+	entries, err := os.ReadDir(keystoreDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	local := map[string]bool{}
+	for _, entry := range entries {
+		local[entry.Name()] = true
+	}
+	walletFiles := []string{}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || strings.HasSuffix(name, ".toml") {
+			continue
+		}
+		tomlFile := fmt.Sprintf("%s.toml", name)
+		if imported[name] && (imported[tomlFile] || !local[tomlFile]) {
+			continue
+		}
+		walletFiles = append(walletFiles, filepath.Join(keystoreDir, name))
+	}
+	return walletFiles, nil
+}
+
+// importPasswordFiles copies the password file of each account into the signer volume
+func (p *EthSignerProvider) importPasswordFiles(ctx context.Context, volumeName string, passwordFiles []string) error {
+	return p.importConcurrently(passwordFiles, func(_ int, passwordFile string) error {
+		if err := docker.CopyFileToVolume(ctx, volumeName, passwordFile, filepath.Base(passwordFile), passwordFileOptions()...); err != nil {
+			return fmt.Errorf("failed to import password file '%s': %s", filepath.Base(passwordFile), err)
+		}
+		return nil
+	})
+}
+
+// importConcurrently calls importFile with each of the files and its index. Each import runs at least one
+// container, so they are run concurrently with a bounded number of workers, and all of the failures are
+// reported together.
+func (p *EthSignerProvider) importConcurrently(files []string, importFile func(i int, file string) error) error {
+	var mux sync.Mutex
+	var importErrors []error
+	g := &errgroup.Group{}
+	if p.DryRun {
+		// Keep the order of the printed commands stable
+		g.SetLimit(1)
+	} else {
+		g.SetLimit(importWorkers)
+	}
+	for i, file := range files {
+		i, file := i, file
+		g.Go(func() error {
+			if err := importFile(i, file); err != nil {
+				mux.Lock()
+				defer mux.Unlock()
+				importErrors = append(importErrors, err)
+			}
+			return nil
+		})
+	}
+	_ = g.Wait()
+	return errors.Join(importErrors...)
+}
+
+// passwordFileOptions make the password files in the signer volume readable only by the signer
+func passwordFileOptions() []docker.CopyOption {
+	return []docker.CopyOption{docker.WithOwner(signerUID, signerGID), docker.WithMode(constants.KeyFileMode)}
+}
+
+// useJavaSigner returns whether the stack runs the Java based EthSigner, which is configured with command
+// line parameters rather than ethsigner.yaml
+func (p *EthSignerProvider) useJavaSigner() bool {
+	return p.stack.SignerType.Equals(types.SignerTypeJava)
+}
+
+// javaSignerSubcommand is the subcommand of the Java signer that serves the keys of a keystore directory
+const javaSignerSubcommand = "multikey-signer"
+
+// getCommand returns the command of the signer container, which is the generated arguments with any extra
+// arguments from the stack config. The extra arguments are options of the signer itself, so for the Java signer
+// they go before its subcommand, which only accepts the options of the subcommand.
+func (p *EthSignerProvider) getCommand(rpcURL string) (string, error) {
+	args, err := p.generatedArgs(rpcURL)
+	if err != nil {
+		return "", err
+	}
+	if i := slices.Index(args, javaSignerSubcommand); i >= 0 {
+		args = slices.Insert(args, i, p.stack.SignerExtraArgs...)
+	} else {
+		args = append(args, p.stack.SignerExtraArgs...)
+	}
+	return strings.Join(args, " "), nil
+}
+
+// generatedArgs returns the arguments the CLI passes to the signer. firefly-signer reads its config from the
+// config volume, so it needs none.
+func (p *EthSignerProvider) generatedArgs(rpcURL string) ([]string, error) {
+	if !p.useJavaSigner() {
+		return nil, nil
 	}
 
 	// The Java based signing runtime if swapped in, requires these command line parameters
-	u, err := url.Parse(rpcURL)
-	if err != nil || rpcURL == "" {
-		panic(fmt.Errorf("RPC URL invalid '%s': %s", rpcURL, err))
+	downstream, err := parseDownstreamRPC(rpcURL)
+	if err != nil {
+		return nil, fmt.Errorf("RPC URL invalid '%s': %s", rpcURL, err)
 	}
 	ethsignerCommand := []string{}
-	ethsignerCommand = append(ethsignerCommand, "--logging=DEBUG")
+	// The Java signer only logs text, and names its levels in upper case
+	ethsignerCommand = append(ethsignerCommand, fmt.Sprintf("--logging=%s", strings.ToUpper(generateLogConfig(p.stack.SignerLog).Level)))
+	if p.stack.SignerMetricsEnabled {
+		ethsignerCommand = append(ethsignerCommand, `--metrics-enabled`, `--metrics-host=0.0.0.0`, fmt.Sprintf(`--metrics-port=%d`, MetricsPort))
+	}
 	ethsignerCommand = append(ethsignerCommand, fmt.Sprintf(`--chain-id=%d`, p.stack.ChainID()))
-	ethsignerCommand = append(ethsignerCommand, fmt.Sprintf(`--downstream-http-host=%s`, u.Hostname()))
-	port := u.Port()
-	if u.Scheme == "https" {
+	ethsignerCommand = append(ethsignerCommand, fmt.Sprintf(`--downstream-http-host=%s`, downstream.Host))
+	if downstream.TLS {
 		ethsignerCommand = append(ethsignerCommand, `--downstream-http-tls-enabled`)
-		if port == "" {
-			port = "443"
+		if caFile := p.caFile(); caFile != "" {
+			ethsignerCommand = append(ethsignerCommand, fmt.Sprintf(`--downstream-http-tls-ca-file=%s`, caFile))
+		}
+	}
+	if downstream.Path != "" {
+		ethsignerCommand = append(ethsignerCommand, fmt.Sprintf(`--downstream-http-path=%s`, downstream.Path))
+	}
+	ethsignerCommand = append(ethsignerCommand, fmt.Sprintf(`--downstream-http-port=%s`, downstream.Port))
+	// The Java signer has no flags for downstream credentials or retries, so init rejects them for this signer
+	ethsignerCommand = append(ethsignerCommand, javaSignerSubcommand)
+	ethsignerCommand = append(ethsignerCommand, fmt.Sprintf(`--directory=%s`, p.keystoreDirectory()))
+	return ethsignerCommand, nil
+}
+
+// ConflictingArgs returns the flags in extra that are also in generated, whatever their values. A flag that is
+// passed twice either fails the signer or silently overrides the value the CLI chose.
+func ConflictingArgs(generated, extra []string) []string {
+	set := make(map[string]bool)
+	for _, arg := range generated {
+		if flag := flagName(arg); flag != "" {
+			set[flag] = true
+		}
+	}
+	conflicts := []string{}
+	for _, arg := range extra {
+		if flag := flagName(arg); flag != "" && set[flag] {
+			conflicts = append(conflicts, flag)
 		}
 	}
-	if u.Path != "" && u.Path != "/" {
-		ethsignerCommand = append(ethsignerCommand, fmt.Sprintf(`--downstream-http-path=%s`, u.Path))
+	return conflicts
+}
+
+// flagName returns the name of the flag in a --name=value or --name argument, or "" if it is not a flag
+func flagName(arg string) string {
+	if !strings.HasPrefix(arg, "-") {
+		return ""
+	}
+	name, _, _ := strings.Cut(arg, "=")
+	return name
+}
+
+func (p *EthSignerProvider) containerName() string {
+	if p.IsShared() {
+		return SharedSignerContainerName(p.stack.SharedSigner)
 	}
-	ethsignerCommand = append(ethsignerCommand, fmt.Sprintf(`--downstream-http-port=%s`, port))
-	ethsignerCommand = append(ethsignerCommand, `multikey-signer`)
-	ethsignerCommand = append(ethsignerCommand, `--directory=/data/keystore`)
-	return strings.Join(ethsignerCommand, " ")
+	return fmt.Sprintf("%s_ethsigner", p.stack.Name)
 }
 
-func (p *EthSignerProvider) GetDockerServiceDefinition(rpcURL string) *docker.ServiceDefinition {
-	return &docker.ServiceDefinition{
+// healthTimeout is how long WaitUntilHealthy waits for the signer, which is as long as its default health
+// check keeps retrying before docker marks it unhealthy
+const healthTimeout = 15 * time.Minute
+
+var waitForHealthy = docker.WaitForHealthy
+
+// WaitUntilHealthy blocks until the health check of the ethsigner container passes. It fails as soon as the
+// container exits or docker marks it unhealthy, rather than waiting out a fixed delay.
+func (p *EthSignerProvider) WaitUntilHealthy() error {
+	if p.IsRemote() {
+		return nil
+	}
+	if err := waitForHealthy(p.ctx, p.containerName(), healthTimeout); err != nil {
+		return fmt.Errorf("ethsigner is not ready: %w", err)
+	}
+	return nil
+}
+
+// healthCheckTest returns the test of the signer health check. By default it only calls net_version, which the
+// signer proxies to the blockchain node. The accounts probe calls eth_accounts, which the signer answers from its
+// keystore, and only passes once the address of every account of every member is in the answer.
+func (p *EthSignerProvider) healthCheckTest() []string {
+	if p.stack.SignerHealthCheck != types.SignerHealthCheckAccounts {
+		return []string{
+			"CMD",
+			"curl",
+			"-X", "POST",
+			"-H", "Content-Type: application/json",
+			"-d", `{"jsonrpc":"2.0","method":"net_version","params":[],"id":"1"}`,
+			"-w", "%{http_code}",
+			"-sS",
+			"--fail",
+			"http://localhost:8545/",
+		}
+	}
+	const accountsFile = "/tmp/healthcheck-accounts"
+	checks := []string{
+		fmt.Sprintf(`curl -X POST -H 'Content-Type: application/json' -d '{"jsonrpc":"2.0","method":"eth_accounts","params":[],"id":"1"}' -sS --fail -o %s http://localhost:8545/`, accountsFile),
+	}
+	for _, address := range p.memberAddresses() {
+		checks = append(checks, fmt.Sprintf("grep -qi %s %s", address, accountsFile))
+	}
+	return []string{"CMD-SHELL", strings.Join(checks, " && ")}
+}
+
+// memberAddresses returns the addresses of the accounts of every signing member of the stack
+func (p *EthSignerProvider) memberAddresses() []string {
+	addresses := []string{}
+	for _, member := range p.stack.Members {
+		for _, account := range member.SigningAccounts() {
+			if a, ok := account.(*ethereum.Account); ok && a.Address != "" {
+				addresses = append(addresses, a.Address)
+			}
+		}
+	}
+	return addresses
+}
+
+// GetDockerServiceDefinition returns the ethsigner service, or nil if the stack uses a remote signer. It fails if
+// the command of the signer cannot be built from the RPC URL.
+func (p *EthSignerProvider) GetDockerServiceDefinition(rpcURL string) (*docker.ServiceDefinition, error) {
+	if !p.runsSigner() {
+		return nil, nil
+	}
+	command, err := p.getCommand(rpcURL)
+	if err != nil {
+		return nil, withKind(ErrSignerConfigInvalid, err)
+	}
+	definition := &docker.ServiceDefinition{
 		ServiceName: "ethsigner",
 		Service: &docker.Service{
 			Image:         p.stack.VersionManifest.Signer.GetDockerImageString(),
-			ContainerName: fmt.Sprintf("%s_ethsigner", p.stack.Name),
+			ContainerName: p.containerName(),
 			User:          "root",
-			Command:       p.getCommand(rpcURL),
+			EntryPoint:    p.stack.SignerEntrypoint,
+			Command:       command,
 			Volumes: []string{
-				"ethsigner:/data",
+				fmt.Sprintf("ethsigner:%s", p.dataDirectory()),
 				"ethsigner_config:/etc/firefly",
 			},
 			Logging: docker.StandardLogOptions,
-			HealthCheck: &docker.HealthCheck{
-				Test: []string{
-					"CMD",
-					"curl",
-					"-X", "POST",
-					"-H", "Content-Type: application/json",
-					"-d", `{"jsonrpc":"2.0","method":"net_version","params":[],"id":"1"}`,
-					"-w", "%{http_code}",
-					"-sS",
-					"--fail",
-					"http://localhost:8545/",
-				},
+			HealthCheck: (&docker.HealthCheck{
+				Test:     p.healthCheckTest(),
 				Interval: "15s", // 6000 requests in a day
 				Retries:  60,
-			},
-			Ports: []string{fmt.Sprintf("%d:8545", p.stack.ExposedBlockchainPort)},
+			}).WithConfig(p.stack.HealthCheck),
+			Ports: p.ports(),
 		},
 		VolumeNames: []string{
 			"ethsigner",
 			"ethsigner_config",
 		},
+		ResourceLimits: resourceLimits,
+	}
+	if p.IsShared() {
+		// The volumes of a shared signer are created by FirstTimeSetup, and are kept when the stack is removed
+		definition.Service.Volumes = []string{
+			fmt.Sprintf("%s:%s", p.volumeName(), p.dataDirectory()),
+			fmt.Sprintf("%s:/etc/firefly", p.configVolumeName()),
+		}
+		definition.VolumeNames = nil
+		definition.ExternalVolumeNames = []string{p.volumeName(), p.configVolumeName()}
+	}
+	if secrets := p.passwordSecrets(); secrets != nil {
+		definition.Secrets = secrets
+		definition.Service.Secrets = []string{keystorePasswordSecret}
+	}
+	return definition, nil
+}
+
+// CreateAccount creates a new key in the keystore of the stack, or imports one with privateKey=<hex_private_key>.
+// Passing member=<id> adds the new key to the accounts of an existing member, so that it can sign from
+// several addresses.
+func (p *EthSignerProvider) CreateAccount(args []string) (_ interface{}, err error) {
+	defer func() { err = dockerError(err) }()
+	var member *types.Organization
+	if memberID := ethereum.ArgValue(args, "member"); memberID != "" {
+		for _, m := range p.stack.Members {
+			if m.ID == memberID {
+				member = m
+				break
+			}
+		}
+		if member == nil {
+			return nil, fmt.Errorf("member '%s' does not exist in stack '%s'", memberID, p.stack.Name)
+		}
+		if member.NonSigning {
+			return nil, fmt.Errorf("member '%s' of stack '%s' does not sign, so it cannot have accounts", memberID, p.stack.Name)
+		}
+	}
+
+	var account *ethereum.Account
+	if p.IsRemote() {
+		account, err = p.remoteAccount(args)
+	} else {
+		account, err = p.createKey(args)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if member != nil {
+		member.AdditionalAccounts = append(member.AdditionalAccounts, account)
 	}
+	return account, nil
 }
 
-func (p *EthSignerProvider) CreateAccount(args []string) (interface{}, error) {
-	ethsignerVolumeName := fmt.Sprintf("%s_ethsigner", p.stack.Name)
+func (p *EthSignerProvider) createKey(args []string) (*ethereum.Account, error) {
+
+	ethsignerVolumeName := p.volumeName()
 	var directory string
 	stackHasRunBefore, err := p.stack.HasRunBefore()
 	if err != nil {
@@ -182,23 +960,68 @@ func (p *EthSignerProvider) CreateAccount(args []string) (interface{}, error) {
 		directory = p.stack.InitDir
 	}
 
-	outputDirectory := filepath.Join(directory, "blockchain", "keystore")
-	keyPair, walletFilePath, err := ethereum.CreateWalletFile(outputDirectory, "", keyPassword)
+	// An existing private key can be imported rather than generating a new one
+	var keyPair *secp256k1.KeyPair
+	if privateKey := ethereum.ArgValue(args, "privateKey"); privateKey != "" {
+		if keyPair, err = ethereum.ParsePrivateKey(privateKey); err != nil {
+			return nil, err
+		}
+	} else if prefix := ethereum.ArgValue(args, "addressPrefix"); prefix != "" {
+		if keyPair, err = ethereum.GenerateKeyPairWithAddressPrefix(prefix); err != nil {
+			return nil, err
+		}
+	} else if keyPair, err = secp256k1.GenerateSecp256k1KeyPair(); err != nil {
+		return nil, err
+	}
+
+	blockchainDirectory := filepath.Join(directory, "blockchain")
+	outputDirectory := filepath.Join(blockchainDirectory, "keystore")
+	if _, err := os.Stat(ethereum.WalletFileName(outputDirectory, "", keyPair)); err == nil {
+		return nil, withKind(ErrAccountExists, fmt.Errorf("account %s already exists in the keystore", keyPair.Address.String()))
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	var password string
+	if p.plaintextPasswords() {
+		password, err = generatePassword()
+	} else {
+		password, err = p.keystorePassword(!stackHasRunBefore)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	walletFilePath, err := ethereum.WriteWalletFile(outputDirectory, "", password, p.stack.KeystoreKDF, keyPair)
 	if err != nil {
 		return nil, err
 	}
 
-	tomlFilePath, err := p.writeTomlKeyFile(walletFilePath)
+	// The password is only written to disk in plaintext mode, as the signer reads it from a secret otherwise
+	var passwordFilePath string
+	if p.plaintextPasswords() {
+		if passwordFilePath, err = p.writePasswordFile(blockchainDirectory, walletFilePath, password); err != nil {
+			return nil, err
+		}
+	}
+
+	tomlFilePath, err := p.writeTomlKeyFile(walletFilePath, p.fileKeySpec(walletFilePath, filepath.Base(passwordFilePath)))
 	if err != nil {
 		return nil, err
 	}
 
 	if stackHasRunBefore {
-		if err := ethereum.CopyWalletFileToVolume(p.ctx, walletFilePath, ethsignerVolumeName); err != nil {
+		if err := p.copyToVolumeKeystore(p.ctx, walletFilePath, ethsignerVolumeName); err != nil {
 			return nil, err
 		}
 
-		if err := p.copyTomlFileToVolume(p.ctx, tomlFilePath, ethsignerVolumeName); err != nil {
+		if passwordFilePath != "" {
+			if err := docker.CopyFileToVolume(p.ctx, ethsignerVolumeName, passwordFilePath, filepath.Base(passwordFilePath), passwordFileOptions()...); err != nil {
+				return nil, err
+			}
+		}
+
+		if err := p.copyToVolumeKeystore(p.ctx, tomlFilePath, ethsignerVolumeName); err != nil {
 			return nil, err
 		}
 
@@ -209,3 +1032,79 @@ func (p *EthSignerProvider) CreateAccount(args []string) (interface{}, error) {
 		PrivateKey: hex.EncodeToString(keyPair.PrivateKeyBytes()),
 	}, nil
 }
+
+// ExportAccount decrypts the private key of an account in the keystore of the stack, so that it can be
+// imported into other tools such as a browser wallet
+func (p *EthSignerProvider) ExportAccount(address string) (map[string]string, error) {
+	if p.IsRemote() {
+		return nil, fmt.Errorf("accounts for stack '%s' are managed by the remote signer at %s and cannot be exported", p.stack.Name, p.stack.RemoteSignerURL)
+	}
+	a, err := ethtypes.NewAddress(address)
+	if err != nil {
+		return nil, fmt.Errorf("invalid address '%s': %s", address, err)
+	}
+
+	directory := p.stack.InitDir
+	stackHasRunBefore, err := p.stack.HasRunBefore()
+	if err != nil {
+		return nil, err
+	}
+	if stackHasRunBefore {
+		directory = p.stack.RuntimeDir
+	}
+	blockchainDirectory := filepath.Join(directory, "blockchain")
+
+	keyFile := a.String()[2:]
+	walletJSON, err := os.ReadFile(filepath.Join(blockchainDirectory, "keystore", keyFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("account %s was not found in the keystore of stack '%s'", a, p.stack.Name)
+		}
+		return nil, err
+	}
+	var password []byte
+	if p.plaintextPasswords() {
+		if password, err = readPasswordFile(blockchainDirectory, keyFile); err != nil {
+			return nil, err
+		}
+	} else {
+		keystorePassword, err := p.keystorePassword(false)
+		if err != nil {
+			return nil, err
+		}
+		password = []byte(keystorePassword)
+	}
+	wallet, err := keystorev3.ReadWalletFile(walletJSON, password)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt the keystore file of account %s: %s", a, err)
+	}
+	keyPair := wallet.KeyPair()
+	return map[string]string{
+		"address":    keyPair.Address.String(),
+		"privateKey": hex.EncodeToString(keyPair.PrivateKeyBytes()),
+	}, nil
+}
+
+// remoteAccount records a key that is held by the remote signer. The CLI never sees the private key, so
+// new accounts cannot be created here and the address of an existing one must be provided instead.
+func (p *EthSignerProvider) remoteAccount(args []string) (*ethereum.Account, error) {
+	address := ethereum.ArgValue(args, "address")
+	if address == "" {
+		return nil, fmt.Errorf("accounts for stack '%s' are managed by the remote signer at %s - create the key there and pass address=<address> to use it", p.stack.Name, p.stack.RemoteSignerURL)
+	}
+	a, err := ethtypes.NewAddress(address)
+	if err != nil {
+		return nil, fmt.Errorf("invalid address '%s': %s", address, err)
+	}
+	return &ethereum.Account{
+		Address: a.String(),
+	}, nil
+}
+
+func generatePassword() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -1,14 +1,41 @@
 package ethsigner
 
 import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/hyperledger/firefly-cli/internal/blockchain/ethereum"
+	"github.com/hyperledger/firefly-cli/internal/constants"
+	"github.com/hyperledger/firefly-cli/internal/docker"
+	"github.com/hyperledger/firefly-cli/internal/docker/dockertest"
+	"github.com/hyperledger/firefly-cli/internal/log"
 	"github.com/hyperledger/firefly-cli/pkg/types"
+	"github.com/hyperledger/firefly-common/pkg/fftypes"
+	ffethsigner "github.com/hyperledger/firefly-signer/pkg/ethsigner"
+	"github.com/hyperledger/firefly-signer/pkg/ethtypes"
+	"github.com/hyperledger/firefly-signer/pkg/secp256k1"
+	"github.com/stretchr/testify/assert"
 )
 
 func TestWriteSignerConfig(t *testing.T) {
 	options := &types.InitOptions{ChainID: int64(689)}
-	stack := &types.Stack{Name: "firefly_eth"}
+	stack := &types.Stack{Name: "firefly_eth", ChainIDPtr: &options.ChainID}
 	rpcURL := "http://localhost:9583"
 	e := EthSignerProvider{
 		stack: stack,
@@ -18,3 +45,1202 @@ func TestWriteSignerConfig(t *testing.T) {
 		t.Logf("unable to write config :%v", err)
 	}
 }
+
+func TestWriteSignerConfigWriteFailure(t *testing.T) {
+	stacksDir := constants.StacksDir
+	defer func() { constants.StacksDir = stacksDir }()
+	constants.StacksDir = t.TempDir()
+
+	options := &types.InitOptions{ChainID: int64(2021)}
+	p := &EthSignerProvider{stack: &types.Stack{Name: "firefly_eth", ChainIDPtr: &options.ChainID}}
+
+	// The config directory is created with the rest of the stack, so it does not exist yet and the write fails
+	err := p.WriteConfig(options, "http://besu:8545")
+	assert.Error(t, err)
+	assert.True(t, os.IsNotExist(err))
+
+	assert.NoError(t, os.MkdirAll(filepath.Join(constants.StacksDir, "firefly_eth", "init", "config"), 0755))
+	assert.NoError(t, p.WriteConfig(options, "http://besu:8545"))
+	_, err = os.Stat(filepath.Join(constants.StacksDir, "firefly_eth", "init", "config", "ethsigner.yaml"))
+	assert.NoError(t, err)
+}
+
+func TestWriteSignerConfigChainIDMismatch(t *testing.T) {
+	chainID := int64(2021)
+	stack := &types.Stack{Name: "firefly_eth_chain_id_test", ChainIDPtr: &chainID}
+	p := &EthSignerProvider{stack: stack}
+
+	err := p.WriteConfig(&types.InitOptions{ChainID: int64(689)}, "http://besu:8545")
+	assert.Regexp(t, "chain ID 689 does not match the chain ID 2021 of stack 'firefly_eth_chain_id_test'", err)
+
+	// A stack without an explicit chain ID uses the original default
+	stack.ChainIDPtr = nil
+	err = p.WriteConfig(&types.InitOptions{ChainID: int64(689)}, "http://besu:8545")
+	assert.Regexp(t, "does not match the chain ID 2021", err)
+}
+
+func TestCreateAccountImportPrivateKey(t *testing.T) {
+	stack := &types.Stack{Name: "firefly_eth_import_test", InitDir: t.TempDir()}
+	p := &EthSignerProvider{stack: stack}
+	privateKey := "0x00112233445566778899aabbccddeeff00112233445566778899aabbccddeeff"
+
+	account, err := p.CreateAccount([]string{"privateKey=" + privateKey})
+	assert.NoError(t, err)
+	assert.Equal(t, "00112233445566778899aabbccddeeff00112233445566778899aabbccddeeff", account.(*ethereum.Account).PrivateKey)
+	keyPair, err := ethereum.ParsePrivateKey(privateKey)
+	assert.NoError(t, err)
+	assert.Equal(t, keyPair.Address.String(), account.(*ethereum.Account).Address)
+
+	_, err = p.CreateAccount([]string{"privateKey=" + privateKey})
+	assert.Regexp(t, "already exists in the keystore", err)
+}
+
+func TestCreateAccountInvalidPrivateKey(t *testing.T) {
+	stack := &types.Stack{Name: "firefly_eth_import_test", InitDir: t.TempDir()}
+	p := &EthSignerProvider{stack: stack}
+
+	_, err := p.CreateAccount([]string{"privateKey=0x1234"})
+	assert.Regexp(t, "invalid private key", err)
+	_, err = os.Stat(filepath.Join(stack.InitDir, "blockchain"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestCreateAccountAddressPrefix(t *testing.T) {
+	stack := &types.Stack{Name: "firefly_eth_prefix_test", InitDir: t.TempDir()}
+	p := &EthSignerProvider{stack: stack}
+
+	account, err := p.CreateAccount([]string{"addressPrefix=0xa"})
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(account.(*ethereum.Account).Address, "0xa"))
+
+	_, err = p.CreateAccount([]string{"addressPrefix=0xnothex"})
+	assert.Regexp(t, "invalid address prefix '0xnothex'", err)
+}
+
+// unavailableDocker returns a docker.CommandRunner for a host whose docker daemon is not running
+func unavailableDocker() *dockertest.Runner {
+	return &dockertest.Runner{Answer: func(name string, args ...string) (string, error) {
+		return "", fmt.Errorf("%s %s [1] Cannot connect to the Docker daemon at unix:///var/run/docker.sock. Is the docker daemon running?", name, args[0])
+	}}
+}
+
+func TestErrorKinds(t *testing.T) {
+	stacksDir := constants.StacksDir
+	defer func() { constants.StacksDir = stacksDir }()
+	constants.StacksDir = t.TempDir()
+	chainID := int64(2021)
+
+	t.Run("account exists", func(t *testing.T) {
+		p := &EthSignerProvider{stack: &types.Stack{Name: "firefly_eth", InitDir: t.TempDir()}}
+		privateKey := "privateKey=0x00112233445566778899aabbccddeeff00112233445566778899aabbccddeeff"
+		_, err := p.CreateAccount([]string{privateKey})
+		assert.NoError(t, err)
+		_, err = p.CreateAccount([]string{privateKey})
+		assert.ErrorIs(t, err, ErrAccountExists)
+		assert.NotErrorIs(t, err, ErrSignerConfigInvalid)
+		assert.Regexp(t, "^account 0x[0-9a-f]{40} already exists in the keystore$", err)
+		var signerErr *Error
+		assert.ErrorAs(t, err, &signerErr)
+		assert.Equal(t, ErrAccountExists, signerErr.Kind)
+	})
+
+	t.Run("chain ID mismatch", func(t *testing.T) {
+		p := &EthSignerProvider{stack: &types.Stack{Name: "firefly_eth", ChainIDPtr: &chainID}}
+		err := p.WriteConfig(&types.InitOptions{ChainID: 689}, "http://besu:8545")
+		assert.ErrorIs(t, err, ErrSignerConfigInvalid)
+		assert.Regexp(t, "^chain ID 689 does not match", err)
+	})
+
+	t.Run("duplicate addresses", func(t *testing.T) {
+		account := &ethereum.Account{Address: "0x1111111111111111111111111111111111111111"}
+		p := &EthSignerProvider{ctx: context.Background(), stack: &types.Stack{
+			Name:       "firefly_eth",
+			ChainIDPtr: &chainID,
+			Members:    []*types.Organization{{ID: "0", Account: account}, {ID: "1", Account: account}},
+		}}
+		assert.ErrorIs(t, p.WriteConfig(&types.InitOptions{ChainID: chainID}, "http://besu:8545"), ErrSignerConfigInvalid)
+		assert.ErrorIs(t, p.FirstTimeSetup(), ErrSignerConfigInvalid)
+	})
+
+	t.Run("invalid config", func(t *testing.T) {
+		p := &EthSignerProvider{stack: &types.Stack{Name: "firefly_eth", ChainIDPtr: &chainID}}
+		_, err := p.SignerConfig("besu:8545")
+		assert.ErrorIs(t, err, ErrSignerConfigInvalid)
+		assert.Regexp(t, "invalid signer config: RPC URL 'besu:8545'", err)
+	})
+
+	t.Run("docker unavailable", func(t *testing.T) {
+		stackDir := t.TempDir()
+		ctx := docker.WithCommandRunner(log.WithLogger(context.Background(), &log.StdoutLogger{}), unavailableDocker())
+		p := &EthSignerProvider{ctx: ctx, stack: &types.Stack{
+			Name:       "firefly_eth",
+			SignerType: types.SignerTypeJava,
+			StackDir:   stackDir,
+			InitDir:    filepath.Join(stackDir, "init"),
+			RuntimeDir: filepath.Join(stackDir, "runtime"),
+		}}
+		err := p.FirstTimeSetup()
+		assert.ErrorIs(t, err, ErrDockerUnavailable)
+		assert.Regexp(t, "Cannot connect to the Docker daemon", err)
+	})
+}
+
+func TestGetDockerServiceDefinitionHealthCheck(t *testing.T) {
+	stack := &types.Stack{
+		Name:            "firefly_eth",
+		VersionManifest: &types.VersionManifest{Signer: &types.ManifestEntry{Image: "ghcr.io/hyperledger/firefly-signer", Tag: "v0.9.6"}},
+	}
+	p := &EthSignerProvider{stack: stack}
+
+	healthCheck := serviceDefinition(t, p).Service.HealthCheck
+	assert.Equal(t, "15s", healthCheck.Interval)
+	assert.Equal(t, 60, healthCheck.Retries)
+	assert.Empty(t, healthCheck.StartPeriod)
+
+	stack.HealthCheck = &types.HealthCheckConfig{Interval: "5s", StartPeriod: "30s"}
+	healthCheck = serviceDefinition(t, p).Service.HealthCheck
+	assert.Equal(t, "5s", healthCheck.Interval)
+	assert.Equal(t, 60, healthCheck.Retries)
+	assert.Equal(t, "30s", healthCheck.StartPeriod)
+}
+
+func TestSignerHealthCheck(t *testing.T) {
+	stack := &types.Stack{
+		Name:            "firefly_eth",
+		VersionManifest: &types.VersionManifest{Signer: &types.ManifestEntry{Image: "ghcr.io/hyperledger/firefly-signer", Tag: "v0.9.6"}},
+		Members: []*types.Organization{
+			{
+				ID:                 "0",
+				Account:            &ethereum.Account{Address: "0x1f8ad7b4ea06e3a4cd2a6ae7ef2e3c78a7a6bd2c"},
+				AdditionalAccounts: []interface{}{&ethereum.Account{Address: "0x2A7b1a1a8D1c9c7a7a2d1b5c8d04e4aa41c1f4b1"}},
+			},
+			{ID: "1", Account: &ethereum.Account{Address: "0x3c2b7e5d4f9c1a8b6e0d2f4a6c8e0b2d4f6a8c0e"}},
+		},
+	}
+	p := &EthSignerProvider{stack: stack}
+
+	// net_version is the default, for compatibility with existing stacks
+	test := serviceDefinition(t, p).Service.HealthCheck.Test
+	assert.Equal(t, "CMD", test[0])
+	assert.Contains(t, test, `{"jsonrpc":"2.0","method":"net_version","params":[],"id":"1"}`)
+	stack.SignerHealthCheck = types.SignerHealthCheckNetVersion
+	assert.Equal(t, test, serviceDefinition(t, p).Service.HealthCheck.Test)
+
+	stack.SignerHealthCheck = types.SignerHealthCheckAccounts
+	assert.Equal(t, []string{
+		"CMD-SHELL",
+		`curl -X POST -H 'Content-Type: application/json' -d '{"jsonrpc":"2.0","method":"eth_accounts","params":[],"id":"1"}' -sS --fail -o /tmp/healthcheck-accounts http://localhost:8545/` +
+			" && grep -qi 0x1f8ad7b4ea06e3a4cd2a6ae7ef2e3c78a7a6bd2c /tmp/healthcheck-accounts" +
+			" && grep -qi 0x2A7b1a1a8D1c9c7a7a2d1b5c8d04e4aa41c1f4b1 /tmp/healthcheck-accounts" +
+			" && grep -qi 0x3c2b7e5d4f9c1a8b6e0d2f4a6c8e0b2d4f6a8c0e /tmp/healthcheck-accounts",
+	}, serviceDefinition(t, p).Service.HealthCheck.Test)
+
+	assert.NoError(t, types.ValidateSignerHealthCheck("accounts"))
+	assert.Regexp(t, "invalid signer health check 'ready': must be one of \\[net_version accounts\\]", types.ValidateSignerHealthCheck("ready"))
+}
+
+func TestImportPasswordFilesReportsAllFailures(t *testing.T) {
+	ctx := log.WithVerbosity(context.Background(), false)
+	ctx = docker.WithCommandRunner(log.WithLogger(ctx, &log.StdoutLogger{}), unavailableDocker())
+	p := &EthSignerProvider{ctx: ctx, stack: &types.Stack{Name: "firefly_eth"}}
+	assert.NoError(t, p.importPasswordFiles(ctx, "firefly_eth_ethsigner", nil))
+
+	// Without a docker daemon available every copy fails, and each failure should be reported
+	dir := t.TempDir()
+	err := p.importPasswordFiles(ctx, "firefly_eth_ethsigner", []string{
+		filepath.Join(dir, "account1.password"),
+		filepath.Join(dir, "account2.password"),
+	})
+	assert.Regexp(t, "account1.password", err)
+	assert.Regexp(t, "account2.password", err)
+}
+
+func TestMissingWalletFiles(t *testing.T) {
+	keystoreDir := filepath.Join(t.TempDir(), "keystore")
+	walletFiles, err := missingWalletFiles(keystoreDir, map[string]bool{})
+	assert.NoError(t, err)
+	assert.Empty(t, walletFiles)
+
+	assert.NoError(t, os.MkdirAll(keystoreDir, 0755))
+	for _, name := range []string{"account1", "account1.toml", "account2", "account2.toml", "account3"} {
+		assert.NoError(t, os.WriteFile(filepath.Join(keystoreDir, name), []byte{}, 0755))
+	}
+
+	walletFiles, err = missingWalletFiles(keystoreDir, map[string]bool{})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		filepath.Join(keystoreDir, "account1"),
+		filepath.Join(keystoreDir, "account2"),
+		filepath.Join(keystoreDir, "account3"),
+	}, walletFiles)
+
+	// account2 was interrupted before its toml file was imported
+	walletFiles, err = missingWalletFiles(keystoreDir, map[string]bool{
+		"account1":      true,
+		"account1.toml": true,
+		"account2":      true,
+		"account3":      true,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{filepath.Join(keystoreDir, "account2")}, walletFiles)
+}
+
+func TestValidateRemoteSignerURL(t *testing.T) {
+	assert.NoError(t, ValidateRemoteSignerURL("https://signer.example.com:8545"))
+	assert.NoError(t, ValidateRemoteSignerURL("http://10.0.0.1/signer"))
+	assert.Regexp(t, "scheme must be http or https", ValidateRemoteSignerURL("signer.example.com:8545"))
+	assert.Regexp(t, "scheme must be http or https", ValidateRemoteSignerURL("ws://signer.example.com"))
+	assert.Regexp(t, "missing host", ValidateRemoteSignerURL("http:///signer"))
+	assert.Regexp(t, "invalid remote signer URL", ValidateRemoteSignerURL("http://[::1"))
+}
+
+func TestRemoteSigner(t *testing.T) {
+	stack := &types.Stack{Name: "firefly_eth_remote", InitDir: t.TempDir(), RemoteSignerURL: "https://signer.example.com"}
+	p := &EthSignerProvider{stack: stack}
+
+	assert.True(t, p.IsRemote())
+	assert.Equal(t, "https://signer.example.com", p.URL())
+	assert.Empty(t, p.DependentServices())
+	assert.Nil(t, serviceDefinition(t, p))
+	assert.NoError(t, p.WriteConfig(&types.InitOptions{ChainID: 2021}, "http://besu:8545"))
+	assert.NoError(t, p.FirstTimeSetup())
+
+	_, err := p.CreateAccount([]string{})
+	assert.Regexp(t, "managed by the remote signer at https://signer.example.com", err)
+	_, err = p.CreateAccount([]string{"address=0x1234"})
+	assert.Regexp(t, "invalid address", err)
+	account, err := p.CreateAccount([]string{"org_0", "org_0", "address=0x1f8ad7b4ea06e3a4cd2a6ae7ef2e3c78a7a6bd2c"})
+	assert.NoError(t, err)
+	assert.Equal(t, "0x1f8ad7b4ea06e3a4cd2a6ae7ef2e3c78a7a6bd2c", account.(*ethereum.Account).Address)
+	assert.Empty(t, account.(*ethereum.Account).PrivateKey)
+
+	// Nothing is written to disk for a remote signer
+	entries, err := os.ReadDir(stack.InitDir)
+	assert.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+func TestCreateAccountKeyMaterialPermissions(t *testing.T) {
+	stack := &types.Stack{Name: "firefly_eth_permissions_test", InitDir: t.TempDir()}
+	p := &EthSignerProvider{stack: stack}
+
+	account, err := p.CreateAccount([]string{})
+	assert.NoError(t, err)
+
+	blockchainDir := filepath.Join(stack.InitDir, "blockchain")
+	keyFile := strings.ToLower(account.(*ethereum.Account).Address[2:])
+	for _, dir := range []string{blockchainDir, filepath.Join(blockchainDir, "keystore")} {
+		info, err := os.Stat(dir)
+		assert.NoError(t, err)
+		assert.Equal(t, os.FileMode(0700), info.Mode().Perm(), dir)
+	}
+	for _, file := range []string{
+		filepath.Join(blockchainDir, "keystore", keyFile),
+		filepath.Join(blockchainDir, "keystore", keyFile+".toml"),
+		filepath.Join(blockchainDir, keyFile+".password"),
+	} {
+		info, err := os.Stat(file)
+		assert.NoError(t, err)
+		assert.Equal(t, os.FileMode(0600), info.Mode().Perm(), file)
+	}
+}
+
+func TestExportAccount(t *testing.T) {
+	stack := &types.Stack{Name: "firefly_eth_export_test", InitDir: t.TempDir()}
+	p := &EthSignerProvider{stack: stack}
+	privateKey := "00112233445566778899aabbccddeeff00112233445566778899aabbccddeeff"
+
+	account, err := p.CreateAccount([]string{"privateKey=" + privateKey})
+	assert.NoError(t, err)
+	address := account.(*ethereum.Account).Address
+
+	exported, err := p.ExportAccount(strings.ToUpper(address[2:]))
+	assert.NoError(t, err)
+	assert.Equal(t, address, exported["address"])
+	assert.Equal(t, privateKey, exported["privateKey"])
+
+	_, err = p.ExportAccount("0x1f8ad7b4ea06e3a4cd2a6ae7ef2e3c78a7a6bd2c")
+	assert.Regexp(t, "was not found in the keystore", err)
+	_, err = p.ExportAccount("not-an-address")
+	assert.Regexp(t, "invalid address", err)
+
+	// Accounts of stacks from older versions of the CLI share a single password file
+	keyFile := address[2:]
+	password, err := os.ReadFile(filepath.Join(stack.InitDir, "blockchain", keyFile+".password"))
+	assert.NoError(t, err)
+	assert.NoError(t, os.Rename(filepath.Join(stack.InitDir, "blockchain", keyFile+".password"), filepath.Join(stack.InitDir, "blockchain", legacyPasswordFile)))
+	exported, err = p.ExportAccount(address)
+	assert.NoError(t, err)
+	assert.Equal(t, privateKey, exported["privateKey"])
+
+	assert.NoError(t, os.WriteFile(filepath.Join(stack.InitDir, "blockchain", legacyPasswordFile), append(password, 'x'), 0600))
+	_, err = p.ExportAccount(address)
+	assert.Regexp(t, "failed to decrypt the keystore file", err)
+}
+
+func TestExportAccountRemoteSigner(t *testing.T) {
+	p := &EthSignerProvider{stack: &types.Stack{Name: "firefly_eth_remote", RemoteSignerURL: "https://signer.example.com"}}
+	_, err := p.ExportAccount("0x1f8ad7b4ea06e3a4cd2a6ae7ef2e3c78a7a6bd2c")
+	assert.Regexp(t, "managed by the remote signer at https://signer.example.com and cannot be exported", err)
+}
+
+func TestWaitUntilHealthy(t *testing.T) {
+	defer func(f func(context.Context, string, time.Duration) error) {
+		waitForHealthy = f
+	}(waitForHealthy)
+
+	t.Run("Healthy", func(t *testing.T) {
+		waitForHealthy = func(ctx context.Context, containerName string, timeout time.Duration) error {
+			assert.Equal(t, "firefly_eth_ethsigner", containerName)
+			assert.Equal(t, healthTimeout, timeout)
+			return nil
+		}
+		p := &EthSignerProvider{ctx: context.Background(), stack: &types.Stack{Name: "firefly_eth"}}
+		assert.NoError(t, p.WaitUntilHealthy())
+	})
+
+	t.Run("NotFound", func(t *testing.T) {
+		waitForHealthy = func(ctx context.Context, containerName string, timeout time.Duration) error {
+			return fmt.Errorf("%w '%s'", docker.ErrContainerNotFound, containerName)
+		}
+		p := &EthSignerProvider{ctx: context.Background(), stack: &types.Stack{Name: "firefly_eth"}}
+		err := p.WaitUntilHealthy()
+		assert.Regexp(t, "ethsigner is not ready", err)
+		assert.ErrorIs(t, err, docker.ErrContainerNotFound)
+	})
+
+	t.Run("Remote", func(t *testing.T) {
+		waitForHealthy = nil
+		p := &EthSignerProvider{stack: &types.Stack{Name: "firefly_eth", RemoteSignerURL: "https://signer.example.com"}}
+		assert.NoError(t, p.WaitUntilHealthy())
+	})
+}
+
+func TestCreateAccountForMember(t *testing.T) {
+	primary := &ethereum.Account{Address: "0x1f8ad7b4ea06e3a4cd2a6ae7ef2e3c78a7a6bd2c"}
+	member := &types.Organization{ID: "0", Account: primary}
+	stack := &types.Stack{Name: "firefly_eth_member_test", InitDir: t.TempDir(), Members: []*types.Organization{member}}
+	p := &EthSignerProvider{stack: stack}
+
+	first, err := p.CreateAccount([]string{"member=0"})
+	assert.NoError(t, err)
+	second, err := p.CreateAccount([]string{"member=0"})
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{primary, first, second}, member.Accounts())
+
+	// Accounts created without a member are not added to any of them
+	_, err = p.CreateAccount([]string{})
+	assert.NoError(t, err)
+	assert.Len(t, member.Accounts(), 3)
+
+	_, err = p.CreateAccount([]string{"member=1"})
+	assert.Regexp(t, "member '1' does not exist in stack 'firefly_eth_member_test'", err)
+}
+
+func TestNonSigningMembers(t *testing.T) {
+	chainID := int64(2021)
+	signing := &types.Organization{ID: "0", OrgName: "org_0", Account: &ethereum.Account{Address: "0x1f8ad7b4ea06e3a4cd2a6ae7ef2e3c78a7a6bd2c"}}
+	querying := &types.Organization{ID: "1", OrgName: "org_1", NonSigning: true}
+	stack := &types.Stack{Name: "firefly_eth_non_signing", ChainIDPtr: &chainID, InitDir: t.TempDir(), Members: []*types.Organization{signing, querying}}
+	p := &EthSignerProvider{stack: stack}
+
+	// Only the keys of the signing member are imported into the signer, and checked for by its health check
+	assert.Equal(t, []string{"0x1f8ad7b4ea06e3a4cd2a6ae7ef2e3c78a7a6bd2c"}, p.memberAddresses())
+	keyFiles := []string{}
+	for _, file := range p.runtimeFiles() {
+		if strings.HasPrefix(file.names[0], "blockchain") {
+			keyFiles = append(keyFiles, file.names[0])
+		}
+	}
+	assert.Equal(t, []string{
+		filepath.Join("blockchain", "keystore", "1f8ad7b4ea06e3a4cd2a6ae7ef2e3c78a7a6bd2c"),
+		filepath.Join("blockchain", "keystore", "1f8ad7b4ea06e3a4cd2a6ae7ef2e3c78a7a6bd2c.toml"),
+		filepath.Join("blockchain", "1f8ad7b4ea06e3a4cd2a6ae7ef2e3c78a7a6bd2c.password"),
+	}, keyFiles)
+
+	_, err := p.CreateAccount([]string{"member=1"})
+	assert.Regexp(t, "member '1' of stack 'firefly_eth_non_signing' does not sign, so it cannot have accounts", err)
+	assert.Empty(t, querying.Accounts())
+
+	// The stack config may have been edited by hand to give the querying member an account
+	querying.Account = &ethereum.Account{Address: "0x2222222222222222222222222222222222222222"}
+	err = p.WriteConfig(&types.InitOptions{ChainID: chainID}, "http://besu:8545")
+	assert.ErrorIs(t, err, ErrSignerConfigInvalid)
+	assert.Regexp(t, `member 1 \('org_1'\) does not sign, so it cannot have accounts`, err)
+
+	querying.Account = nil
+	signing.NonSigning = true
+	signing.Account = nil
+	err = p.WriteConfig(&types.InitOptions{ChainID: chainID}, "http://besu:8545")
+	assert.ErrorIs(t, err, ErrSignerConfigInvalid)
+	assert.Regexp(t, "at least one member of the stack must sign", err)
+}
+
+func TestDryRun(t *testing.T) {
+	stacksDir := constants.StacksDir
+	defer func() { constants.StacksDir = stacksDir }()
+	constants.StacksDir = t.TempDir()
+
+	chainID := int64(2021)
+	stackDir := filepath.Join(constants.StacksDir, "firefly_eth")
+	stack := &types.Stack{Name: "firefly_eth", ChainIDPtr: &chainID, StackDir: stackDir, InitDir: filepath.Join(stackDir, "init"), RuntimeDir: filepath.Join(stackDir, "runtime")}
+	keystoreDir := filepath.Join(stack.RuntimeDir, "blockchain", "keystore")
+	assert.NoError(t, os.MkdirAll(keystoreDir, 0700))
+	for _, name := range []string{"keystore/1f8ad7b4ea06e3a4cd2a6ae7ef2e3c78a7a6bd2c", "keystore/1f8ad7b4ea06e3a4cd2a6ae7ef2e3c78a7a6bd2c.toml", "1f8ad7b4ea06e3a4cd2a6ae7ef2e3c78a7a6bd2c.password"} {
+		assert.NoError(t, os.WriteFile(filepath.Join(stack.RuntimeDir, "blockchain", name), []byte{}, 0600))
+	}
+
+	out := &strings.Builder{}
+	ctx := log.WithVerbosity(context.Background(), true)
+	ctx = log.WithLogger(ctx, &log.StdoutLogger{})
+	p := &EthSignerProvider{ctx: ctx, stack: stack, DryRun: true, out: out}
+	assert.NoError(t, p.WriteConfig(&types.InitOptions{ChainID: chainID}, "http://besu:8545"))
+	assert.NoError(t, p.FirstTimeSetup())
+
+	expected := `mkdir -p <stacks>/firefly_eth/init/blockchain
+write <stacks>/firefly_eth/init/config/ethsigner.yaml
+server:
+  port: 8545
+  address: 0.0.0.0
+backend:
+  chainId: 2021
+  url: http://besu:8545
+fileWallet:
+  path: /data/keystore
+  filenames:
+    primaryExt: .toml
+  metadata:
+    keyFileProperty: '{{ index .signing "key-file" }}'
+    passwordFileProperty: '{{ index .signing "password-file" }}'
+log:
+  level: info
+docker volume ls --quiet --filter name=^firefly_eth_ethsigner$
+docker volume ls --quiet --filter name=^firefly_eth_ethsigner$
+docker volume create --label io.hyperledger.firefly-cli.stack=firefly_eth firefly_eth_ethsigner
+mkdir -p <stacks>/firefly_eth/runtime/contracts
+docker run --rm --mount type=bind,source=<stacks>/firefly_eth/runtime/config/ethsigner.yaml,target=/source/ethsigner.yaml -v firefly_eth_ethsigner_config:/dest alpine /bin/sh -c cp -R /source/ethsigner.yaml /dest/firefly.ffsigner && chgrp -R 0 /dest/firefly.ffsigner && chmod -R g+rwX /dest/firefly.ffsigner
+docker run --rm --mount type=bind,source=<stacks>/firefly_eth/runtime/blockchain/1f8ad7b4ea06e3a4cd2a6ae7ef2e3c78a7a6bd2c.password,target=/source/1f8ad7b4ea06e3a4cd2a6ae7ef2e3c78a7a6bd2c.password -v firefly_eth_ethsigner:/dest alpine /bin/sh -c cp -R /source/1f8ad7b4ea06e3a4cd2a6ae7ef2e3c78a7a6bd2c.password /dest/1f8ad7b4ea06e3a4cd2a6ae7ef2e3c78a7a6bd2c.password && chown -R 0:0 /dest/1f8ad7b4ea06e3a4cd2a6ae7ef2e3c78a7a6bd2c.password && find /dest/1f8ad7b4ea06e3a4cd2a6ae7ef2e3c78a7a6bd2c.password -type f -exec chmod 600 {} +
+docker run --rm --mount type=bind,source=<stacks>/firefly_eth/runtime/blockchain/keystore,target=/source/keystore -v firefly_eth_ethsigner:/dest alpine /bin/sh -c cp -R /source/keystore /dest && chgrp -R 0 /dest/keystore && chmod -R g+rwX /dest/keystore
+`
+	assert.Equal(t, expected, strings.ReplaceAll(out.String(), constants.StacksDir, "<stacks>"))
+
+	// The password files keep their permissions, as nothing copied after them changes the whole volume
+	commands := out.String()
+	passwordChmod := strings.Index(commands, "-type f -exec chmod 600 {} +")
+	assert.Greater(t, passwordChmod, 0)
+	assert.NotRegexp(t, `(chgrp|chown|chmod) -R \S+ /dest( |$|\n)`, commands[passwordChmod:])
+
+	// Nothing was written to disk
+	_, err := os.Stat(stack.InitDir)
+	assert.True(t, os.IsNotExist(err))
+	_, err = os.Stat(filepath.Join(stack.RuntimeDir, "contracts"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestFirstTimeSetupProgress(t *testing.T) {
+	keyFiles := []string{"1f8ad7b4ea06e3a4cd2a6ae7ef2e3c78a7a6bd2c", "2e9bd8c5fb17f4b5de3b7bf8f03f4d89b8b7ce3d"}
+	newProvider := func(t *testing.T, runner docker.CommandRunner) (*EthSignerProvider, *[]string) {
+		chainID := int64(2021)
+		stackDir := t.TempDir()
+		stack := &types.Stack{Name: "firefly_eth", ChainIDPtr: &chainID, StackDir: stackDir, InitDir: filepath.Join(stackDir, "init"), RuntimeDir: filepath.Join(stackDir, "runtime")}
+		assert.NoError(t, os.MkdirAll(filepath.Join(stack.RuntimeDir, "blockchain", "keystore"), 0700))
+		assert.NoError(t, os.MkdirAll(filepath.Join(stack.RuntimeDir, "config"), 0700))
+		assert.NoError(t, os.WriteFile(filepath.Join(stack.RuntimeDir, "config", "ethsigner.yaml"), []byte{}, 0600))
+		for i, keyFile := range keyFiles {
+			stack.Members = append(stack.Members, &types.Organization{ID: fmt.Sprint(i), Account: &ethereum.Account{Address: "0x" + keyFile}})
+			for _, name := range []string{"keystore/" + keyFile, "keystore/" + keyFile + ".toml", keyFile + ".password"} {
+				assert.NoError(t, os.WriteFile(filepath.Join(stack.RuntimeDir, "blockchain", name), []byte{}, 0600))
+			}
+		}
+
+		events := &[]string{}
+		ctx := log.WithLogger(context.Background(), &log.StdoutLogger{})
+		ctx = log.WithProgress(docker.WithCommandRunner(ctx, runner), func(progress log.Progress) {
+			*events = append(*events, progress.String())
+		})
+		return &EthSignerProvider{ctx: ctx, stack: stack}, events
+	}
+
+	t.Run("new volume", func(t *testing.T) {
+		p, events := newProvider(t, volumeFiles{}.runner())
+		assert.NoError(t, p.FirstTimeSetup())
+		assert.Equal(t, []string{
+			"creating signer volume",
+			"creating signer volume done",
+			"copying signer config",
+			"copying signer config done",
+			"importing passwords",
+			"importing passwords done",
+			"importing 2 accounts",
+			"importing 2 accounts done",
+		}, *events)
+	})
+
+	t.Run("interrupted import", func(t *testing.T) {
+		// The first account was imported before the previous setup was interrupted
+		p, events := newProvider(t, volumeFiles{"firefly_eth_ethsigner": {"/keystore/" + keyFiles[0]: "", "/keystore/" + keyFiles[0] + ".toml": ""}}.runner())
+		assert.NoError(t, p.FirstTimeSetup())
+		assert.Equal(t, []string{
+			"copying signer config",
+			"copying signer config done",
+			"importing passwords",
+			"importing passwords done",
+			"importing account 1/1",
+			"importing account 1/1 done",
+		}, *events)
+	})
+
+	t.Run("no progress function", func(t *testing.T) {
+		p, _ := newProvider(t, volumeFiles{}.runner())
+		p.ctx = docker.WithCommandRunner(log.WithLogger(context.Background(), &log.StdoutLogger{}), volumeFiles{}.runner())
+		assert.NoError(t, p.FirstTimeSetup())
+	})
+}
+
+func TestValidateKeystoreDirectory(t *testing.T) {
+	assert.NoError(t, ValidateKeystoreDirectory("/data/keystore"))
+	assert.NoError(t, ValidateKeystoreDirectory("/opt/signer/keys/"))
+	assert.Regexp(t, "must be an absolute path", ValidateKeystoreDirectory("keys"))
+	assert.Regexp(t, "cannot be the root directory", ValidateKeystoreDirectory("/"))
+	assert.Regexp(t, "must be below a directory other than the root, such as /data/keys", ValidateKeystoreDirectory("/keys"))
+	assert.Regexp(t, "must be below a directory other than the root", ValidateKeystoreDirectory("/keys/"))
+}
+
+func TestCustomKeystoreDirectory(t *testing.T) {
+	chainID := int64(2021)
+	stack := &types.Stack{
+		Name:                    "firefly_eth",
+		ChainIDPtr:              &chainID,
+		SignerKeystoreDirectory: "/opt/signer/keys",
+		VersionManifest:         &types.VersionManifest{Signer: &types.ManifestEntry{Image: "ghcr.io/hyperledger/firefly-signer"}},
+	}
+	p := NewEthSignerProvider(context.Background(), stack)
+
+	assert.Equal(t, "/opt/signer/keys", GenerateSignerConfig(chainID, "http://besu:8545", p.keystoreDirectory(), nil, nil, "").FileWallet.Path)
+	assert.Contains(t, serviceDefinition(t, p).Service.Volumes, "ethsigner:/opt/signer")
+	assert.Equal(t, "/keys", p.volumeKeystoreDirectory())
+
+	walletFile := filepath.Join(t.TempDir(), "wallet")
+	tomlFile, err := p.writeTomlKeyFile(walletFile, p.fileKeySpec(walletFile, "wallet.password"))
+	assert.NoError(t, err)
+	toml, err := os.ReadFile(tomlFile)
+	assert.NoError(t, err)
+	assert.Contains(t, string(toml), `key-file = "/opt/signer/keys/wallet"`)
+	assert.Contains(t, string(toml), `password-file = "/opt/signer/wallet.password"`)
+
+	// Stacks without an override keep the original layout
+	p = NewEthSignerProvider(context.Background(), &types.Stack{Name: "firefly_eth", VersionManifest: stack.VersionManifest})
+	assert.Contains(t, serviceDefinition(t, p).Service.Volumes, "ethsigner:/data")
+	assert.Equal(t, "/keystore", p.volumeKeystoreDirectory())
+}
+
+func TestSignerType(t *testing.T) {
+	stacksDir := constants.StacksDir
+	defer func() { constants.StacksDir = stacksDir }()
+	constants.StacksDir = t.TempDir()
+
+	testcases := []struct {
+		Name       string
+		SignerType fftypes.FFEnum
+		Command    string
+		Config     bool
+	}{
+		{Name: "default", SignerType: "", Command: "", Config: true},
+		{Name: "firefly-signer", SignerType: types.SignerTypeFireFly, Command: "", Config: true},
+		{Name: "java-ethsigner", SignerType: types.SignerTypeJava, Command: "--logging=INFO --chain-id=2021 --downstream-http-host=besu --downstream-http-port=8545 multikey-signer --directory=/data/keystore"},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.Name, func(t *testing.T) {
+			chainID := int64(2021)
+			stack := &types.Stack{
+				Name:            "firefly_eth",
+				ChainIDPtr:      &chainID,
+				SignerType:      tc.SignerType,
+				VersionManifest: &types.VersionManifest{Signer: &types.ManifestEntry{Image: JavaSignerImage}},
+			}
+			out := &strings.Builder{}
+			p := &EthSignerProvider{ctx: context.Background(), stack: stack, DryRun: true, out: out}
+
+			assert.Equal(t, tc.Command, serviceDefinition(t, p).Service.Command)
+			assert.NoError(t, p.WriteConfig(&types.InitOptions{ChainID: chainID}, "http://besu:8545"))
+			if tc.Config {
+				assert.Contains(t, out.String(), "ethsigner.yaml\n")
+			} else {
+				assert.NotContains(t, out.String(), "ethsigner.yaml")
+			}
+		})
+	}
+}
+
+// writeTestCACertificate writes a self signed CA certificate in PEM format, as a private CA would provide
+func writeTestCACertificate(t *testing.T, filename string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.NoError(t, err)
+	assert.NoError(t, os.WriteFile(filename, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644))
+}
+
+func TestValidateCACertificate(t *testing.T) {
+	dir := t.TempDir()
+	caFile := filepath.Join(dir, "ca.pem")
+	writeTestCACertificate(t, caFile)
+	assert.NoError(t, ValidateCACertificate(caFile))
+
+	assert.Regexp(t, "invalid downstream RPC CA certificate '.*missing.pem'", ValidateCACertificate(filepath.Join(dir, "missing.pem")))
+
+	notPEM := filepath.Join(dir, "not.pem")
+	assert.NoError(t, os.WriteFile(notPEM, []byte("not a certificate"), 0644))
+	assert.Regexp(t, "no PEM encoded certificates found", ValidateCACertificate(notPEM))
+
+	badCertificate := filepath.Join(dir, "bad.pem")
+	assert.NoError(t, os.WriteFile(badCertificate, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: []byte("garbage")}), 0644))
+	assert.Regexp(t, "invalid downstream RPC CA certificate", ValidateCACertificate(badCertificate))
+}
+
+func TestDownstreamRPCCACert(t *testing.T) {
+	stacksDir := constants.StacksDir
+	defer func() { constants.StacksDir = stacksDir }()
+	constants.StacksDir = t.TempDir()
+	configDir := filepath.Join(constants.StacksDir, "firefly_eth", "init", "config")
+	assert.NoError(t, os.MkdirAll(configDir, 0755))
+
+	caCert := filepath.Join(t.TempDir(), "ca.pem")
+	writeTestCACertificate(t, caCert)
+	chainID := int64(2021)
+	stack := &types.Stack{Name: "firefly_eth", ChainIDPtr: &chainID, DownstreamRPCCACert: caCert}
+	p := &EthSignerProvider{ctx: context.Background(), stack: stack}
+
+	assert.NoError(t, p.WriteConfig(&types.InitOptions{ChainID: chainID}, "https://rpc.example.com"))
+	copied, err := os.ReadFile(filepath.Join(configDir, downstreamCAFile))
+	assert.NoError(t, err)
+	original, err := os.ReadFile(caCert)
+	assert.NoError(t, err)
+	assert.Equal(t, original, copied)
+	config, err := os.ReadFile(filepath.Join(configDir, "ethsigner.yaml"))
+	assert.NoError(t, err)
+	assert.Contains(t, string(config), "  tls:\n    enabled: true\n    caFile: /etc/firefly/downstream-ca.pem\n")
+
+	stack.SignerType = types.SignerTypeJava
+	command, err := p.getCommand("https://rpc.example.com")
+	assert.NoError(t, err)
+	assert.Contains(t, command, " --downstream-http-tls-ca-file=/etc/firefly/downstream-ca.pem ")
+}
+
+func TestWriteSignerConfigCleanupOnFailure(t *testing.T) {
+	stacksDir := constants.StacksDir
+	defer func() { constants.StacksDir = stacksDir }()
+	constants.StacksDir = t.TempDir()
+	initDir := filepath.Join(constants.StacksDir, "firefly_eth", "init")
+	configDir := filepath.Join(initDir, "config")
+	assert.NoError(t, os.MkdirAll(configDir, 0755))
+	existing := filepath.Join(configDir, "firefly_core_0.yml")
+	assert.NoError(t, os.WriteFile(existing, []byte("existing"), 0644))
+
+	// A directory in the way of the signer config fails the write after the CA file and keystore directory are created
+	assert.NoError(t, os.MkdirAll(filepath.Join(configDir, "ethsigner.yaml", "stray"), 0755))
+
+	caCert := filepath.Join(t.TempDir(), "ca.pem")
+	writeTestCACertificate(t, caCert)
+	chainID := int64(2021)
+	p := &EthSignerProvider{ctx: context.Background(), stack: &types.Stack{Name: "firefly_eth", ChainIDPtr: &chainID, DownstreamRPCCACert: caCert}}
+	assert.Error(t, p.WriteConfig(&types.InitOptions{ChainID: chainID}, "https://rpc.example.com"))
+
+	_, err := os.Stat(filepath.Join(configDir, downstreamCAFile))
+	assert.True(t, os.IsNotExist(err))
+	_, err = os.Stat(filepath.Join(initDir, "blockchain"))
+	assert.True(t, os.IsNotExist(err))
+
+	// Only what the failed write created is removed
+	b, err := os.ReadFile(existing)
+	assert.NoError(t, err)
+	assert.Equal(t, "existing", string(b))
+	_, err = os.Stat(filepath.Join(configDir, "ethsigner.yaml", "stray"))
+	assert.NoError(t, err)
+}
+
+func TestCreatedPaths(t *testing.T) {
+	dir := t.TempDir()
+	existing := filepath.Join(dir, "existing")
+	assert.NoError(t, os.MkdirAll(existing, 0755))
+
+	created := &createdPaths{}
+	assert.NoError(t, created.mkdirAll(filepath.Join(existing, "a", "b"), 0755))
+	assert.NoError(t, created.mkdirAll(existing, 0755))
+	assert.NoError(t, created.track(filepath.Join(existing, "new.txt")))
+	assert.NoError(t, os.WriteFile(filepath.Join(existing, "new.txt"), []byte{}, 0644))
+	assert.NoError(t, created.track(filepath.Join(existing, "new.txt")))
+	assert.Equal(t, []string{filepath.Join(existing, "a"), filepath.Join(existing, "new.txt")}, created.paths)
+
+	assert.NoError(t, created.remove())
+	entries, err := os.ReadDir(existing)
+	assert.NoError(t, err)
+	assert.Empty(t, entries)
+	assert.Empty(t, created.paths)
+}
+
+func TestSignerBindAddress(t *testing.T) {
+	testcases := []struct {
+		Name        string
+		BindAddress string
+		Port        string
+	}{
+		{Name: "default", BindAddress: "", Port: "127.0.0.1:5100:8545"},
+		{Name: "all-interfaces", BindAddress: "0.0.0.0", Port: "0.0.0.0:5100:8545"},
+		{Name: "ipv6", BindAddress: "::1", Port: "[::1]:5100:8545"},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.Name, func(t *testing.T) {
+			stack := &types.Stack{
+				Name:                  "firefly_eth",
+				ExposedBlockchainPort: 5100,
+				SignerBindAddress:     tc.BindAddress,
+				VersionManifest:       &types.VersionManifest{Signer: &types.ManifestEntry{Image: "ghcr.io/hyperledger/firefly-signer"}},
+			}
+			p := &EthSignerProvider{stack: stack}
+			assert.Equal(t, []string{tc.Port}, serviceDefinition(t, p).Service.Ports)
+		})
+	}
+
+	assert.NoError(t, ValidateBindAddress("0.0.0.0"))
+	assert.Regexp(t, "invalid signer bind address 'localhost': must be an IP address", ValidateBindAddress("localhost"))
+}
+
+func TestPrintSignerConfig(t *testing.T) {
+	stacksDir := constants.StacksDir
+	defer func() { constants.StacksDir = stacksDir }()
+	constants.StacksDir = t.TempDir()
+	configDir := filepath.Join(constants.StacksDir, "firefly_eth", "init", "config")
+
+	chainID := int64(2021)
+	stack := &types.Stack{Name: "firefly_eth", ChainIDPtr: &chainID, DownstreamRPCAuth: &types.RPCAuthConfig{Username: "user", Password: "secret"}}
+	out := &strings.Builder{}
+	p := &EthSignerProvider{ctx: context.Background(), stack: stack, out: out}
+
+	// Rendering the config has no side effects
+	signerConfig, err := p.SignerConfig("https://rpc.example.com")
+	assert.NoError(t, err)
+	assert.Equal(t, "secret", signerConfig.Backend.Auth.Password)
+	assert.NoDirExists(t, configDir)
+
+	assert.NoError(t, os.MkdirAll(configDir, 0755))
+	assert.NoError(t, p.WriteConfig(&types.InitOptions{ChainID: chainID, PrintSignerConfig: true}, "https://rpc.example.com"))
+	assert.Contains(t, out.String(), "url: https://rpc.example.com:443\n")
+	assert.Contains(t, out.String(), "password: <redacted>\n")
+	assert.NotContains(t, out.String(), "secret")
+	written, err := os.ReadFile(filepath.Join(configDir, "ethsigner.yaml"))
+	assert.NoError(t, err)
+	assert.Contains(t, string(written), "password: secret\n")
+}
+
+func TestDuplicateMemberAddresses(t *testing.T) {
+	chainID := int64(2021)
+	account := &ethereum.Account{Address: "0x1111111111111111111111111111111111111111"}
+	stack := &types.Stack{
+		Name:       "firefly_eth",
+		ChainIDPtr: &chainID,
+		Members: []*types.Organization{
+			{ID: "0", OrgName: "org_0", Account: account},
+			{ID: "1", OrgName: "org_1", Account: &ethereum.Account{Address: account.Address}},
+		},
+	}
+	p := &EthSignerProvider{ctx: context.Background(), stack: stack, out: &strings.Builder{}}
+
+	err := p.WriteConfig(&types.InitOptions{ChainID: chainID}, "http://besu:8545")
+	assert.Regexp(t, `address 0x1111111111111111111111111111111111111111 is used by both member 0 \('org_0'\) and member 1 \('org_1'\)`, err)
+	err = p.FirstTimeSetup()
+	assert.Regexp(t, "is used by both member 0", err)
+}
+
+func TestSignerLog(t *testing.T) {
+	stacksDir := constants.StacksDir
+	defer func() { constants.StacksDir = stacksDir }()
+	constants.StacksDir = t.TempDir()
+
+	testcases := []struct {
+		Name       string
+		SignerLog  *types.SignerLogConfig
+		SignerType fftypes.FFEnum
+		Config     string
+		Command    string
+	}{
+		{Name: "default", Config: "log:\n  level: info\n"},
+		{Name: "level", SignerLog: &types.SignerLogConfig{Level: "warn"}, Config: "log:\n  level: warn\n"},
+		{Name: "json", SignerLog: &types.SignerLogConfig{Level: "trace", Format: "json"}, Config: "log:\n  level: trace\n  json:\n    enabled: true\n"},
+		{Name: "text", SignerLog: &types.SignerLogConfig{Format: "text"}, Config: "log:\n  level: info\n"},
+		{Name: "java default", SignerType: types.SignerTypeJava, Command: "--logging=INFO "},
+		{Name: "java level", SignerLog: &types.SignerLogConfig{Level: "error"}, SignerType: types.SignerTypeJava, Command: "--logging=ERROR "},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.Name, func(t *testing.T) {
+			chainID := int64(2021)
+			stack := &types.Stack{
+				Name:            "firefly_eth",
+				ChainIDPtr:      &chainID,
+				SignerType:      tc.SignerType,
+				SignerLog:       tc.SignerLog,
+				VersionManifest: &types.VersionManifest{Signer: &types.ManifestEntry{Image: JavaSignerImage}},
+			}
+			out := &strings.Builder{}
+			p := &EthSignerProvider{ctx: context.Background(), stack: stack, DryRun: true, out: out}
+
+			assert.NoError(t, p.WriteConfig(&types.InitOptions{ChainID: chainID}, "http://besu:8545"))
+			command := serviceDefinition(t, p).Service.Command
+			if tc.Command != "" {
+				assert.True(t, strings.HasPrefix(command, tc.Command), command)
+			} else {
+				assert.True(t, strings.HasSuffix(out.String(), tc.Config), out.String())
+			}
+		})
+	}
+}
+
+// testLogger is a logger that records the warnings it is given
+type testLogger struct {
+	log.StdoutLogger
+	warnings []string
+}
+
+func (l *testLogger) Warn(s string) {
+	l.warnings = append(l.warnings, s)
+}
+
+// serviceDefinition returns the signer service of a stack whose RPC node is besu
+func serviceDefinition(t *testing.T, p *EthSignerProvider) *docker.ServiceDefinition {
+	definition, err := p.GetDockerServiceDefinition("http://besu:8545")
+	assert.NoError(t, err)
+	return definition
+}
+
+func TestSignerExtraArgs(t *testing.T) {
+	stacksDir := constants.StacksDir
+	defer func() { constants.StacksDir = stacksDir }()
+	constants.StacksDir = t.TempDir()
+
+	testcases := []struct {
+		Name       string
+		SignerType fftypes.FFEnum
+		Entrypoint []string
+		ExtraArgs  []string
+		Command    string
+		Warning    string
+	}{
+		{Name: "none", Command: ""},
+		{Name: "firefly-signer", ExtraArgs: []string{"--metrics-enabled", "-v"}, Command: "--metrics-enabled -v"},
+		{Name: "entrypoint", Entrypoint: []string{"/usr/bin/ffsigner", "-f", "/etc/firefly/custom.ffsigner"}, Command: ""},
+		{
+			Name:       "java",
+			SignerType: types.SignerTypeJava,
+			ExtraArgs:  []string{"--http-listen-host=0.0.0.0"},
+			Command:    "--logging=INFO --chain-id=2021 --downstream-http-host=besu --downstream-http-port=8545 --http-listen-host=0.0.0.0 multikey-signer --directory=/data/keystore",
+		},
+		{
+			Name:       "java conflict",
+			SignerType: types.SignerTypeJava,
+			ExtraArgs:  []string{"--chain-id=1337"},
+			Command:    "--logging=INFO --chain-id=2021 --downstream-http-host=besu --downstream-http-port=8545 --chain-id=1337 multikey-signer --directory=/data/keystore",
+			Warning:    "the extra signer argument --chain-id is already set by the CLI",
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.Name, func(t *testing.T) {
+			chainID := int64(2021)
+			stack := &types.Stack{
+				Name:             "firefly_eth",
+				ChainIDPtr:       &chainID,
+				SignerType:       tc.SignerType,
+				SignerEntrypoint: tc.Entrypoint,
+				SignerExtraArgs:  tc.ExtraArgs,
+				VersionManifest:  &types.VersionManifest{Signer: &types.ManifestEntry{Image: JavaSignerImage}},
+			}
+			logger := &testLogger{}
+			p := &EthSignerProvider{ctx: log.WithLogger(context.Background(), logger), stack: stack, DryRun: true, out: &strings.Builder{}}
+
+			assert.NoError(t, p.WriteConfig(&types.InitOptions{ChainID: chainID}, "http://besu:8545"))
+			service := serviceDefinition(t, p).Service
+			assert.Equal(t, tc.Command, service.Command)
+			assert.Equal(t, tc.Entrypoint, service.EntryPoint)
+			if tc.Warning != "" {
+				assert.Len(t, logger.warnings, 1)
+				assert.Contains(t, logger.warnings[0], tc.Warning)
+			} else {
+				assert.Empty(t, logger.warnings)
+			}
+		})
+	}
+}
+
+func TestInvalidJavaSignerRPCURL(t *testing.T) {
+	stacksDir := constants.StacksDir
+	defer func() { constants.StacksDir = stacksDir }()
+	constants.StacksDir = t.TempDir()
+	chainID := int64(2021)
+	stack := &types.Stack{
+		Name:            "firefly_eth",
+		ChainIDPtr:      &chainID,
+		SignerType:      types.SignerTypeJava,
+		VersionManifest: &types.VersionManifest{Signer: &types.ManifestEntry{Image: JavaSignerImage}},
+	}
+	p := &EthSignerProvider{ctx: log.WithLogger(context.Background(), &log.StdoutLogger{}), stack: stack, DryRun: true, out: &strings.Builder{}}
+
+	err := p.WriteConfig(&types.InitOptions{ChainID: chainID}, "ftp://besu:8545")
+	assert.ErrorIs(t, err, ErrSignerConfigInvalid)
+	assert.Regexp(t, "RPC URL invalid 'ftp://besu:8545'", err)
+
+	definition, err := p.GetDockerServiceDefinition("ftp://besu:8545")
+	assert.Nil(t, definition)
+	assert.ErrorIs(t, err, ErrSignerConfigInvalid)
+}
+
+func TestConflictingArgs(t *testing.T) {
+	generated := []string{"--chain-id=2021", "--downstream-http-tls-enabled", "multikey-signer"}
+	assert.Equal(t, []string{}, ConflictingArgs(generated, []string{"--metrics-enabled", "multikey-signer"}))
+	assert.Equal(t, []string{"--chain-id", "--downstream-http-tls-enabled"}, ConflictingArgs(generated, []string{"--chain-id", "--downstream-http-tls-enabled=false"}))
+	assert.Equal(t, []string{}, ConflictingArgs(nil, []string{"--chain-id=1"}))
+}
+
+func TestEnsureRuntimeFiles(t *testing.T) {
+	keyFile := "1f8ad7b4ea06e3a4cd2a6ae7ef2e3c78a7a6bd2c"
+	files := []string{
+		"config/ethsigner.yaml",
+		"blockchain/keystore/" + keyFile,
+		"blockchain/keystore/" + keyFile + ".toml",
+		"blockchain/" + keyFile + ".password",
+	}
+	newProvider := func(t *testing.T, initFiles, runtimeFiles []string) *EthSignerProvider {
+		stackDir := t.TempDir()
+		stack := &types.Stack{
+			Name:       "firefly_eth",
+			InitDir:    filepath.Join(stackDir, "init"),
+			RuntimeDir: filepath.Join(stackDir, "runtime"),
+			Members:    []*types.Organization{{ID: "0", Account: &ethereum.Account{Address: "0x" + keyFile}}},
+		}
+		for dir, names := range map[string][]string{stack.InitDir: initFiles, stack.RuntimeDir: runtimeFiles} {
+			for _, name := range names {
+				assert.NoError(t, os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0700))
+				assert.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(name), 0600))
+			}
+		}
+		return &EthSignerProvider{ctx: log.WithLogger(context.Background(), &log.StdoutLogger{}), stack: stack}
+	}
+
+	t.Run("intact", func(t *testing.T) {
+		p := newProvider(t, nil, files)
+		assert.NoError(t, p.ensureRuntimeFiles())
+		assert.NoDirExists(t, p.stack.InitDir)
+	})
+
+	t.Run("recoverable", func(t *testing.T) {
+		// Only the config survived in the runtime directory, and the older stack has a shared password file
+		p := newProvider(t, []string{files[1], files[2], "blockchain/password"}, []string{files[0]})
+		assert.NoError(t, p.ensureRuntimeFiles())
+		for _, name := range []string{files[1], files[2], "blockchain/password"} {
+			b, err := os.ReadFile(filepath.Join(p.stack.RuntimeDir, name))
+			assert.NoError(t, err)
+			assert.Equal(t, name, string(b))
+		}
+	})
+
+	t.Run("unrecoverable", func(t *testing.T) {
+		// The toml key file is optional, as older stacks do not have one
+		p := newProvider(t, []string{files[3]}, []string{files[1]})
+		err := p.ensureRuntimeFiles()
+		assert.EqualError(t, err, "stack 'firefly_eth' is missing config/ethsigner.yaml from both its init and runtime directories. Remove the stack and run 'ff init' to create it again")
+		assert.FileExists(t, filepath.Join(p.stack.RuntimeDir, files[3]))
+	})
+}
+
+func TestSignerMetrics(t *testing.T) {
+	stacksDir := constants.StacksDir
+	defer func() { constants.StacksDir = stacksDir }()
+	constants.StacksDir = t.TempDir()
+
+	newProvider := func(signerType fftypes.FFEnum, metrics bool) (*EthSignerProvider, *strings.Builder) {
+		chainID := int64(2021)
+		stack := &types.Stack{
+			Name:                     "firefly_eth",
+			ChainIDPtr:               &chainID,
+			ExposedBlockchainPort:    5100,
+			SignerType:               signerType,
+			SignerMetricsEnabled:     metrics,
+			ExposedSignerMetricsPort: 6100,
+			VersionManifest:          &types.VersionManifest{Signer: &types.ManifestEntry{Image: JavaSignerImage}},
+		}
+		out := &strings.Builder{}
+		return &EthSignerProvider{ctx: context.Background(), stack: stack, DryRun: true, out: out}, out
+	}
+
+	// Disabling metrics leaves the service definition exactly as it is without the option
+	disabled, _ := newProvider(types.SignerTypeJava, false)
+	unset, _ := newProvider(types.SignerTypeJava, false)
+	unset.stack.ExposedSignerMetricsPort = 0
+	assert.Equal(t, serviceDefinition(t, unset), serviceDefinition(t, disabled))
+	assert.NotContains(t, serviceDefinition(t, disabled).Service.Command, "metrics")
+
+	// Enabling metrics passes the metrics flags to the Java signer, and publishes the metrics port
+	enabled, _ := newProvider(types.SignerTypeJava, true)
+	service := serviceDefinition(t, enabled).Service
+	assert.Equal(t, []string{"127.0.0.1:5100:8545", "127.0.0.1:6100:6000"}, service.Ports)
+	assert.Contains(t, service.Command, "--metrics-enabled --metrics-host=0.0.0.0 --metrics-port=6000 ")
+
+	// firefly-signer has no metrics, so its config never has a metrics section
+	fireflySigner, out := newProvider("", false)
+	assert.NoError(t, fireflySigner.WriteConfig(&types.InitOptions{ChainID: 2021}, "http://besu:8545"))
+	assert.NotContains(t, out.String(), "metrics")
+}
+
+// fakeSigner answers eth_accounts and eth_signTransaction for its keys, signing for its chain ID
+type fakeSigner struct {
+	t       *testing.T
+	chainID int64
+	keys    map[string]*secp256k1.KeyPair
+	// accounts is answered to eth_accounts instead of the addresses of keys, if set
+	accounts []string
+	// raw returns the signed transaction alone, rather than in an object, and signs it as EIP-1559
+	raw  bool
+	urls []string
+}
+
+func (f *fakeSigner) RoundTrip(req *http.Request) (*http.Response, error) {
+	f.urls = append(f.urls, req.URL.String())
+	var rpcReq struct {
+		Method string                   `json:"method"`
+		Params []map[string]interface{} `json:"params"`
+	}
+	assert.NoError(f.t, json.NewDecoder(req.Body).Decode(&rpcReq))
+	var result interface{}
+	switch rpcReq.Method {
+	case "eth_accounts":
+		accounts := f.accounts
+		if accounts == nil {
+			for address := range f.keys {
+				accounts = append(accounts, address)
+			}
+		}
+		result = accounts
+	case "eth_signTransaction":
+		keyPair, ok := f.keys[strings.ToLower(rpcReq.Params[0]["from"].(string))]
+		if !ok {
+			return f.response(`{"jsonrpc":"2.0","id":1,"error":{"code":-32000,"message":"unknown account"}}`), nil
+		}
+		to := keyPair.Address
+		tx := &ffethsigner.Transaction{To: &to, GasLimit: ethtypes.NewHexInteger64(21000)}
+		if f.raw {
+			tx.MaxFeePerGas = ethtypes.NewHexInteger64(1)
+		}
+		signed, err := tx.Sign(keyPair, f.chainID)
+		assert.NoError(f.t, err)
+		if f.raw {
+			result = "0x" + hex.EncodeToString(signed)
+		} else {
+			result = map[string]string{"raw": "0x" + hex.EncodeToString(signed)}
+		}
+	}
+	b, err := json.Marshal(map[string]interface{}{"jsonrpc": "2.0", "id": 1, "result": result})
+	assert.NoError(f.t, err)
+	return f.response(string(b)), nil
+}
+
+func (f *fakeSigner) response(body string) *http.Response {
+	return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(body)), Header: http.Header{}}
+}
+
+func TestVerifySigner(t *testing.T) {
+	key0, err := secp256k1.GenerateSecp256k1KeyPair()
+	assert.NoError(t, err)
+	key1, err := secp256k1.GenerateSecp256k1KeyPair()
+	assert.NoError(t, err)
+	newProvider := func(signer *fakeSigner, chainID int64) *EthSignerProvider {
+		stack := &types.Stack{
+			Name:                  "firefly_eth",
+			ChainIDPtr:            &chainID,
+			ExposedBlockchainPort: 5100,
+			Members: []*types.Organization{
+				{ID: "0", Account: &ethereum.Account{Address: key0.Address.String()}},
+				{ID: "1", Account: &ethereum.Account{Address: key1.Address.String()}},
+			},
+		}
+		return &EthSignerProvider{ctx: context.Background(), stack: stack, HTTPClient: &http.Client{Transport: signer}}
+	}
+	keys := map[string]*secp256k1.KeyPair{key0.Address.String(): key0, key1.Address.String(): key1}
+
+	signer := &fakeSigner{t: t, chainID: 2021, keys: keys}
+	assert.NoError(t, newProvider(signer, 2021).VerifySigner())
+	assert.Equal(t, []string{"http://127.0.0.1:5100/", "http://127.0.0.1:5100/", "http://127.0.0.1:5100/"}, signer.urls)
+
+	// The signed transaction may be returned raw, here as an EIP-1559 transaction rather than a legacy one
+	assert.NoError(t, newProvider(&fakeSigner{t: t, chainID: 2021, keys: keys, raw: true}, 2021).VerifySigner())
+
+	err = newProvider(&fakeSigner{t: t, chainID: 1337, keys: keys}, 2021).VerifySigner()
+	assert.EqualError(t, err, fmt.Sprintf("the signer signed a transaction from account %s of member 0 for chain ID 1337, but the chain ID of stack 'firefly_eth' is 2021", key0.Address.String()))
+
+	err = newProvider(&fakeSigner{t: t, chainID: 2021, keys: map[string]*secp256k1.KeyPair{key0.Address.String(): key0}}, 2021).VerifySigner()
+	assert.EqualError(t, err, fmt.Sprintf("the signer does not hold account %s of member 1", key1.Address.String()))
+
+	// The signer lists a key that it cannot sign with
+	p := newProvider(&fakeSigner{t: t, chainID: 2021, keys: map[string]*secp256k1.KeyPair{key0.Address.String(): key0}, accounts: []string{key0.Address.String(), key1.Address.String()}}, 2021)
+	err = p.VerifySigner()
+	assert.EqualError(t, err, fmt.Sprintf("the signer could not sign a transaction from account %s of member 1 for chain ID 2021: unknown account", key1.Address.String()))
+
+	p.HTTPClient.Transport = roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		return nil, fmt.Errorf("connection refused")
+	})
+	assert.Regexp(t, "signer at http://127.0.0.1:5100/ did not answer eth_accounts: .*connection refused", p.VerifySigner())
+
+	// Every signing account of a member is verified, not only its primary account, while those of a member
+	// that does not sign are not
+	key2, err := secp256k1.GenerateSecp256k1KeyPair()
+	assert.NoError(t, err)
+	p = newProvider(&fakeSigner{t: t, chainID: 2021, keys: keys}, 2021)
+	p.stack.Members[0].AdditionalAccounts = []interface{}{&ethereum.Account{Address: key2.Address.String()}}
+	assert.EqualError(t, p.VerifySigner(), fmt.Sprintf("the signer does not hold account %s of member 0", key2.Address.String()))
+	p.stack.Members[0].NonSigning = true
+	assert.NoError(t, p.VerifySigner())
+
+	// A remote signer is reached on its configured URL
+	signer = &fakeSigner{t: t, chainID: 2021, keys: keys}
+	p = newProvider(signer, 2021)
+	p.stack.RemoteSignerURL = "https://signer.example.com:8545/rpc"
+	assert.NoError(t, p.VerifySigner())
+	assert.Equal(t, []string{"https://signer.example.com:8545/rpc", "https://signer.example.com:8545/rpc", "https://signer.example.com:8545/rpc"}, signer.urls)
+}
+
+type roundTripFunc func(req *http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestJavaSignerDownstreamArgs(t *testing.T) {
+	testcases := []struct {
+		URL  string
+		Args []string
+	}{
+		{URL: "http://besu:8545", Args: []string{"--downstream-http-host=besu", "--downstream-http-port=8545"}},
+		{URL: "http://10.0.0.1/rpc", Args: []string{"--downstream-http-host=10.0.0.1", "--downstream-http-path=/rpc", "--downstream-http-port=80"}},
+		{URL: "http://[::1]:8545", Args: []string{"--downstream-http-host=::1", "--downstream-http-port=8545"}},
+		{URL: "https://user:secret@[2001:db8::1]/v1", Args: []string{"--downstream-http-host=2001:db8::1", "--downstream-http-tls-enabled", "--downstream-http-path=/v1", "--downstream-http-port=443"}},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.URL, func(t *testing.T) {
+			p := &EthSignerProvider{stack: &types.Stack{Name: "firefly_eth", SignerType: types.SignerTypeJava}}
+			args, err := p.generatedArgs(tc.URL)
+			assert.NoError(t, err)
+			downstreamArgs := []string{}
+			for _, arg := range args {
+				if strings.HasPrefix(arg, "--downstream-http-") {
+					downstreamArgs = append(downstreamArgs, arg)
+				}
+			}
+			assert.Equal(t, tc.Args, downstreamArgs)
+		})
+	}
+}
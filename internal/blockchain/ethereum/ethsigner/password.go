@@ -0,0 +1,141 @@
+// Copyright © 2024 Kaleido, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ethsigner
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/hyperledger/firefly-cli/internal/docker"
+	"github.com/hyperledger/firefly-cli/pkg/types"
+	"golang.org/x/term"
+)
+
+// keystorePasswordSecret is the docker secret that passes the keystore password to the signer, for every password
+// source but plaintext
+const keystorePasswordSecret = "keystore_password"
+
+// plaintextPasswords returns whether each key of the stack has its own random password, which is written next to
+// the key and copied into the signer volume
+func (p *EthSignerProvider) plaintextPasswords() bool {
+	return p.stack == nil || p.stack.KeystorePassword == nil || p.stack.KeystorePassword.Source == types.KeystorePasswordPlaintext
+}
+
+// secretPasswordFile is where compose mounts the keystore password secret in the signer container
+func secretPasswordFile() string {
+	return path.Join("/run/secrets", keystorePasswordSecret)
+}
+
+var readPassword = readTerminalPassword
+
+// readTerminalPassword reads a password from the terminal without echoing it
+func readTerminalPassword(prompt string) (string, error) {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return "", fmt.Errorf("standard input is not a terminal")
+	}
+	fmt.Fprint(os.Stderr, prompt)
+	b, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	return string(b), err
+}
+
+// keystorePassword returns the single password that every key of the stack is encrypted with, from the source the
+// stack is configured with. A prompted password is entered twice when confirm is set, as it is about to encrypt
+// the first keys of the stack, and is then kept in the environment of this process for compose to pass on.
+func (p *EthSignerProvider) keystorePassword(confirm bool) (string, error) {
+	source := p.stack.KeystorePassword
+	if p.plaintextPasswords() {
+		return "", fmt.Errorf("stack '%s' generates a separate password for each key", p.stack.Name)
+	}
+	switch source.Source {
+	case types.KeystorePasswordFile:
+		b, err := os.ReadFile(source.File)
+		if err != nil {
+			return "", fmt.Errorf("failed to read the keystore password of stack '%s': %s", p.stack.Name, err)
+		}
+		password := strings.TrimRight(string(b), "\r\n")
+		if password == "" {
+			return "", fmt.Errorf("keystore password file '%s' of stack '%s' is empty", source.File, p.stack.Name)
+		}
+		return password, nil
+	case types.KeystorePasswordEnv:
+		password := os.Getenv(source.PasswordEnv())
+		if password == "" {
+			return "", fmt.Errorf("environment variable %s, which holds the keystore password of stack '%s', is not set", source.PasswordEnv(), p.stack.Name)
+		}
+		return password, nil
+	default:
+		if password := os.Getenv(source.PasswordEnv()); password != "" {
+			return password, nil
+		}
+		password, err := p.promptPassword(confirm)
+		if err != nil {
+			return "", err
+		}
+		if err := os.Setenv(source.PasswordEnv(), password); err != nil {
+			return "", err
+		}
+		return password, nil
+	}
+}
+
+func (p *EthSignerProvider) promptPassword(confirm bool) (string, error) {
+	env := p.stack.KeystorePassword.PasswordEnv()
+	password, err := readPassword(fmt.Sprintf("keystore password for stack '%s': ", p.stack.Name))
+	if err != nil {
+		return "", fmt.Errorf("failed to read the keystore password of stack '%s': %s. Set %s to pass it without a prompt", p.stack.Name, err, env)
+	}
+	if password == "" {
+		return "", fmt.Errorf("the keystore password of stack '%s' cannot be empty", p.stack.Name)
+	}
+	if confirm {
+		again, err := readPassword("confirm the keystore password: ")
+		if err != nil {
+			return "", fmt.Errorf("failed to read the keystore password of stack '%s': %s. Set %s to pass it without a prompt", p.stack.Name, err, env)
+		}
+		if again != password {
+			return "", fmt.Errorf("the keystore passwords entered for stack '%s' do not match", p.stack.Name)
+		}
+	}
+	return password, nil
+}
+
+// PreStart makes sure that the keystore password can be passed to the signer before it starts, prompting for it in
+// prompt mode, so that a missing password fails the start rather than leaving the signer unable to load its keys
+func (p *EthSignerProvider) PreStart() error {
+	if !p.runsSigner() || p.plaintextPasswords() {
+		return nil
+	}
+	_, err := p.keystorePassword(false)
+	return err
+}
+
+// passwordSecrets returns the docker secret that passes the keystore password to the signer, which compose reads
+// from the environment, or straight from the password file of the stack
+func (p *EthSignerProvider) passwordSecrets() map[string]*docker.Secret {
+	if p.plaintextPasswords() {
+		return nil
+	}
+	source := p.stack.KeystorePassword
+	if source.Source == types.KeystorePasswordFile {
+		return map[string]*docker.Secret{keystorePasswordSecret: {File: source.File}}
+	}
+	return map[string]*docker.Secret{keystorePasswordSecret: {Environment: source.PasswordEnv()}}
+}
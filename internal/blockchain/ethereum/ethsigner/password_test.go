@@ -0,0 +1,182 @@
+package ethsigner
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hyperledger/firefly-cli/internal/blockchain/ethereum"
+	"github.com/hyperledger/firefly-cli/internal/docker"
+	"github.com/hyperledger/firefly-cli/pkg/types"
+	"github.com/stretchr/testify/assert"
+)
+
+// stubReadPassword answers each password prompt with the next of the given passwords
+func stubReadPassword(t *testing.T, passwords ...string) *[]string {
+	prompts := &[]string{}
+	original := readPassword
+	t.Cleanup(func() { readPassword = original })
+	readPassword = func(prompt string) (string, error) {
+		*prompts = append(*prompts, prompt)
+		if len(*prompts) > len(passwords) {
+			return "", fmt.Errorf("standard input is not a terminal")
+		}
+		return passwords[len(*prompts)-1], nil
+	}
+	return prompts
+}
+
+func TestKeystorePassword(t *testing.T) {
+	newProvider := func(source *types.PasswordSource) *EthSignerProvider {
+		return &EthSignerProvider{stack: &types.Stack{Name: "firefly_eth", KeystorePassword: source}}
+	}
+
+	t.Run("plaintext", func(t *testing.T) {
+		_, err := newProvider(nil).keystorePassword(false)
+		assert.Regexp(t, "generates a separate password for each key", err)
+		_, err = newProvider(&types.PasswordSource{Source: types.KeystorePasswordPlaintext}).keystorePassword(false)
+		assert.Regexp(t, "generates a separate password for each key", err)
+	})
+
+	t.Run("env", func(t *testing.T) {
+		p := newProvider(&types.PasswordSource{Source: types.KeystorePasswordEnv, Env: "FF_TEST_KEYSTORE_PASSWORD"})
+		t.Setenv("FF_TEST_KEYSTORE_PASSWORD", "")
+		_, err := p.keystorePassword(false)
+		assert.Regexp(t, "environment variable FF_TEST_KEYSTORE_PASSWORD, which holds the keystore password of stack 'firefly_eth', is not set", err)
+
+		t.Setenv("FF_TEST_KEYSTORE_PASSWORD", "s3cret")
+		password, err := p.keystorePassword(true)
+		assert.NoError(t, err)
+		assert.Equal(t, "s3cret", password)
+	})
+
+	t.Run("file", func(t *testing.T) {
+		passwordFile := filepath.Join(t.TempDir(), "password")
+		p := newProvider(&types.PasswordSource{Source: types.KeystorePasswordFile, File: passwordFile})
+		_, err := p.keystorePassword(false)
+		assert.Regexp(t, "failed to read the keystore password of stack 'firefly_eth'", err)
+
+		assert.NoError(t, os.WriteFile(passwordFile, []byte("\n"), 0600))
+		_, err = p.keystorePassword(false)
+		assert.Regexp(t, "is empty", err)
+
+		assert.NoError(t, os.WriteFile(passwordFile, []byte("s3cret\n"), 0600))
+		password, err := p.keystorePassword(false)
+		assert.NoError(t, err)
+		assert.Equal(t, "s3cret", password)
+	})
+
+	t.Run("prompt", func(t *testing.T) {
+		t.Setenv(types.DefaultKeystorePasswordEnv, "")
+		p := newProvider(&types.PasswordSource{Source: types.KeystorePasswordPrompt})
+
+		stubReadPassword(t, "s3cret", "typo")
+		_, err := p.keystorePassword(true)
+		assert.Regexp(t, "the keystore passwords entered for stack 'firefly_eth' do not match", err)
+
+		stubReadPassword(t)
+		_, err = p.keystorePassword(false)
+		assert.Regexp(t, "standard input is not a terminal. Set FF_KEYSTORE_PASSWORD to pass it without a prompt", err)
+
+		prompts := stubReadPassword(t, "s3cret", "s3cret")
+		password, err := p.keystorePassword(true)
+		assert.NoError(t, err)
+		assert.Equal(t, "s3cret", password)
+		assert.Len(t, *prompts, 2)
+
+		// The password is kept for compose, and for the rest of the process
+		assert.Equal(t, "s3cret", os.Getenv(types.DefaultKeystorePasswordEnv))
+		password, err = p.keystorePassword(true)
+		assert.NoError(t, err)
+		assert.Equal(t, "s3cret", password)
+		assert.Len(t, *prompts, 2)
+	})
+}
+
+func TestCreateAccountPasswordSource(t *testing.T) {
+	t.Setenv("FF_TEST_KEYSTORE_PASSWORD", "s3cret")
+	stack := &types.Stack{
+		Name:             "firefly_eth",
+		InitDir:          t.TempDir(),
+		KeystorePassword: &types.PasswordSource{Source: types.KeystorePasswordEnv, Env: "FF_TEST_KEYSTORE_PASSWORD"},
+	}
+	p := &EthSignerProvider{stack: stack}
+
+	account, err := p.CreateAccount([]string{})
+	assert.NoError(t, err)
+	address := account.(*ethereum.Account).Address
+	keyFile := address[2:]
+
+	// No password is written to disk, and the signer reads it from the secret
+	_, err = os.Stat(filepath.Join(stack.InitDir, "blockchain", keyFile+".password"))
+	assert.True(t, os.IsNotExist(err))
+	toml, err := os.ReadFile(filepath.Join(stack.InitDir, "blockchain", "keystore", keyFile+".toml"))
+	assert.NoError(t, err)
+	assert.Contains(t, string(toml), `password-file = "/run/secrets/keystore_password"`)
+	for _, file := range p.runtimeFiles() {
+		assert.NotContains(t, file.names[0], ".password")
+	}
+
+	exported, err := p.ExportAccount(address)
+	assert.NoError(t, err)
+	assert.Equal(t, account.(*ethereum.Account).PrivateKey, exported["privateKey"])
+
+	t.Setenv("FF_TEST_KEYSTORE_PASSWORD", "")
+	_, err = p.ExportAccount(address)
+	assert.Regexp(t, "FF_TEST_KEYSTORE_PASSWORD, which holds the keystore password of stack 'firefly_eth', is not set", err)
+}
+
+func TestPasswordSecrets(t *testing.T) {
+	manifest := &types.VersionManifest{Signer: &types.ManifestEntry{Image: "ghcr.io/hyperledger/firefly-signer", Tag: "v0.9.6"}}
+	testCases := []struct {
+		name    string
+		source  *types.PasswordSource
+		secrets map[string]*docker.Secret
+	}{
+		{name: "default"},
+		{name: "plaintext", source: &types.PasswordSource{Source: types.KeystorePasswordPlaintext}},
+		{
+			name:    "env",
+			source:  &types.PasswordSource{Source: types.KeystorePasswordEnv, Env: "FF_TEST_KEYSTORE_PASSWORD"},
+			secrets: map[string]*docker.Secret{"keystore_password": {Environment: "FF_TEST_KEYSTORE_PASSWORD"}},
+		},
+		{
+			name:    "file",
+			source:  &types.PasswordSource{Source: types.KeystorePasswordFile, File: "/etc/firefly/password"},
+			secrets: map[string]*docker.Secret{"keystore_password": {File: "/etc/firefly/password"}},
+		},
+		{
+			name:    "prompt",
+			source:  &types.PasswordSource{Source: types.KeystorePasswordPrompt},
+			secrets: map[string]*docker.Secret{"keystore_password": {Environment: types.DefaultKeystorePasswordEnv}},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			p := &EthSignerProvider{stack: &types.Stack{Name: "firefly_eth", VersionManifest: manifest, KeystorePassword: tc.source}}
+			definition := serviceDefinition(t, p)
+			assert.Equal(t, tc.secrets, definition.Secrets)
+			if tc.secrets == nil {
+				assert.Empty(t, definition.Service.Secrets)
+			} else {
+				assert.Equal(t, []string{"keystore_password"}, definition.Service.Secrets)
+			}
+		})
+	}
+}
+
+func TestPreStartPasswordSource(t *testing.T) {
+	t.Setenv(types.DefaultKeystorePasswordEnv, "")
+	stubReadPassword(t)
+	p := &EthSignerProvider{stack: &types.Stack{Name: "firefly_eth"}}
+	assert.NoError(t, p.PreStart())
+
+	p.stack.KeystorePassword = &types.PasswordSource{Source: types.KeystorePasswordPrompt}
+	assert.Regexp(t, "failed to read the keystore password of stack 'firefly_eth'", p.PreStart())
+
+	prompts := stubReadPassword(t, "s3cret")
+	assert.NoError(t, p.PreStart())
+	assert.Len(t, *prompts, 1)
+	assert.Equal(t, "s3cret", os.Getenv(types.DefaultKeystorePasswordEnv))
+}
@@ -0,0 +1,168 @@
+// Copyright © 2024 Kaleido, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ethsigner
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/firefly-cli/internal/blockchain/ethereum"
+	"github.com/hyperledger/firefly-cli/internal/docker"
+	"github.com/hyperledger/firefly-cli/internal/log"
+	"github.com/hyperledger/firefly-cli/pkg/types"
+	"github.com/hyperledger/firefly-signer/pkg/keystorev3"
+	"gopkg.in/yaml.v2"
+)
+
+// signerConfigFile is the name of the firefly-signer config in the config volume of the signer
+const signerConfigFile = "firefly.ffsigner"
+
+// ExistingAccounts returns the accounts in the keystore of a signer volume that was left behind by an earlier
+// stack with the same name, so that a stack being initialized again can reuse them rather than generating new
+// ones. It returns no accounts when there is no such volume, or its keystore is empty, in which case the stack
+// starts with a fresh keystore. The accounts are in the order of their addresses, as the keystore does not record
+// which member each belonged to, and the keystore is refused if it was set up for a different chain ID.
+func ExistingAccounts(ctx context.Context, stack *types.Stack) ([]*ethereum.Account, error) {
+	p := NewEthSignerProvider(ctx, stack)
+	volumeName := p.volumeName()
+	exists, err := docker.VolumeExists(ctx, volumeName)
+	if err != nil || !exists {
+		return nil, err
+	}
+	entries, err := docker.ListFilesInVolume(ctx, volumeName, p.volumeKeystoreDirectory())
+	if err != nil {
+		return nil, err
+	}
+	walletFiles := []string{}
+	for _, entry := range entries {
+		if !strings.HasSuffix(entry, ".toml") {
+			walletFiles = append(walletFiles, entry)
+		}
+	}
+	if len(walletFiles) == 0 {
+		return nil, nil
+	}
+	if err := p.checkExistingChainID(ctx, volumeName); err != nil {
+		return nil, err
+	}
+
+	var rootFiles map[string]bool
+	accounts := make([]*ethereum.Account, 0, len(walletFiles))
+	for _, walletFile := range walletFiles {
+		var password []byte
+		if p.plaintextPasswords() {
+			if rootFiles == nil {
+				if rootFiles, err = listVolumeRoot(ctx, volumeName); err != nil {
+					return nil, err
+				}
+			}
+			if password, err = p.existingPassword(ctx, volumeName, walletFile, rootFiles); err != nil {
+				return nil, err
+			}
+		} else {
+			keystorePassword, err := p.keystorePassword(false)
+			if err != nil {
+				return nil, err
+			}
+			password = []byte(keystorePassword)
+		}
+		walletJSON, err := docker.ReadFileFromVolume(ctx, volumeName, path.Join(p.volumeKeystoreDirectory(), walletFile))
+		if err != nil {
+			return nil, err
+		}
+		wallet, err := keystorev3.ReadWalletFile(walletJSON, password)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt the keystore file '%s' in volume '%s': %s", walletFile, volumeName, err)
+		}
+		keyPair := wallet.KeyPair()
+		accounts = append(accounts, &ethereum.Account{
+			Address:    keyPair.Address.String(),
+			PrivateKey: hex.EncodeToString(keyPair.PrivateKeyBytes()),
+		})
+	}
+	return accounts, nil
+}
+
+// checkExistingChainID makes sure that an existing keystore was set up for the chain ID of the stack. That is
+// recorded in the volume of a shared signer, and otherwise is in the firefly-signer config that was copied into the
+// config volume alongside the keystore. The Java signer is passed its chain ID on its command line instead, so the
+// chain ID of its keystore cannot be checked.
+func (p *EthSignerProvider) checkExistingChainID(ctx context.Context, volumeName string) error {
+	chainIDs, err := docker.ListFilesInVolume(ctx, volumeName, sharedChainsDirectory)
+	if err != nil {
+		return err
+	}
+	if len(chainIDs) > 0 {
+		if chainID := strconv.FormatInt(p.stack.ChainID(), 10); chainIDs[0] != chainID {
+			return withKind(ErrSignerConfigInvalid, fmt.Errorf("the keystore in volume '%s' holds the accounts of chain ID %s, so they cannot be reused by stack '%s' on chain ID %s", volumeName, chainIDs[0], p.stack.Name, chainID))
+		}
+		return nil
+	}
+	if p.useJavaSigner() {
+		log.LoggerFromContext(ctx).Warn(fmt.Sprintf("the chain ID of the keystore in volume '%s' is not recorded by the %s signer, so make sure its accounts are for chain ID %d", volumeName, types.SignerTypeJava, p.stack.ChainID()))
+		return nil
+	}
+	configVolumeName := p.configVolumeName()
+	files, err := listVolumeRoot(ctx, configVolumeName)
+	if err != nil {
+		return err
+	}
+	if !files[signerConfigFile] {
+		return withKind(ErrSignerConfigInvalid, fmt.Errorf("the chain ID of the keystore in volume '%s' is not known, as volume '%s' has no signer config, so its accounts cannot be reused", p.volumeName(), configVolumeName))
+	}
+	b, err := docker.ReadFileFromVolume(ctx, configVolumeName, signerConfigFile)
+	if err != nil {
+		return err
+	}
+	var config Config
+	if err := yaml.Unmarshal(b, &config); err != nil || config.Backend.ChainID == nil {
+		return withKind(ErrSignerConfigInvalid, fmt.Errorf("the chain ID of the keystore in volume '%s' is not known, as the signer config in volume '%s' is invalid, so its accounts cannot be reused", p.volumeName(), configVolumeName))
+	}
+	if chainID := p.stack.ChainID(); *config.Backend.ChainID != chainID {
+		return withKind(ErrSignerConfigInvalid, fmt.Errorf("the keystore in volume '%s' holds the accounts of chain ID %d, so they cannot be reused by stack '%s' on chain ID %d", p.volumeName(), *config.Backend.ChainID, p.stack.Name, chainID))
+	}
+	return nil
+}
+
+// existingPassword reads the password of a wallet file in the signer volume, falling back to the single shared
+// password file of stacks initialized by older versions of the CLI
+func (p *EthSignerProvider) existingPassword(ctx context.Context, volumeName, walletFile string, rootFiles map[string]bool) ([]byte, error) {
+	passwordFile := fmt.Sprintf("%s.password", walletFile)
+	if !rootFiles[passwordFile] {
+		passwordFile = legacyPasswordFile
+	}
+	if !rootFiles[passwordFile] {
+		return nil, fmt.Errorf("no password was found for the keystore file '%s' in volume '%s'", walletFile, volumeName)
+	}
+	return docker.ReadFileFromVolume(ctx, volumeName, passwordFile)
+}
+
+func listVolumeRoot(ctx context.Context, volumeName string) (map[string]bool, error) {
+	entries, err := docker.ListFilesInVolume(ctx, volumeName, "/")
+	if err != nil {
+		return nil, err
+	}
+	files := make(map[string]bool, len(entries))
+	for _, entry := range entries {
+		files[entry] = true
+	}
+	return files, nil
+}
@@ -0,0 +1,173 @@
+package ethsigner
+
+import (
+	"context"
+	"encoding/hex"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/hyperledger/firefly-cli/internal/blockchain/ethereum"
+	"github.com/hyperledger/firefly-cli/internal/docker"
+	"github.com/hyperledger/firefly-cli/internal/docker/dockertest"
+	"github.com/hyperledger/firefly-cli/internal/log"
+	"github.com/hyperledger/firefly-cli/pkg/types"
+	"github.com/hyperledger/firefly-signer/pkg/secp256k1"
+	"github.com/stretchr/testify/assert"
+)
+
+// volumeFiles fakes the docker volumes of the host, as the files in each volume keyed by their path in it
+type volumeFiles map[string]map[string]string
+
+var (
+	volumeLsRegex   = regexp.MustCompile(`^docker volume ls --quiet --filter name=\^(.+)\$$`)
+	volumeListRegex = regexp.MustCompile(`^docker run --rm -v ([^:]+):/dest alpine /bin/sh -c if \[ -d /dest(\S*) \]`)
+	volumeCatRegex  = regexp.MustCompile(`^docker run --rm -v ([^:]+):/source:ro alpine /bin/sh -c cat /source(\S+)$`)
+)
+
+// runner returns a docker.CommandRunner that answers the commands that list and read the files of the volumes
+func (v volumeFiles) runner() *dockertest.Runner {
+	return &dockertest.Runner{Answer: v.answer}
+}
+
+func (v volumeFiles) answer(name string, args ...string) (string, error) {
+	command := strings.Join(append([]string{name}, args...), " ")
+	if m := volumeLsRegex.FindStringSubmatch(command); m != nil {
+		if _, ok := v[m[1]]; ok {
+			return m[1], nil
+		}
+		return "", nil
+	}
+	if m := volumeListRegex.FindStringSubmatch(command); m != nil {
+		dir := path.Clean("/" + m[2])
+		entries := []string{}
+		for filePath := range v[m[1]] {
+			// ls does not list hidden files, which lets a test keep a directory that is otherwise empty
+			if path.Dir(filePath) == dir && !strings.HasPrefix(path.Base(filePath), ".") {
+				entries = append(entries, path.Base(filePath))
+			}
+		}
+		return strings.Join(entries, "\n"), nil
+	}
+	if m := volumeCatRegex.FindStringSubmatch(command); m != nil {
+		return v[m[1]][m[2]], nil
+	}
+	return "", nil
+}
+
+func TestExistingAccounts(t *testing.T) {
+	chainID := int64(2021)
+	stack := &types.Stack{Name: "stack", ChainIDPtr: &chainID, KeystoreKDF: types.KeystoreKDFFast}
+
+	keyPair, err := secp256k1.GenerateSecp256k1KeyPair()
+	assert.NoError(t, err)
+	walletFile, err := ethereum.WriteWalletFile(t.TempDir(), "", "secret", types.KeystoreKDFFast, keyPair)
+	assert.NoError(t, err)
+	wallet, err := os.ReadFile(walletFile)
+	assert.NoError(t, err)
+	walletName := filepath.Base(walletFile)
+
+	signerVolume := func(passwordFile string) map[string]string {
+		return map[string]string{
+			"/keystore/" + walletName:           string(wallet),
+			"/keystore/" + walletName + ".toml": "",
+			"/" + passwordFile:                  "secret",
+		}
+	}
+	signerConfig := func(chainID int64) map[string]string {
+		config, err := GenerateSignerConfig(chainID, "http://besu:8545", "/data/keystore", nil, nil, "").Marshal()
+		assert.NoError(t, err)
+		return map[string]string{"/firefly.ffsigner": string(config)}
+	}
+	existingAccounts := func(volumes volumeFiles) ([]*ethereum.Account, error) {
+		ctx := docker.WithCommandRunner(log.WithLogger(context.Background(), &log.StdoutLogger{}), volumes.runner())
+		return ExistingAccounts(ctx, stack)
+	}
+	expected := []*ethereum.Account{{Address: keyPair.Address.String(), PrivateKey: hex.EncodeToString(keyPair.PrivateKeyBytes())}}
+
+	t.Run("no volume", func(t *testing.T) {
+		accounts, err := existingAccounts(volumeFiles{})
+		assert.NoError(t, err)
+		assert.Empty(t, accounts)
+	})
+
+	t.Run("empty keystore", func(t *testing.T) {
+		accounts, err := existingAccounts(volumeFiles{
+			"stack_ethsigner":        {"/keystore/.keep": ""},
+			"stack_ethsigner_config": signerConfig(2021),
+		})
+		assert.NoError(t, err)
+		assert.Empty(t, accounts)
+	})
+
+	t.Run("existing keystore", func(t *testing.T) {
+		accounts, err := existingAccounts(volumeFiles{
+			"stack_ethsigner":        signerVolume(walletName + ".password"),
+			"stack_ethsigner_config": signerConfig(2021),
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, expected, accounts)
+	})
+
+	t.Run("legacy password file", func(t *testing.T) {
+		accounts, err := existingAccounts(volumeFiles{
+			"stack_ethsigner":        signerVolume(legacyPasswordFile),
+			"stack_ethsigner_config": signerConfig(2021),
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, expected, accounts)
+	})
+
+	t.Run("chain ID mismatch", func(t *testing.T) {
+		_, err := existingAccounts(volumeFiles{
+			"stack_ethsigner":        signerVolume(walletName + ".password"),
+			"stack_ethsigner_config": signerConfig(689),
+		})
+		assert.ErrorIs(t, err, ErrSignerConfigInvalid)
+		assert.Regexp(t, "the keystore in volume 'stack_ethsigner' holds the accounts of chain ID 689, so they cannot be reused by stack 'stack' on chain ID 2021", err)
+	})
+
+	t.Run("unknown chain ID", func(t *testing.T) {
+		_, err := existingAccounts(volumeFiles{
+			"stack_ethsigner": signerVolume(walletName + ".password"),
+		})
+		assert.ErrorIs(t, err, ErrSignerConfigInvalid)
+		assert.Regexp(t, "the chain ID of the keystore in volume 'stack_ethsigner' is not known", err)
+	})
+
+	t.Run("recorded chain ID mismatch", func(t *testing.T) {
+		volume := signerVolume(walletName + ".password")
+		volume["/chains/689"] = "stack\n"
+		_, err := existingAccounts(volumeFiles{
+			"stack_ethsigner":        volume,
+			"stack_ethsigner_config": signerConfig(2021),
+		})
+		assert.ErrorIs(t, err, ErrSignerConfigInvalid)
+		assert.Regexp(t, "the keystore in volume 'stack_ethsigner' holds the accounts of chain ID 689", err)
+	})
+
+	t.Run("java signer", func(t *testing.T) {
+		// The Java signer has no config file in its config volume that records the chain ID
+		javaStack := *stack
+		javaStack.SignerType = types.SignerTypeJava
+		ctx := docker.WithCommandRunner(log.WithLogger(context.Background(), &log.StdoutLogger{}), volumeFiles{
+			"stack_ethsigner": signerVolume(walletName + ".password"),
+		}.runner())
+		accounts, err := ExistingAccounts(ctx, &javaStack)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, accounts)
+	})
+
+	t.Run("missing password", func(t *testing.T) {
+		volume := signerVolume(walletName + ".password")
+		delete(volume, "/"+walletName+".password")
+		_, err := existingAccounts(volumeFiles{
+			"stack_ethsigner":        volume,
+			"stack_ethsigner_config": signerConfig(2021),
+		})
+		assert.Regexp(t, "no password was found for the keystore file", err)
+	})
+}
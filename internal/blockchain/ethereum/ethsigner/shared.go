@@ -0,0 +1,138 @@
+// Copyright © 2024 Kaleido, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ethsigner
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+
+	"github.com/hyperledger/firefly-cli/internal/docker"
+	"github.com/hyperledger/firefly-cli/pkg/types"
+)
+
+// sharedSignerPrefix starts the names of the container and volumes of a shared signer, which are named after
+// the signer rather than after any one of the stacks that use it
+const sharedSignerPrefix = "ff_signer_"
+
+// sharedChainsDirectory is where the volume of a shared signer records the chain ID its accounts were imported
+// for, as a file named after the chain ID
+const sharedChainsDirectory = "/chains"
+
+// SharedSignerOwner decides whether the stack runs the shared signer it references itself, or uses the one that
+// another stack on this host runs. The first stack to reference a signer owns it, and as the owner cannot be
+// removed while other stacks use the signer, see SharedSignerUsers, it keeps running the signer for them. All the
+// stacks that share a signer must be on the same chain, as the signer signs their transactions with a single chain
+// ID, and on the same external network, which they reach the signer on.
+func SharedSignerOwner(stack *types.Stack, others []*types.Stack) (bool, error) {
+	if stack.SharedSigner == "" {
+		return false, nil
+	}
+	owned := false
+	for _, other := range others {
+		if other.SharedSigner != stack.SharedSigner {
+			continue
+		}
+		if other.ChainID() != stack.ChainID() {
+			return false, withKind(ErrSignerConfigInvalid, fmt.Errorf("shared signer '%s' is used by stack '%s' on chain ID %d, so it cannot be used by stack '%s' on chain ID %d", stack.SharedSigner, other.Name, other.ChainID(), stack.Name, stack.ChainID()))
+		}
+		if other.ExternalNetwork != stack.ExternalNetwork {
+			return false, withKind(ErrSignerConfigInvalid, fmt.Errorf("shared signer '%s' is reached on external network '%s' by stack '%s', so stack '%s' must use the same external network", stack.SharedSigner, other.ExternalNetwork, other.Name, stack.Name))
+		}
+		owned = owned || other.SharedSignerOwner
+	}
+	return !owned, nil
+}
+
+// SharedSignerUsers returns the names of the other stacks that use the shared signer of the stack, in order
+func SharedSignerUsers(stack *types.Stack, others []*types.Stack) []string {
+	users := []string{}
+	if stack.SharedSigner == "" {
+		return users
+	}
+	for _, other := range others {
+		if other.SharedSigner == stack.SharedSigner {
+			users = append(users, other.Name)
+		}
+	}
+	sort.Strings(users)
+	return users
+}
+
+// SharedSignerRunner returns the name of the stack that runs the shared signer of the stack, which is empty if
+// none of them does
+func SharedSignerRunner(stack *types.Stack, others []*types.Stack) string {
+	if stack.SharedSignerOwner {
+		return stack.Name
+	}
+	for _, other := range others {
+		if other.SharedSigner == stack.SharedSigner && other.SharedSignerOwner {
+			return other.Name
+		}
+	}
+	return ""
+}
+
+// SharedSignerContainerName is the name of the container of the shared signer with the given name
+func SharedSignerContainerName(name string) string {
+	return sharedSignerPrefix + name
+}
+
+// IsShared returns whether the stack uses a signer that it shares with other stacks on this host
+func (p *EthSignerProvider) IsShared() bool {
+	return p.stack.SharedSigner != ""
+}
+
+// runsSigner returns whether the signer container is part of the stack. A stack with its own signer always runs
+// it, whereas only the owner of a shared signer runs that.
+func (p *EthSignerProvider) runsSigner() bool {
+	return !p.IsRemote() && (!p.IsShared() || p.stack.SharedSignerOwner)
+}
+
+// volumeName is the name of the docker volume that holds the keystore of the signer
+func (p *EthSignerProvider) volumeName() string {
+	if p.IsShared() {
+		return sharedSignerPrefix + p.stack.SharedSigner
+	}
+	return fmt.Sprintf("%s_ethsigner", p.stack.Name)
+}
+
+// configVolumeName is the name of the docker volume that holds the config files of the signer
+func (p *EthSignerProvider) configVolumeName() string {
+	return p.volumeName() + "_config"
+}
+
+// checkSharedChainID makes sure that the shared signer volume only ever holds the accounts of one chain. The first
+// stack to import its accounts records its chain ID, and any stack on another chain is refused.
+func (p *EthSignerProvider) checkSharedChainID(ctx context.Context, volumeName string) error {
+	chainID := strconv.FormatInt(p.stack.ChainID(), 10)
+	chainIDs, err := docker.ListFilesInVolume(ctx, volumeName, sharedChainsDirectory)
+	if err != nil {
+		return err
+	}
+	for _, id := range chainIDs {
+		if id != chainID {
+			return withKind(ErrSignerConfigInvalid, fmt.Errorf("shared signer '%s' holds the accounts of chain ID %s, so the accounts of stack '%s' on chain ID %s cannot be imported into it", p.stack.SharedSigner, id, p.stack.Name, chainID))
+		}
+	}
+	if len(chainIDs) > 0 {
+		return nil
+	}
+	return docker.WriteToVolume(ctx, volumeName, path.Join(sharedChainsDirectory, chainID), []byte(p.stack.Name+"\n"), 0644)
+}
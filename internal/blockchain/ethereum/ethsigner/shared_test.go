@@ -0,0 +1,177 @@
+package ethsigner
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/hyperledger/firefly-cli/internal/docker"
+	"github.com/hyperledger/firefly-cli/internal/docker/dockertest"
+	"github.com/hyperledger/firefly-cli/internal/log"
+	"github.com/hyperledger/firefly-cli/pkg/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSharedSignerOwner(t *testing.T) {
+	chainID := int64(2021)
+	otherChainID := int64(689)
+	newStack := func(name, signer string, owner bool) *types.Stack {
+		return &types.Stack{Name: name, ChainIDPtr: &chainID, ExternalNetwork: "ff_shared", SharedSigner: signer, SharedSignerOwner: owner}
+	}
+
+	testCases := []struct {
+		name   string
+		stack  *types.Stack
+		others []*types.Stack
+		owner  bool
+		err    string
+	}{
+		{
+			name:   "isolated signer",
+			stack:  newStack("stack_b", "", false),
+			others: []*types.Stack{newStack("stack_a", "dev", true)},
+		},
+		{
+			name:  "first stack to use the signer",
+			stack: newStack("stack_a", "dev", false),
+			owner: true,
+		},
+		{
+			name:   "signer owned by another stack",
+			stack:  newStack("stack_b", "dev", false),
+			others: []*types.Stack{newStack("stack_a", "dev", true)},
+		},
+		{
+			name:   "another signer",
+			stack:  newStack("stack_b", "test", false),
+			others: []*types.Stack{newStack("stack_a", "dev", true)},
+			owner:  true,
+		},
+		{
+			name:   "owner removed",
+			stack:  newStack("stack_c", "dev", false),
+			others: []*types.Stack{newStack("stack_b", "dev", false)},
+			owner:  true,
+		},
+		{
+			name:  "conflicting chain ID",
+			stack: newStack("stack_b", "dev", false),
+			others: []*types.Stack{
+				{Name: "stack_a", ChainIDPtr: &otherChainID, ExternalNetwork: "ff_shared", SharedSigner: "dev", SharedSignerOwner: true},
+			},
+			err: "shared signer 'dev' is used by stack 'stack_a' on chain ID 689, so it cannot be used by stack 'stack_b' on chain ID 2021",
+		},
+		{
+			name:  "conflicting external network",
+			stack: newStack("stack_b", "dev", false),
+			others: []*types.Stack{
+				{Name: "stack_a", ChainIDPtr: &chainID, ExternalNetwork: "ff_other", SharedSigner: "dev", SharedSignerOwner: true},
+			},
+			err: "shared signer 'dev' is reached on external network 'ff_other' by stack 'stack_a'",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			owner, err := SharedSignerOwner(tc.stack, tc.others)
+			if tc.err != "" {
+				assert.ErrorIs(t, err, ErrSignerConfigInvalid)
+				assert.Regexp(t, tc.err, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.owner, owner)
+		})
+	}
+}
+
+func TestSharedSignerServiceDefinition(t *testing.T) {
+	manifest := &types.VersionManifest{Signer: &types.ManifestEntry{Image: "ghcr.io/hyperledger/firefly-signer", Tag: "v0.9.6"}}
+
+	t.Run("isolated", func(t *testing.T) {
+		p := &EthSignerProvider{stack: &types.Stack{Name: "stack_a", VersionManifest: manifest}}
+		definition := serviceDefinition(t, p)
+		assert.Equal(t, "stack_a_ethsigner", definition.Service.ContainerName)
+		assert.Equal(t, []string{"ethsigner", "ethsigner_config"}, definition.VolumeNames)
+		assert.Empty(t, definition.ExternalVolumeNames)
+		assert.Equal(t, "http://ethsigner:8545", p.URL())
+		assert.Equal(t, map[string]string{"ethsigner": "service_healthy"}, p.DependentServices())
+	})
+
+	t.Run("owner", func(t *testing.T) {
+		p := &EthSignerProvider{stack: &types.Stack{Name: "stack_a", VersionManifest: manifest, SharedSigner: "dev", SharedSignerOwner: true}}
+		definition := serviceDefinition(t, p)
+		assert.Equal(t, "ff_signer_dev", definition.Service.ContainerName)
+		assert.Equal(t, []string{"ff_signer_dev:/data", "ff_signer_dev_config:/etc/firefly"}, definition.Service.Volumes)
+		assert.Empty(t, definition.VolumeNames)
+		assert.Equal(t, []string{"ff_signer_dev", "ff_signer_dev_config"}, definition.ExternalVolumeNames)
+		assert.Equal(t, "http://ff_signer_dev:8545", p.URL())
+		assert.Equal(t, map[string]string{"ethsigner": "service_healthy"}, p.DependentServices())
+	})
+
+	t.Run("shared with another stack", func(t *testing.T) {
+		p := &EthSignerProvider{stack: &types.Stack{Name: "stack_b", VersionManifest: manifest, SharedSigner: "dev"}}
+		assert.Nil(t, serviceDefinition(t, p))
+		assert.Equal(t, "http://ff_signer_dev:8545", p.URL())
+		assert.Empty(t, p.DependentServices())
+		assert.Regexp(t, "shared signer 'dev' is not published by stack 'stack_b'", p.VerifySigner())
+	})
+}
+
+// sharedVolume returns a docker.CommandRunner for a shared signer volume that holds the given chain IDs
+func sharedVolume(chainIDs ...string) *dockertest.Runner {
+	return &dockertest.Runner{Answer: func(name string, args ...string) (string, error) {
+		if strings.Contains(args[len(args)-1], "ls -1 /dest/chains") {
+			return strings.Join(chainIDs, "\n"), nil
+		}
+		return "", nil
+	}}
+}
+
+func TestCheckSharedChainID(t *testing.T) {
+	chainID := int64(2021)
+	newProvider := func(v *dockertest.Runner) *EthSignerProvider {
+		ctx := docker.WithCommandRunner(log.WithLogger(context.Background(), &log.StdoutLogger{}), v)
+		return &EthSignerProvider{ctx: ctx, stack: &types.Stack{Name: "stack_b", ChainIDPtr: &chainID, SharedSigner: "dev"}}
+	}
+
+	t.Run("first import", func(t *testing.T) {
+		v := sharedVolume()
+		p := newProvider(v)
+		assert.NoError(t, p.checkSharedChainID(p.ctx, "ff_signer_dev"))
+		assert.Len(t, v.Inputs, 1)
+		assert.Contains(t, v.Commands[len(v.Commands)-1], "cat > /dest/chains/2021")
+	})
+
+	t.Run("same chain", func(t *testing.T) {
+		v := sharedVolume("2021")
+		p := newProvider(v)
+		assert.NoError(t, p.checkSharedChainID(p.ctx, "ff_signer_dev"))
+		assert.Empty(t, v.Inputs)
+	})
+
+	t.Run("conflicting chain", func(t *testing.T) {
+		v := sharedVolume("689")
+		p := newProvider(v)
+		err := p.checkSharedChainID(p.ctx, "ff_signer_dev")
+		assert.ErrorIs(t, err, ErrSignerConfigInvalid)
+		assert.Regexp(t, "shared signer 'dev' holds the accounts of chain ID 689, so the accounts of stack 'stack_b' on chain ID 2021 cannot be imported into it", err)
+		assert.Empty(t, v.Inputs)
+	})
+}
+
+func TestSharedSignerUsers(t *testing.T) {
+	stack := &types.Stack{Name: "stack_a", SharedSigner: "dev", SharedSignerOwner: true}
+	others := []*types.Stack{
+		{Name: "stack_c", SharedSigner: "dev"},
+		{Name: "stack_b", SharedSigner: "dev"},
+		{Name: "stack_d", SharedSigner: "test", SharedSignerOwner: true},
+		{Name: "stack_e"},
+	}
+	assert.Equal(t, []string{"stack_b", "stack_c"}, SharedSignerUsers(stack, others))
+	assert.Empty(t, SharedSignerUsers(&types.Stack{Name: "stack_f"}, others))
+
+	assert.Equal(t, "stack_a", SharedSignerRunner(stack, others))
+	assert.Equal(t, "stack_a", SharedSignerRunner(others[0], append(others, stack)))
+	assert.Equal(t, "", SharedSignerRunner(others[0], others))
+	assert.Equal(t, "ff_signer_dev", SharedSignerContainerName("dev"))
+}
@@ -0,0 +1,220 @@
+// Copyright © 2024 Kaleido, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ethsigner
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/firefly-cli/internal/blockchain/ethereum"
+	"github.com/hyperledger/firefly-cli/pkg/types"
+	"github.com/hyperledger/firefly-signer/pkg/rlp"
+)
+
+// verifyTimeout bounds each request made to the signer by VerifySigner, when no HTTPClient is set
+var verifyTimeout = 30 * time.Second
+
+type rpcRequest struct {
+	JSONRPC string        `json:"jsonrpc"`
+	ID      int           `json:"id"`
+	Method  string        `json:"method"`
+	Params  []interface{} `json:"params"`
+}
+
+type rpcResponse struct {
+	Error *struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"error,omitempty"`
+	Result json.RawMessage `json:"result"`
+}
+
+// VerifySigner checks end to end that the running signer can sign for the stack, so that a misconfigured signer
+// fails the start rather than the first transaction. It checks that eth_accounts lists every signing account of
+// every member, and that eth_signTransaction signs a transaction from each of them for the chain ID of the stack.
+// The transactions are only signed, never submitted.
+func (p *EthSignerProvider) VerifySigner() error {
+	if p.IsShared() && !p.runsSigner() {
+		return fmt.Errorf("shared signer '%s' is not published by stack '%s', so it can only be verified from the stack that runs it", p.stack.SharedSigner, p.stack.Name)
+	}
+	accounts := []string{}
+	if err := p.callSigner("eth_accounts", &accounts); err != nil {
+		return fmt.Errorf("signer at %s did not answer eth_accounts: %s", p.verifyURL(), err)
+	}
+	held := make(map[string]bool)
+	for _, account := range accounts {
+		held[strings.ToLower(account)] = true
+	}
+
+	chainID := p.stack.ChainID()
+	for _, member := range p.stack.Members {
+		if member.External {
+			continue
+		}
+		for _, signingAccount := range member.SigningAccounts() {
+			account, ok := signingAccount.(*ethereum.Account)
+			if !ok {
+				continue
+			}
+			if err := p.verifyAccount(member, account, held, chainID); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// verifyAccount checks that the signer holds an account of a member, and signs a transaction from it for chainID
+func (p *EthSignerProvider) verifyAccount(member *types.Organization, account *ethereum.Account, held map[string]bool, chainID int64) error {
+	if !held[strings.ToLower(account.Address)] {
+		return fmt.Errorf("the signer does not hold account %s of member %s", account.Address, member.ID)
+	}
+	tx := map[string]string{
+		"from":     account.Address,
+		"to":       account.Address,
+		"gas":      "0x5208",
+		"gasPrice": "0x0",
+		"value":    "0x0",
+		"nonce":    "0x0",
+		"data":     "0x",
+	}
+	var result json.RawMessage
+	if err := p.callSigner("eth_signTransaction", &result, tx); err != nil {
+		return fmt.Errorf("the signer could not sign a transaction from account %s of member %s for chain ID %d: %s", account.Address, member.ID, chainID, err)
+	}
+	signedChainID, err := signedTransactionChainID(result)
+	if err != nil {
+		return fmt.Errorf("the signer returned an invalid signed transaction from account %s of member %s: %s", account.Address, member.ID, err)
+	}
+	if signedChainID != chainID {
+		return fmt.Errorf("the signer signed a transaction from account %s of member %s for chain ID %d, but the chain ID of stack '%s' is %d", account.Address, member.ID, signedChainID, p.stack.Name, chainID)
+	}
+	return nil
+}
+
+// verifyURL returns the URL that VerifySigner sends its requests to. A remote signer is reached on the URL it was
+// configured with, and a local one on the port it is exposed on to this host.
+func (p *EthSignerProvider) verifyURL() string {
+	if p.IsRemote() {
+		return p.URL()
+	}
+	return p.hostURL()
+}
+
+// hostURL returns the URL that the local signer is reached on from this host
+func (p *EthSignerProvider) hostURL() string {
+	address := p.stack.SignerBindAddress
+	switch address {
+	case "", "0.0.0.0":
+		address = DefaultBindAddress
+	case "::":
+		address = "::1"
+	}
+	return fmt.Sprintf("http://%s/", net.JoinHostPort(address, fmt.Sprint(p.stack.ExposedBlockchainPort)))
+}
+
+func (p *EthSignerProvider) callSigner(method string, result interface{}, params ...interface{}) error {
+	if params == nil {
+		params = []interface{}{}
+	}
+	requestBody, err := json.Marshal(&rpcRequest{JSONRPC: "2.0", ID: 1, Method: method, Params: params})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(p.ctx, http.MethodPost, p.verifyURL(), bytes.NewBuffer(requestBody))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	client := p.HTTPClient
+	if client == nil {
+		client = &http.Client{Timeout: verifyTimeout}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	responseBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	var rpcResp rpcResponse
+	if err := json.Unmarshal(responseBody, &rpcResp); err != nil {
+		return fmt.Errorf("[%d] %s", resp.StatusCode, responseBody)
+	}
+	if rpcResp.Error != nil {
+		return fmt.Errorf("%s", rpcResp.Error.Message)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("[%d] %s", resp.StatusCode, responseBody)
+	}
+	return json.Unmarshal(rpcResp.Result, result)
+}
+
+// signedTransactionChainID returns the chain ID that a signed transaction returned by eth_signTransaction was
+// signed for. The result is either the raw transaction, or an object holding it as "raw".
+func signedTransactionChainID(result json.RawMessage) (int64, error) {
+	var raw string
+	if err := json.Unmarshal(result, &raw); err != nil {
+		var object struct {
+			Raw string `json:"raw"`
+		}
+		if err := json.Unmarshal(result, &object); err != nil {
+			return 0, err
+		}
+		raw = object.Raw
+	}
+	b, err := hex.DecodeString(strings.TrimPrefix(raw, "0x"))
+	if err != nil || len(b) == 0 {
+		return 0, fmt.Errorf("'%s' is not a hex encoded transaction", raw)
+	}
+
+	// Typed transactions (EIP-2718) start with their type, and hold their chain ID as their first field, while
+	// legacy transactions hold it in their EIP-155 V value of 2*ChainID + 35 + Y-parity
+	typed := b[0] < 0x7f
+	if typed {
+		b = b[1:]
+	}
+	element, _, err := rlp.Decode(b)
+	if err != nil {
+		return 0, err
+	}
+	fields, ok := element.(rlp.List)
+	if !ok || (typed && len(fields) < 3) || (!typed && len(fields) != 9) {
+		return 0, fmt.Errorf("unexpected transaction fields")
+	}
+	if typed {
+		chainID, ok := fields[0].(rlp.Data)
+		if !ok {
+			return 0, fmt.Errorf("unexpected transaction fields")
+		}
+		return chainID.Int().Int64(), nil
+	}
+	v, ok := fields[6].(rlp.Data)
+	if !ok || v.Int().Int64() < 35 {
+		return 0, fmt.Errorf("the transaction is not signed with an EIP-155 chain ID")
+	}
+	return (v.Int().Int64() - 35) / 2, nil
+}
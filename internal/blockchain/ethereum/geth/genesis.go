@@ -19,10 +19,25 @@ package geth
 import (
 	"encoding/json"
 	"fmt"
+	"math/big"
 	"os"
 	"strings"
 )
 
+// DefaultPrefundBalance is the balance, in wei, given to each account in the genesis block
+// when no prefund balance is configured for the stack
+const DefaultPrefundBalance = "0x200000000000000000000000000000000000000000000000000000000000000"
+
+// DefaultGasLimit is the gas limit of the genesis block, and of the blocks geth mines, when no gas limit is
+// configured for the stack
+const DefaultGasLimit = 0xffffff
+
+// MinGasLimit and MaxGasLimit are the lowest and highest gas limits that geth accepts for a block
+const (
+	MinGasLimit = 5000
+	MaxGasLimit = 0x7fffffffffffffff
+)
+
 type Genesis struct {
 	Config     *GenesisConfig    `json:"config"`
 	Nonce      string            `json:"nonce"`
@@ -69,7 +84,7 @@ func CreateGenesis(addresses []string, blockPeriod int, chainID int64) *Genesis
 	alloc := make(map[string]*Alloc)
 	for _, address := range addresses {
 		alloc[address] = &Alloc{
-			Balance: "0x200000000000000000000000000000000000000000000000000000000000000",
+			Balance: DefaultPrefundBalance,
 		}
 		extraData += address
 	}
@@ -94,7 +109,7 @@ func CreateGenesis(addresses []string, blockPeriod int, chainID int64) *Genesis
 		Nonce:      "0x0",
 		Timestamp:  "0x60edb1c7",
 		ExtraData:  extraData,
-		GasLimit:   "0xffffff",
+		GasLimit:   fmt.Sprintf("0x%x", DefaultGasLimit),
 		Difficulty: "0x1",
 		MixHash:    "0x0000000000000000000000000000000000000000000000000000000000000000",
 		Coinbase:   "0x0000000000000000000000000000000000000000",
@@ -105,6 +120,55 @@ func CreateGenesis(addresses []string, blockPeriod int, chainID int64) *Genesis
 	}
 }
 
+// ParsePrefundBalance parses a decimal or 0x prefixed hex amount of wei, returning it in
+// the hex format used for balances in the genesis block
+func ParsePrefundBalance(balance string) (string, error) {
+	if balance == "" {
+		return DefaultPrefundBalance, nil
+	}
+	amount, ok := new(big.Int).SetString(balance, 0)
+	if !ok || amount.Sign() < 0 {
+		return "", fmt.Errorf("invalid prefund balance '%s': must be a non-negative amount of wei", balance)
+	}
+	return "0x" + amount.Text(16), nil
+}
+
+// ValidateGasLimit checks that a block gas limit is one that geth accepts
+func ValidateGasLimit(gasLimit uint64) error {
+	if gasLimit < MinGasLimit || gasLimit > MaxGasLimit {
+		return fmt.Errorf("invalid gas limit %d: must be between %d and %d", gasLimit, uint64(MinGasLimit), uint64(MaxGasLimit))
+	}
+	return nil
+}
+
+// SetGasLimit sets the gas limit of the genesis block
+func (g *Genesis) SetGasLimit(gasLimit uint64) {
+	g.GasLimit = fmt.Sprintf("0x%x", gasLimit)
+}
+
+// Prefund allocates the given balance to an account in the genesis block. The address
+// must be provided without the 0x prefix.
+func (g *Genesis) Prefund(address, balance string) {
+	if g.Alloc == nil {
+		g.Alloc = make(map[string]*Alloc)
+	}
+	g.Alloc[address] = &Alloc{
+		Balance: balance,
+	}
+}
+
+func ReadGenesisJSON(filename string) (*Genesis, error) {
+	b, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var genesis *Genesis
+	if err := json.Unmarshal(b, &genesis); err != nil {
+		return nil, err
+	}
+	return genesis, nil
+}
+
 func (g *Genesis) WriteGenesisJSON(filename string) error {
 	genesisJSONBytes, _ := json.MarshalIndent(g, "", " ")
 	if err := os.WriteFile(filename, genesisJSONBytes, 0755); err != nil {
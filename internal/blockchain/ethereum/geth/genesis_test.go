@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 
@@ -167,3 +168,55 @@ func TestWriteGenesisJSON(t *testing.T) {
 	}
 
 }
+
+func TestParsePrefundBalance(t *testing.T) {
+	balance, err := ParsePrefundBalance("")
+	assert.NoError(t, err)
+	assert.Equal(t, DefaultPrefundBalance, balance)
+
+	balance, err = ParsePrefundBalance("1000000000000000000")
+	assert.NoError(t, err)
+	assert.Equal(t, "0xde0b6b3a7640000", balance)
+
+	balance, err = ParsePrefundBalance("0xde0b6b3a7640000")
+	assert.NoError(t, err)
+	assert.Equal(t, "0xde0b6b3a7640000", balance)
+
+	_, err = ParsePrefundBalance("-1")
+	assert.Regexp(t, "invalid prefund balance '-1'", err)
+
+	_, err = ParsePrefundBalance("lots")
+	assert.Regexp(t, "invalid prefund balance 'lots'", err)
+}
+
+func TestPrefundGenesis(t *testing.T) {
+	genesis := CreateGenesis([]string{"1234567890abcdef0123456789abcdef6789abcd"}, 0, 2021)
+	genesis.Prefund("1234567890abcdef0123456789abcdef6789abcd", "0x64")
+	genesis.Prefund("1234567890abcdef012345670000000000000000", "0x64")
+
+	filename := filepath.Join(t.TempDir(), "genesis.json")
+	assert.NoError(t, genesis.WriteGenesisJSON(filename))
+	written, err := ReadGenesisJSON(filename)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]*Alloc{
+		"1234567890abcdef0123456789abcdef6789abcd": {Balance: "0x64"},
+		"1234567890abcdef012345670000000000000000": {Balance: "0x64"},
+	}, written.Alloc)
+	// Only the original address is a clique signer
+	assert.NotContains(t, written.ExtraData, "1234567890abcdef012345670000000000000000")
+}
+
+func TestValidateGasLimit(t *testing.T) {
+	assert.NoError(t, ValidateGasLimit(MinGasLimit))
+	assert.NoError(t, ValidateGasLimit(DefaultGasLimit))
+	assert.NoError(t, ValidateGasLimit(MaxGasLimit))
+	assert.Regexp(t, "invalid gas limit 4999: must be between 5000 and 9223372036854775807", ValidateGasLimit(4999))
+	assert.Regexp(t, "invalid gas limit 9223372036854775808", ValidateGasLimit(MaxGasLimit+1))
+}
+
+func TestSetGasLimit(t *testing.T) {
+	genesis := CreateGenesis([]string{}, -1, 2021)
+	assert.Equal(t, "0xffffff", genesis.GasLimit)
+	genesis.SetGasLimit(30000000)
+	assert.Equal(t, "0x1c9c380", genesis.GasLimit)
+}
@@ -34,9 +34,16 @@ import (
 	"github.com/hyperledger/firefly-cli/internal/docker"
 	"github.com/hyperledger/firefly-cli/internal/log"
 	"github.com/hyperledger/firefly-cli/pkg/types"
+	"github.com/hyperledger/firefly-signer/pkg/secp256k1"
 )
 
-var gethImage = "ethereum/client-go:release-1.10"
+var DefaultImage = "ethereum/client-go:release-1.10"
+
+// MinimumImageVersion is the oldest geth release that stacks created by this CLI are known to work with
+const MinimumImageVersion = "v1.10.0"
+
+// resourceLimits is the default limit of the geth container, which keeps it from starving the rest of the stack
+var resourceLimits = &docker.ResourceLimits{CPUs: "2", Memory: "4g"}
 
 // TODO: Probably randomize this and make it different per member?
 var keyPassword = "correcthorsebatterystaple"
@@ -68,7 +75,7 @@ func (p *GethProvider) WriteConfig(options *types.InitOptions) error {
 	for i, member := range p.stack.Members {
 		// Generate the connector config for each member
 		connectorConfigPath := filepath.Join(initDir, "config", fmt.Sprintf("%s_%v.yaml", p.connector.Name(), i))
-		if err := p.connector.GenerateConfig(p.stack, member, "geth").WriteConfig(connectorConfigPath, options.ExtraConnectorConfigPath); err != nil {
+		if err := p.connector.GenerateConfig(p.stack, member, "http://geth:8545").WriteConfig(connectorConfigPath, options.ExtraConnectorConfigPath); err != nil {
 			return nil
 		}
 	}
@@ -81,6 +88,18 @@ func (p *GethProvider) WriteConfig(options *types.InitOptions) error {
 		addresses[i] = address[2:]
 	}
 	genesis := CreateGenesis(addresses, options.BlockPeriod, p.stack.ChainID())
+	genesis.SetGasLimit(p.getGasLimit())
+
+	// Prefund every account of every member, not just the signers
+	balance, err := ParsePrefundBalance(p.stack.PrefundBalance)
+	if err != nil {
+		return err
+	}
+	for _, member := range p.stack.Members {
+		for _, account := range member.Accounts() {
+			genesis.Prefund(account.(*ethereum.Account).Address[2:], balance)
+		}
+	}
 	if err := genesis.WriteGenesisJSON(filepath.Join(initDir, "blockchain", "genesis.json")); err != nil {
 		return err
 	}
@@ -122,13 +141,31 @@ func (p *GethProvider) FirstTimeSetup() error {
 	}
 
 	// Initialize the genesis block
-	if err := docker.RunDockerCommand(p.ctx, p.stack.StackDir, "run", "--rm", "-v", fmt.Sprintf("%s:/data", gethVolumeName), gethImage, "--datadir", "/data", "init", "/data/genesis.json"); err != nil {
+	if err := docker.RunDockerCommand(p.ctx, p.stack.StackDir, "run", "--rm", "-v", fmt.Sprintf("%s:/data", gethVolumeName), p.getImage(), "--datadir", "/data", "init", "/data/genesis.json"); err != nil {
 		return err
 	}
 
 	return nil
 }
 
+// getImage returns the geth image pinned in the stack config, falling back to the default for stacks
+// created before the image was pinned at init time
+func (p *GethProvider) getImage() string {
+	if p.stack.GethImage != "" {
+		return p.stack.GethImage
+	}
+	return DefaultImage
+}
+
+// getGasLimit returns the block gas limit configured for the stack, which the genesis block starts with and geth
+// keeps the blocks it mines at
+func (p *GethProvider) getGasLimit() uint64 {
+	if p.stack.GethGasLimit != 0 {
+		return p.stack.GethGasLimit
+	}
+	return DefaultGasLimit
+}
+
 func (p *GethProvider) PreStart() error {
 	return nil
 }
@@ -174,27 +211,28 @@ func (p *GethProvider) DeployFireFlyContract() (*types.ContractDeploymentResult,
 	if err != nil {
 		return nil, err
 	}
-	return p.connector.DeployContract(contract, "FireFly", p.stack.Members[0], nil)
+	return p.connector.DeployContract(contract, "FireFly", p.stack.SigningMember(), nil)
 }
 
-func (p *GethProvider) GetDockerServiceDefinitions() []*docker.ServiceDefinition {
-	gethCommand := fmt.Sprintf(`--datadir /data --syncmode 'full' --port 30311 --http --http.addr "0.0.0.0" --http.corsdomain="*"  -http.port 8545 --http.vhosts "*" --http.api 'admin,personal,eth,net,web3,txpool,miner,clique,debug' --networkid %d --miner.gasprice 0 --password /data/password --mine --allow-insecure-unlock --nodiscover --verbosity 4 --miner.gaslimit 16777215`, p.stack.ChainID())
+func (p *GethProvider) GetDockerServiceDefinitions() ([]*docker.ServiceDefinition, error) {
+	gethCommand := fmt.Sprintf(`--datadir /data --syncmode 'full' --port 30311 --http --http.addr "0.0.0.0" --http.corsdomain="*"  -http.port 8545 --http.vhosts "*" --http.api 'admin,personal,eth,net,web3,txpool,miner,clique,debug' --networkid %d --miner.gasprice 0 --password /data/password --mine --allow-insecure-unlock --nodiscover --verbosity 4 --miner.gaslimit %d`, p.stack.ChainID(), p.getGasLimit())
 
 	serviceDefinitions := make([]*docker.ServiceDefinition, 1)
 	serviceDefinitions[0] = &docker.ServiceDefinition{
 		ServiceName: "geth",
 		Service: &docker.Service{
-			Image:         gethImage,
+			Image:         p.getImage(),
 			ContainerName: fmt.Sprintf("%s_geth", p.stack.Name),
 			Command:       gethCommand,
 			Volumes:       []string{"geth:/data"},
 			Logging:       docker.StandardLogOptions,
 			Ports:         []string{fmt.Sprintf("%d:8545", p.stack.ExposedBlockchainPort)},
 		},
-		VolumeNames: []string{"geth"},
+		VolumeNames:    []string{"geth"},
+		ResourceLimits: resourceLimits,
 	}
 	serviceDefinitions = append(serviceDefinitions, p.connector.GetServiceDefinitions(p.stack, map[string]string{"geth": "service_started"})...)
-	return serviceDefinitions
+	return serviceDefinitions, nil
 }
 
 func (p *GethProvider) GetBlockchainPluginConfig(stack *types.Stack, m *types.Organization) (blockchainConfig *types.BlockchainConfig) {
@@ -267,7 +305,22 @@ func (p *GethProvider) CreateAccount(args []string) (interface{}, error) {
 
 	prefix := strconv.FormatInt(time.Now().UnixNano(), 10)
 	outputDirectory := filepath.Join(directory, "blockchain", "keystore")
-	keyPair, walletFilePath, err := ethereum.CreateWalletFile(outputDirectory, prefix, keyPassword)
+	var keyPair *secp256k1.KeyPair
+	var walletFilePath string
+	// An existing private key can be imported rather than generating a new one
+	if privateKey := ethereum.ArgValue(args, "privateKey"); privateKey != "" {
+		if keyPair, err = ethereum.ParsePrivateKey(privateKey); err != nil {
+			return nil, err
+		}
+		walletFilePath, err = ethereum.WriteWalletFile(outputDirectory, prefix, keyPassword, p.stack.KeystoreKDF, keyPair)
+	} else if addressPrefix := ethereum.ArgValue(args, "addressPrefix"); addressPrefix != "" {
+		if keyPair, err = ethereum.GenerateKeyPairWithAddressPrefix(addressPrefix); err != nil {
+			return nil, err
+		}
+		walletFilePath, err = ethereum.WriteWalletFile(outputDirectory, prefix, keyPassword, p.stack.KeystoreKDF, keyPair)
+	} else {
+		keyPair, walletFilePath, err = ethereum.CreateWalletFile(outputDirectory, prefix, keyPassword, p.stack.KeystoreKDF)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -279,6 +332,8 @@ func (p *GethProvider) CreateAccount(args []string) (interface{}, error) {
 		if err := p.unlockAccount(keyPair.Address.String(), keyPassword); err != nil {
 			return nil, err
 		}
+	} else if err := p.prefundAccount(keyPair.Address.String()); err != nil {
+		return nil, err
 	}
 
 	return &ethereum.Account{
@@ -287,6 +342,25 @@ func (p *GethProvider) CreateAccount(args []string) (interface{}, error) {
 	}, nil
 }
 
+// prefundAccount adds an account created before the stack's first run to the genesis
+// block, so that it has a balance once the chain is initialized
+func (p *GethProvider) prefundAccount(address string) error {
+	genesisPath := filepath.Join(p.stack.InitDir, "blockchain", "genesis.json")
+	genesis, err := ReadGenesisJSON(genesisPath)
+	if os.IsNotExist(err) {
+		// The genesis block has not been written yet, and will include the account when it is
+		return nil
+	} else if err != nil {
+		return err
+	}
+	balance, err := ParsePrefundBalance(p.stack.PrefundBalance)
+	if err != nil {
+		return err
+	}
+	genesis.Prefund(address[2:], balance)
+	return genesis.WriteGenesisJSON(genesisPath)
+}
+
 func (p *GethProvider) ParseAccount(account interface{}) interface{} {
 	accountMap := account.(map[string]interface{})
 	return &ethereum.Account{
@@ -5,10 +5,12 @@ import (
 	"encoding/hex"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"testing"
 
 	"github.com/hyperledger/firefly-cli/internal/blockchain/ethereum"
+	"github.com/hyperledger/firefly-cli/internal/constants"
 	"github.com/hyperledger/firefly-cli/pkg/types"
 	"github.com/hyperledger/firefly-common/pkg/fftypes"
 	"github.com/stretchr/testify/assert"
@@ -321,3 +323,140 @@ func TestCreateAccount(t *testing.T) {
 		})
 	}
 }
+
+func TestCreateAccountFromMnemonic(t *testing.T) {
+	mnemonic := "test test test test test test test test test test test junk"
+	addresses := []string{}
+	for round := 0; round < 2; round++ {
+		p := &GethProvider{stack: &types.Stack{Name: "geth_mnemonic", InitDir: t.TempDir(), RuntimeDir: t.TempDir()}}
+		for index := 0; index < 2; index++ {
+			keyPair, err := ethereum.DeriveKeyPair(mnemonic, index)
+			assert.NoError(t, err)
+			account, err := p.CreateAccount([]string{"org", "org", "privateKey=" + hex.EncodeToString(keyPair.PrivateKeyBytes())})
+			assert.NoError(t, err)
+			addresses = append(addresses, account.(*ethereum.Account).Address)
+		}
+	}
+	// Every stack initialized from the mnemonic gets the same accounts in the same order
+	assert.Equal(t, []string{
+		"0xf39fd6e51aad88f6f4ce6ab8827279cfffb92266",
+		"0x70997970c51812dc3a010c7d01b50e0d17dc79c8",
+		"0xf39fd6e51aad88f6f4ce6ab8827279cfffb92266",
+		"0x70997970c51812dc3a010c7d01b50e0d17dc79c8",
+	}, addresses)
+}
+
+func TestGetImage(t *testing.T) {
+	p := &GethProvider{stack: &types.Stack{}}
+	assert.Equal(t, DefaultImage, p.getImage())
+
+	p.stack.GethImage = "ethereum/client-go@sha256:1234"
+	assert.Equal(t, "ethereum/client-go@sha256:1234", p.getImage())
+}
+
+func TestWriteConfigPrefundsMemberAccounts(t *testing.T) {
+	stacksDir := constants.StacksDir
+	constants.StacksDir = t.TempDir()
+	defer func() { constants.StacksDir = stacksDir }()
+
+	stack := &types.Stack{
+		Name:                   "prefund",
+		BlockchainProvider:     types.BlockchainProviderEthereum,
+		BlockchainConnector:    types.BlockchainConnectorEvmconnect,
+		BlockchainNodeProvider: types.BlockchainNodeProviderGeth,
+		PrefundBalance:         "1000000000000000000",
+		Members: []*types.Organization{
+			{
+				ID:      "0",
+				OrgName: "org_0",
+				Account: &ethereum.Account{Address: "0x1234567890abcdef0123456789abcdef6789abcd"},
+				AdditionalAccounts: []interface{}{
+					&ethereum.Account{Address: "0x1234567890abcdef012345670000000000000000"},
+				},
+			},
+			{
+				ID:      "1",
+				OrgName: "org_1",
+				Account: &ethereum.Account{Address: "0xabcdeffedcba9876543210abcdeffedc00000000"},
+			},
+		},
+	}
+	stack.InitDir = filepath.Join(constants.StacksDir, stack.Name, "init")
+	stack.RuntimeDir = filepath.Join(constants.StacksDir, stack.Name, "runtime")
+	assert.NoError(t, os.MkdirAll(filepath.Join(stack.InitDir, "config"), 0755))
+	assert.NoError(t, os.MkdirAll(filepath.Join(stack.InitDir, "blockchain"), 0755))
+
+	p := NewGethProvider(context.Background(), stack)
+	assert.NoError(t, p.WriteConfig(&types.InitOptions{BlockPeriod: 0}))
+
+	genesisPath := filepath.Join(stack.InitDir, "blockchain", "genesis.json")
+	genesis, err := ReadGenesisJSON(genesisPath)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]*Alloc{
+		"1234567890abcdef0123456789abcdef6789abcd": {Balance: "0xde0b6b3a7640000"},
+		"1234567890abcdef012345670000000000000000": {Balance: "0xde0b6b3a7640000"},
+		"abcdeffedcba9876543210abcdeffedc00000000": {Balance: "0xde0b6b3a7640000"},
+	}, genesis.Alloc)
+
+	// Accounts created before the first run are added to the genesis block
+	account, err := p.CreateAccount([]string{})
+	assert.NoError(t, err)
+	genesis, err = ReadGenesisJSON(genesisPath)
+	assert.NoError(t, err)
+	assert.Equal(t, &Alloc{Balance: "0xde0b6b3a7640000"}, genesis.Alloc[account.(*ethereum.Account).Address[2:]])
+}
+
+func TestNetworkParams(t *testing.T) {
+	stacksDir := constants.StacksDir
+	constants.StacksDir = t.TempDir()
+	defer func() { constants.StacksDir = stacksDir }()
+
+	newStack := func(name string, gasLimit uint64) *types.Stack {
+		stack := &types.Stack{
+			Name:                   name,
+			BlockchainProvider:     types.BlockchainProviderEthereum,
+			BlockchainConnector:    types.BlockchainConnectorEvmconnect,
+			BlockchainNodeProvider: types.BlockchainNodeProviderGeth,
+			GethGasLimit:           gasLimit,
+			VersionManifest:        &types.VersionManifest{Evmconnect: &types.ManifestEntry{Image: "ghcr.io/hyperledger/firefly-evmconnect"}},
+			Members: []*types.Organization{
+				{ID: "0", OrgName: "org_0", Account: &ethereum.Account{Address: "0x1234567890abcdef0123456789abcdef6789abcd"}},
+			},
+		}
+		stack.InitDir = filepath.Join(constants.StacksDir, stack.Name, "init")
+		assert.NoError(t, os.MkdirAll(filepath.Join(stack.InitDir, "config"), 0755))
+		assert.NoError(t, os.MkdirAll(filepath.Join(stack.InitDir, "blockchain"), 0755))
+		return stack
+	}
+
+	testcases := []struct {
+		Name             string
+		BlockPeriod      int
+		GasLimit         uint64
+		ExpectedPeriod   int
+		ExpectedGenesis  string
+		ExpectedGasLimit string
+	}{
+		{Name: "defaults", BlockPeriod: -1, ExpectedPeriod: 0, ExpectedGenesis: "0xffffff", ExpectedGasLimit: "--miner.gaslimit 16777215"},
+		{Name: "fast blocks", BlockPeriod: 1, GasLimit: 30000000, ExpectedPeriod: 1, ExpectedGenesis: "0x1c9c380", ExpectedGasLimit: "--miner.gaslimit 30000000"},
+		{Name: "slow blocks", BlockPeriod: 15, ExpectedPeriod: 15, ExpectedGenesis: "0xffffff", ExpectedGasLimit: "--miner.gaslimit 16777215"},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.Name, func(t *testing.T) {
+			stack := newStack(strings.ReplaceAll(tc.Name, " ", "_"), tc.GasLimit)
+			p := NewGethProvider(context.Background(), stack)
+			assert.NoError(t, p.WriteConfig(&types.InitOptions{BlockPeriod: tc.BlockPeriod}))
+
+			genesis, err := ReadGenesisJSON(filepath.Join(stack.InitDir, "blockchain", "genesis.json"))
+			assert.NoError(t, err)
+			assert.Equal(t, tc.ExpectedPeriod, genesis.Config.Clique.Period)
+			assert.Equal(t, tc.ExpectedGenesis, genesis.GasLimit)
+
+			serviceDefinitions, err := p.GetDockerServiceDefinitions()
+			assert.NoError(t, err)
+			assert.Equal(t, resourceLimits, serviceDefinitions[0].ResourceLimits)
+			command := serviceDefinitions[0].Service.Command
+			assert.Contains(t, command, tc.ExpectedGasLimit)
+		})
+	}
+}
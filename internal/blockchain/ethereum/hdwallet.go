@@ -0,0 +1,105 @@
+// Copyright © 2024 Kaleido, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ethereum
+
+import (
+	"crypto/hmac"
+	"crypto/sha512"
+	"encoding/binary"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/hyperledger/firefly-signer/pkg/secp256k1"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// HDPath is the BIP-44 derivation path of the Ethereum accounts derived from a mnemonic, to which the
+// index of each account is appended. It is the path used by most wallets and development tools.
+const HDPath = "m/44'/60'/0'/0"
+
+// hdPath is HDPath as BIP-32 child indexes
+var hdPath = []uint32{hardened + 44, hardened + 60, hardened + 0, 0}
+
+const hardened uint32 = 0x80000000
+
+var mnemonicRegex = regexp.MustCompile(`^[a-z]+( [a-z]+)*$`)
+
+// ValidateMnemonic checks that a mnemonic looks like an English BIP-39 mnemonic. The words are not checked
+// against the word list, so the checksum is not verified, but the same mnemonic always yields the same keys.
+func ValidateMnemonic(mnemonic string) error {
+	normalized := normalizeMnemonic(mnemonic)
+	if !mnemonicRegex.MatchString(normalized) {
+		return fmt.Errorf("invalid mnemonic: must be lower case English words separated by spaces")
+	}
+	switch len(strings.Fields(normalized)) {
+	case 12, 15, 18, 21, 24:
+		return nil
+	default:
+		return fmt.Errorf("invalid mnemonic: must have 12, 15, 18, 21 or 24 words")
+	}
+}
+
+func normalizeMnemonic(mnemonic string) string {
+	return strings.Join(strings.Fields(mnemonic), " ")
+}
+
+// DeriveKeyPair returns the key pair at the given index below HDPath for a BIP-39 mnemonic, with no passphrase
+func DeriveKeyPair(mnemonic string, index int) (*secp256k1.KeyPair, error) {
+	if err := ValidateMnemonic(mnemonic); err != nil {
+		return nil, err
+	}
+	if index < 0 || uint32(index) >= hardened {
+		return nil, fmt.Errorf("invalid account index %d", index)
+	}
+	seed := pbkdf2.Key([]byte(normalizeMnemonic(mnemonic)), []byte("mnemonic"), 2048, 64, sha512.New)
+
+	// BIP-32 master key
+	mac := hmac.New(sha512.New, []byte("Bitcoin seed"))
+	mac.Write(seed)
+	i := mac.Sum(nil)
+	key, chainCode := i[:32], i[32:]
+	var k btcec.ModNScalar
+	if overflow := k.SetByteSlice(key); overflow || k.IsZero() {
+		return nil, fmt.Errorf("invalid master key derived from mnemonic")
+	}
+
+	for _, child := range append(append([]uint32{}, hdPath...), uint32(index)) {
+		var data []byte
+		if child >= hardened {
+			data = append([]byte{0}, key...)
+		} else {
+			data = btcec.PrivKeyFromScalar(&k).PubKey().SerializeCompressed()
+		}
+		data = binary.BigEndian.AppendUint32(data, child)
+		mac := hmac.New(sha512.New, chainCode)
+		mac.Write(data)
+		i := mac.Sum(nil)
+		var tweak btcec.ModNScalar
+		if overflow := tweak.SetByteSlice(i[:32]); overflow {
+			return nil, fmt.Errorf("invalid key derived for account index %d", index)
+		}
+		k.Add(&tweak)
+		if k.IsZero() {
+			return nil, fmt.Errorf("invalid key derived for account index %d", index)
+		}
+		b := k.Bytes()
+		key, chainCode = b[:], i[32:]
+	}
+	return secp256k1.NewSecp256k1KeyPair(key)
+}
@@ -0,0 +1,44 @@
+package ethereum
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testMnemonic is the well known development mnemonic of Hardhat and Anvil, whose accounts are published
+const testMnemonic = "test test test test test test test test test test test junk"
+
+func TestDeriveKeyPair(t *testing.T) {
+	testcases := []struct {
+		Index      int
+		Address    string
+		PrivateKey string
+	}{
+		{Index: 0, Address: "0xf39fd6e51aad88f6f4ce6ab8827279cfffb92266", PrivateKey: "ac0974bec39a17e36ba4a6b4d238ff944bacb478cbed5efcae784d7bf4f2ff80"},
+		{Index: 1, Address: "0x70997970c51812dc3a010c7d01b50e0d17dc79c8", PrivateKey: "59c6995e998f97a5a0044966f0945389dc9e86dae88c7a8412f4603b6b78690d"},
+		{Index: 2, Address: "0x3c44cdddb6a900fa2b585dd299e03d12fa4293bc", PrivateKey: "5de4111afa1a4b94908f83103eb1f1706367c2e68ca870fc3fb9a804cdab365a"},
+	}
+	for _, tc := range testcases {
+		keyPair, err := DeriveKeyPair(testMnemonic, tc.Index)
+		assert.NoError(t, err)
+		assert.Equal(t, tc.Address, keyPair.Address.String())
+		assert.Equal(t, tc.PrivateKey, hex.EncodeToString(keyPair.PrivateKeyBytes()))
+
+		// The same mnemonic always yields the same key, however it is spaced
+		again, err := DeriveKeyPair("  test test test test test test\ttest test test test test junk\n", tc.Index)
+		assert.NoError(t, err)
+		assert.Equal(t, keyPair.Address.String(), again.Address.String())
+	}
+
+	_, err := DeriveKeyPair(testMnemonic, -1)
+	assert.Regexp(t, "invalid account index -1", err)
+}
+
+func TestValidateMnemonic(t *testing.T) {
+	assert.NoError(t, ValidateMnemonic(testMnemonic))
+	assert.Regexp(t, "must have 12, 15, 18, 21 or 24 words", ValidateMnemonic("test test test"))
+	assert.Regexp(t, "must be lower case English words", ValidateMnemonic("Test test test test test test test test test test test junk"))
+	assert.Regexp(t, "must be lower case English words", ValidateMnemonic(""))
+}
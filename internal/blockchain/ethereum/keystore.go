@@ -0,0 +1,140 @@
+// Copyright © 2024 Kaleido, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ethereum
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/firefly-cli/pkg/types"
+	"github.com/hyperledger/firefly-common/pkg/fftypes"
+	"github.com/hyperledger/firefly-signer/pkg/secp256k1"
+	"golang.org/x/crypto/scrypt"
+	"golang.org/x/crypto/sha3"
+)
+
+// ScryptParams are the cost parameters of the scrypt key derivation a keystore file is encrypted with
+type ScryptParams struct {
+	N int
+	R int
+	P int
+}
+
+// keystoreKDFs maps each keystore KDF preset to its scrypt parameters. The standard preset is the cost that keys
+// have always been encrypted with, and strong is the go-ethereum default, which needs 128*N*R bytes (256MB) of
+// memory to encrypt or decrypt a key.
+var keystoreKDFs = map[string]ScryptParams{
+	types.KeystoreKDFFast:     {N: 1 << 8, R: 8, P: 1},
+	types.KeystoreKDFStandard: {N: 1 << 10, R: 8, P: 1},
+	types.KeystoreKDFStrong:   {N: 1 << 18, R: 8, P: 1},
+}
+
+// KeystoreScryptParams returns the scrypt parameters of a keystore KDF preset, defaulting to the standard preset
+func KeystoreScryptParams(kdf string) (ScryptParams, error) {
+	if kdf == "" {
+		kdf = types.KeystoreKDFStandard
+	}
+	params, ok := keystoreKDFs[kdf]
+	if !ok {
+		return ScryptParams{}, types.ValidateKeystoreKDF(kdf)
+	}
+	return params, nil
+}
+
+type keystoreFile struct {
+	Address string         `json:"address"`
+	ID      string         `json:"id"`
+	Version int            `json:"version"`
+	Crypto  keystoreCrypto `json:"crypto"`
+}
+
+type keystoreCrypto struct {
+	Cipher       string                 `json:"cipher"`
+	CipherText   string                 `json:"ciphertext"`
+	CipherParams keystoreCipherParams   `json:"cipherparams"`
+	KDF          string                 `json:"kdf"`
+	KDFParams    keystoreScryptKDFParam `json:"kdfparams"`
+	MAC          string                 `json:"mac"`
+}
+
+type keystoreCipherParams struct {
+	IV string `json:"iv"`
+}
+
+type keystoreScryptKDFParam struct {
+	DKLen int    `json:"dklen"`
+	N     int    `json:"n"`
+	P     int    `json:"p"`
+	R     int    `json:"r"`
+	Salt  string `json:"salt"`
+}
+
+// encryptKeystore encrypts the key pair with the password into the JSON of a keystore v3 file, using scrypt with
+// the parameters of the keystore KDF preset and AES-128-CTR, as go-ethereum and firefly-signer do
+func encryptKeystore(password, kdf string, keyPair *secp256k1.KeyPair) ([]byte, error) {
+	params, err := KeystoreScryptParams(kdf)
+	if err != nil {
+		return nil, err
+	}
+	salt := make([]byte, 32)
+	iv := make([]byte, aes.BlockSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	if _, err := rand.Read(iv); err != nil {
+		return nil, err
+	}
+	derivedKey, err := scrypt.Key([]byte(password), salt, params.N, params.R, params.P, 32)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive keystore key: %s", err)
+	}
+
+	// The first half of the derived key encrypts the private key, and the second half authenticates it
+	block, err := aes.NewCipher(derivedKey[:16])
+	if err != nil {
+		return nil, err
+	}
+	cipherText := make([]byte, len(keyPair.PrivateKeyBytes()))
+	cipher.NewCTR(block, iv).XORKeyStream(cipherText, keyPair.PrivateKeyBytes())
+	hash := sha3.NewLegacyKeccak256()
+	hash.Write(derivedKey[16:32])
+	hash.Write(cipherText)
+
+	return json.Marshal(&keystoreFile{
+		Address: hex.EncodeToString(keyPair.Address[:]),
+		ID:      fftypes.NewUUID().String(),
+		Version: 3,
+		Crypto: keystoreCrypto{
+			Cipher:       "aes-128-ctr",
+			CipherText:   hex.EncodeToString(cipherText),
+			CipherParams: keystoreCipherParams{IV: hex.EncodeToString(iv)},
+			KDF:          "scrypt",
+			KDFParams: keystoreScryptKDFParam{
+				DKLen: 32,
+				N:     params.N,
+				P:     params.P,
+				R:     params.R,
+				Salt:  hex.EncodeToString(salt),
+			},
+			MAC: hex.EncodeToString(hash.Sum(nil)),
+		},
+	})
+}
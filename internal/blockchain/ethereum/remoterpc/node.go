@@ -0,0 +1,149 @@
+// Copyright © 2024 Kaleido, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package remoterpc
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/firefly-cli/pkg/types"
+)
+
+// remoteNodeTimeout bounds each request made to the remote node while the stack is initialized
+var remoteNodeTimeout = 30 * time.Second
+
+type rpcRequest struct {
+	JSONRPC string        `json:"jsonrpc"`
+	ID      int           `json:"id"`
+	Method  string        `json:"method"`
+	Params  []interface{} `json:"params"`
+}
+
+type rpcResponse struct {
+	Error *struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"error,omitempty"`
+	Result string `json:"result"`
+}
+
+// requireRemoteNodeURL checks that the stack has the URL of its remote node
+func requireRemoteNodeURL(stack *types.Stack) error {
+	if stack.RemoteNodeURL == "" {
+		return fmt.Errorf("the '%s' blockchain node provider requires the URL of the remote node, set with --remote-node-url", types.BlockchainNodeProviderRemoteRPC)
+	}
+	return nil
+}
+
+// CheckRemoteNode checks that the remote node of the stack answers net_version, and that it is on the chain of
+// the stack, so that a wrong URL, credential or chain ID fails the init rather than the first transaction. The
+// chain ID is read with eth_chainId, falling back to the network ID for nodes that predate it.
+func CheckRemoteNode(ctx context.Context, stack *types.Stack) error {
+	if err := requireRemoteNodeURL(stack); err != nil {
+		return err
+	}
+	client, err := remoteNodeClient(stack)
+	if err != nil {
+		return err
+	}
+	networkID, err := callRemoteNode(ctx, client, stack, "net_version")
+	if err != nil {
+		return fmt.Errorf("remote node %s did not answer net_version: %s", stack.RemoteNodeURL, err)
+	}
+	chainID, err := strconv.ParseInt(networkID, 10, 64)
+	if err != nil {
+		return fmt.Errorf("remote node %s returned an invalid network ID '%s'", stack.RemoteNodeURL, networkID)
+	}
+	if hexChainID, err := callRemoteNode(ctx, client, stack, "eth_chainId"); err == nil {
+		if chainID, err = strconv.ParseInt(strings.TrimPrefix(hexChainID, "0x"), 16, 64); err != nil {
+			return fmt.Errorf("remote node %s returned an invalid chain ID '%s'", stack.RemoteNodeURL, hexChainID)
+		}
+	}
+	if chainID != stack.ChainID() {
+		return fmt.Errorf("remote node %s is on chain ID %d, but the chain ID of stack '%s' is %d: set --chain-id to %d", stack.RemoteNodeURL, chainID, stack.Name, stack.ChainID(), chainID)
+	}
+	return nil
+}
+
+// remoteNodeClient returns an HTTP client that trusts the downstream RPC CA bundle of the stack, if it has one
+func remoteNodeClient(stack *types.Stack) (*http.Client, error) {
+	client := &http.Client{Timeout: remoteNodeTimeout}
+	if stack.DownstreamRPCCACert == "" {
+		return client, nil
+	}
+	b, err := os.ReadFile(stack.DownstreamRPCCACert)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(b) {
+		return nil, fmt.Errorf("no PEM certificates found in '%s'", stack.DownstreamRPCCACert)
+	}
+	client.Transport = &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}}
+	return client, nil
+}
+
+// callRemoteNode calls a JSON-RPC method without parameters on the remote node, with the downstream RPC
+// credentials of the stack, and returns its string result
+func callRemoteNode(ctx context.Context, client *http.Client, stack *types.Stack, method string) (string, error) {
+	requestBody, err := json.Marshal(&rpcRequest{JSONRPC: "2.0", ID: 1, Method: method, Params: []interface{}{}})
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, stack.RemoteNodeURL, bytes.NewBuffer(requestBody))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if auth := stack.DownstreamRPCAuth; auth != nil {
+		if auth.BearerToken != "" {
+			req.Header.Set("Authorization", "Bearer "+auth.BearerToken)
+		} else if auth.Username != "" || auth.Password != "" {
+			req.SetBasicAuth(auth.Username, auth.Password)
+		}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	responseBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("[%d] %s", resp.StatusCode, responseBody)
+	}
+	var rpcResp rpcResponse
+	if err := json.Unmarshal(responseBody, &rpcResp); err != nil {
+		return "", fmt.Errorf("invalid JSON-RPC response: %s", err)
+	}
+	if rpcResp.Error != nil {
+		return "", fmt.Errorf("%s", rpcResp.Error.Message)
+	}
+	return rpcResp.Result, nil
+}
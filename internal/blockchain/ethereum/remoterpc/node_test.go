@@ -0,0 +1,83 @@
+package remoterpc
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/hyperledger/firefly-cli/internal/docker"
+	"github.com/hyperledger/firefly-cli/internal/log"
+	"github.com/hyperledger/firefly-cli/pkg/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestNode(t *testing.T, results map[string]string) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req rpcRequest
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		if user, password, ok := r.BasicAuth(); ok && (user != "user" || password != "secret") {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		result, ok := results[req.Method]
+		if !ok {
+			fmt.Fprintf(w, `{"jsonrpc":"2.0","id":1,"error":{"code":-32601,"message":"the method %s does not exist"}}`, req.Method)
+			return
+		}
+		fmt.Fprintf(w, `{"jsonrpc":"2.0","id":1,"result":"%s"}`, result)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestCheckRemoteNode(t *testing.T) {
+	chainID := int64(1337)
+	node := newTestNode(t, map[string]string{"net_version": "1337", "eth_chainId": "0x539"})
+	stack := &types.Stack{Name: "devnet", RemoteNodeURL: node.URL, ChainIDPtr: &chainID}
+	assert.NoError(t, CheckRemoteNode(context.Background(), stack))
+
+	stack.DownstreamRPCAuth = &types.RPCAuthConfig{Username: "user", Password: "secret"}
+	assert.NoError(t, CheckRemoteNode(context.Background(), stack))
+	stack.DownstreamRPCAuth.Password = "wrong"
+	assert.Regexp(t, "did not answer net_version: \\[401\\]", CheckRemoteNode(context.Background(), stack))
+	stack.DownstreamRPCAuth = nil
+
+	// A node without eth_chainId is checked against its network ID
+	legacy := newTestNode(t, map[string]string{"net_version": "1337"})
+	assert.NoError(t, CheckRemoteNode(context.Background(), &types.Stack{Name: "devnet", RemoteNodeURL: legacy.URL, ChainIDPtr: &chainID}))
+
+	// The chain ID is what is compared, where the node reports one that differs from its network ID
+	otherChain := newTestNode(t, map[string]string{"net_version": "1337", "eth_chainId": "0x7e5"})
+	err := CheckRemoteNode(context.Background(), &types.Stack{Name: "devnet", RemoteNodeURL: otherChain.URL, ChainIDPtr: &chainID})
+	assert.Regexp(t, "remote node .* is on chain ID 2021, but the chain ID of stack 'devnet' is 1337: set --chain-id to 2021", err)
+
+	noNetVersion := newTestNode(t, map[string]string{"eth_chainId": "0x539"})
+	err = CheckRemoteNode(context.Background(), &types.Stack{Name: "devnet", RemoteNodeURL: noNetVersion.URL, ChainIDPtr: &chainID})
+	assert.Regexp(t, "did not answer net_version: the method net_version does not exist", err)
+
+	node.Close()
+	assert.Regexp(t, "did not answer net_version", CheckRemoteNode(context.Background(), stack))
+
+	assert.Regexp(t, "requires the URL of the remote node", CheckRemoteNode(context.Background(), &types.Stack{Name: "devnet"}))
+}
+
+func TestSkipRemoteNodeCheck(t *testing.T) {
+	chainID := int64(1337)
+	node := newTestNode(t, map[string]string{"net_version": "1337"})
+	node.Close()
+	ctx := log.WithLogger(context.Background(), &log.StdoutLogger{})
+	stack := &types.Stack{Name: "devnet", RemoteNodeURL: node.URL, ChainIDPtr: &chainID}
+
+	p := NewRemoteRPCProvider(ctx, stack)
+	assert.Regexp(t, "did not answer net_version", p.checkRemoteNode(&types.InitOptions{}))
+	assert.NoError(t, p.checkRemoteNode(&types.InitOptions{SkipRemoteNodeCheck: true}))
+	assert.NoError(t, NewRemoteRPCProvider(docker.WithOffline(ctx), stack).checkRemoteNode(&types.InitOptions{}))
+	assert.NoError(t, NewRemoteRPCProvider(docker.WithDryRun(ctx, io.Discard), stack).checkRemoteNode(&types.InitOptions{}))
+
+	// The URL of the node is still required
+	assert.Regexp(t, "requires the URL of the remote node", NewRemoteRPCProvider(ctx, &types.Stack{Name: "devnet"}).checkRemoteNode(&types.InitOptions{SkipRemoteNodeCheck: true}))
+}
@@ -28,6 +28,7 @@ import (
 	"github.com/hyperledger/firefly-cli/internal/blockchain/ethereum/ethsigner"
 	"github.com/hyperledger/firefly-cli/internal/constants"
 	"github.com/hyperledger/firefly-cli/internal/docker"
+	"github.com/hyperledger/firefly-cli/internal/log"
 	"github.com/hyperledger/firefly-cli/pkg/types"
 )
 
@@ -55,13 +56,28 @@ func NewRemoteRPCProvider(ctx context.Context, stack *types.Stack) *RemoteRPCPro
 	}
 }
 
+// checkRemoteNode checks the remote node with CheckRemoteNode, unless the check is turned off, or the stack is
+// initialized offline or as a dry run, where the node may not be reachable yet
+func (p *RemoteRPCProvider) checkRemoteNode(options *types.InitOptions) error {
+	if options.SkipRemoteNodeCheck || docker.IsOffline(p.ctx) || docker.IsDryRun(p.ctx) {
+		log.LoggerFromContext(p.ctx).Info(fmt.Sprintf("not checking the remote node %s", p.stack.RemoteNodeURL))
+		return requireRemoteNodeURL(p.stack)
+	}
+	return CheckRemoteNode(p.ctx, p.stack)
+}
+
 func (p *RemoteRPCProvider) WriteConfig(options *types.InitOptions) error {
+	// Only the signer and connectors are deployed, so the remote node must already be running on the right chain
+	if err := p.checkRemoteNode(options); err != nil {
+		return err
+	}
+
 	initDir := filepath.Join(constants.StacksDir, p.stack.Name, "init")
 	for i, member := range p.stack.Members {
 
 		// Generate the connector config for each member
 		connectorConfigPath := filepath.Join(initDir, "config", fmt.Sprintf("%s_%v.yaml", p.connector.Name(), i))
-		if err := p.connector.GenerateConfig(p.stack, member, "ethsigner").WriteConfig(connectorConfigPath, options.ExtraConnectorConfigPath); err != nil {
+		if err := p.connector.GenerateConfig(p.stack, member, p.signer.URL()).WriteConfig(connectorConfigPath, options.ExtraConnectorConfigPath); err != nil {
 			return err
 		}
 
@@ -92,11 +108,16 @@ func (p *RemoteRPCProvider) FirstTimeSetup() error {
 }
 
 func (p *RemoteRPCProvider) PreStart() error {
-	return nil
+	return p.signer.PreStart()
 }
 
 func (p *RemoteRPCProvider) PostStart(fistTimeSetup bool) error {
-	return nil
+	return p.signer.WaitUntilHealthy()
+}
+
+// VerifySigner checks that the signer of the running stack can sign for each member
+func (p *RemoteRPCProvider) VerifySigner() error {
+	return p.signer.VerifySigner()
 }
 
 func (p *RemoteRPCProvider) DeployFireFlyContract() (*types.ContractDeploymentResult, error) {
@@ -105,17 +126,22 @@ func (p *RemoteRPCProvider) DeployFireFlyContract() (*types.ContractDeploymentRe
 		if err != nil {
 			return nil, err
 		}
-		return p.connector.DeployContract(contract, "FireFly", p.stack.Members[0], nil)
+		return p.connector.DeployContract(contract, "FireFly", p.stack.SigningMember(), nil)
 	}
 	return nil, fmt.Errorf("you must pre-deploy your FireFly contract when using a remote RPC endpoint")
 }
 
-func (p *RemoteRPCProvider) GetDockerServiceDefinitions() []*docker.ServiceDefinition {
-	defs := []*docker.ServiceDefinition{
-		p.signer.GetDockerServiceDefinition(p.stack.RemoteNodeURL),
+func (p *RemoteRPCProvider) GetDockerServiceDefinitions() ([]*docker.ServiceDefinition, error) {
+	defs := []*docker.ServiceDefinition{}
+	signer, err := p.signer.GetDockerServiceDefinition(p.stack.RemoteNodeURL)
+	if err != nil {
+		return nil, err
+	}
+	if signer != nil {
+		defs = append(defs, signer)
 	}
-	defs = append(defs, p.connector.GetServiceDefinitions(p.stack, map[string]string{"ethsigner": "service_healthy"})...)
-	return defs
+	defs = append(defs, p.connector.GetServiceDefinitions(p.stack, p.signer.DependentServices())...)
+	return defs, nil
 }
 
 func (p *RemoteRPCProvider) GetBlockchainPluginConfig(stack *types.Stack, m *types.Organization) (blockchainConfig *types.BlockchainConfig) {
@@ -139,10 +165,12 @@ func (p *RemoteRPCProvider) GetBlockchainPluginConfig(stack *types.Stack, m *typ
 }
 
 func (p *RemoteRPCProvider) GetOrgConfig(stack *types.Stack, m *types.Organization) (orgConfig *types.OrgConfig) {
-	account := m.Account.(*ethereum.Account)
 	orgConfig = &types.OrgConfig{
 		Name: m.OrgName,
-		Key:  account.Address,
+	}
+	// A member that only queries the chain has no key to sign with
+	if account, ok := m.Account.(*ethereum.Account); ok {
+		orgConfig.Key = account.Address
 	}
 	return
 }
@@ -100,7 +100,7 @@ func (p *FabricProvider) WriteConfig(options *types.InitOptions) error {
 func (p *FabricProvider) FirstTimeSetup() error {
 	if !p.stack.RemoteFabricNetwork {
 		volumeName := fmt.Sprintf("%s_firefly_fabric", p.stack.Name)
-		if err := docker.CreateVolume(p.ctx, volumeName); err != nil {
+		if err := docker.CreateVolume(p.ctx, volumeName, docker.StackLabel(p.stack.Name)); err != nil {
 			return err
 		}
 		blockchainDirectory := path.Join(p.stack.RuntimeDir, "blockchain")
@@ -228,13 +228,13 @@ func (p *FabricProvider) PostStart(firstTimeSetup bool) error {
 	return nil
 }
 
-func (p *FabricProvider) GetDockerServiceDefinitions() []*docker.ServiceDefinition {
+func (p *FabricProvider) GetDockerServiceDefinitions() ([]*docker.ServiceDefinition, error) {
 	if p.stack.RemoteFabricNetwork {
-		return p.getFabconnectServiceDefinitions(p.stack.Members)
+		return p.getFabconnectServiceDefinitions(p.stack.Members), nil
 	}
 	serviceDefinitions := GenerateDockerServiceDefinitions(p.stack)
 	serviceDefinitions = append(serviceDefinitions, p.getFabconnectServiceDefinitions(p.stack.Members)...)
-	return serviceDefinitions
+	return serviceDefinitions, nil
 }
 
 func (p *FabricProvider) GetBlockchainPluginConfig(stack *types.Stack, m *types.Organization) (blockchainConfig *types.BlockchainConfig) {
@@ -90,13 +90,13 @@ func (p *RemoteRPCProvider) DeployFireFlyContract() (*types.ContractDeploymentRe
 	return nil, fmt.Errorf("you must pre-deploy your FireFly contract when using a remote RPC endpoint")
 }
 
-func (p *RemoteRPCProvider) GetDockerServiceDefinitions() []*docker.ServiceDefinition {
+func (p *RemoteRPCProvider) GetDockerServiceDefinitions() ([]*docker.ServiceDefinition, error) {
 	defs := []*docker.ServiceDefinition{
 		p.signer.GetDockerServiceDefinition(p.stack.RemoteNodeURL),
 	}
 	defs = append(defs, p.connector.GetServiceDefinitions(p.stack, map[string]string{"tezossigner": "service_healthy"})...)
 
-	return defs
+	return defs, nil
 }
 
 func (p *RemoteRPCProvider) GetBlockchainPluginConfig(stack *types.Stack, m *types.Organization) (blockchainConfig *types.BlockchainConfig) {
@@ -67,7 +67,7 @@ func (p *TezosSignerProvider) FirstTimeSetup() error {
 	tezossignerVolumeName := fmt.Sprintf("%s_tezossigner", p.stack.Name)
 	blockchainDir := filepath.Join(p.stack.RuntimeDir, "blockchain")
 
-	if err := docker.CreateVolume(p.ctx, tezossignerVolumeName); err != nil {
+	if err := docker.CreateVolume(p.ctx, tezossignerVolumeName, docker.StackLabel(p.stack.Name)); err != nil {
 		return err
 	}
 
@@ -135,7 +135,7 @@ func (p *TezosSignerProvider) CreateAccount(args []string) (interface{}, error)
 	}
 
 	outputDirectory := filepath.Join(directory, "blockchain", "keystore")
-	if err := os.MkdirAll(outputDirectory, 0755); err != nil {
+	if err := os.MkdirAll(outputDirectory, constants.KeyDirectoryMode); err != nil {
 		return nil, err
 	}
 
@@ -153,7 +153,7 @@ func (p *TezosSignerProvider) CreateAccount(args []string) (interface{}, error)
 ]`, address, pk)
 
 	filename := filepath.Join(outputDirectory, "secret.json")
-	if err := os.WriteFile(filename, []byte(json), 0755); err != nil {
+	if err := os.WriteFile(filename, []byte(json), constants.KeyFileMode); err != nil {
 		return nil, err
 	}
 
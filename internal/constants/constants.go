@@ -28,6 +28,13 @@ var PostgresImageName = "postgres"
 var PrometheusImageName = "prom/prometheus"
 var SandboxImageName = "ghcr.io/hyperledger/firefly-sandbox:latest"
 
+// Files holding private keys, or the passwords that protect them, are only accessible to the current user.
+// They are copied into docker volumes by containers running as root, which can still read them.
+const (
+	KeyFileMode      os.FileMode = 0600
+	KeyDirectoryMode os.FileMode = 0700
+)
+
 func checkHome() string {
 	var homeDir, _ = os.UserHomeDir()
 	var StacksDir = filepath.Join(homeDir, ".firefly", "stacks")
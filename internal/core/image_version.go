@@ -0,0 +1,68 @@
+// Copyright © 2024 Kaleido, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package core
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ImageVersionLabel is the standard OCI label that images use to advertise the version they were built from
+const ImageVersionLabel = "org.opencontainers.image.version"
+
+// CompareVersions compares two semantic versions, with or without a leading "v". Any pre-release or build
+// suffix is ignored. The result is -1, 0 or 1 if a is older than, the same as, or newer than b.
+func CompareVersions(a, b string) (int, error) {
+	aParts, err := parseVersion(a)
+	if err != nil {
+		return 0, err
+	}
+	bParts, err := parseVersion(b)
+	if err != nil {
+		return 0, err
+	}
+	for i := range aParts {
+		switch {
+		case aParts[i] < bParts[i]:
+			return -1, nil
+		case aParts[i] > bParts[i]:
+			return 1, nil
+		}
+	}
+	return 0, nil
+}
+
+func parseVersion(version string) ([3]int, error) {
+	var parts [3]int
+	v := strings.TrimPrefix(strings.TrimSpace(version), "v")
+	if i := strings.IndexAny(v, "-+"); i >= 0 {
+		v = v[:i]
+	}
+	split := strings.Split(v, ".")
+	if len(split) > 3 {
+		return parts, fmt.Errorf("'%s' is not a semantic version", version)
+	}
+	for i, s := range split {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			return parts, fmt.Errorf("'%s' is not a semantic version", version)
+		}
+		parts[i] = n
+	}
+	return parts, nil
+}
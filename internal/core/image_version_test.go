@@ -0,0 +1,39 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompareVersions(t *testing.T) {
+	testCases := []struct {
+		a, b     string
+		expected int
+	}{
+		{"v1.2.0", "v1.2.0", 0},
+		{"1.2.0", "v1.2.0", 0},
+		{"v1.2", "v1.2.0", 0},
+		{"v1.1.9", "v1.2.0", -1},
+		{"v1.10.0", "v1.9.0", 1},
+		{"v2.0.0", "v1.99.99", 1},
+		{"v1.3.0-rc.1", "v1.3.0", 0},
+		{"1.10.26+build.3", "1.10.0", 1},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.a+"_"+tc.b, func(t *testing.T) {
+			result, err := CompareVersions(tc.a, tc.b)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, result)
+		})
+	}
+}
+
+func TestCompareVersionsInvalid(t *testing.T) {
+	_, err := CompareVersions("latest", "v1.2.0")
+	assert.Regexp(t, "'latest' is not a semantic version", err)
+	_, err = CompareVersions("v1.2.0", "1.2.3.4")
+	assert.Regexp(t, "'1.2.3.4' is not a semantic version", err)
+	_, err = CompareVersions("", "v1.2.0")
+	assert.Error(t, err)
+}
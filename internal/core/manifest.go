@@ -17,6 +17,7 @@
 package core
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"os"
@@ -28,7 +29,7 @@ import (
 	"github.com/hyperledger/firefly-common/pkg/fftypes"
 )
 
-func GetManifestForChannel(releaseChannel fftypes.FFEnum) (*types.VersionManifest, error) {
+func GetManifestForChannel(ctx context.Context, releaseChannel fftypes.FFEnum) (*types.VersionManifest, error) {
 	dockerTag := releaseChannel.String()
 	if releaseChannel == types.ReleaseChannelStable {
 		dockerTag = "latest"
@@ -36,12 +37,12 @@ func GetManifestForChannel(releaseChannel fftypes.FFEnum) (*types.VersionManifes
 
 	imageName := fmt.Sprintf("%s:%s", constants.FireFlyCoreImageName, dockerTag)
 
-	gitCommit, err := docker.GetImageLabel(imageName, "commit")
+	gitCommit, err := docker.GetImageLabel(ctx, imageName, "commit")
 	if err != nil {
 		return nil, err
 	}
 
-	sha, err := getSHA(constants.FireFlyCoreImageName, dockerTag)
+	sha, err := getSHA(ctx, constants.FireFlyCoreImageName, dockerTag)
 	if err != nil {
 		return nil, err
 	}
@@ -63,12 +64,12 @@ func GetManifestForChannel(releaseChannel fftypes.FFEnum) (*types.VersionManifes
 	return manifest, nil
 }
 
-func GetManifestForRelease(version string) (*types.VersionManifest, error) {
+func GetManifestForRelease(ctx context.Context, version string) (*types.VersionManifest, error) {
 	tag := version
 	if version == "main" {
 		tag = "head"
 	}
-	sha, err := getSHA(constants.FireFlyCoreImageName, tag)
+	sha, err := getSHA(ctx, constants.FireFlyCoreImageName, tag)
 	if err != nil {
 		return nil, err
 	}
@@ -98,8 +99,8 @@ func getManifest(version string) (*types.VersionManifest, error) {
 	return manifest, nil
 }
 
-func getSHA(imageName, imageTag string) (string, error) {
-	digest, err := docker.GetImageDigest(fmt.Sprintf("%s:%s", imageName, imageTag))
+func getSHA(ctx context.Context, imageName, imageTag string) (string, error) {
+	digest, err := docker.GetImageDigest(ctx, fmt.Sprintf("%s:%s", imageName, imageTag))
 	if err != nil {
 		return "", err
 	} else {
@@ -17,6 +17,7 @@
 package core
 
 import (
+	"context"
 	"testing"
 
 	"github.com/hyperledger/firefly-cli/pkg/types"
@@ -24,7 +25,7 @@ import (
 )
 
 func TestGetFireFlyManifest(t *testing.T) {
-	manifest, err := GetManifestForRelease("main")
+	manifest, err := GetManifestForRelease(context.Background(), "main")
 	assert.NoError(t, err)
 	assert.NotNil(t, manifest)
 	assert.NotNil(t, manifest.Ethconnect)
@@ -35,7 +36,7 @@ func TestGetFireFlyManifest(t *testing.T) {
 }
 
 func TestGetLatestReleaseManifest(t *testing.T) {
-	manifest, err := GetManifestForChannel(types.ReleaseChannelStable)
+	manifest, err := GetManifestForChannel(context.Background(), types.ReleaseChannelStable)
 	assert.NoError(t, err)
 	assert.NotNil(t, manifest)
 	assert.NotNil(t, manifest.FireFly)
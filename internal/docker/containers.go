@@ -0,0 +1,125 @@
+// Copyright © 2024 Kaleido, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package docker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/hyperledger/firefly-cli/internal/log"
+)
+
+// ContainerInfo is a container of a stack, as listed by docker ps
+type ContainerInfo struct {
+	ID    string
+	Name  string
+	Image string
+	// State is the state of the container process, such as "running" or "exited"
+	State string
+	// Status is the human readable status docker prints, such as "Up 2 minutes (healthy)"
+	Status string
+	// Ports are the published ports of the container, such as "0.0.0.0:5000->5000/tcp"
+	Ports []string
+	// Health is the result of the container health check, or empty if the container does not have one
+	Health string
+}
+
+// psContainer is a line of the output of docker ps --format '{{json .}}'
+type psContainer struct {
+	ID     string `json:"ID"`
+	Names  string `json:"Names"`
+	Image  string `json:"Image"`
+	State  string `json:"State"`
+	Status string `json:"Status"`
+	Ports  string `json:"Ports"`
+	Labels string `json:"Labels"`
+}
+
+// composeProjectLabel is the label compose puts on every container it creates, set to the name of the project
+const composeProjectLabel = "com.docker.compose.project"
+
+// statusHealthRegex matches the health check result that docker ps appends to the status of a container
+var statusHealthRegex = regexp.MustCompile(`\((healthy|unhealthy|health: starting)\)`)
+
+// ListStackContainers returns every container of the stack, whether or not it is running, sorted by name. Stack
+// names may contain underscores, so a container that compose created for another stack whose name shares the
+// prefix is left out.
+func ListStackContainers(ctx context.Context, stackName string) ([]ContainerInfo, error) {
+	output, err := RunDockerCommandBuffered(ctx, ".", "ps", "--all", "--filter", "name=^"+regexp.QuoteMeta(stackName+"_"), "--format", "{{json .}}")
+	if err != nil {
+		return nil, err
+	}
+	containers := []ContainerInfo{}
+	for _, line := range strings.Split(output, "\n") {
+		if line = strings.TrimSpace(line); line == "" {
+			continue
+		}
+		container, project, err := parsePSContainer(line)
+		if err != nil {
+			log.LoggerFromContext(ctx).Warn(fmt.Sprintf("ignoring container of stack '%s': %s", stackName, err))
+			continue
+		}
+		if !strings.HasPrefix(container.Name, stackName+"_") {
+			continue
+		}
+		if project != "" && project != ComposeProjectName(stackName) {
+			continue
+		}
+		containers = append(containers, container)
+	}
+	sort.Slice(containers, func(i, j int) bool { return containers[i].Name < containers[j].Name })
+	return containers, nil
+}
+
+// parsePSContainer parses a line of docker ps output, returning the container along with the compose project it
+// belongs to, which is empty if compose did not create it
+func parsePSContainer(line string) (ContainerInfo, string, error) {
+	var ps psContainer
+	if err := json.Unmarshal([]byte(line), &ps); err != nil {
+		return ContainerInfo{}, "", fmt.Errorf("unexpected docker ps output '%s': %s", line, err)
+	}
+	if ps.Names == "" {
+		return ContainerInfo{}, "", fmt.Errorf("unexpected docker ps output '%s': no container name", line)
+	}
+	container := ContainerInfo{
+		ID:     ps.ID,
+		Name:   strings.Split(ps.Names, ",")[0],
+		Image:  ps.Image,
+		State:  ps.State,
+		Status: ps.Status,
+		Ports:  []string{},
+	}
+	for _, port := range strings.Split(ps.Ports, ",") {
+		if port = strings.TrimSpace(port); port != "" {
+			container.Ports = append(container.Ports, port)
+		}
+	}
+	if match := statusHealthRegex.FindStringSubmatch(ps.Status); match != nil {
+		container.Health = strings.TrimPrefix(match[1], "health: ")
+	}
+	project := ""
+	for _, label := range strings.Split(ps.Labels, ",") {
+		if key, value, ok := strings.Cut(label, "="); ok && key == composeProjectLabel {
+			project = value
+		}
+	}
+	return container, project, nil
+}
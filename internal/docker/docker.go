@@ -19,20 +19,38 @@ package docker
 import (
 	"bufio"
 	"context"
+	"crypto/sha256"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"net"
+	"net/http"
+	"os"
 	"os/exec"
 	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
+	"github.com/google/go-containerregistry/pkg/authn"
 	"github.com/google/go-containerregistry/pkg/crane"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
 	"github.com/hyperledger/firefly-cli/internal/log"
+	"golang.org/x/sync/singleflight"
 )
 
 type (
 	CtxIsLogCmdKey       struct{}
 	CtxComposeVersionKey struct{}
+	CtxDryRunKey         struct{}
+	CtxOfflineKey        struct{}
+	CtxComposeProjectKey struct{}
 	DockerComposeVersion int
 )
 
@@ -42,56 +60,640 @@ const (
 	ComposeV2
 )
 
-func CreateVolume(ctx context.Context, volumeName string) error {
-	return RunDockerCommand(ctx, ".", "volume", "create", volumeName)
+// WithDryRun returns a context in which docker commands are written to w instead of being run. Commands that
+// query docker, such as VolumeExists, then behave as though nothing has been created yet.
+func WithDryRun(ctx context.Context, w io.Writer) context.Context {
+	return context.WithValue(ctx, CtxDryRunKey{}, w)
 }
 
-func CopyFileToVolume(ctx context.Context, volumeName string, sourcePath string, destPath string) error {
-	fileName := path.Base(sourcePath)
-	source := path.Join("/", "source", fileName)
+// WithOffline returns a context in which no image is ever pulled or looked up in a registry. The digests and
+// labels of images are read from the images that have already been loaded into the local docker engine.
+func WithOffline(ctx context.Context) context.Context {
+	return context.WithValue(ctx, CtxOfflineKey{}, true)
+}
+
+// WithComposeProject returns a context in which every compose command is run against the compose project of the
+// given stack, rather than the project compose derives from the name of the working directory
+func WithComposeProject(ctx context.Context, stackName string) context.Context {
+	return context.WithValue(ctx, CtxComposeProjectKey{}, ComposeProjectName(stackName))
+}
+
+// composeProjectInvalidRegex matches the characters compose does not allow in a project name
+var composeProjectInvalidRegex = regexp.MustCompile(`[^a-z0-9_-]`)
+
+// ComposeProjectName returns the compose project name of a stack. It is normalized the same way compose
+// normalizes the name of the stack directory, so stacks that were started before the project name was passed
+// explicitly are still found.
+func ComposeProjectName(stackName string) string {
+	name := composeProjectInvalidRegex.ReplaceAllString(strings.ToLower(stackName), "")
+	return strings.TrimLeft(name, "_-")
+}
+
+// IsDryRun returns whether docker commands are printed rather than run
+func IsDryRun(ctx context.Context) bool {
+	w, ok := ctx.Value(CtxDryRunKey{}).(io.Writer)
+	return ok && w != nil
+}
+
+// IsOffline returns whether images must only come from the local docker engine
+func IsOffline(ctx context.Context) bool {
+	offline, _ := ctx.Value(CtxOfflineKey{}).(bool)
+	return offline
+}
+
+// StackNameLabel is the label applied to the docker volumes created for a stack, whose value is the
+// name of the stack that owns them
+const StackNameLabel = "io.hyperledger.firefly-cli.stack"
+
+// StackLabel returns the label identifying the resources that belong to the given stack
+func StackLabel(stackName string) string {
+	return fmt.Sprintf("%s=%s", StackNameLabel, stackName)
+}
+
+// CreateVolume creates a docker volume with the given labels, each in key=value form. It is not an
+// error for the volume to exist already, in which case its labels are left unchanged.
+func CreateVolume(ctx context.Context, volumeName string, labels ...string) error {
+	volumeExists, err := VolumeExists(ctx, volumeName)
+	if err != nil || volumeExists {
+		return err
+	}
+	args := []string{"volume", "create"}
+	for _, label := range labels {
+		args = append(args, "--label", label)
+	}
+	return RunDockerCommand(ctx, ".", append(args, volumeName)...)
+}
+
+// VolumeExists returns whether a docker volume with the given name has already been created
+func VolumeExists(ctx context.Context, volumeName string) (bool, error) {
+	output, err := RunDockerCommandBuffered(ctx, ".", "volume", "ls", "--quiet", "--filter", fmt.Sprintf("name=^%s$", volumeName))
+	if err != nil {
+		return false, err
+	}
+	for _, name := range strings.Split(output, "\n") {
+		if strings.TrimSpace(name) == volumeName {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// NetworkExists returns whether a docker network with the given name exists
+func NetworkExists(ctx context.Context, networkName string) (bool, error) {
+	output, err := RunDockerCommandBuffered(ctx, ".", "network", "ls", "--quiet", "--filter", fmt.Sprintf("name=^%s$", networkName), "--format", "{{.Name}}")
+	if err != nil {
+		return false, err
+	}
+	for _, name := range strings.Split(output, "\n") {
+		if strings.TrimSpace(name) == networkName {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func NetworkCreate(ctx context.Context, networkName string) error {
+	return RunDockerCommand(ctx, ".", "network", "create", networkName)
+}
+
+// ListFilesInVolume returns the names of the entries in a directory inside a docker volume. A directory
+// that does not exist in the volume is treated as empty.
+func ListFilesInVolume(ctx context.Context, volumeName string, directory string) ([]string, error) {
+	dir := path.Join("/", "dest", directory)
+	command := fmt.Sprintf("if [ -d %s ]; then ls -1 %s; fi", dir, dir)
+	// Only the output of the container is read, so that the messages of docker pulling the helper image are not
+	// taken to be files
+	output, err := runOutput(ctx, ".", "docker", "run", "--rm", "-v", fmt.Sprintf("%s:/dest", volumeName), HelperImage, "/bin/sh", "-c", command)
+	if err != nil {
+		return nil, err
+	}
+	files := []string{}
+	for _, name := range strings.Split(output, "\n") {
+		if name = strings.TrimSpace(name); name != "" {
+			files = append(files, name)
+		}
+	}
+	return files, nil
+}
+
+// ReadFileFromVolume returns the content of a file inside a docker volume
+func ReadFileFromVolume(ctx context.Context, volumeName string, filePath string) ([]byte, error) {
+	source := shellQuote(path.Join("/", "source", filePath))
+	output, err := runOutput(ctx, ".", "docker", "run", "--rm", "-v", fmt.Sprintf("%s:/source:ro", volumeName), HelperImage, "/bin/sh", "-c", fmt.Sprintf("cat %s", source))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read '%s' from volume '%s': %s", filePath, volumeName, err)
+	}
+	return []byte(output), nil
+}
+
+type copyOptions struct {
+	owner string
+	mode  os.FileMode
+}
+
+// CopyOption customizes the ownership and permissions of the files copied by CopyFileToVolume
+type CopyOption func(*copyOptions)
+
+// WithOwner makes the copied files owned by the given user and group, rather than root
+func WithOwner(uid, gid int) CopyOption {
+	return func(o *copyOptions) {
+		o.owner = fmt.Sprintf("%d:%d", uid, gid)
+	}
+}
+
+// WithMode sets the permissions of the copied files, rather than making them group writable. Directories
+// keep their permissions, so that they can still be traversed.
+func WithMode(mode os.FileMode) CopyOption {
+	return func(o *copyOptions) {
+		o.mode = mode
+	}
+}
+
+func CopyFileToVolume(ctx context.Context, volumeName string, sourcePath string, destPath string, options ...CopyOption) error {
+	mount, err := bindMount(sourcePath, path.Join("/", "source", filepath.Base(sourcePath)))
+	if err != nil {
+		return err
+	}
+	return RunDockerCommand(ctx, ".", "run", "--rm", "--mount", mount, "-v", fmt.Sprintf("%s:/dest", volumeName), HelperImage, "/bin/sh", "-c", copyFileToVolumeCommand(sourcePath, destPath, options...))
+}
+
+// bindMount returns the --mount argument that binds a host path into a container. Unlike the -v syntax,
+// which splits on colons, this works for Windows drive letters and for paths containing colons or spaces.
+func bindMount(sourcePath, target string) (string, error) {
+	if strings.ContainsAny(sourcePath, "\x00\n\r") {
+		return "", fmt.Errorf("invalid path '%s': contains control characters", sourcePath)
+	}
+	source, err := filepath.Abs(sourcePath)
+	if err != nil {
+		return "", err
+	}
+	// The fields of --mount are comma separated values, so any that contain a comma or quote must be quoted
+	fields := []string{"type=bind", "source=" + source, "target=" + target}
+	for i, field := range fields {
+		if strings.ContainsAny(field, ",\"") {
+			fields[i] = `"` + strings.ReplaceAll(field, `"`, `""`) + `"`
+		}
+	}
+	return strings.Join(fields, ","), nil
+}
+
+// shellQuote quotes a path for use in a /bin/sh command, if it contains any characters the shell would
+// interpret
+func shellQuote(s string) string {
+	if shellSafeRegex.MatchString(s) {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+var shellSafeRegex = regexp.MustCompile(`^[A-Za-z0-9_./:=@%+-]+$`)
+
+// copyFileToVolumeCommand returns the command that copies the source into the volume. A destPath of "/", or one
+// ending in "/", is a directory that the source is copied into, and only the copied entry has its ownership and
+// permissions changed, so that the other files already in the directory keep theirs.
+func copyFileToVolumeCommand(sourcePath string, destPath string, options ...CopyOption) string {
+	source := shellQuote(path.Join("/", "source", filepath.Base(sourcePath)))
+	dest := path.Join("/", "dest", destPath)
+	target := dest
+	if strings.HasSuffix(destPath, "/") {
+		target = path.Join(dest, filepath.Base(sourcePath))
+	}
+	dest, target = shellQuote(dest), shellQuote(target)
+	o := &copyOptions{}
+	for _, option := range options {
+		option(o)
+	}
+	if o.owner == "" && o.mode == 0 {
+		return fmt.Sprintf("cp -R %s %s && chgrp -R 0 %s && chmod -R g+rwX %s", source, dest, target, target)
+	}
+	// The busybox cp in alpine does not preserve the mode or ownership of the source without -p, so the
+	// ownership and permissions requested are set explicitly on the result
+	command := fmt.Sprintf("cp -R %s %s", source, dest)
+	if o.owner != "" {
+		command += fmt.Sprintf(" && chown -R %s %s", o.owner, target)
+	} else {
+		command += fmt.Sprintf(" && chgrp -R 0 %s", target)
+	}
+	if o.mode != 0 {
+		command += fmt.Sprintf(" && find %s -type f -exec chmod %o {} +", target, o.mode.Perm())
+	} else {
+		command += fmt.Sprintf(" && chmod -R g+rwX %s", target)
+	}
+	return command
+}
+
+// WriteToVolume writes content to destPath in a volume, creating its directory if needed. The content is piped
+// into the container, so unlike CopyFileToVolume there is no file on the host to create or clean up. The file is
+// group owned by root, like those copied by CopyFileToVolume, with the given permissions.
+func WriteToVolume(ctx context.Context, volumeName, destPath string, content []byte, mode os.FileMode) error {
 	dest := path.Join("/", "dest", destPath)
-	// command := fmt.Sprintf("run --rm -v %s:%s -v %s:%s alpine /bin/sh -c 'cp -R %s %s '", sourcePath, source, volumeName, dest, source, dest, dest, dest)
-	command := fmt.Sprintf("cp -R %s %s && chgrp -R 0 %s && chmod -R g+rwX %s", source, dest, dest, dest)
-	return RunDockerCommand(ctx, ".", "run", "--rm", "-v", fmt.Sprintf("%s:%s", sourcePath, source), "-v", fmt.Sprintf("%s:/dest", volumeName), "alpine", "/bin/sh", "-c", command)
+	command := fmt.Sprintf("mkdir -p %s && cat > %s && chgrp 0 %s && chmod %o %s", shellQuote(path.Dir(dest)), shellQuote(dest), shellQuote(dest), mode.Perm(), shellQuote(dest))
+	output, err := runWithInput(ctx, ".", content, "docker", "run", "--rm", "-i", "-v", fmt.Sprintf("%s:/dest", volumeName), HelperImage, "/bin/sh", "-c", command)
+	if err != nil && output != "" {
+		return fmt.Errorf("%s", output)
+	}
+	return err
 }
 
 func MkdirInVolume(ctx context.Context, volumeName string, directory string) error {
 	dest := path.Join("/", "dest", directory)
 	command := fmt.Sprintf("mkdir -p %s && chgrp -R 0 %s && chmod -R g+rwX %s", dest, dest, dest)
-	return RunDockerCommand(ctx, ".", "run", "--rm", "-v", fmt.Sprintf("%s:/dest", volumeName), "alpine", "/bin/sh", "-c", command)
+	return RunDockerCommand(ctx, ".", "run", "--rm", "-v", fmt.Sprintf("%s:/dest", volumeName), HelperImage, "/bin/sh", "-c", command)
 }
 
 func RemoveVolume(ctx context.Context, volumeName string) error {
 	return RunDockerCommand(ctx, ".", "volume", "remove", volumeName)
 }
 
+// SnapshotVolume writes the contents of a docker volume to a gzipped tar archive at outPath on the host, which
+// RestoreVolume restores. The archive is written by the helper container straight into the directory of outPath,
+// so large volumes are never held in memory, and is only renamed to outPath once it is complete. It holds the
+// numeric owner and mode of every file, and as it may hold keys it is only readable by its owner, which is the
+// user running the CLI rather than the root user of the container.
+func SnapshotVolume(ctx context.Context, volumeName, outPath string) error {
+	dir := filepath.Dir(outPath)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	mount, err := bindMount(dir, "/backup")
+	if err != nil {
+		return err
+	}
+	archive := shellQuote(path.Join("/", "backup", filepath.Base(outPath)))
+	partial := shellQuote(path.Join("/", "backup", "."+filepath.Base(outPath)+".partial"))
+	command := fmt.Sprintf("tar --numeric-owner -czf %s -C /source . && chmod 600 %s%s && mv %s %s || { rm -f %s; exit 1; }", partial, partial, chownToCaller(partial), partial, archive, partial)
+	if err := RunDockerCommand(ctx, ".", "run", "--rm", "-v", fmt.Sprintf("%s:/source:ro", volumeName), "--mount", mount, HelperImage, "/bin/sh", "-c", command); err != nil {
+		return fmt.Errorf("failed to snapshot volume '%s' to '%s': %s", volumeName, outPath, err)
+	}
+	return nil
+}
+
+// chownToCaller returns the command that gives a file written by a helper container to the user running the CLI, to
+// be chained after another command. There is no such user on Windows, where the file is owned by the user anyway.
+func chownToCaller(file string) string {
+	uid, gid := os.Getuid(), os.Getgid()
+	if uid < 0 || gid < 0 {
+		return ""
+	}
+	return fmt.Sprintf(" && chown %d:%d %s", uid, gid, file)
+}
+
+// RestoreVolume extracts an archive written by SnapshotVolume into a docker volume, creating the volume if it does
+// not exist. The files keep the owners and modes they had in the snapshot. The volume must be empty, so that a
+// snapshot is never mixed with the files of another stack.
+func RestoreVolume(ctx context.Context, volumeName, snapshotPath string) error {
+	if _, err := os.Stat(snapshotPath); err != nil {
+		return fmt.Errorf("failed to restore volume '%s' from '%s': %s", volumeName, snapshotPath, err)
+	}
+	if err := CreateVolume(ctx, volumeName); err != nil {
+		return err
+	}
+	files, err := ListFilesInVolume(ctx, volumeName, "")
+	if err != nil {
+		return err
+	}
+	if len(files) > 0 {
+		return fmt.Errorf("failed to restore volume '%s' from '%s': the volume is not empty", volumeName, snapshotPath)
+	}
+	mount, err := bindMount(snapshotPath, path.Join("/", "backup", filepath.Base(snapshotPath)))
+	if err != nil {
+		return err
+	}
+	command := fmt.Sprintf("tar --numeric-owner -xzpf %s -C /dest", shellQuote(path.Join("/", "backup", filepath.Base(snapshotPath))))
+	if err := RunDockerCommand(ctx, ".", "run", "--rm", "--mount", mount+",readonly", "-v", fmt.Sprintf("%s:/dest", volumeName), HelperImage, "/bin/sh", "-c", command); err != nil {
+		return fmt.Errorf("failed to restore volume '%s' from '%s': %s", volumeName, snapshotPath, err)
+	}
+	return nil
+}
+
+// RemoveVolumesByLabel removes every docker volume that has the given label, in key or key=value
+// form, regardless of the name of the volume
+func RemoveVolumesByLabel(ctx context.Context, label string) error {
+	output, err := RunDockerCommandBuffered(ctx, ".", "volume", "ls", "--quiet", "--filter", fmt.Sprintf("label=%s", label))
+	if err != nil {
+		return err
+	}
+	for _, volumeName := range strings.Split(output, "\n") {
+		if volumeName = strings.TrimSpace(volumeName); volumeName == "" {
+			continue
+		}
+		if err := RemoveVolume(ctx, volumeName); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// PruneStack removes every container, network and volume whose name starts with the name of the stack
+// followed by an underscore, along with any volume labelled as belonging to the stack. Resources that have
+// already gone are ignored. Stack names may contain underscores, so the names of any other stacks that
+// share the prefix must be passed in keep, and their resources are left alone.
+func PruneStack(ctx context.Context, stackName string, keep ...string) error {
+	prefix := stackName + "_"
+	listContainers := func(filter string) []string {
+		return []string{"ps", "--all", "--filter", "name=" + filter, "--format", "{{.Names}}"}
+	}
+	if err := removeStackResources(ctx, prefix, keep, listContainers, "rm", "--force"); err != nil {
+		return err
+	}
+	listNetworks := func(filter string) []string {
+		return []string{"network", "ls", "--filter", "name=" + filter, "--format", "{{.Name}}"}
+	}
+	if err := removeStackResources(ctx, prefix, keep, listNetworks, "network", "remove"); err != nil {
+		return err
+	}
+	listVolumes := func(filter string) []string {
+		return []string{"volume", "ls", "--quiet", "--filter", "name=" + filter}
+	}
+	if err := removeStackResources(ctx, prefix, keep, listVolumes, "volume", "remove"); err != nil {
+		return err
+	}
+	return RemoveVolumesByLabel(ctx, StackLabel(stackName))
+}
+
+// removeStackResources removes each of the resources printed by the list command whose name starts with
+// prefix, skipping those that belong to any of the stacks in keep. The list command is built for a docker
+// name filter, which is a regular expression.
+func removeStackResources(ctx context.Context, prefix string, keep []string, list func(filter string) []string, remove ...string) error {
+	names, err := listResourceNames(ctx, list("^"+regexp.QuoteMeta(prefix)))
+	if err != nil {
+		return err
+	}
+outer:
+	for _, name := range names {
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		for _, otherStack := range keep {
+			if strings.HasPrefix(name, otherStack+"_") {
+				continue outer
+			}
+		}
+		if err := RunDockerCommand(ctx, ".", append(remove, name)...); err != nil {
+			// The resource may have gone since it was listed, which is not an error
+			remaining, listErr := listResourceNames(ctx, list("^"+regexp.QuoteMeta(name)+"$"))
+			if listErr != nil || len(remaining) > 0 {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func listResourceNames(ctx context.Context, command []string) ([]string, error) {
+	output, err := RunDockerCommandBuffered(ctx, ".", command...)
+	if err != nil {
+		return nil, err
+	}
+	names := []string{}
+	for _, name := range strings.Split(output, "\n") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+// CopyFromContainer copies a file or a whole directory out of a container, which need not be running. When
+// destPath is an existing directory, or ends in a path separator, the source is copied into it. Otherwise the
+// source is copied to destPath itself, and any missing parent directories are created first. The files are
+// owned by the local user rather than keeping the owner they have in the container.
 func CopyFromContainer(ctx context.Context, containerName string, sourcePath string, destPath string) error {
+	dir := filepath.Dir(destPath)
+	if strings.HasSuffix(destPath, string(filepath.Separator)) || strings.HasSuffix(destPath, "/") {
+		dir = destPath
+	}
+	if w, ok := ctx.Value(CtxDryRunKey{}).(io.Writer); ok && w != nil {
+		if _, err := fmt.Fprintf(w, "mkdir -p %s\n", dir); err != nil {
+			return err
+		}
+	} else if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
 	if err := RunDockerCommand(ctx, ".", "cp", containerName+":"+sourcePath, destPath); err != nil {
+		if strings.Contains(strings.ToLower(err.Error()), "could not find the file") {
+			return fmt.Errorf("'%s' does not exist in container '%s'", sourcePath, containerName)
+		}
 		return err
 	}
 	return nil
 }
 
-func RunDockerCommandRetry(ctx context.Context, workingDir string, retries int, command ...string) error {
+// IsDaemonUnavailable returns whether a docker command failed because docker is not installed, or because its
+// daemon could not be reached, rather than because of the command itself
+func IsDaemonUnavailable(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, exec.ErrNotFound) {
+		return true
+	}
+	msg := strings.ToLower(err.Error())
+	return strings.Contains(msg, "cannot connect to the docker daemon") ||
+		strings.Contains(msg, "is the docker daemon running") ||
+		strings.Contains(msg, "error during connect")
+}
+
+// ErrContainerNotFound is returned by InspectContainerState when there is no container with the given name
+var ErrContainerNotFound = errors.New("no such container")
+
+const (
+	HealthStarting  = "starting"
+	HealthHealthy   = "healthy"
+	HealthUnhealthy = "unhealthy"
+)
+
+// State is the runtime state of a container
+type State struct {
+	// Status is the state of the container process, such as "running" or "exited"
+	Status string
+	// Health is the result of the container health check, or empty if the container does not have one
+	Health string
+	// ExitCode is the exit code of the process, which is only meaningful once it has exited
+	ExitCode int
+}
+
+const containerStateFormat = "{{.State.Status}}|{{if .State.Health}}{{.State.Health.Status}}{{end}}|{{.State.ExitCode}}"
+
+func InspectContainerState(ctx context.Context, containerName string) (State, error) {
+	output, err := RunDockerCommandBuffered(ctx, ".", "inspect", "--type", "container", "--format", containerStateFormat, containerName)
+	if err != nil {
+		if strings.Contains(err.Error(), "No such container") || strings.Contains(err.Error(), "No such object") {
+			return State{}, fmt.Errorf("%w '%s'", ErrContainerNotFound, containerName)
+		}
+		return State{}, err
+	}
+	return parseContainerState(output)
+}
+
+// ErrContainerNotRunning is returned by Exec and ExecInteractive when the container exists but is not running
+var ErrContainerNotRunning = errors.New("container is not running")
+
+// Exec runs a command inside a running container, returning its output
+func Exec(ctx context.Context, containerName string, command ...string) (string, error) {
+	output, err := RunDockerCommandBuffered(ctx, ".", append([]string{"exec", containerName}, command...)...)
+	if err != nil {
+		return output, execError(containerName, err)
+	}
+	return output, nil
+}
+
+// ExecInteractive runs a command inside a running container with a TTY, attached to the terminal of this
+// process. The output goes straight to the terminal, so the container is checked to be running first.
+func ExecInteractive(ctx context.Context, containerName string, command ...string) error {
+	args := append([]string{"exec", "-it", containerName}, command...)
+	if w, ok := ctx.Value(CtxDryRunKey{}).(io.Writer); ok && w != nil {
+		_, err := fmt.Fprintf(w, "docker %s\n", strings.Join(args, " "))
+		return err
+	}
+	state, err := InspectContainerState(ctx, containerName)
+	if err != nil {
+		return err
+	}
+	if state.Status != "running" {
+		return fmt.Errorf("%w: '%s' is %s", ErrContainerNotRunning, containerName, state.Status)
+	}
+	return commandRunner(ctx).Interactive(ctx, "docker", args...)
+}
+
+// execError replaces the error of a failed docker exec with ErrContainerNotFound or ErrContainerNotRunning
+// when the container is missing or stopped, rather than the command having failed inside it
+func execError(containerName string, err error) error {
+	switch msg := err.Error(); {
+	case strings.Contains(msg, "No such container"):
+		return fmt.Errorf("%w '%s'", ErrContainerNotFound, containerName)
+	case strings.Contains(msg, "is not running"), strings.Contains(msg, "is paused"), strings.Contains(msg, "is restarting"):
+		return fmt.Errorf("%w: '%s'", ErrContainerNotRunning, containerName)
+	default:
+		return err
+	}
+}
+
+func (s State) String() string {
+	if s.Health != "" {
+		return fmt.Sprintf("%s (health: %s, exit code: %d)", s.Status, s.Health, s.ExitCode)
+	}
+	return fmt.Sprintf("%s (exit code: %d)", s.Status, s.ExitCode)
+}
+
+// healthPollInitialDelay is the delay before WaitForHealthy checks a container again, which doubles after
+// each check up to healthPollMaxDelay
+var (
+	healthPollInitialDelay = 250 * time.Millisecond
+	healthPollMaxDelay     = 5 * time.Second
+)
+
+var inspectContainerState = InspectContainerState
+
+// WaitForHealthy blocks until the health check of a container passes, or until the container is running if
+// it has no health check. It fails as soon as the container exits or docker marks it unhealthy, and gives up
+// once the timeout or the context expires, reporting the last state that was observed.
+func WaitForHealthy(ctx context.Context, containerName string, timeout time.Duration) error {
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	delay := healthPollInitialDelay
+	for {
+		state, err := inspectContainerState(ctx, containerName)
+		if err != nil {
+			return err
+		}
+		switch {
+		case state.Status == "exited" || state.Status == "dead":
+			return fmt.Errorf("container '%s' exited with code %d before it became healthy - check its logs", containerName, state.ExitCode)
+		case state.Health == HealthUnhealthy:
+			return fmt.Errorf("container '%s' failed its health check - check its logs", containerName)
+		case state.Health == HealthHealthy, state.Status == "running" && state.Health == "":
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("gave up waiting for container '%s' to become healthy, last state %s: %w", containerName, state, ctx.Err())
+		case <-deadline.C:
+			return fmt.Errorf("container '%s' did not become healthy within %s, last state %s", containerName, timeout, state)
+		case <-time.After(delay):
+		}
+		if delay *= 2; delay > healthPollMaxDelay {
+			delay = healthPollMaxDelay
+		}
+	}
+}
+
+func parseContainerState(output string) (State, error) {
+	parts := strings.Split(strings.TrimSpace(output), "|")
+	if len(parts) != 3 {
+		return State{}, fmt.Errorf("unexpected container state '%s'", strings.TrimSpace(output))
+	}
+	exitCode, err := strconv.Atoi(parts[2])
+	if err != nil {
+		return State{}, fmt.Errorf("unexpected container exit code '%s'", parts[2])
+	}
+	return State{
+		Status:   parts[0],
+		Health:   parts[1],
+		ExitCode: exitCode,
+	}, nil
+}
+
+// DefaultRetryDelay is the delay before the first retry of a failed docker command, which doubles on each
+// subsequent attempt up to maxRetryDelay
+const DefaultRetryDelay = time.Second
+
+const maxRetryDelay = 30 * time.Second
+
+// nonRetryableErrors are fragments of docker error output that indicate a permanent failure,
+// such as a bad image name or an authentication problem, that retrying cannot fix
+var nonRetryableErrors = []string{
+	"invalid reference format",
+	"manifest unknown",
+	"not found",
+	"repository does not exist",
+	"unauthorized",
+	"authentication required",
+	"denied",
+}
+
+func RunDockerCommandRetry(ctx context.Context, workingDir string, retries int, baseDelay time.Duration, command ...string) error {
 	attempt := 0
 	for {
 		err := RunDockerCommand(ctx, workingDir, command...)
-		if err != nil && attempt < retries {
-			attempt++
-			continue
-		} else if err != nil {
+		if err == nil {
+			return nil
+		}
+		if attempt >= retries || !isRetryableError(err) {
 			return err
 		}
-		break
+		select {
+		case <-time.After(retryDelay(baseDelay, attempt)):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		attempt++
 	}
-	return nil
+}
+
+func isRetryableError(err error) bool {
+	msg := strings.ToLower(err.Error())
+	for _, nonRetryable := range nonRetryableErrors {
+		if strings.Contains(msg, nonRetryable) {
+			return false
+		}
+	}
+	return true
+}
+
+func retryDelay(baseDelay time.Duration, attempt int) time.Duration {
+	delay := baseDelay
+	for i := 0; i < attempt && delay < maxRetryDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxRetryDelay {
+		return maxRetryDelay
+	}
+	return delay
 }
 
 func RunDockerCommand(ctx context.Context, workingDir string, command ...string) error {
-	//nolint:gosec
-	dockerCmd := exec.Command("docker", command...)
-	dockerCmd.Dir = workingDir
-	output, err := runCommand(ctx, dockerCmd)
+	output, err := run(ctx, workingDir, "docker", command...)
 	if err != nil && output != "" {
 		return fmt.Errorf(output)
 	}
@@ -101,71 +703,383 @@ func RunDockerCommand(ctx context.Context, workingDir string, command ...string)
 func RunDockerCommandLine(ctx context.Context, workingDir string, command string) error {
 	parsedCommand := strings.Split(command, " ")
 	fmt.Println(parsedCommand)
-	dockerCmd := exec.Command("docker", parsedCommand...)
-	dockerCmd.Dir = workingDir
-	_, err := runCommand(ctx, dockerCmd)
+	_, err := run(ctx, workingDir, "docker", parsedCommand...)
 	return err
 }
 
+// RunDockerComposeCommand runs a command with the version of compose in the context, or the version that is
+// detected as installed if there is not one in the context
 func RunDockerComposeCommand(ctx context.Context, workingDir string, command ...string) error {
-	switch ctx.Value(CtxComposeVersionKey{}) {
+	_, err := RunDockerComposeCommandBuffered(ctx, workingDir, command...)
+	return err
+}
+
+// RunDockerComposeCommandBuffered runs a compose command with whichever version of compose is installed, and
+// returns its output so that it can be parsed, e.g. from "ps --format json"
+func RunDockerComposeCommandBuffered(ctx context.Context, workingDir string, command ...string) (string, error) {
+	name, args, err := composeCommand(ctx, command...)
+	if err != nil {
+		return "", err
+	}
+	return run(ctx, workingDir, name, args...)
+}
+
+// composeCommand returns the executable and arguments that run a compose command with the installed version,
+// against the compose project in the context if there is one
+func composeCommand(ctx context.Context, command ...string) (string, []string, error) {
+	version, err := DetectComposeVersion(ctx)
+	if err != nil {
+		return "", nil, err
+	}
+	if project, ok := ctx.Value(CtxComposeProjectKey{}).(string); ok && project != "" {
+		command = append([]string{"-p", project}, command...)
+	}
+	switch version {
 	case ComposeV1:
-		//nolint:gosec
-		dockerCmd := exec.Command("docker-compose", command...)
-		dockerCmd.Dir = workingDir
-		_, err := runCommand(ctx, dockerCmd)
-		return err
+		return "docker-compose", command, nil
 	case ComposeV2:
-		//nolint:gosec
-		dockerCmd := exec.Command("docker", append([]string{"compose"}, command...)...)
-		dockerCmd.Dir = workingDir
-		_, err := runCommand(ctx, dockerCmd)
-		return err
+		return "docker", append([]string{"compose"}, command...), nil
 	default:
-		return fmt.Errorf("no version for docker-compose has been detected")
+		return "", nil, fmt.Errorf("no version for docker-compose has been detected")
+	}
+}
+
+// commandFailureRegex matches the exit code of a failed command in its error, which is followed by its output
+var commandFailureRegex = regexp.MustCompile(`^.*? \[-?\d+\] `)
+
+// ValidateCompose checks the compose project in workingDir with 'compose config', so that a compose file that
+// is invalid YAML, or that does not match the compose schema, is reported up front, with the error from compose
+func ValidateCompose(ctx context.Context, workingDir string) error {
+	_, err := RunDockerComposeCommandBuffered(ctx, workingDir, "config", "-q")
+	if err == nil {
+		return nil
+	}
+	// Errors without output, such as compose not being installed, are returned as they are
+	output := commandFailureRegex.ReplaceAllString(err.Error(), "")
+	if output == err.Error() {
+		return err
+	}
+	// Warnings, such as that the version attribute is obsolete, are not why the validation failed
+	lines := []string{}
+	for _, line := range strings.Split(output, "\n") {
+		if line = strings.TrimSpace(line); line != "" && !strings.Contains(line, "level=warning") {
+			lines = append(lines, line)
+		}
+	}
+	return fmt.Errorf("invalid docker compose file in '%s': %s", workingDir, strings.Join(lines, "\n"))
+}
+
+// ComposeUp creates and starts the containers of the compose project in workingDir in the background. Offline,
+// the compose plugin is told never to pull an image, so that a missing image fails rather than reaching out to a
+// registry. The standalone docker-compose has no such option.
+func ComposeUp(ctx context.Context, workingDir string) error {
+	args := []string{"up", "-d"}
+	if IsOffline(ctx) {
+		version, err := DetectComposeVersion(ctx)
+		if err != nil {
+			return err
+		}
+		if version == ComposeV2 {
+			args = append(args, "--pull", "never")
+		}
+	}
+	return RunDockerComposeCommand(ctx, workingDir, args...)
+}
+
+// ComposeDown stops and removes the containers and networks of the compose project in workingDir, including
+// any orphaned containers of services that are no longer in the compose file. Services are given timeout to
+// shut down cleanly before they are killed, or the compose default if it is zero. If removeVolumes is set,
+// the named volumes of the project are removed as well.
+func ComposeDown(ctx context.Context, workingDir string, removeVolumes bool, timeout time.Duration) error {
+	return RunDockerComposeCommand(ctx, workingDir, composeDownArgs(removeVolumes, timeout)...)
+}
+
+// ComposeStop stops the containers of the compose project in workingDir without removing them. Services are
+// given timeout to shut down cleanly before they are killed, or the compose default if it is zero.
+func ComposeStop(ctx context.Context, workingDir string, timeout time.Duration) error {
+	args := []string{"stop"}
+	if timeout > 0 {
+		args = append(args, "-t", fmt.Sprint(composeTimeoutSeconds(timeout)))
 	}
+	return RunDockerComposeCommand(ctx, workingDir, args...)
+}
+
+func composeDownArgs(removeVolumes bool, timeout time.Duration) []string {
+	args := []string{"down", "--remove-orphans"}
+	if timeout > 0 {
+		args = append(args, "-t", fmt.Sprint(composeTimeoutSeconds(timeout)))
+	}
+	if removeVolumes {
+		args = append(args, "-v")
+	}
+	return args
+}
+
+// composeTimeoutSeconds converts a timeout to the whole number of seconds compose expects, rounding up so
+// that services are never given less time than requested
+func composeTimeoutSeconds(timeout time.Duration) int64 {
+	return int64((timeout + time.Second - 1) / time.Second)
 }
 
 func RunDockerCommandBuffered(ctx context.Context, workingDir string, command ...string) (string, error) {
-	//nolint:gosec
-	dockerCmd := exec.Command("docker", command...)
-	dockerCmd.Dir = workingDir
-	return runCommand(ctx, dockerCmd)
+	return run(ctx, workingDir, "docker", command...)
+}
+
+// RunDockerCommandWithInput runs a docker command with the content of stdin as its standard input, such as an
+// image archive for 'docker load', and returns its output like RunDockerCommandBuffered
+func RunDockerCommandWithInput(ctx context.Context, workingDir string, stdin io.Reader, command ...string) (string, error) {
+	return runWithReader(ctx, workingDir, stdin, "docker", command...)
+}
+
+// FollowLogsOptions controls which of the existing logs of a container are shown before following new output
+type FollowLogsOptions struct {
+	// Since only shows logs written within this duration before now, or all of them if zero
+	Since time.Duration
+	// Tail is the number of lines to show from the end of the existing logs, or all of them if zero or negative
+	Tail int
+}
+
+// FollowLogs streams the logs of a container to w until the container stops or ctx is cancelled. Cancelling
+// ctx is not treated as an error. If nil options are passed, all of the existing logs are shown first.
+func FollowLogs(ctx context.Context, containerName string, w io.Writer, options *FollowLogsOptions) error {
+	args := []string{"logs", "--follow"}
+	if options != nil {
+		if options.Since > 0 {
+			args = append(args, "--since", options.Since.String())
+		}
+		if options.Tail > 0 {
+			args = append(args, "--tail", fmt.Sprint(options.Tail))
+		}
+	}
+	args = append(args, containerName)
+	return commandRunner(ctx).Follow(ctx, w, "docker", args...)
+}
+
+// WaitForLogLine follows the logs of a container until a line matches the pattern, for services that only report
+// that they are ready in their logs rather than with a health check. The logs are followed from the start, so a
+// line logged before it is called is found too. The docker logs process is stopped before it returns.
+func WaitForLogLine(ctx context.Context, containerName, pattern string, timeout time.Duration) error {
+	regex, err := regexp.Compile(pattern)
+	if err != nil {
+		return fmt.Errorf("invalid log line pattern '%s': %s", pattern, err)
+	}
+	followCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	matcher := &logLineMatcher{regex: regex, matched: cancel}
+	err = FollowLogs(followCtx, containerName, matcher, nil)
+	if followCtx.Err() == nil {
+		// The logs ended, so a last line without a newline is complete
+		matcher.flush()
+	}
+	switch {
+	case matcher.found:
+		return nil
+	case err != nil:
+		return err
+	case ctx.Err() != nil:
+		return ctx.Err()
+	case followCtx.Err() != nil:
+		return fmt.Errorf("timed out after %s waiting for container '%s' to log a line matching '%s'", timeout, containerName, pattern)
+	default:
+		return fmt.Errorf("container '%s' stopped before logging a line matching '%s'", containerName, pattern)
+	}
+}
+
+// logLineMatcher is the writer WaitForLogLine follows the logs with. Output is split into lines, as it is not
+// always written a line at a time, and matched is called on the first line that matches. A line is only matched
+// once it is complete, so that a pattern cannot match the start of a line that is still being written.
+type logLineMatcher struct {
+	regex   *regexp.Regexp
+	matched func()
+	found   bool
+	partial string
+}
+
+func (m *logLineMatcher) Write(b []byte) (int, error) {
+	if m.found {
+		return len(b), nil
+	}
+	lines := strings.Split(m.partial+string(b), "\n")
+	m.partial = lines[len(lines)-1]
+	for _, line := range lines[:len(lines)-1] {
+		if m.match(line) {
+			break
+		}
+	}
+	return len(b), nil
+}
+
+// flush matches the last line of the logs, if it did not end with a newline
+func (m *logLineMatcher) flush() {
+	if !m.found && m.partial != "" {
+		m.match(m.partial)
+	}
+	m.partial = ""
+}
+
+func (m *logLineMatcher) match(line string) bool {
+	if m.regex.MatchString(strings.TrimRight(line, "\r")) {
+		m.found = true
+		m.matched()
+	}
+	return m.found
+}
+
+// followCommand copies the output of a long running command to w. The command is killed when ctx is
+// cancelled or w returns an error, and the pipes are always drained so that the goroutines reading them exit.
+func followCommand(ctx context.Context, cmd *exec.Cmd, w io.Writer) error {
+	if log.DockerVerbosityFromContext(ctx) {
+		fmt.Println(cmd.String())
+	}
+	stdoutChan := make(chan string)
+	stderrChan := make(chan string)
+	// Buffered so that neither pipeCommand nor the pipe readers block sending an error we are not waiting for
+	errChan := make(chan error, 3)
+	pipeCommand(cmd, stdoutChan, stderrChan, errChan)
+
+	kill := func() {
+		if cmd.Process != nil {
+			_ = cmd.Process.Kill()
+		}
+	}
+	done := ctx.Done()
+	cancelled := false
+	var pipeErr, writeErr error
+	for stdoutChan != nil || stderrChan != nil {
+		var line string
+		var ok bool
+		select {
+		case <-done:
+			cancelled = true
+			done = nil
+			kill()
+			continue
+		case err := <-errChan:
+			if pipeErr == nil {
+				pipeErr = err
+			}
+			continue
+		case line, ok = <-stdoutChan:
+			if !ok {
+				stdoutChan = nil
+				continue
+			}
+		case line, ok = <-stderrChan:
+			if !ok {
+				stderrChan = nil
+				continue
+			}
+		}
+		if writeErr == nil && !cancelled {
+			if _, err := io.WriteString(w, line); err != nil {
+				writeErr = err
+				kill()
+			}
+		}
+	}
+
+	var waitErr error
+	if cmd.Process != nil {
+		waitErr = cmd.Wait()
+	}
+	switch {
+	case cancelled:
+		return nil
+	case writeErr != nil:
+		return writeErr
+	case pipeErr != nil:
+		return pipeErr
+	case waitErr != nil:
+		return fmt.Errorf("%s: %s", strings.Join(cmd.Args, " "), waitErr)
+	}
+	return nil
+}
+
+// maxCommandOutput is the most output of a command that is kept, so that a command that writes a lot of output
+// cannot use up memory. The end of the output is kept, since that is where a failed command reports its error.
+var maxCommandOutput = 1 << 20
+
+// commandOutput accumulates the output of a command, keeping only the last maxCommandOutput bytes. runCommand
+// only writes to it from a single goroutine, but it is safe to use from several.
+type commandOutput struct {
+	mux       sync.Mutex
+	buf       []byte
+	truncated int
+}
+
+func (o *commandOutput) WriteString(s string) {
+	o.mux.Lock()
+	defer o.mux.Unlock()
+	o.buf = append(o.buf, s...)
+	// Only trim once the buffer has doubled, so that the output is not copied on every write
+	if len(o.buf) > 2*maxCommandOutput {
+		drop := len(o.buf) - maxCommandOutput
+		o.truncated += drop
+		o.buf = append(o.buf[:0], o.buf[drop:]...)
+	}
 }
 
-func RunDockerComposeCommandReturnsStdout(workingDir string, command ...string) ([]byte, error) {
-	//nolint:gosec
-	dockerCmd := exec.Command("docker", append([]string{"compose"}, command...)...)
-	dockerCmd.Dir = workingDir
-	return dockerCmd.Output()
+func (o *commandOutput) String() string {
+	o.mux.Lock()
+	defer o.mux.Unlock()
+	buf := o.buf
+	truncated := o.truncated
+	if len(buf) > maxCommandOutput {
+		truncated += len(buf) - maxCommandOutput
+		buf = buf[len(buf)-maxCommandOutput:]
+	}
+	if truncated > 0 {
+		return fmt.Sprintf("[%d bytes of output truncated]\n%s", truncated, buf)
+	}
+	return string(buf)
 }
 
-func runCommand(ctx context.Context, cmd *exec.Cmd) (string, error) {
-	verbose := log.VerbosityFromContext(ctx)
+func runCommand(ctx context.Context, cmd *exec.Cmd) (_ string, err error) {
+	verbose := log.DockerVerbosityFromContext(ctx)
 	isLogCmd, _ := ctx.Value(CtxIsLogCmdKey{}).(bool)
 	if verbose {
 		fmt.Println(cmd.String())
 	}
-	outputBuff := strings.Builder{}
+	// Only the select loop below writes to the output, which is read once the pipes are drained
+	outputBuff := &commandOutput{}
+	if log.EventsEnabled(ctx) {
+		startTime := time.Now()
+		defer func() {
+			emitCommandEvent(ctx, cmd, startTime, outputBuff.String(), err)
+		}()
+	}
 	stdoutChan := make(chan string)
 	stderrChan := make(chan string)
-	errChan := make(chan error)
-	go pipeCommand(cmd, stdoutChan, stderrChan, errChan)
+	// Buffered so that neither pipeCommand nor the pipe readers block sending an error we are not waiting for.
+	// The command is started here rather than in another goroutine, so that cmd.Process can be read below.
+	errChan := make(chan error, 3)
+	pipeCommand(cmd, stdoutChan, stderrChan, errChan)
 
-outputCapture:
-	for {
+	// Cancelling the context kills the process, after which its output is drained as normal
+	done := ctx.Done()
+	cancelled := false
+	// Both pipes must be drained before calling Wait, which closes them, or the tail of the output is lost
+	for stdoutChan != nil || stderrChan != nil {
 		select {
+		case <-done:
+			cancelled = true
+			done = nil
+			if cmd.Process != nil {
+				_ = cmd.Process.Kill()
+			}
 		case s, ok := <-stdoutChan:
+			if !ok {
+				stdoutChan = nil
+				continue
+			}
 			if isLogCmd || verbose {
-				if !ok {
-					break outputCapture
-				}
 				fmt.Print(s)
 			}
 			outputBuff.WriteString(s)
 		case s, ok := <-stderrChan:
 			if !ok {
-				break outputCapture
+				stderrChan = nil
+				continue
 			}
 			if verbose {
 				fmt.Print(s)
@@ -176,8 +1090,14 @@ outputCapture:
 		}
 	}
 	if err := cmd.Wait(); err != nil {
+		if cancelled {
+			return outputBuff.String(), ctx.Err()
+		}
 		return outputBuff.String(), err
 	}
+	if cancelled {
+		return outputBuff.String(), ctx.Err()
+	}
 	statusCode := cmd.ProcessState.ExitCode()
 	if statusCode != 0 {
 		return "", fmt.Errorf("%s [%d] %s", strings.Join(cmd.Args, " "), statusCode, outputBuff.String())
@@ -185,6 +1105,27 @@ outputCapture:
 	return outputBuff.String(), nil
 }
 
+// emitCommandEvent reports a command that has finished running to the event sink of the context. The
+// events are informational, so failing to write one does not fail the command.
+func emitCommandEvent(ctx context.Context, cmd *exec.Cmd, startTime time.Time, output string, err error) {
+	event := &log.Event{
+		Command:   cmd.Args,
+		StartTime: startTime,
+		EndTime:   time.Now(),
+		ExitCode:  -1,
+		Output:    output,
+	}
+	if cmd.ProcessState != nil {
+		event.ExitCode = cmd.ProcessState.ExitCode()
+	}
+	if err != nil {
+		event.Error = err.Error()
+	}
+	if err := log.EmitEvent(ctx, event); err != nil {
+		log.LoggerFromContext(ctx).Warn(fmt.Sprintf("failed to write event for command '%s': %s", strings.Join(cmd.Args, " "), err))
+	}
+}
+
 func pipeCommand(cmd *exec.Cmd, stdoutChan chan string, stderrChan chan string, errChan chan error) {
 	stdout, err := cmd.StdoutPipe()
 	if err != nil {
@@ -208,6 +1149,10 @@ func readPipe(pipe io.ReadCloser, outputChan chan string, errChan chan error) {
 	for {
 		line, err := buf.ReadString('\n')
 		if err != nil {
+			// Output that does not end in a newline is still returned alongside the error
+			if line != "" {
+				outputChan <- line
+			}
 			if err == io.EOF {
 				close(outputChan)
 				return
@@ -222,8 +1167,323 @@ func readPipe(pipe io.ReadCloser, outputChan chan string, errChan chan error) {
 	}
 }
 
-func GetImageConfig(image string) (map[string]interface{}, error) {
-	b, err := crane.Config(image)
+// PullImage pulls an image so that download progress is visible up front, rather than happening implicitly
+// inside docker compose. If the image is already present locally, it is not pulled again.
+func PullImage(ctx context.Context, image string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if ImageExistsLocally(ctx, image) {
+		return nil
+	}
+	if IsOffline(ctx) {
+		return fmt.Errorf("%w: %s", ErrImageNotAvailableLocally, image)
+	}
+	log.LoggerFromContext(ctx).Info(fmt.Sprintf("pulling '%s'", image))
+	if err := RunDockerCommand(ctx, ".", "pull", image); err != nil {
+		return fmt.Errorf("failed to pull image '%s': %s", image, err)
+	}
+	return nil
+}
+
+// DefaultPullConcurrency is how many images PullImages pulls at once, unless it is given another limit
+const DefaultPullConcurrency = 3
+
+// PullImages pulls images with PullImage, at most concurrency at a time, so that a stack with many services does
+// not overwhelm the docker daemon or the rate limits of the registry. Every image is attempted even if another
+// fails, and all of the failures are reported together. No more pulls are started once the context is cancelled.
+func PullImages(ctx context.Context, images []string, concurrency int) error {
+	if concurrency < 1 {
+		concurrency = DefaultPullConcurrency
+	}
+	errs := make([]error, len(images))
+	slots := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i, image := range images {
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		wg.Add(1)
+		go func(i int, image string) {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+			errs[i] = PullImage(ctx, image)
+		}(i, image)
+	}
+	wg.Wait()
+
+	// A pull that never started because of a cancellation is reported once, as the cancellation
+	failed := []error{}
+	for _, err := range errs {
+		if err != nil && err != ctx.Err() {
+			failed = append(failed, err)
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		failed = append(failed, err)
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to pull the images of the stack:\n%w", errors.Join(failed...))
+	}
+	return nil
+}
+
+// PullImagesRetry pulls images with PullImages, and retries up to retries times if any of them fail, with the
+// same backoff as RunDockerCommandRetry. The images that were pulled are already local, so a retry only pulls
+// the ones that failed. Nothing is retried if one of the failures is permanent, such as an unknown image.
+func PullImagesRetry(ctx context.Context, images []string, concurrency, retries int, baseDelay time.Duration) error {
+	attempt := 0
+	for {
+		err := PullImages(ctx, images, concurrency)
+		if err == nil {
+			return nil
+		}
+		if attempt >= retries || !isRetryableError(err) || ctx.Err() != nil {
+			return err
+		}
+		log.LoggerFromContext(ctx).Warn(fmt.Sprintf("retrying the images that failed to pull: %s", err))
+		select {
+		case <-time.After(retryDelay(baseDelay, attempt)):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		attempt++
+	}
+}
+
+// TagImage gives the local image src the additional name dst
+func TagImage(ctx context.Context, src, dst string) error {
+	return RunDockerCommand(ctx, ".", "tag", src, dst)
+}
+
+func ImageExistsLocally(ctx context.Context, image string) bool {
+	_, err := RunDockerCommandBuffered(ctx, ".", "image", "inspect", "--format", "{{.Id}}", image)
+	return err == nil
+}
+
+// ErrImageNotFound is returned by the functions that query a registry when the registry has no such image
+var ErrImageNotFound = errors.New("image not found")
+
+// ErrImageNotAvailableLocally is returned in offline mode for an image that has not been loaded into the local
+// docker engine, as it cannot be pulled
+var ErrImageNotAvailableLocally = errors.New("image not available locally")
+
+// ErrRegistryUnreachable is returned by the functions that query a registry when it still could not be
+// reached, or kept failing, after retrying
+var ErrRegistryUnreachable = errors.New("registry unreachable")
+
+// registryTimeout bounds each attempt to query a registry, so that a registry that accepts connections but
+// never responds does not hang the CLI
+var registryTimeout = 30 * time.Second
+
+// registryRetries is how many times a failed registry query is retried, after waiting registryRetryDelay,
+// doubling on each attempt
+var (
+	registryRetries    = 3
+	registryRetryDelay = DefaultRetryDelay
+)
+
+var (
+	craneConfig = crane.Config
+	craneDigest = crane.Digest
+)
+
+// queryRegistry runs a crane query with a timeout, retrying the failures that might be temporary. The
+// options are passed to crane, e.g. those of WithRegistryAuth or InsecureRegistry for a private registry.
+func queryRegistry[T any](ctx context.Context, image string, query func(string, ...crane.Option) (T, error), options ...crane.Option) (T, error) {
+	attempt := 0
+	for {
+		attemptCtx, cancel := context.WithTimeout(ctx, registryTimeout)
+		result, err := query(image, append([]crane.Option{crane.WithContext(attemptCtx)}, options...)...)
+		cancel()
+		if err == nil {
+			return result, nil
+		}
+		var zero T
+		if ctx.Err() != nil {
+			return zero, ctx.Err()
+		}
+		switch {
+		case isImageNotFound(err):
+			return zero, fmt.Errorf("%w: %s: %w", ErrImageNotFound, image, err)
+		case !isRegistryUnavailable(err):
+			return zero, err
+		case attempt >= registryRetries:
+			return zero, fmt.Errorf("%w: %s: %w", ErrRegistryUnreachable, image, err)
+		}
+		select {
+		case <-time.After(retryDelay(registryRetryDelay, attempt)):
+		case <-ctx.Done():
+			return zero, ctx.Err()
+		}
+		attempt++
+	}
+}
+
+func isImageNotFound(err error) bool {
+	var transportErr *transport.Error
+	if !errors.As(err, &transportErr) {
+		return false
+	}
+	for _, diagnostic := range transportErr.Errors {
+		if diagnostic.Code == transport.ManifestUnknownErrorCode || diagnostic.Code == transport.NameUnknownErrorCode {
+			return true
+		}
+	}
+	return transportErr.StatusCode == http.StatusNotFound
+}
+
+// isRegistryUnavailable returns whether a registry query failed in a way that might succeed on retry, i.e. the
+// registry could not be reached, timed out, or reported a server error or rate limit
+func isRegistryUnavailable(err error) bool {
+	var transportErr *transport.Error
+	if errors.As(err, &transportErr) {
+		return transportErr.StatusCode >= http.StatusInternalServerError || transportErr.StatusCode == http.StatusTooManyRequests
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, context.DeadlineExceeded) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// RegistryOption configures how an image is queried in its registry
+type RegistryOption func(*registryOptions)
+
+// registryOptions are the options of a registry query that change what the registry returns, so they are kept
+// here rather than only as crane options, which cannot be inspected, to be part of the cache key of the query
+type registryOptions struct {
+	platform *v1.Platform
+	insecure bool
+	auth     authn.Authenticator
+}
+
+// WithPlatform queries the image for the given platform, rather than the default platform of the registry
+func WithPlatform(platform *v1.Platform) RegistryOption {
+	return func(o *registryOptions) {
+		o.platform = platform
+	}
+}
+
+// WithRegistryAuth authenticates to the registry with auth, rather than with the credentials of the docker config
+func WithRegistryAuth(auth authn.Authenticator) RegistryOption {
+	return func(o *registryOptions) {
+		o.auth = auth
+	}
+}
+
+// InsecureRegistry queries a registry that is served over plain http, or over https with an untrusted certificate
+func InsecureRegistry() RegistryOption {
+	return func(o *registryOptions) {
+		o.insecure = true
+	}
+}
+
+func newRegistryOptions(options []RegistryOption) *registryOptions {
+	o := &registryOptions{}
+	for _, option := range options {
+		option(o)
+	}
+	return o
+}
+
+// craneOptions returns the crane options of the query
+func (o *registryOptions) craneOptions() []crane.Option {
+	options := []crane.Option{}
+	if o.platform != nil {
+		options = append(options, crane.WithPlatform(o.platform))
+	}
+	if o.insecure {
+		options = append(options, crane.Insecure)
+	}
+	if o.auth != nil {
+		options = append(options, crane.WithAuth(o.auth))
+	}
+	return options
+}
+
+// cacheKey returns the key of the image queried with these options. Credentials are identified by a hash of the
+// authorization they resolve to, so that the key holds no secret.
+func (o *registryOptions) cacheKey(image string) (string, error) {
+	key := image
+	if ref, err := name.ParseReference(image); err == nil {
+		key = ref.Name()
+	}
+	if o.platform != nil {
+		key += "|platform=" + o.platform.String()
+	}
+	if o.insecure {
+		key += "|insecure"
+	}
+	if o.auth != nil {
+		authorization, err := o.auth.Authorization()
+		if err != nil {
+			return "", err
+		}
+		b, err := json.Marshal(authorization)
+		if err != nil {
+			return "", err
+		}
+		key += fmt.Sprintf("|auth=%x", sha256.Sum256(b))
+	}
+	return key, nil
+}
+
+// imageConfigCache holds the configs fetched from registries during one invocation of the CLI, keyed by the
+// fully qualified reference of each image and the registry options it was fetched with, so that validating
+// the same image more than once only queries the registry once. Concurrent lookups of the same image share a
+// single query.
+type imageConfigCache struct {
+	mutex   sync.Mutex
+	configs map[string][]byte
+	queries singleflight.Group
+}
+
+func newImageConfigCache() *imageConfigCache {
+	return &imageConfigCache{configs: make(map[string][]byte)}
+}
+
+var imageConfigs = newImageConfigCache()
+
+// get returns the config of the image, querying the registry for it only if it has not been fetched before.
+// Failed queries are not cached.
+func (c *imageConfigCache) get(ctx context.Context, image string, options *registryOptions) ([]byte, error) {
+	key, err := options.cacheKey(image)
+	if err != nil {
+		return nil, err
+	}
+	c.mutex.Lock()
+	b, ok := c.configs[key]
+	c.mutex.Unlock()
+	if ok {
+		return b, nil
+	}
+	result, err, _ := c.queries.Do(key, func() (interface{}, error) {
+		b, err := queryRegistry(ctx, image, craneConfig, options.craneOptions()...)
+		if err != nil {
+			return nil, err
+		}
+		c.mutex.Lock()
+		c.configs[key] = b
+		c.mutex.Unlock()
+		return b, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result.([]byte), nil
+}
+
+// GetImageConfig returns the config of an image in the registry, or of the local image in offline mode. The
+// config of each image is only fetched from the registry once.
+func GetImageConfig(ctx context.Context, image string, options ...RegistryOption) (map[string]interface{}, error) {
+	if IsOffline(ctx) {
+		return localImageConfig(ctx, image)
+	}
+	b, err := imageConfigs.get(ctx, image, newRegistryOptions(options))
 	if err != nil {
 		return nil, err
 	}
@@ -235,26 +1495,70 @@ func GetImageConfig(image string) (map[string]interface{}, error) {
 	return jsonMap, nil
 }
 
-func GetImageLabel(image, label string) (string, error) {
-	config, err := GetImageConfig(image)
+func GetImageLabel(ctx context.Context, image, label string, options ...RegistryOption) (string, error) {
+	config, err := GetImageConfig(ctx, image, options...)
 	if err != nil {
 		return "", err
 	}
-	c, ok := config["config"]
-	if !ok {
-		return "", nil
+	// Images without labels, which are common locally, have null rather than empty labels
+	c, _ := config["config"].(map[string]interface{})
+	labels, _ := c["Labels"].(map[string]interface{})
+	val, _ := labels[label].(string)
+	return val, nil
+}
+
+// GetImageDigest returns the digest of an image in the registry. In offline mode it is the digest that the
+// local image was pulled with, which is empty if the image was built or loaded locally instead.
+func GetImageDigest(ctx context.Context, image string, options ...RegistryOption) (string, error) {
+	if IsOffline(ctx) {
+		return localImageDigest(ctx, image)
 	}
-	labels, ok := c.(map[string]interface{})["Labels"]
-	if !ok {
-		return "", nil
+	return queryRegistry(ctx, image, craneDigest, newRegistryOptions(options).craneOptions()...)
+}
+
+// inspectLocalImage returns the given field of a local image as JSON
+func inspectLocalImage(ctx context.Context, image, field string) (string, error) {
+	output, err := RunDockerCommandBuffered(ctx, ".", "image", "inspect", "--format", fmt.Sprintf("{{json .%s}}", field), image)
+	if err != nil {
+		if strings.Contains(strings.ToLower(err.Error()), "no such image") {
+			return "", fmt.Errorf("%w: %s", ErrImageNotAvailableLocally, image)
+		}
+		return "", err
 	}
-	val, ok := labels.(map[string]interface{})[label]
-	if !ok {
-		return "", nil
+	return output, nil
+}
+
+// localImageConfig returns the config of a local image, in the same form as the config in a registry
+func localImageConfig(ctx context.Context, image string) (map[string]interface{}, error) {
+	output, err := inspectLocalImage(ctx, image, "Config")
+	if err != nil {
+		return nil, err
 	}
-	return val.(string), nil
+	var config map[string]interface{}
+	if err := json.Unmarshal([]byte(output), &config); err != nil {
+		return nil, fmt.Errorf("failed to parse the config of image '%s': %s", image, err)
+	}
+	return map[string]interface{}{"config": config}, nil
 }
 
-func GetImageDigest(image string) (string, error) {
-	return crane.Digest(image)
+func localImageDigest(ctx context.Context, image string) (string, error) {
+	ref, err := name.ParseReference(image)
+	if err != nil {
+		return "", err
+	}
+	output, err := inspectLocalImage(ctx, image, "RepoDigests")
+	if err != nil {
+		return "", err
+	}
+	var repoDigests []string
+	if err := json.Unmarshal([]byte(output), &repoDigests); err != nil {
+		return "", fmt.Errorf("failed to parse the digests of image '%s': %s", image, err)
+	}
+	for _, repoDigest := range repoDigests {
+		digest, err := name.NewDigest(repoDigest)
+		if err == nil && digest.Context().Name() == ref.Context().Name() {
+			return digest.DigestStr(), nil
+		}
+	}
+	return "", nil
 }
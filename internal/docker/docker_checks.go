@@ -17,37 +17,114 @@
 package docker
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"net"
 	"os/exec"
+	"strings"
+	"sync"
 )
 
-func CheckDockerConfig() (DockerComposeVersion, error) {
+// errComposeNotInstalled is returned when neither the compose plugin nor the standalone docker-compose is found
+var errComposeNotInstalled = fmt.Errorf("docker compose is not installed. Install the Docker Compose plugin, or the standalone docker-compose, by following https://docs.docker.com/compose/install/")
 
-	dockerCmd := exec.Command("docker", "-v")
-	_, err := dockerCmd.Output()
-	if err != nil {
-		return None, fmt.Errorf("an error occurred while running docker. Is docker installed on your computer?")
+var (
+	// errDockerNotInstalled is returned when the docker binary cannot be found
+	errDockerNotInstalled = fmt.Errorf("docker is not installed, or is not on your PATH. Install Docker by following https://docs.docker.com/get-docker/")
+	// errDockerNotRunning is returned when docker is installed, but the docker daemon cannot be reached
+	errDockerNotRunning = fmt.Errorf("the Docker daemon is not running. Start Docker, or Docker Desktop, and try again")
+	// errDockerPermissionDenied is returned when the current user is not allowed to use the docker daemon
+	errDockerPermissionDenied = fmt.Errorf("permission denied while connecting to the Docker daemon. Add your user to the 'docker' group with 'sudo usermod -aG docker $USER', then log out and back in, and try again")
+)
+
+// composeVersionProbe runs a command to check whether a version of compose is installed
+var composeVersionProbe = func(name string, args ...string) error {
+	ctx := context.Background()
+	_, err := commandRunner(ctx).Run(ctx, "", name, args...)
+	return err
+}
+
+var composeVersionCache struct {
+	sync.Mutex
+	version DockerComposeVersion
+}
+
+func (v DockerComposeVersion) String() string {
+	switch v {
+	case ComposeV1:
+		return "docker-compose (v1)"
+	case ComposeV2:
+		return "docker compose (v2)"
+	default:
+		return "none"
 	}
+}
 
-	dockerDeamonCheck := exec.Command("docker", "ps")
-	_, err = dockerDeamonCheck.Output()
-	if err != nil {
-		return None, fmt.Errorf("an error occurred while running docker. Is docker running on your computer?")
+func CheckDockerConfig() (DockerComposeVersion, error) {
+	ctx := context.Background()
+	if err := CheckDockerAvailable(ctx); err != nil {
+		return None, err
 	}
+	return DetectComposeVersion(ctx)
+}
 
-	// check for docker-compose (V2) version
-	dockerComposeCmd := exec.Command("docker", "compose", "version")
-	_, err = dockerComposeCmd.Output()
+// CheckDockerAvailable runs 'docker info' to check that docker is installed and that its daemon can be
+// used, returning an error that says which of those is the problem and how to fix it
+func CheckDockerAvailable(ctx context.Context) error {
+	output, err := commandRunner(ctx).Run(ctx, "", "docker", "info")
 	if err == nil {
-		return ComposeV2, nil
+		return nil
 	}
+	if errors.Is(err, exec.ErrNotFound) {
+		return errDockerNotInstalled
+	}
+	// The output of a failed command is part of its error, and docker reports connection problems on stderr
+	message := strings.ToLower(output + err.Error())
+	switch {
+	case strings.Contains(message, "permission denied"):
+		return errDockerPermissionDenied
+	case strings.Contains(message, "cannot connect to the docker daemon"),
+		strings.Contains(message, "is the docker daemon running"),
+		strings.Contains(message, "error during connect"):
+		return errDockerNotRunning
+	default:
+		return fmt.Errorf("an error occurred while running docker: %s", err)
+	}
+}
 
-	// check for docker-compose (v1) version
-	dockerComposeCmd = exec.Command("docker-compose", "-v")
-	_, err = dockerComposeCmd.Output()
-	if err == nil {
-		return ComposeV1, nil
+// DetectComposeVersion returns the version of compose that is installed, preferring the compose plugin (v2)
+// over the standalone docker-compose (v1). A version already stored in the context under
+// CtxComposeVersionKey is used as is, and otherwise the result of detection is cached for the process.
+func DetectComposeVersion(ctx context.Context) (DockerComposeVersion, error) {
+	if version, ok := ctx.Value(CtxComposeVersionKey{}).(DockerComposeVersion); ok && version != None {
+		return version, nil
+	}
+
+	composeVersionCache.Lock()
+	defer composeVersionCache.Unlock()
+	if composeVersionCache.version != None {
+		return composeVersionCache.version, nil
 	}
 
-	return None, fmt.Errorf("an error occurred while running docker-compose. Is docker-compose installed on your computer?")
+	switch {
+	case composeVersionProbe("docker", "compose", "version") == nil:
+		composeVersionCache.version = ComposeV2
+	case composeVersionProbe("docker-compose", "version") == nil:
+		composeVersionCache.version = ComposeV1
+	default:
+		return None, errComposeNotInstalled
+	}
+	return composeVersionCache.version, nil
+}
+
+// IsPortAvailable returns true if nothing on this host is listening on the given TCP port, so that docker
+// will be able to publish a container port on it
+func IsPortAvailable(port int) bool {
+	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	if err != nil {
+		return false
+	}
+	listener.Close()
+	return true
 }
@@ -19,18 +19,41 @@ package docker
 import (
 	"fmt"
 	"path/filepath"
+	"sort"
 
 	"github.com/hyperledger/firefly-cli/internal/constants"
 	"github.com/hyperledger/firefly-cli/pkg/types"
+	"gopkg.in/yaml.v3"
 )
 
 type DependsOn map[string]map[string]string
 
 type HealthCheck struct {
-	Test     []string `yaml:"test,omitempty"`
-	Interval string   `yaml:"interval,omitempty"`
-	Timeout  string   `yaml:"timeout,omitempty"`
-	Retries  int      `yaml:"retries,omitempty"`
+	Test        []string `yaml:"test,omitempty"`
+	Interval    string   `yaml:"interval,omitempty"`
+	Timeout     string   `yaml:"timeout,omitempty"`
+	StartPeriod string   `yaml:"start_period,omitempty"`
+	Retries     int      `yaml:"retries,omitempty"`
+}
+
+// WithConfig overrides the parameters of the health check with any that are set in the stack config
+func (h *HealthCheck) WithConfig(config *types.HealthCheckConfig) *HealthCheck {
+	if config == nil {
+		return h
+	}
+	if config.Interval != "" {
+		h.Interval = config.Interval
+	}
+	if config.Timeout != "" {
+		h.Timeout = config.Timeout
+	}
+	if config.StartPeriod != "" {
+		h.StartPeriod = config.StartPeriod
+	}
+	if config.Retries > 0 {
+		h.Retries = config.Retries
+	}
+	return h
 }
 
 type LoggingConfig struct {
@@ -42,6 +65,45 @@ type ServiceDefinition struct {
 	ServiceName string
 	Service     *Service
 	VolumeNames []string
+	// ExternalVolumeNames are the volumes the service mounts that are managed outside of the stack. Compose
+	// only references them, and they are kept when the stack is removed.
+	ExternalVolumeNames []string
+	// Secrets are the docker secrets the service uses, keyed by name
+	Secrets map[string]*Secret
+	// ResourceLimits is the default limit of the service, which the stack config can override
+	ResourceLimits *ResourceLimits
+}
+
+// ResourceLimits caps the CPU and memory a service can use
+type ResourceLimits struct {
+	CPUs   string `yaml:"cpus,omitempty"`
+	Memory string `yaml:"memory,omitempty"`
+}
+
+// WithConfig returns a copy of the limits, overridden by any that are set in the stack config
+func (r *ResourceLimits) WithConfig(config *types.ResourceLimitsConfig) *ResourceLimits {
+	limits := &ResourceLimits{}
+	if r != nil {
+		*limits = *r
+	}
+	if config == nil {
+		return limits
+	}
+	if config.CPUs != "" {
+		limits.CPUs = config.CPUs
+	}
+	if config.Memory != "" {
+		limits.Memory = config.Memory
+	}
+	return limits
+}
+
+type Deploy struct {
+	Resources *DeployResources `yaml:"resources,omitempty"`
+}
+
+type DeployResources struct {
+	Limits *ResourceLimits `yaml:"limits,omitempty"`
 }
 
 type Service struct {
@@ -60,12 +122,188 @@ type Service struct {
 	EntryPoint    []string                     `yaml:"entrypoint,omitempty"`
 	EnvFile       string                       `yaml:"env_file,omitempty"`
 	Expose        []int                        `yaml:"expose,omitempty"`
+	Networks      []string                     `yaml:"networks,omitempty"`
+	CPUs          string                       `yaml:"cpus,omitempty"`
+	MemLimit      string                       `yaml:"mem_limit,omitempty"`
+	Deploy        *Deploy                      `yaml:"deploy,omitempty"`
+	Restart       string                       `yaml:"restart,omitempty"`
+	Secrets       []string                     `yaml:"secrets,omitempty"`
+}
+
+// SetResourceLimits emits the limits in the syntax the installed compose understands. The standalone
+// docker-compose only applies the cpus and mem_limit fields of the 2.x file format, from 2.2 for cpus, whereas
+// the compose plugin reads deploy.resources.limits.
+func (s *Service) SetResourceLimits(limits *ResourceLimits, version DockerComposeVersion) {
+	s.CPUs, s.MemLimit, s.Deploy = "", "", nil
+	if limits == nil || (limits.CPUs == "" && limits.Memory == "") {
+		return
+	}
+	if version == ComposeV1 {
+		s.CPUs = limits.CPUs
+		s.MemLimit = limits.Memory
+		return
+	}
+	s.Deploy = &Deploy{
+		Resources: &DeployResources{
+			Limits: &ResourceLimits{CPUs: limits.CPUs, Memory: limits.Memory},
+		},
+	}
+}
+
+type Network struct {
+	External bool `yaml:"external,omitempty"`
+}
+
+type Volume struct {
+	External bool `yaml:"external,omitempty"`
+}
+
+// Secret is a docker secret, which compose mounts into the services that use it at /run/secrets/<name>. It is read
+// from either a file or an environment variable of the compose process when the stack starts.
+type Secret struct {
+	File        string `yaml:"file,omitempty"`
+	Environment string `yaml:"environment,omitempty"`
 }
 
 type DockerComposeConfig struct {
 	Version  string              `yaml:"version,omitempty"`
 	Services map[string]*Service `yaml:"services,omitempty"`
-	Volumes  map[string]struct{} `yaml:"volumes,omitempty"`
+	Volumes  map[string]*Volume  `yaml:"volumes,omitempty"`
+	Networks map[string]*Network `yaml:"networks,omitempty"`
+	Secrets  map[string]*Secret  `yaml:"secrets,omitempty"`
+}
+
+// anchoredServiceFields are the fields of a service that MarshalAnchored defines once when several services
+// share the same value
+var anchoredServiceFields = []string{"logging", "restart", "environment"}
+
+// MarshalAnchored renders the compose file with the logging, restart policy and environment that are shared by
+// several services defined once, in an x- extension field with a YAML anchor that each of the services refers
+// to with an alias. Compose expands the aliases, so the result means the same as that of yaml.Marshal.
+func (c *DockerComposeConfig) MarshalAnchored() ([]byte, error) {
+	var root yaml.Node
+	if err := root.Encode(c); err != nil {
+		return nil, err
+	}
+	services := mappingValue(&root, "services")
+	if services == nil {
+		return yaml.Marshal(&root)
+	}
+	extensions := []*yaml.Node{}
+	for _, field := range anchoredServiceFields {
+		shared, err := sharedServiceValue(services, field)
+		if err != nil {
+			return nil, err
+		}
+		if shared == nil {
+			continue
+		}
+		anchor := *shared
+		anchor.Anchor = field
+		for i := 1; i < len(services.Content); i += 2 {
+			service := services.Content[i]
+			for j := 0; j < len(service.Content); j += 2 {
+				if service.Content[j].Value != field {
+					continue
+				}
+				if same, err := sameNode(service.Content[j+1], shared); err != nil {
+					return nil, err
+				} else if same {
+					service.Content[j+1] = &yaml.Node{Kind: yaml.AliasNode, Value: field, Alias: &anchor}
+				}
+			}
+		}
+		extensions = append(extensions, &yaml.Node{Kind: yaml.ScalarNode, Value: "x-" + field}, &anchor)
+	}
+	// Anchors must come before the aliases that refer to them, so the extension fields go first
+	root.Content = append(extensions, root.Content...)
+	return yaml.Marshal(&root)
+}
+
+// mappingValue returns the value of a key of a YAML mapping, or nil if it is not there
+func mappingValue(mapping *yaml.Node, key string) *yaml.Node {
+	for i := 0; i < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			return mapping.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// sharedServiceValue returns the most common value of a field among the services, or nil if no two of them
+// have the same value for it
+func sharedServiceValue(services *yaml.Node, field string) (*yaml.Node, error) {
+	counts := make(map[string]int)
+	var shared *yaml.Node
+	sharedCount := 1
+	// The services are in order of their names, so the choice between equally common values is stable
+	for i := 1; i < len(services.Content); i += 2 {
+		value := mappingValue(services.Content[i], field)
+		if value == nil {
+			continue
+		}
+		b, err := yaml.Marshal(value)
+		if err != nil {
+			return nil, err
+		}
+		counts[string(b)]++
+		if counts[string(b)] > sharedCount {
+			shared, sharedCount = value, counts[string(b)]
+		}
+	}
+	return shared, nil
+}
+
+func sameNode(a, b *yaml.Node) (bool, error) {
+	aBytes, err := yaml.Marshal(a)
+	if err != nil {
+		return false, err
+	}
+	bBytes, err := yaml.Marshal(b)
+	if err != nil {
+		return false, err
+	}
+	return string(aBytes) == string(bBytes), nil
+}
+
+// MirrorImages rewrites the image of every service to be pulled from the mirror registry with the given
+// prefix, and returns the original image of each rewritten one keyed by its new name
+func (c *DockerComposeConfig) MirrorImages(prefix string) map[string]string {
+	upstreams := make(map[string]string)
+	for _, service := range c.Services {
+		if mirrored := types.MirrorImage(prefix, service.Image); mirrored != service.Image {
+			upstreams[mirrored] = service.Image
+			service.Image = mirrored
+		}
+	}
+	return upstreams
+}
+
+// AttachExternalNetwork connects every service to an existing docker network that is managed outside of the
+// stack, as well as to the default network of the stack. Compose only references the network, so it must
+// already exist when the stack is started.
+func (c *DockerComposeConfig) AttachExternalNetwork(networkName string) {
+	if c.Networks == nil {
+		c.Networks = make(map[string]*Network)
+	}
+	c.Networks[networkName] = &Network{External: true}
+	for _, service := range c.Services {
+		service.Networks = append(service.Networks, "default", networkName)
+	}
+}
+
+// Images returns the image of every service, sorted and without duplicates
+func (c *DockerComposeConfig) Images() []string {
+	seen := make(map[string]bool)
+	images := make([]string, 0, len(c.Services))
+	for _, service := range c.Services {
+		if service.Image != "" && !seen[service.Image] {
+			seen[service.Image] = true
+			images = append(images, service.Image)
+		}
+	}
+	sort.Strings(images)
+	return images
 }
 
 var StandardLogOptions = &LoggingConfig{
@@ -78,9 +316,10 @@ var StandardLogOptions = &LoggingConfig{
 
 func CreateDockerCompose(s *types.Stack) *DockerComposeConfig {
 	compose := &DockerComposeConfig{
-		Version:  "2.1",
+		// 2.2 is the first version of the file format in which the standalone docker-compose accepts cpus
+		Version:  "2.2",
 		Services: make(map[string]*Service),
-		Volumes:  make(map[string]struct{}),
+		Volumes:  make(map[string]*Volume),
 	}
 	for _, member := range s.Members {
 
@@ -102,7 +341,7 @@ func CreateDockerCompose(s *types.Stack) *DockerComposeConfig {
 				DependsOn: map[string]map[string]string{},
 				Logging:   StandardLogOptions,
 			}
-			compose.Volumes[fmt.Sprintf("%s_data_%s", fireflyCore, member.ID)] = struct{}{}
+			compose.Volumes[fmt.Sprintf("%s_data_%s", fireflyCore, member.ID)] = &Volume{}
 			compose.Services[fireflyCore+"_"+member.ID].DependsOn["dataexchange_"+member.ID] = map[string]string{"condition": "service_started"}
 			compose.Services[fireflyCore+"_"+member.ID].DependsOn["ipfs_"+member.ID] = map[string]string{"condition": "service_healthy"}
 		}
@@ -124,7 +363,7 @@ func CreateDockerCompose(s *types.Stack) *DockerComposeConfig {
 				},
 				Logging: StandardLogOptions,
 			}
-			compose.Volumes[fmt.Sprintf("postgres_%s", member.ID)] = struct{}{}
+			compose.Volumes[fmt.Sprintf("postgres_%s", member.ID)] = &Volume{}
 			if service, ok := compose.Services[fmt.Sprintf("%s_%s", fireflyCore, member.ID)]; ok {
 				service.DependsOn["postgres_"+member.ID] = map[string]string{"condition": "service_healthy"}
 			}
@@ -155,8 +394,8 @@ func CreateDockerCompose(s *types.Stack) *DockerComposeConfig {
 			}
 		}
 		compose.Services["ipfs_"+member.ID] = sharedStorage
-		compose.Volumes[fmt.Sprintf("ipfs_staging_%s", member.ID)] = struct{}{}
-		compose.Volumes[fmt.Sprintf("ipfs_data_%s", member.ID)] = struct{}{}
+		compose.Volumes[fmt.Sprintf("ipfs_staging_%s", member.ID)] = &Volume{}
+		compose.Volumes[fmt.Sprintf("ipfs_data_%s", member.ID)] = &Volume{}
 		compose.Services["dataexchange_"+member.ID] = &Service{
 			Image:         s.VersionManifest.DataExchange.GetDockerImageString(),
 			ContainerName: fmt.Sprintf("%s_dataexchange_%s", s.Name, member.ID),
@@ -164,7 +403,7 @@ func CreateDockerCompose(s *types.Stack) *DockerComposeConfig {
 			Volumes:       []string{fmt.Sprintf("dataexchange_%s:/data", member.ID)},
 			Logging:       StandardLogOptions,
 		}
-		compose.Volumes[fmt.Sprintf("dataexchange_%s", member.ID)] = struct{}{}
+		compose.Volumes[fmt.Sprintf("dataexchange_%s", member.ID)] = &Volume{}
 		if s.SandboxEnabled {
 			compose.Services["sandbox_"+member.ID] = &Service{
 				Image:         constants.SandboxImageName,
@@ -185,8 +424,8 @@ func CreateDockerCompose(s *types.Stack) *DockerComposeConfig {
 			Volumes:       []string{"prometheus_data:/prometheus", "prometheus_config:/etc/prometheus"},
 			Logging:       StandardLogOptions,
 		}
-		compose.Volumes["prometheus_data"] = struct{}{}
-		compose.Volumes["prometheus_config"] = struct{}{}
+		compose.Volumes["prometheus_data"] = &Volume{}
+		compose.Volumes["prometheus_config"] = &Volume{}
 	}
 
 	return compose
@@ -0,0 +1,1697 @@
+package docker
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	stdlog "log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/crane"
+	"github.com/google/go-containerregistry/pkg/registry"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+	"github.com/hyperledger/firefly-cli/internal/constants"
+	"github.com/hyperledger/firefly-cli/internal/docker/dockertest"
+	"github.com/hyperledger/firefly-cli/internal/log"
+	"github.com/hyperledger/firefly-cli/pkg/types"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v3"
+)
+
+func newTestContext() context.Context {
+	ctx := log.WithVerbosity(context.Background(), false)
+	return log.WithLogger(ctx, &log.StdoutLogger{})
+}
+
+func TestRetryDelay(t *testing.T) {
+	assert.Equal(t, time.Second, retryDelay(time.Second, 0))
+	assert.Equal(t, 2*time.Second, retryDelay(time.Second, 1))
+	assert.Equal(t, 16*time.Second, retryDelay(time.Second, 4))
+	assert.Equal(t, maxRetryDelay, retryDelay(time.Second, 5))
+	assert.Equal(t, maxRetryDelay, retryDelay(time.Second, 100))
+}
+
+func TestIsRetryableError(t *testing.T) {
+	assert.True(t, isRetryableError(fmt.Errorf("dial tcp 127.0.0.1:443: connect: connection refused")))
+	assert.True(t, isRetryableError(fmt.Errorf("received unexpected HTTP status: 503 Service Unavailable")))
+	assert.False(t, isRetryableError(fmt.Errorf("invalid reference format: repository name must be lowercase")))
+	assert.False(t, isRetryableError(fmt.Errorf("Error response from daemon: manifest unknown")))
+	assert.False(t, isRetryableError(fmt.Errorf("unauthorized: authentication required")))
+}
+
+func TestRunDockerCommandRetryCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(newTestContext())
+	cancel()
+	err := RunDockerCommandRetry(ctx, ".", 5, time.Hour, "this-is-not-a-docker-command")
+	assert.Error(t, err)
+}
+
+type failingWriter struct {
+	writes int
+}
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	w.writes++
+	return 0, fmt.Errorf("pop")
+}
+
+// cancelWriter cancels the context once the first output has been written
+type cancelWriter struct {
+	out    strings.Builder
+	cancel context.CancelFunc
+}
+
+func (w *cancelWriter) Write(p []byte) (int, error) {
+	defer w.cancel()
+	return w.out.Write(p)
+}
+
+func TestFollowCommand(t *testing.T) {
+	out := &strings.Builder{}
+	err := followCommand(newTestContext(), exec.Command("sh", "-c", "echo one; echo two >&2; echo three"), out)
+	assert.NoError(t, err)
+	assert.Contains(t, out.String(), "one\n")
+	assert.Contains(t, out.String(), "two\n")
+	assert.Contains(t, out.String(), "three\n")
+}
+
+func TestFollowCommandCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(newTestContext())
+	out := &cancelWriter{cancel: cancel}
+	err := followCommand(ctx, exec.Command("sh", "-c", "echo started; exec sleep 60"), out)
+	assert.NoError(t, err)
+	assert.Equal(t, "started\n", out.out.String())
+}
+
+func TestFollowCommandWriterError(t *testing.T) {
+	w := &failingWriter{}
+	err := followCommand(newTestContext(), exec.Command("sh", "-c", "exec yes"), w)
+	assert.Regexp(t, "pop", err)
+	assert.Equal(t, 1, w.writes)
+}
+
+func TestFollowCommandExitError(t *testing.T) {
+	err := followCommand(newTestContext(), exec.Command("sh", "-c", "exit 3"), &strings.Builder{})
+	assert.Regexp(t, "exit status 3", err)
+}
+
+// logStream is a docker.CommandRunner whose container logs are the output of a shell script
+type logStream struct {
+	dockertest.Runner
+	script string
+}
+
+func (r *logStream) Follow(ctx context.Context, w io.Writer, name string, args ...string) error {
+	_ = r.Runner.Follow(ctx, w, name, args...)
+	return followCommand(ctx, exec.Command("sh", "-c", r.script), w)
+}
+
+func TestWaitForLogLine(t *testing.T) {
+	// The logs process would run for another minute if it was not stopped once the line is found
+	runner := &logStream{script: "echo starting; sleep 0.1; printf 'listening on'; sleep 0.1; echo ' port 8545'; exec sleep 60"}
+	ctx := WithCommandRunner(newTestContext(), runner)
+	start := time.Now()
+	assert.NoError(t, WaitForLogLine(ctx, "stack_signer", `^listening on port \d+$`, time.Minute))
+	assert.Less(t, time.Since(start), 30*time.Second)
+	assert.Equal(t, []string{"docker logs --follow stack_signer"}, runner.Commands)
+
+	runner = &logStream{script: "echo starting; exec sleep 60"}
+	ctx = WithCommandRunner(newTestContext(), runner)
+	err := WaitForLogLine(ctx, "stack_signer", "listening", 200*time.Millisecond)
+	assert.EqualError(t, err, "timed out after 200ms waiting for container 'stack_signer' to log a line matching 'listening'")
+
+	runner = &logStream{script: "echo starting; echo stopped"}
+	ctx = WithCommandRunner(newTestContext(), runner)
+	err = WaitForLogLine(ctx, "stack_signer", "listening", time.Minute)
+	assert.EqualError(t, err, "container 'stack_signer' stopped before logging a line matching 'listening'")
+
+	// The last line is matched once the container stops, even without a newline
+	runner = &logStream{script: "echo starting; printf listening"}
+	ctx = WithCommandRunner(newTestContext(), runner)
+	assert.NoError(t, WaitForLogLine(ctx, "stack_signer", "^listening$", time.Minute))
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	assert.ErrorIs(t, WaitForLogLine(cancelled, "stack_signer", "listening", time.Minute), context.Canceled)
+
+	assert.Regexp(t, "invalid log line pattern", WaitForLogLine(ctx, "stack_signer", "(", time.Minute))
+}
+
+func TestLogLineMatcher(t *testing.T) {
+	matched := 0
+	m := &logLineMatcher{regex: regexp.MustCompile(`^started$`), matched: func() { matched++ }}
+
+	// A line split across writes is only matched once it is complete
+	_, _ = m.Write([]byte("started"))
+	assert.Equal(t, 0, matched)
+	_, _ = m.Write([]byte(" slowly\nstar"))
+	assert.Equal(t, 0, matched)
+	_, _ = m.Write([]byte("ted\r\n"))
+	assert.Equal(t, 1, matched)
+	_, _ = m.Write([]byte("started\n"))
+	assert.Equal(t, 1, matched)
+
+	// The last line of logs that end without a newline is matched when they end
+	matched = 0
+	m = &logLineMatcher{regex: regexp.MustCompile(`^started$`), matched: func() { matched++ }}
+	_, _ = m.Write([]byte("starting\nstarted"))
+	assert.Equal(t, 0, matched)
+	m.flush()
+	assert.Equal(t, 1, matched)
+}
+
+func TestComposeDownArgs(t *testing.T) {
+	assert.Equal(t, []string{"down", "--remove-orphans"}, composeDownArgs(false, 0))
+	assert.Equal(t, []string{"down", "--remove-orphans", "-t", "30", "-v"}, composeDownArgs(true, 30*time.Second))
+	// Partial seconds are rounded up, so services are never given less time than requested
+	assert.Equal(t, []string{"down", "--remove-orphans", "-t", "2"}, composeDownArgs(false, 1500*time.Millisecond))
+}
+
+func TestComposeUp(t *testing.T) {
+	testcases := []struct {
+		Name    string
+		Version DockerComposeVersion
+		Offline bool
+		Command string
+	}{
+		{Name: "v2", Version: ComposeV2, Command: "docker compose up -d\n"},
+		{Name: "v2 offline", Version: ComposeV2, Offline: true, Command: "docker compose up -d --pull never\n"},
+		{Name: "v1 offline", Version: ComposeV1, Offline: true, Command: "docker-compose up -d\n"},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.Name, func(t *testing.T) {
+			out := &strings.Builder{}
+			ctx := context.WithValue(WithDryRun(newTestContext(), out), CtxComposeVersionKey{}, tc.Version)
+			if tc.Offline {
+				ctx = WithOffline(ctx)
+			}
+			assert.NoError(t, ComposeUp(ctx, t.TempDir()))
+			assert.Equal(t, tc.Command, out.String())
+		})
+	}
+}
+
+func TestComposeDownNoComposeVersion(t *testing.T) {
+	defer stubComposeVersionProbe(func(name string, args ...string) error { return fmt.Errorf("not found") })()
+
+	err := ComposeDown(newTestContext(), t.TempDir(), true, time.Minute)
+	assert.Regexp(t, "docker compose is not installed", err)
+	err = ComposeStop(newTestContext(), t.TempDir(), time.Minute)
+	assert.Regexp(t, "docker compose is not installed", err)
+	_, err = RunDockerComposeCommandBuffered(newTestContext(), t.TempDir(), "ps", "--format", "json")
+	assert.Regexp(t, "docker compose is not installed", err)
+}
+
+func TestRunDockerComposeCommandBuffered(t *testing.T) {
+	testcases := []struct {
+		Version DockerComposeVersion
+		Command string
+	}{
+		{Version: ComposeV1, Command: "docker-compose ps --format json\n"},
+		{Version: ComposeV2, Command: "docker compose ps --format json\n"},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.Version.String(), func(t *testing.T) {
+			out := &strings.Builder{}
+			ctx := context.WithValue(WithDryRun(newTestContext(), out), CtxComposeVersionKey{}, tc.Version)
+			_, err := RunDockerComposeCommandBuffered(ctx, t.TempDir(), "ps", "--format", "json")
+			assert.NoError(t, err)
+			assert.Equal(t, tc.Command, out.String())
+		})
+	}
+}
+
+func TestComposeProject(t *testing.T) {
+	for _, version := range []DockerComposeVersion{ComposeV1, ComposeV2} {
+		t.Run(version.String(), func(t *testing.T) {
+			runner := &dockertest.Runner{}
+			ctx := context.WithValue(WithCommandRunner(newTestContext(), runner), CtxComposeVersionKey{}, version)
+			ctx = WithComposeProject(ctx, "dev")
+			assert.NoError(t, ComposeDown(ctx, "/stacks/dev", false, 0))
+			assert.NoError(t, ComposeStop(ctx, "/stacks/dev", 0))
+			assert.NoError(t, ValidateCompose(ctx, "/stacks/dev"))
+			assert.NoError(t, RunDockerComposeCommand(ctx, "/stacks/dev", "logs", "-f"))
+			executable := "docker compose"
+			if version == ComposeV1 {
+				executable = "docker-compose"
+			}
+			assert.Equal(t, []string{
+				executable + " -p dev down --remove-orphans",
+				executable + " -p dev stop",
+				executable + " -p dev config -q",
+				executable + " -p dev logs -f",
+			}, runner.Commands)
+		})
+	}
+}
+
+func TestComposeProjectName(t *testing.T) {
+	assert.Equal(t, "dev", ComposeProjectName("dev"))
+	assert.Equal(t, "my_stack-1", ComposeProjectName("My_Stack-1"))
+	assert.Equal(t, "stack", ComposeProjectName("_st.ack"))
+}
+
+// stubComposeVersionProbe replaces the compose version probe and clears the cached version, returning a
+// function that restores them
+func stubComposeVersionProbe(probe func(name string, args ...string) error) func() {
+	original := composeVersionProbe
+	composeVersionProbe = probe
+	composeVersionCache.version = None
+	return func() {
+		composeVersionProbe = original
+		composeVersionCache.version = None
+	}
+}
+
+func TestDetectComposeVersion(t *testing.T) {
+	testCases := []struct {
+		name      string
+		installed map[string]bool
+		expected  DockerComposeVersion
+	}{
+		{name: "v2", installed: map[string]bool{"docker": true, "docker-compose": true}, expected: ComposeV2},
+		{name: "v1", installed: map[string]bool{"docker-compose": true}, expected: ComposeV1},
+		{name: "none", installed: map[string]bool{}, expected: None},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			probes := 0
+			defer stubComposeVersionProbe(func(name string, args ...string) error {
+				probes++
+				if tc.installed[name] {
+					return nil
+				}
+				return fmt.Errorf("%s: executable file not found in $PATH", name)
+			})()
+
+			version, err := DetectComposeVersion(newTestContext())
+			assert.Equal(t, tc.expected, version)
+			if tc.expected == None {
+				assert.Regexp(t, "docker compose is not installed", err)
+				return
+			}
+			assert.NoError(t, err)
+
+			// The detected version is cached, so the second call does not probe again
+			probeCount := probes
+			version, err = DetectComposeVersion(newTestContext())
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, version)
+			assert.Equal(t, probeCount, probes)
+		})
+	}
+}
+
+func TestDetectComposeVersionFromContext(t *testing.T) {
+	defer stubComposeVersionProbe(func(name string, args ...string) error { return fmt.Errorf("not found") })()
+
+	ctx := context.WithValue(newTestContext(), CtxComposeVersionKey{}, ComposeV1)
+	version, err := DetectComposeVersion(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, ComposeV1, version)
+	assert.Equal(t, "docker-compose (v1)", version.String())
+}
+
+func TestParseContainerState(t *testing.T) {
+	state, err := parseContainerState("running|healthy|0\n")
+	assert.NoError(t, err)
+	assert.Equal(t, State{Status: "running", Health: HealthHealthy}, state)
+
+	state, err = parseContainerState("exited||137")
+	assert.NoError(t, err)
+	assert.Equal(t, State{Status: "exited", ExitCode: 137}, state)
+
+	_, err = parseContainerState("running")
+	assert.Regexp(t, "unexpected container state 'running'", err)
+	_, err = parseContainerState("running||abc")
+	assert.Regexp(t, "unexpected container exit code 'abc'", err)
+}
+
+func TestRunCommandDeadline(t *testing.T) {
+	ctx, cancel := context.WithTimeout(newTestContext(), 100*time.Millisecond)
+	defer cancel()
+	cmd := exec.Command("sh", "-c", "exec sleep 60")
+	start := time.Now()
+	_, err := runCommand(ctx, cmd)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), 10*time.Second)
+	// The process was killed rather than being left to run
+	assert.NotNil(t, cmd.ProcessState)
+	assert.False(t, cmd.ProcessState.Success())
+}
+
+func TestRunCommandCapturesAllOutput(t *testing.T) {
+	// stdout is closed long before stderr is finished with, and neither stream ends in a newline
+	output, err := runCommand(newTestContext(), exec.Command("sh", "-c", "echo out1; printf out2; exec 1>&-; sleep 0.2; echo err1 >&2; printf err2 >&2"))
+	assert.NoError(t, err)
+	assert.Contains(t, output, "out1\n")
+	assert.Contains(t, output, "out2")
+	assert.Contains(t, output, "err1\n")
+	assert.Contains(t, output, "err2")
+	assert.Len(t, output, len("out1\nout2err1\nerr2"))
+}
+
+func TestRunCommandBoundsOutput(t *testing.T) {
+	defer func(max int) { maxCommandOutput = max }(maxCommandOutput)
+	maxCommandOutput = 64 * 1024
+
+	// Both streams write far more than is kept, at the same time
+	output, err := runCommand(newTestContext(), exec.Command("sh", "-c", "yes out | head -c 1000000 & yes err | head -c 1000000 >&2; wait; sleep 0.2; echo last"))
+	assert.NoError(t, err)
+	assert.Regexp(t, "^\\[[0-9]+ bytes of output truncated\\]\n", output)
+	assert.True(t, strings.HasSuffix(output, "last\n"))
+	assert.LessOrEqual(t, len(output), maxCommandOutput+len("[2000005 bytes of output truncated]\n"))
+}
+
+func TestCommandOutputConcurrentWrites(t *testing.T) {
+	defer func(max int) { maxCommandOutput = max }(maxCommandOutput)
+	maxCommandOutput = 1000
+
+	output := &commandOutput{}
+	wg := sync.WaitGroup{}
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				output.WriteString("0123456789")
+				_ = output.String()
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, "[79000 bytes of output truncated]\n"+strings.Repeat("0123456789", 100), output.String())
+
+	small := &commandOutput{}
+	small.WriteString("out\n")
+	assert.Equal(t, "out\n", small.String())
+}
+
+func TestAttachExternalNetwork(t *testing.T) {
+	compose := &DockerComposeConfig{
+		Services: map[string]*Service{
+			"firefly_core_0": {Image: "ghcr.io/hyperledger/firefly"},
+			"ethsigner":      {Image: "ghcr.io/hyperledger/firefly-signer"},
+		},
+	}
+	compose.AttachExternalNetwork("mock_rpc")
+
+	b, err := yaml.Marshal(compose)
+	assert.NoError(t, err)
+	assert.Contains(t, string(b), "networks:\n    mock_rpc:\n        external: true\n")
+	for _, service := range compose.Services {
+		assert.Equal(t, []string{"default", "mock_rpc"}, service.Networks)
+	}
+}
+
+func TestSetResourceLimits(t *testing.T) {
+	limits := (&ResourceLimits{CPUs: "2", Memory: "1g"}).WithConfig(&types.ResourceLimitsConfig{Memory: "512m"})
+	assert.Equal(t, &ResourceLimits{CPUs: "2", Memory: "512m"}, limits)
+
+	service := &Service{Image: "ghcr.io/hyperledger/firefly-signer"}
+	service.SetResourceLimits(limits, ComposeV1)
+	b, err := yaml.Marshal(service)
+	assert.NoError(t, err)
+	assert.Equal(t, "image: ghcr.io/hyperledger/firefly-signer\ncpus: \"2\"\nmem_limit: 512m\n", string(b))
+
+	service.SetResourceLimits(limits, ComposeV2)
+	b, err = yaml.Marshal(service)
+	assert.NoError(t, err)
+	assert.Equal(t, "image: ghcr.io/hyperledger/firefly-signer\ndeploy:\n    resources:\n        limits:\n            cpus: \"2\"\n            memory: 512m\n", string(b))
+
+	// Services without any limit are left unlimited
+	service.SetResourceLimits((*ResourceLimits)(nil).WithConfig(nil), ComposeV2)
+	b, err = yaml.Marshal(service)
+	assert.NoError(t, err)
+	assert.Equal(t, "image: ghcr.io/hyperledger/firefly-signer\n", string(b))
+}
+
+func TestCopyFileToVolumeCommand(t *testing.T) {
+	assert.Equal(t, "cp -R /source/config.yaml /dest/config && chgrp -R 0 /dest/config && chmod -R g+rwX /dest/config", copyFileToVolumeCommand("/tmp/config.yaml", "config"))
+	assert.Equal(t, "cp -R /source/key.password /dest/key.password && chown -R 1000:1000 /dest/key.password && find /dest/key.password -type f -exec chmod 600 {} +", copyFileToVolumeCommand("/tmp/key.password", "key.password", WithOwner(1000, 1000), WithMode(0600)))
+	assert.Equal(t, "cp -R /source/keystore /dest && chown -R 1000:0 /dest/keystore && chmod -R g+rwX /dest/keystore", copyFileToVolumeCommand("/tmp/keystore", "/", WithOwner(1000, 0)))
+	assert.Equal(t, "cp -R /source/keystore /dest && chgrp -R 0 /dest/keystore && find /dest/keystore -type f -exec chmod 640 {} +", copyFileToVolumeCommand("/tmp/keystore", "/", WithMode(0640)))
+
+	// Copying into a directory leaves the other files in it alone
+	assert.Equal(t, "cp -R /source/keystore /dest && chgrp -R 0 /dest/keystore && chmod -R g+rwX /dest/keystore", copyFileToVolumeCommand("/tmp/keystore", "/"))
+	assert.Equal(t, "cp -R /source/account1 /dest/keystore && chgrp -R 0 /dest/keystore/account1 && chmod -R g+rwX /dest/keystore/account1", copyFileToVolumeCommand("/tmp/account1", "/keystore/"))
+}
+
+func TestRemoveVolumesByLabel(t *testing.T) {
+	runner := &dockertest.Runner{Outputs: map[string]string{
+		"docker volume ls --quiet --filter label=" + StackLabel("stack_a"): "stack_a_geth\nstack_a_renamed_ethsigner\n",
+	}}
+	assert.NoError(t, RemoveVolumesByLabel(WithCommandRunner(newTestContext(), runner), StackLabel("stack_a")))
+	assert.Equal(t, []string{
+		"docker volume ls --quiet --filter label=io.hyperledger.firefly-cli.stack=stack_a",
+		"docker volume remove stack_a_geth",
+		"docker volume remove stack_a_renamed_ethsigner",
+	}, runner.Commands)
+}
+
+func TestCreateVolume(t *testing.T) {
+	runner := &dockertest.Runner{}
+	assert.NoError(t, CreateVolume(WithCommandRunner(newTestContext(), runner), "stack_a_geth", StackLabel("stack_a")))
+	assert.Contains(t, runner.Commands, "docker volume create --label io.hyperledger.firefly-cli.stack=stack_a stack_a_geth")
+}
+
+// removedResources returns the commands that removed a container, network or volume
+func removedResources(commands []string) []string {
+	removed := []string{}
+	for _, command := range commands {
+		for _, remove := range []string{"docker rm --force ", "docker network remove ", "docker volume remove "} {
+			if strings.HasPrefix(command, remove) {
+				removed = append(removed, command)
+			}
+		}
+	}
+	return removed
+}
+
+func TestPruneStack(t *testing.T) {
+	// The listings are what docker prints for each name filter. stack_a_postgres_0 is listed by prefix as though
+	// it had just been removed, so removing it fails but it is no longer there when listed by its own name.
+	runner := &dockertest.Runner{
+		Outputs: map[string]string{
+			"docker ps --all --filter name=^stack_a_ --format {{.Names}}":  "stack_a_ethsigner_1\nstack_a_firefly_core_0_1\nstack_a_b_ethsigner_1\nother_stack_a_geth_1\n",
+			"docker network ls --filter name=^stack_a_ --format {{.Name}}": "stack_a_default\nstack_a_b_default\n",
+			"docker volume ls --quiet --filter name=^stack_a_":             "stack_a_ethsigner\nstack_a_ethsigner_config\nstack_a_b_ethsigner\nstack_a_postgres_0\n",
+		},
+		Errors: map[string]error{
+			"docker volume remove stack_a_postgres_0": fmt.Errorf("Error: no such volume: stack_a_postgres_0"),
+		},
+	}
+
+	assert.NoError(t, PruneStack(WithCommandRunner(newTestContext(), runner), "stack_a", "stack_a_b"))
+
+	assert.Equal(t, []string{
+		"docker rm --force stack_a_ethsigner_1",
+		"docker rm --force stack_a_firefly_core_0_1",
+		"docker network remove stack_a_default",
+		"docker volume remove stack_a_ethsigner",
+		"docker volume remove stack_a_ethsigner_config",
+		"docker volume remove stack_a_postgres_0",
+	}, removedResources(runner.Commands))
+	assert.Contains(t, runner.Commands, "docker volume ls --quiet --filter name=^stack_a_postgres_0$")
+	assert.Contains(t, runner.Commands, "docker volume ls --quiet --filter label=io.hyperledger.firefly-cli.stack=stack_a")
+}
+
+func TestPruneStackRemoveFails(t *testing.T) {
+	// The removal fails while the volume is still there
+	runner := &dockertest.Runner{
+		Outputs: map[string]string{
+			"docker volume ls --quiet --filter name=^stack_a_":           "stack_a_ethsigner\n",
+			"docker volume ls --quiet --filter name=^stack_a_ethsigner$": "stack_a_ethsigner\n",
+		},
+		Errors: map[string]error{
+			"docker volume remove stack_a_ethsigner": fmt.Errorf("exit status 1"),
+		},
+	}
+	assert.EqualError(t, PruneStack(WithCommandRunner(newTestContext(), runner), "stack_a"), "exit status 1")
+}
+
+func TestMirrorImages(t *testing.T) {
+	compose := &DockerComposeConfig{
+		Services: map[string]*Service{
+			"firefly_core_0": {Image: "ghcr.io/hyperledger/firefly:v1.3.0"},
+			"ethsigner":      {Image: "ghcr.io/hyperledger/firefly-signer@sha256:abcd"},
+			"geth":           {Image: "ethereum/client-go:release-1.10"},
+			"postgres_0":     {Image: "postgres"},
+			"mirrored":       {Image: "registry.internal/mirror/evmconnect:v1.3.0"},
+		},
+	}
+	upstreams := compose.MirrorImages("registry.internal/mirror/")
+
+	assert.Equal(t, "registry.internal/mirror/hyperledger/firefly:v1.3.0", compose.Services["firefly_core_0"].Image)
+	assert.Equal(t, "registry.internal/mirror/hyperledger/firefly-signer@sha256:abcd", compose.Services["ethsigner"].Image)
+	assert.Equal(t, "registry.internal/mirror/ethereum/client-go:release-1.10", compose.Services["geth"].Image)
+	assert.Equal(t, "registry.internal/mirror/postgres", compose.Services["postgres_0"].Image)
+	assert.Equal(t, "registry.internal/mirror/evmconnect:v1.3.0", compose.Services["mirrored"].Image)
+	assert.Equal(t, map[string]string{
+		"registry.internal/mirror/hyperledger/firefly:v1.3.0":             "ghcr.io/hyperledger/firefly:v1.3.0",
+		"registry.internal/mirror/hyperledger/firefly-signer@sha256:abcd": "ghcr.io/hyperledger/firefly-signer@sha256:abcd",
+		"registry.internal/mirror/ethereum/client-go:release-1.10":        "ethereum/client-go:release-1.10",
+		"registry.internal/mirror/postgres":                               "postgres",
+	}, upstreams)
+
+	// Without a mirror nothing is rewritten
+	compose = &DockerComposeConfig{Services: map[string]*Service{"postgres_0": {Image: "postgres"}}}
+	assert.Empty(t, compose.MirrorImages(""))
+	assert.Equal(t, "postgres", compose.Services["postgres_0"].Image)
+}
+
+func TestTagImage(t *testing.T) {
+	runner := &dockertest.Runner{}
+	assert.NoError(t, TagImage(WithCommandRunner(newTestContext(), runner), "ghcr.io/hyperledger/firefly-signer:v0.9.1", "registry.internal/mirror/firefly-signer:v0.9.1"))
+	assert.Equal(t, []string{"docker tag ghcr.io/hyperledger/firefly-signer:v0.9.1 registry.internal/mirror/firefly-signer:v0.9.1"}, runner.Commands)
+}
+
+func TestRunCommandEvents(t *testing.T) {
+	var events bytes.Buffer
+	ctx := log.WithEventSink(newTestContext(), &events)
+
+	_, err := runCommand(ctx, exec.Command("sh", "-c", "echo hello"))
+	assert.NoError(t, err)
+	_, err = runCommand(ctx, exec.Command("sh", "-c", "echo broken >&2; exit 3"))
+	assert.Error(t, err)
+	_, err = runCommand(ctx, exec.Command("sh", "-c", fmt.Sprintf("head -c %d /dev/zero | tr '\\000' a; echo end", log.MaxEventOutputLength)))
+	assert.NoError(t, err)
+
+	lines := strings.Split(strings.TrimSpace(events.String()), "\n")
+	assert.Len(t, lines, 3)
+	parsed := make([]*log.Event, len(lines))
+	for i, line := range lines {
+		assert.NoError(t, json.Unmarshal([]byte(line), &parsed[i]))
+	}
+
+	assert.Equal(t, []string{"sh", "-c", "echo hello"}, parsed[0].Command)
+	assert.Equal(t, 0, parsed[0].ExitCode)
+	assert.Equal(t, "hello\n", parsed[0].Output)
+	assert.Empty(t, parsed[0].Error)
+	assert.False(t, parsed[0].EndTime.Before(parsed[0].StartTime))
+
+	assert.Equal(t, 3, parsed[1].ExitCode)
+	assert.Equal(t, "broken\n", parsed[1].Output)
+	assert.Equal(t, "exit status 3", parsed[1].Error)
+
+	assert.Equal(t, 0, parsed[2].ExitCode)
+	assert.True(t, parsed[2].Truncated)
+	assert.Len(t, parsed[2].Output, log.MaxEventOutputLength)
+	assert.True(t, strings.HasSuffix(parsed[2].Output, "aend\n"))
+}
+
+func TestRunCommandNoEvents(t *testing.T) {
+	assert.False(t, log.EventsEnabled(newTestContext()))
+	_, err := runCommand(newTestContext(), exec.Command("sh", "-c", "echo hello"))
+	assert.NoError(t, err)
+}
+
+func TestWaitForHealthy(t *testing.T) {
+	defer func(f func(context.Context, string) (State, error), initial, max time.Duration) {
+		inspectContainerState = f
+		healthPollInitialDelay = initial
+		healthPollMaxDelay = max
+	}(inspectContainerState, healthPollInitialDelay, healthPollMaxDelay)
+	healthPollInitialDelay = time.Millisecond
+	healthPollMaxDelay = 4 * time.Millisecond
+
+	starting := State{Status: "running", Health: HealthStarting}
+	testCases := []struct {
+		Name    string
+		States  []State
+		Timeout time.Duration
+		Error   string
+	}{
+		{Name: "Healthy", States: []State{starting, starting, {Status: "running", Health: HealthHealthy}}},
+		{Name: "NoHealthCheck", States: []State{{Status: "created"}, {Status: "running"}}},
+		{Name: "Unhealthy", States: []State{starting, {Status: "running", Health: HealthUnhealthy}}, Error: "container 'firefly_eth_ethsigner' failed its health check"},
+		{Name: "Exited", States: []State{{Status: "exited", ExitCode: 1}}, Error: "container 'firefly_eth_ethsigner' exited with code 1 before it became healthy"},
+		{Name: "Timeout", States: []State{starting}, Timeout: 20 * time.Millisecond, Error: `did not become healthy within 20ms, last state running \(health: starting, exit code: 0\)`},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			calls := 0
+			inspectContainerState = func(ctx context.Context, containerName string) (State, error) {
+				assert.Equal(t, "firefly_eth_ethsigner", containerName)
+				// The last state is repeated once the fake runs out of states
+				state := tc.States[min(calls, len(tc.States)-1)]
+				calls++
+				return state, nil
+			}
+			timeout := tc.Timeout
+			if timeout == 0 {
+				timeout = time.Minute
+			}
+			err := WaitForHealthy(newTestContext(), "firefly_eth_ethsigner", timeout)
+			if tc.Error == "" {
+				assert.NoError(t, err)
+				assert.Equal(t, len(tc.States), calls)
+			} else {
+				assert.Regexp(t, tc.Error, err)
+			}
+		})
+	}
+
+	t.Run("Cancelled", func(t *testing.T) {
+		inspectContainerState = func(ctx context.Context, containerName string) (State, error) {
+			return starting, nil
+		}
+		ctx, cancel := context.WithTimeout(newTestContext(), 20*time.Millisecond)
+		defer cancel()
+		err := WaitForHealthy(ctx, "firefly_eth_ethsigner", time.Minute)
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+		assert.Regexp(t, `last state running \(health: starting, exit code: 0\)`, err)
+	})
+
+	t.Run("NotFound", func(t *testing.T) {
+		inspectContainerState = func(ctx context.Context, containerName string) (State, error) {
+			return State{}, fmt.Errorf("%w '%s'", ErrContainerNotFound, containerName)
+		}
+		assert.ErrorIs(t, WaitForHealthy(newTestContext(), "firefly_eth_ethsigner", time.Minute), ErrContainerNotFound)
+	})
+}
+
+func TestBindMount(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "Application Support", "firefly")
+
+	mount, err := bindMount(filepath.Join(dir, "ethsigner.yaml"), "/source/ethsigner.yaml")
+	assert.NoError(t, err)
+	assert.Equal(t, fmt.Sprintf("type=bind,source=%s,target=/source/ethsigner.yaml", filepath.Join(dir, "ethsigner.yaml")), mount)
+
+	mount, err = bindMount(filepath.Join(dir, `a,b"c.yaml`), `/source/a,b"c.yaml`)
+	assert.NoError(t, err)
+	assert.Equal(t, fmt.Sprintf(`type=bind,"source=%s","target=/source/a,b""c.yaml"`, filepath.Join(dir, `a,b""c.yaml`)), mount)
+
+	// Relative paths are resolved, as a bind mount needs an absolute source
+	mount, err = bindMount("ethsigner.yaml", "/source/ethsigner.yaml")
+	assert.NoError(t, err)
+	cwd, err := os.Getwd()
+	assert.NoError(t, err)
+	assert.Contains(t, mount, "source="+filepath.Join(cwd, "ethsigner.yaml")+",")
+
+	_, err = bindMount(filepath.Join(dir, "bad\nname"), "/source/bad")
+	assert.Regexp(t, "contains control characters", err)
+}
+
+func TestCopyFileToVolumeCommandQuoting(t *testing.T) {
+	sourcePath := filepath.Join(t.TempDir(), "Application Support", "my key's.password")
+	assert.Equal(t, `cp -R '/source/my key'\''s.password' '/dest/keys/my key'\''s.password' && chgrp -R 0 '/dest/keys/my key'\''s.password' && chmod -R g+rwX '/dest/keys/my key'\''s.password'`, copyFileToVolumeCommand(sourcePath, "keys/my key's.password"))
+}
+
+// captureStdout returns everything written to stdout while f runs
+func captureStdout(t *testing.T, f func()) string {
+	r, w, err := os.Pipe()
+	assert.NoError(t, err)
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+	output := make(chan string)
+	go func() {
+		b, _ := io.ReadAll(r)
+		output <- string(b)
+	}()
+	f()
+	w.Close()
+	return <-output
+}
+
+func TestRunCommandDockerVerbosity(t *testing.T) {
+	testcases := []struct {
+		Name          string
+		Verbose       bool
+		DockerVerbose bool
+		Output        string
+	}{
+		{Name: "quiet", Verbose: false, DockerVerbose: false, Output: ""},
+		{Name: "verbose", Verbose: true, DockerVerbose: false, Output: ""},
+		{Name: "docker-verbose", Verbose: false, DockerVerbose: true, Output: "/bin/sh -c echo docker output\ndocker output\n"},
+		{Name: "both", Verbose: true, DockerVerbose: true, Output: "/bin/sh -c echo docker output\ndocker output\n"},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.Name, func(t *testing.T) {
+			ctx := log.WithVerbosity(context.Background(), tc.Verbose)
+			ctx = log.WithDockerVerbosity(ctx, tc.DockerVerbose)
+			ctx = log.WithLogger(ctx, &log.StdoutLogger{})
+			output := captureStdout(t, func() {
+				result, err := runCommand(ctx, exec.Command("/bin/sh", "-c", "echo docker output"))
+				assert.NoError(t, err)
+				// The output is always returned to the caller, whether or not it is printed
+				assert.Equal(t, "docker output\n", result)
+			})
+			assert.Equal(t, tc.Output, output)
+		})
+	}
+}
+
+func TestCopyFromContainer(t *testing.T) {
+	dir := t.TempDir()
+
+	testcases := []struct {
+		Name    string
+		Source  string
+		Dest    string
+		Created string
+	}{
+		{Name: "file", Source: "/firefly/contracts/firefly_fabric.tar.gz", Dest: filepath.Join(dir, "contracts", "firefly_fabric.tar.gz"), Created: filepath.Join(dir, "contracts")},
+		{Name: "directory", Source: "/data/keystore", Dest: filepath.Join(dir, "keystore"), Created: dir},
+		{Name: "into-existing-directory", Source: "/data/keystore", Dest: dir, Created: dir},
+		{Name: "into-new-directory", Source: "/firefly/contracts", Dest: filepath.Join(dir, "inspect") + string(filepath.Separator), Created: filepath.Join(dir, "inspect")},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.Name, func(t *testing.T) {
+			runner := &dockertest.Runner{}
+			assert.NoError(t, CopyFromContainer(WithCommandRunner(newTestContext(), runner), "stack_ethsigner", tc.Source, tc.Dest))
+			assert.Equal(t, []string{fmt.Sprintf("docker cp stack_ethsigner:%s %s", tc.Source, tc.Dest)}, runner.Commands)
+			assert.DirExists(t, tc.Created)
+		})
+	}
+}
+
+func TestRunDockerCommandWithInput(t *testing.T) {
+	runner := &dockertest.Runner{}
+	_, err := RunDockerCommandWithInput(WithCommandRunner(newTestContext(), runner), "", strings.NewReader("abc"), "load", "--quiet")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"docker load --quiet"}, runner.Commands)
+	assert.Equal(t, []string{"abc"}, runner.Inputs)
+
+	out := &strings.Builder{}
+	_, err = RunDockerCommandWithInput(WithDryRun(newTestContext(), out), "", strings.NewReader("abc"), "load")
+	assert.NoError(t, err)
+	assert.Equal(t, "docker load < (3 bytes)\n", out.String())
+}
+
+func TestCopyFromContainerMissingSource(t *testing.T) {
+	dir := t.TempDir()
+	runner := &dockertest.Runner{Errors: map[string]error{
+		"docker cp stack_ethsigner:/nope " + filepath.Join(dir, "out"): fmt.Errorf("Error response from daemon: Could not find the file /nope in container stack_ethsigner"),
+	}}
+	err := CopyFromContainer(WithCommandRunner(newTestContext(), runner), "stack_ethsigner", "/nope", filepath.Join(dir, "out"))
+	assert.Regexp(t, "^'/nope' does not exist in container 'stack_ethsigner'$", err)
+}
+
+func TestWriteToVolume(t *testing.T) {
+	runner := &dockertest.Runner{}
+	ctx := WithCommandRunner(newTestContext(), runner)
+	assert.NoError(t, WriteToVolume(ctx, "stack_ethsigner_config", "keys/firefly.ffsigner", []byte("server:\n  port: 8545\n"), 0640))
+	assert.Equal(t, []string{
+		"docker run --rm -i -v stack_ethsigner_config:/dest alpine /bin/sh -c mkdir -p /dest/keys && cat > /dest/keys/firefly.ffsigner && chgrp 0 /dest/keys/firefly.ffsigner && chmod 640 /dest/keys/firefly.ffsigner",
+	}, runner.Commands)
+	assert.Equal(t, []string{"server:\n  port: 8545\n"}, runner.Inputs)
+
+	// Destinations the shell would interpret are quoted
+	runner = &dockertest.Runner{}
+	assert.NoError(t, WriteToVolume(WithCommandRunner(newTestContext(), runner), "stack_data", "my dir/it's.txt", nil, 0600))
+	assert.Contains(t, runner.Commands[0], `mkdir -p '/dest/my dir' && cat > '/dest/my dir/it'\''s.txt'`)
+
+	out := &strings.Builder{}
+	assert.NoError(t, WriteToVolume(WithDryRun(newTestContext(), out), "stack_data", "config.yaml", []byte("abc"), 0644))
+	assert.Equal(t, "docker run --rm -i -v stack_data:/dest alpine /bin/sh -c mkdir -p /dest && cat > /dest/config.yaml && chgrp 0 /dest/config.yaml && chmod 644 /dest/config.yaml < (3 bytes)\n", out.String())
+
+	runner = &dockertest.Runner{Errors: map[string]error{}, Outputs: map[string]string{}}
+	command := "docker run --rm -i -v stack_data:/dest alpine /bin/sh -c mkdir -p /dest && cat > /dest/config.yaml && chgrp 0 /dest/config.yaml && chmod 644 /dest/config.yaml"
+	runner.Outputs[command] = "docker: Error response from daemon: no space left on device."
+	runner.Errors[command] = fmt.Errorf("exit status 125")
+	err := WriteToVolume(WithCommandRunner(newTestContext(), runner), "stack_data", "config.yaml", []byte("abc"), 0644)
+	assert.EqualError(t, err, "docker: Error response from daemon: no space left on device.")
+}
+
+func TestWriteToVolumeRoundTrip(t *testing.T) {
+	content := []byte("line one\nline 'two'\n\x00\xff binary\n")
+	for i := 0; i < 1000; i++ {
+		content = append(content, []byte("more content to fill the pipe\n")...)
+	}
+	runner := &dockertest.Runner{}
+	assert.NoError(t, WriteToVolume(WithCommandRunner(newTestContext(), runner), "stack_data", "config.yaml", content, 0600))
+	assert.Equal(t, []string{string(content)}, runner.Inputs)
+}
+
+func TestGetImageDigestRetries(t *testing.T) {
+	defer func(delay time.Duration, digest func(string, ...crane.Option) (string, error)) {
+		registryRetryDelay, craneDigest = delay, digest
+	}(registryRetryDelay, craneDigest)
+	registryRetryDelay = time.Millisecond
+
+	calls := 0
+	craneDigest = func(image string, options ...crane.Option) (string, error) {
+		calls++
+		if calls < 3 {
+			return "", &transport.Error{StatusCode: http.StatusServiceUnavailable}
+		}
+		return "sha256:abcd", nil
+	}
+	digest, err := GetImageDigest(context.Background(), "ghcr.io/hyperledger/firefly:latest")
+	assert.NoError(t, err)
+	assert.Equal(t, "sha256:abcd", digest)
+	assert.Equal(t, 3, calls)
+
+	// Errors that retrying cannot fix are returned straight away
+	calls = 0
+	craneDigest = func(image string, options ...crane.Option) (string, error) {
+		calls++
+		return "", &transport.Error{StatusCode: http.StatusUnauthorized}
+	}
+	_, err = GetImageDigest(context.Background(), "ghcr.io/hyperledger/firefly:latest")
+	assert.Error(t, err)
+	assert.False(t, errors.Is(err, ErrRegistryUnreachable))
+	assert.Equal(t, 1, calls)
+}
+
+func TestGetImageDigestLocalRegistry(t *testing.T) {
+	defer func(delay time.Duration) { registryRetryDelay = delay }(registryRetryDelay)
+	registryRetryDelay = time.Millisecond
+
+	server := httptest.NewServer(registry.New(registry.Logger(stdlog.New(io.Discard, "", 0))))
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	_, err := GetImageDigest(context.Background(), host+"/hyperledger/firefly:missing", InsecureRegistry())
+	assert.True(t, errors.Is(err, ErrImageNotFound), err)
+	_, err = GetImageConfig(context.Background(), host+"/hyperledger/firefly:missing", InsecureRegistry())
+	assert.True(t, errors.Is(err, ErrImageNotFound), err)
+
+	server.Close()
+	_, err = GetImageDigest(context.Background(), host+"/hyperledger/firefly:missing", InsecureRegistry())
+	assert.True(t, errors.Is(err, ErrRegistryUnreachable), err)
+	assert.Regexp(t, "^registry unreachable: "+regexp.QuoteMeta(host), err)
+}
+
+func TestGetImageConfigCached(t *testing.T) {
+	defer func(config func(string, ...crane.Option) ([]byte, error), cache *imageConfigCache) {
+		craneConfig, imageConfigs = config, cache
+	}(craneConfig, imageConfigs)
+	imageConfigs = newImageConfigCache()
+
+	var calls atomic.Int32
+	craneConfig = func(image string, options ...crane.Option) ([]byte, error) {
+		calls.Add(1)
+		if strings.Contains(image, "missing") {
+			return nil, &transport.Error{StatusCode: http.StatusUnauthorized}
+		}
+		return []byte(`{"config":{"Labels":{"org.opencontainers.image.version":"1.3.0"}}}`), nil
+	}
+
+	// The short and fully qualified references of an image share the same cached config
+	_, err := GetImageConfig(context.Background(), "hyperledger/firefly:v1.3.0")
+	assert.NoError(t, err)
+	version, err := GetImageLabel(context.Background(), "docker.io/hyperledger/firefly:v1.3.0", "org.opencontainers.image.version")
+	assert.NoError(t, err)
+	assert.Equal(t, "1.3.0", version)
+	assert.Equal(t, int32(1), calls.Load())
+
+	// A different tag or digest is another image
+	_, err = GetImageConfig(context.Background(), "hyperledger/firefly@sha256:1111111111111111111111111111111111111111111111111111111111111111")
+	assert.NoError(t, err)
+	assert.Equal(t, int32(2), calls.Load())
+
+	// The same image fetched for another platform, with other credentials or from an insecure registry is
+	// queried separately, and each is cached in turn
+	arm64 := WithPlatform(&v1.Platform{OS: "linux", Architecture: "arm64"})
+	basic := WithRegistryAuth(&authn.Basic{Username: "user", Password: "secret"})
+	for _, options := range [][]RegistryOption{{arm64}, {basic}, {arm64, basic}, {InsecureRegistry()}} {
+		_, err = GetImageConfig(context.Background(), "hyperledger/firefly:v1.3.0", options...)
+		assert.NoError(t, err)
+		_, err = GetImageConfig(context.Background(), "hyperledger/firefly:v1.3.0", options...)
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, int32(6), calls.Load())
+
+	// Credentials are told apart by what they authorize, not by the value that holds them
+	_, err = GetImageConfig(context.Background(), "hyperledger/firefly:v1.3.0", WithRegistryAuth(&authn.Basic{Username: "user", Password: "other"}))
+	assert.NoError(t, err)
+	_, err = GetImageConfig(context.Background(), "hyperledger/firefly:v1.3.0", WithRegistryAuth(&authn.Basic{Username: "user", Password: "secret"}))
+	assert.NoError(t, err)
+	assert.Equal(t, int32(7), calls.Load())
+
+	// Concurrent lookups are safe, and share the cached config
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := GetImageConfig(context.Background(), "hyperledger/firefly:v1.3.0")
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(7), calls.Load())
+
+	// Failures are not cached
+	_, err = GetImageConfig(context.Background(), "hyperledger/firefly:missing")
+	assert.Error(t, err)
+	_, err = GetImageConfig(context.Background(), "hyperledger/firefly:missing")
+	assert.Error(t, err)
+	assert.Equal(t, int32(9), calls.Load())
+}
+
+func TestExecRunnerRunWithInput(t *testing.T) {
+	// The command echoes its stdin, so that what is piped to it comes back as its output
+	input := "line one\nline two\n\x00\xff"
+	output, err := ExecRunner{}.RunWithInput(newTestContext(), "", strings.NewReader(input), "/bin/sh", "-c", "cat")
+	assert.NoError(t, err)
+	assert.Equal(t, input, output)
+
+	// A failed command returns its output along with the error, as the other commands do
+	output, err = ExecRunner{}.RunWithInput(newTestContext(), "", strings.NewReader(input), "/bin/sh", "-c", "cat > /dev/null; echo 'Error response from daemon: failed' >&2; exit 3")
+	assert.EqualError(t, err, "exit status 3")
+	assert.Equal(t, "Error response from daemon: failed\n", output)
+}
+
+func TestExecRunnerOutput(t *testing.T) {
+	// The messages docker writes to stderr, such as those of pulling an image, are not part of the output
+	output, err := ExecRunner{}.Output(newTestContext(), "", "/bin/sh", "-c", "echo 'Unable to find image' >&2; printf 'keystore\\n'")
+	assert.NoError(t, err)
+	assert.Equal(t, "keystore\n", output)
+
+	output, err = ExecRunner{}.Output(newTestContext(), "", "/bin/sh", "-c", "printf partial; echo 'No such file' >&2; exit 1")
+	assert.EqualError(t, err, "No such file")
+	assert.Equal(t, "partial", output)
+}
+
+func TestCommandRunner(t *testing.T) {
+	runner := &dockertest.Runner{Outputs: map[string]string{
+		"docker volume ls --quiet --filter name=^stack_existing$": "stack_existing\n",
+	}}
+	ctx := WithCommandRunner(newTestContext(), runner)
+
+	assert.NoError(t, CreateVolume(ctx, "stack_geth", StackLabel("stack")))
+	assert.NoError(t, CreateVolume(ctx, "stack_existing", StackLabel("stack")))
+	assert.NoError(t, CopyFileToVolume(ctx, "stack_geth", "/tmp/genesis.json", "/genesis.json"))
+	assert.NoError(t, FollowLogs(ctx, "stack_geth", &strings.Builder{}, &FollowLogsOptions{Tail: 10}))
+	for _, version := range []DockerComposeVersion{ComposeV1, ComposeV2} {
+		assert.NoError(t, ComposeStop(context.WithValue(ctx, CtxComposeVersionKey{}, version), "/stacks/stack", time.Minute))
+	}
+
+	assert.Equal(t, []string{
+		"docker volume ls --quiet --filter name=^stack_geth$",
+		"docker volume create --label io.hyperledger.firefly-cli.stack=stack stack_geth",
+		"docker volume ls --quiet --filter name=^stack_existing$",
+		"docker run --rm --mount type=bind,source=/tmp/genesis.json,target=/source/genesis.json -v stack_geth:/dest alpine /bin/sh -c cp -R /source/genesis.json /dest/genesis.json && chgrp -R 0 /dest/genesis.json && chmod -R g+rwX /dest/genesis.json",
+		"docker logs --follow --tail 10 stack_geth",
+		"docker-compose stop -t 60",
+		"docker compose stop -t 60",
+	}, runner.Commands)
+}
+
+func TestFollowLogsOptions(t *testing.T) {
+	runner := &dockertest.Runner{}
+	ctx := WithCommandRunner(newTestContext(), runner)
+
+	// The zero value of the options shows all of the existing logs, as nil options do
+	assert.NoError(t, FollowLogs(ctx, "stack_geth", &strings.Builder{}, nil))
+	assert.NoError(t, FollowLogs(ctx, "stack_geth", &strings.Builder{}, &FollowLogsOptions{}))
+	assert.NoError(t, FollowLogs(ctx, "stack_geth", &strings.Builder{}, &FollowLogsOptions{Tail: -1}))
+	assert.NoError(t, FollowLogs(ctx, "stack_geth", &strings.Builder{}, &FollowLogsOptions{Since: 5 * time.Minute, Tail: 100}))
+
+	assert.Equal(t, []string{
+		"docker logs --follow stack_geth",
+		"docker logs --follow stack_geth",
+		"docker logs --follow stack_geth",
+		"docker logs --follow --since 5m0s --tail 100 stack_geth",
+	}, runner.Commands)
+}
+
+func TestSetCommandRunner(t *testing.T) {
+	runner := &dockertest.Runner{}
+	previous := SetCommandRunner(runner)
+	defer SetCommandRunner(previous)
+	assert.Equal(t, ExecRunner{}, previous)
+
+	assert.NoError(t, RunDockerCommand(newTestContext(), ".", "ps"))
+	// A runner in the context takes precedence over the package level one
+	contextRunner := &dockertest.Runner{}
+	assert.NoError(t, RunDockerCommand(WithCommandRunner(newTestContext(), contextRunner), ".", "ps", "-a"))
+	// Dry run mode prints the commands without running them at all
+	out := &strings.Builder{}
+	assert.NoError(t, RunDockerCommand(WithDryRun(newTestContext(), out), ".", "volume", "ls"))
+
+	assert.Equal(t, []string{"docker ps"}, runner.Commands)
+	assert.Equal(t, []string{"docker ps -a"}, contextRunner.Commands)
+	assert.Equal(t, "docker volume ls\n", out.String())
+}
+
+func TestIsPortAvailable(t *testing.T) {
+	listener, err := net.Listen("tcp", ":0")
+	assert.NoError(t, err)
+	port := listener.Addr().(*net.TCPAddr).Port
+
+	assert.False(t, IsPortAvailable(port))
+	listener.Close()
+	assert.True(t, IsPortAvailable(port))
+}
+
+func TestCheckDockerAvailable(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+		err    error
+		result error
+	}{
+		{name: "available", output: "Server Version: 27.1.1\n"},
+		{name: "not installed", err: &exec.Error{Name: "docker", Err: exec.ErrNotFound}, result: errDockerNotInstalled},
+		{
+			name:   "not running",
+			err:    fmt.Errorf("docker info [1] Cannot connect to the Docker daemon at unix:///var/run/docker.sock. Is the docker daemon running?"),
+			result: errDockerNotRunning,
+		},
+		{
+			name:   "docker desktop not running",
+			err:    fmt.Errorf("docker info [1] error during connect: Get \"http://%%2F%%2F.%%2Fpipe%%2FdockerDesktopLinuxEngine/v1.46/info\": open //./pipe/dockerDesktopLinuxEngine: The system cannot find the file specified."),
+			result: errDockerNotRunning,
+		},
+		{
+			name:   "permission denied",
+			err:    fmt.Errorf("docker info [1] permission denied while trying to connect to the Docker daemon socket at unix:///var/run/docker.sock"),
+			result: errDockerPermissionDenied,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			runner := &dockertest.Runner{
+				Outputs: map[string]string{"docker info": test.output},
+				Errors:  map[string]error{"docker info": test.err},
+			}
+			err := CheckDockerAvailable(WithCommandRunner(context.Background(), runner))
+			assert.Equal(t, test.result, err)
+			assert.Equal(t, []string{"docker info"}, runner.Commands)
+		})
+	}
+
+	runner := &dockertest.Runner{Errors: map[string]error{"docker info": fmt.Errorf("docker info [1] unexpected failure")}}
+	err := CheckDockerAvailable(WithCommandRunner(context.Background(), runner))
+	assert.Regexp(t, "an error occurred while running docker: docker info \\[1\\] unexpected failure", err)
+}
+
+func TestIsDaemonUnavailable(t *testing.T) {
+	assert.False(t, IsDaemonUnavailable(nil))
+	assert.True(t, IsDaemonUnavailable(fmt.Errorf("docker volume inspect [1] Cannot connect to the Docker daemon at unix:///var/run/docker.sock. Is the docker daemon running?")))
+	assert.True(t, IsDaemonUnavailable(fmt.Errorf("docker ps [1] error during connect: this error may indicate that the docker daemon is not running")))
+	_, err := ExecRunner{}.Run(context.Background(), "", "firefly-cli-no-such-command")
+	assert.True(t, IsDaemonUnavailable(err))
+	assert.False(t, IsDaemonUnavailable(fmt.Errorf("docker volume create [1] Error response from daemon: volume name is invalid")))
+}
+
+func TestExecRunnerCommandNotFound(t *testing.T) {
+	_, err := ExecRunner{}.Run(context.Background(), "", "firefly-cli-no-such-command")
+	assert.ErrorIs(t, err, exec.ErrNotFound)
+	err = ExecRunner{}.Follow(context.Background(), io.Discard, "firefly-cli-no-such-command")
+	assert.ErrorIs(t, err, exec.ErrNotFound)
+}
+
+func TestOfflineImageMetadata(t *testing.T) {
+	defer func(config func(string, ...crane.Option) ([]byte, error), digest func(string, ...crane.Option) (string, error)) {
+		craneConfig, craneDigest = config, digest
+	}(craneConfig, craneDigest)
+	craneConfig = func(image string, options ...crane.Option) ([]byte, error) {
+		t.Fatalf("registry queried for the config of %s", image)
+		return nil, nil
+	}
+	craneDigest = func(image string, options ...crane.Option) (string, error) {
+		t.Fatalf("registry queried for the digest of %s", image)
+		return "", nil
+	}
+
+	geth := "ethereum/client-go:v1.14.0"
+	runner := &dockertest.Runner{
+		Outputs: map[string]string{
+			"docker image inspect --format {{json .Config}} " + geth:      `{"Labels":{"org.opencontainers.image.version":"1.14.0"}}`,
+			"docker image inspect --format {{json .RepoDigests}} " + geth: `["registry.internal/ethereum/client-go@sha256:1111111111111111111111111111111111111111111111111111111111111111","ethereum/client-go@sha256:2222222222222222222222222222222222222222222222222222222222222222"]`,
+			"docker image inspect --format {{json .Config}} firefly":      `{"Labels":null}`,
+			"docker image inspect --format {{json .RepoDigests}} firefly": `[]`,
+		},
+		Errors: map[string]error{
+			"docker image inspect --format {{json .Config}} missing:latest":      fmt.Errorf("docker image inspect [1] Error: No such image: missing:latest"),
+			"docker image inspect --format {{json .RepoDigests}} missing:latest": fmt.Errorf("docker image inspect [1] Error: No such image: missing:latest"),
+		},
+	}
+	ctx := WithOffline(WithCommandRunner(context.Background(), runner))
+
+	version, err := GetImageLabel(ctx, geth, "org.opencontainers.image.version")
+	assert.NoError(t, err)
+	assert.Equal(t, "1.14.0", version)
+	// The digest is the one the image was pulled from its own repository with
+	digest, err := GetImageDigest(ctx, geth)
+	assert.NoError(t, err)
+	assert.Equal(t, "sha256:2222222222222222222222222222222222222222222222222222222222222222", digest)
+
+	// A locally built image has no labels and no registry digest
+	version, err = GetImageLabel(ctx, "firefly", "org.opencontainers.image.version")
+	assert.NoError(t, err)
+	assert.Empty(t, version)
+	digest, err = GetImageDigest(ctx, "firefly")
+	assert.NoError(t, err)
+	assert.Empty(t, digest)
+
+	_, err = GetImageLabel(ctx, "missing:latest", "org.opencontainers.image.version")
+	assert.ErrorIs(t, err, ErrImageNotAvailableLocally)
+	_, err = GetImageDigest(ctx, "missing:latest")
+	assert.ErrorIs(t, err, ErrImageNotAvailableLocally)
+}
+
+// concurrentPulls is a docker.CommandRunner that records the most pulls it has had running at once
+type concurrentPulls struct {
+	dockertest.Runner
+	running    int32
+	maxRunning int32
+}
+
+func (r *concurrentPulls) Run(ctx context.Context, workingDir string, name string, args ...string) (string, error) {
+	if args[0] == "pull" {
+		running := atomic.AddInt32(&r.running, 1)
+		defer atomic.AddInt32(&r.running, -1)
+		for max := atomic.LoadInt32(&r.maxRunning); running > max && !atomic.CompareAndSwapInt32(&r.maxRunning, max, running); {
+			max = atomic.LoadInt32(&r.maxRunning)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return r.Runner.Run(ctx, workingDir, name, args...)
+}
+
+func TestPullImages(t *testing.T) {
+	images := []string{"good:1", "bad:1", "good:2", "bad:2", "good:3", "good:4"}
+	runner := &concurrentPulls{Runner: dockertest.Runner{Errors: map[string]error{
+		"docker pull bad:1": fmt.Errorf("docker pull bad:1 [1] Error response from daemon: manifest unknown"),
+		"docker pull bad:2": fmt.Errorf("docker pull bad:2 [1] Error response from daemon: toomanyrequests"),
+	}}}
+	for _, image := range images {
+		runner.Errors["docker image inspect --format {{.Id}} "+image] = fmt.Errorf("Error: No such image: %s", image)
+	}
+	ctx := WithCommandRunner(newTestContext(), runner)
+
+	err := PullImages(ctx, images, 2)
+	assert.EqualError(t, err, "failed to pull the images of the stack:\n"+
+		"failed to pull image 'bad:1': docker pull bad:1 [1] Error response from daemon: manifest unknown\n"+
+		"failed to pull image 'bad:2': docker pull bad:2 [1] Error response from daemon: toomanyrequests")
+	for _, image := range images {
+		assert.Contains(t, runner.Commands, "docker pull "+image)
+	}
+	assert.Equal(t, int32(2), runner.maxRunning)
+
+	// Once cancelled, no more pulls are started
+	runner.Commands = nil
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	err = PullImages(cancelled, images, 2)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.EqualError(t, err, "failed to pull the images of the stack:\ncontext canceled")
+	assert.Empty(t, runner.Commands)
+
+	assert.NoError(t, PullImages(ctx, []string{"good:1"}, 0))
+}
+
+func TestPullImagesRetry(t *testing.T) {
+	runner := &dockertest.Runner{Errors: map[string]error{
+		"docker image inspect --format {{.Id}} flaky:1":   fmt.Errorf("Error: No such image: flaky:1"),
+		"docker image inspect --format {{.Id}} missing:1": fmt.Errorf("Error: No such image: missing:1"),
+		"docker pull flaky:1":                             fmt.Errorf("docker pull flaky:1 [1] Error response from daemon: toomanyrequests"),
+		"docker pull missing:1":                           fmt.Errorf("docker pull missing:1 [1] Error response from daemon: manifest unknown"),
+	}}
+	ctx := WithCommandRunner(newTestContext(), runner)
+	pulls := func() int {
+		count := 0
+		for _, command := range runner.Commands {
+			if strings.HasPrefix(command, "docker pull ") {
+				count++
+			}
+		}
+		return count
+	}
+
+	// A pull that keeps failing is retried until the retries run out
+	assert.Regexp(t, "toomanyrequests", PullImagesRetry(ctx, []string{"local:1", "flaky:1"}, 1, 2, time.Millisecond))
+	assert.Equal(t, 3, pulls())
+
+	// A permanent failure is never retried
+	runner.Commands = nil
+	assert.Regexp(t, "manifest unknown", PullImagesRetry(ctx, []string{"flaky:1", "missing:1"}, 1, 2, time.Millisecond))
+	assert.Equal(t, 2, pulls())
+
+	assert.NoError(t, PullImagesRetry(ctx, []string{"local:1"}, 1, 2, time.Millisecond))
+}
+
+func TestOfflineNeverPulls(t *testing.T) {
+	runner := &dockertest.Runner{
+		Errors: map[string]error{
+			"docker image inspect --format {{.Id}} missing:latest": fmt.Errorf("docker image inspect [1] Error: No such image: missing:latest"),
+		},
+	}
+	ctx := WithOffline(WithCommandRunner(context.Background(), runner))
+
+	err := PullImage(ctx, "missing:latest")
+	assert.ErrorIs(t, err, ErrImageNotAvailableLocally)
+	assert.Regexp(t, "image not available locally: missing:latest", err)
+	assert.NoError(t, PullImage(ctx, "present:latest"))
+	assert.NoError(t, RunDockerCommand(ctx, ".", "run", "--rm", "present:latest", "version"))
+
+	assert.Equal(t, []string{
+		"docker image inspect --format {{.Id}} missing:latest",
+		"docker image inspect --format {{.Id}} present:latest",
+		"docker run --pull=never --rm present:latest version",
+	}, runner.Commands)
+}
+
+func TestValidateCompose(t *testing.T) {
+	runner := &dockertest.Runner{}
+	ctx := WithCommandRunner(newTestContext(), runner)
+	for _, version := range []DockerComposeVersion{ComposeV1, ComposeV2} {
+		assert.NoError(t, ValidateCompose(context.WithValue(ctx, CtxComposeVersionKey{}, version), "/stacks/stack"))
+	}
+	assert.Equal(t, []string{"docker-compose config -q", "docker compose config -q"}, runner.Commands)
+}
+
+func TestValidateComposeInvalid(t *testing.T) {
+	runner := &dockertest.Runner{Errors: map[string]error{
+		"docker compose config -q": fmt.Errorf("docker compose config -q [15] " +
+			"time=\"2024-05-01T10:00:00Z\" level=warning msg=\"/stacks/stack/docker-compose.yml: `version` is obsolete\"\n" +
+			"validating /stacks/stack/docker-compose.yml: services.ethsigner additional properties 'imagee' not allowed\n"),
+	}}
+	ctx := context.WithValue(WithCommandRunner(newTestContext(), runner), CtxComposeVersionKey{}, ComposeV2)
+
+	err := ValidateCompose(ctx, "/stacks/stack")
+	assert.EqualError(t, err, "invalid docker compose file in '/stacks/stack': validating /stacks/stack/docker-compose.yml: services.ethsigner additional properties 'imagee' not allowed")
+}
+
+func TestValidateComposeNotInstalled(t *testing.T) {
+	defer stubComposeVersionProbe(func(name string, args ...string) error {
+		return fmt.Errorf("not installed")
+	})()
+	runner := &dockertest.Runner{}
+	ctx := context.WithValue(WithCommandRunner(newTestContext(), runner), CtxComposeVersionKey{}, None)
+
+	err := ValidateCompose(ctx, "/stacks/stack")
+	assert.Equal(t, errComposeNotInstalled, err)
+	assert.Empty(t, runner.Commands)
+}
+
+func TestTrackStackImages(t *testing.T) {
+	stacksDir := constants.StacksDir
+	defer func() { constants.StacksDir = stacksDir }()
+	constants.StacksDir = t.TempDir()
+	assert.NoError(t, os.MkdirAll(filepath.Join(constants.StacksDir, "stack1"), 0755))
+
+	images, err := StackImages("stack1")
+	assert.NoError(t, err)
+	assert.Empty(t, images)
+
+	assert.NoError(t, TrackStackImages("stack1", "ghcr.io/hyperledger/firefly:v1.3.0", "postgres"))
+	// The images of an upgraded stack are added to the ones it used before
+	assert.NoError(t, TrackStackImages("stack1", "ghcr.io/hyperledger/firefly:v1.3.1", "postgres", ""))
+	images, err = StackImages("stack1")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"ghcr.io/hyperledger/firefly:v1.3.0", "ghcr.io/hyperledger/firefly:v1.3.1", "postgres"}, images)
+	info, err := os.Stat(filepath.Join(constants.StacksDir, "stack1", stackImagesFile))
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0644), info.Mode().Perm())
+
+	assert.NoError(t, os.WriteFile(filepath.Join(constants.StacksDir, "stack1", stackImagesFile), []byte("{"), 0755))
+	_, err = StackImages("stack1")
+	assert.Regexp(t, "invalid image list for stack 'stack1'", err)
+}
+
+func TestRemoveUnusedStackImages(t *testing.T) {
+	stacksDir := constants.StacksDir
+	defer func() { constants.StacksDir = stacksDir }()
+	constants.StacksDir = t.TempDir()
+	for _, stackName := range []string{"stack1", "stack2"} {
+		assert.NoError(t, os.MkdirAll(filepath.Join(constants.StacksDir, stackName), 0755))
+	}
+	assert.NoError(t, TrackStackImages("stack1", "ghcr.io/hyperledger/firefly:v1.3.0", "ghcr.io/hyperledger/firefly:v1.3.1", "postgres", "ipfs/kubo", "alpine"))
+	assert.NoError(t, TrackStackImages("stack2", "ghcr.io/hyperledger/firefly:v1.3.1", "postgres"))
+
+	runner := &dockertest.Runner{Errors: map[string]error{
+		"docker image rm alpine":    fmt.Errorf("docker image rm alpine [1] Error response from daemon: conflict: unable to remove repository reference \"alpine\" (must force) - container 6f2a is using its referenced image 1d34"),
+		"docker image rm ipfs/kubo": fmt.Errorf("docker image rm ipfs/kubo [1] Error response from daemon: No such image: ipfs/kubo:latest"),
+	}}
+	ctx := WithCommandRunner(newTestContext(), runner)
+	assert.NoError(t, RemoveUnusedStackImages(ctx, "stack1"))
+	// The images that stack2 also uses are preserved
+	assert.Equal(t, []string{
+		"docker image rm alpine",
+		"docker image rm ghcr.io/hyperledger/firefly:v1.3.0",
+		"docker image rm ipfs/kubo",
+	}, runner.Commands)
+
+	// Once stack1 has gone, the images of stack2 are no longer shared
+	assert.NoError(t, os.RemoveAll(filepath.Join(constants.StacksDir, "stack1")))
+	runner = &dockertest.Runner{Errors: map[string]error{
+		"docker image rm postgres": fmt.Errorf("docker image rm postgres [1] Cannot connect to the Docker daemon"),
+	}}
+	err := RemoveUnusedStackImages(WithCommandRunner(newTestContext(), runner), "stack2")
+	assert.Regexp(t, "failed to remove image 'postgres'", err)
+}
+
+func TestExec(t *testing.T) {
+	runner := &dockertest.Runner{
+		Outputs: map[string]string{
+			"docker exec stack_ethsigner ls /data/keystore": "1f8ad7b4ea06e3a4cd2a6ae7ef2e3c78a7a6bd2c\n",
+		},
+		Errors: map[string]error{
+			"docker exec stack_geth ls":               fmt.Errorf("docker exec stack_geth ls [1] Error response from daemon: container 6f2a1c is not running"),
+			"docker exec stack_gone ls":               fmt.Errorf("docker exec stack_gone ls [1] Error response from daemon: No such container: stack_gone"),
+			"docker exec stack_ethsigner ls /missing": fmt.Errorf("docker exec stack_ethsigner ls /missing [2] ls: /missing: No such file or directory"),
+		},
+	}
+	ctx := WithCommandRunner(newTestContext(), runner)
+
+	output, err := Exec(ctx, "stack_ethsigner", "ls", "/data/keystore")
+	assert.NoError(t, err)
+	assert.Equal(t, "1f8ad7b4ea06e3a4cd2a6ae7ef2e3c78a7a6bd2c\n", output)
+
+	_, err = Exec(ctx, "stack_geth", "ls")
+	assert.ErrorIs(t, err, ErrContainerNotRunning)
+	_, err = Exec(ctx, "stack_gone", "ls")
+	assert.ErrorIs(t, err, ErrContainerNotFound)
+	// A command that fails inside the container is reported as it is
+	_, err = Exec(ctx, "stack_ethsigner", "ls", "/missing")
+	assert.Regexp(t, "No such file or directory", err)
+	assert.NotErrorIs(t, err, ErrContainerNotRunning)
+}
+
+func TestExecInteractive(t *testing.T) {
+	runner := &dockertest.Runner{Outputs: map[string]string{
+		"docker inspect --type container --format " + containerStateFormat + " stack_ethsigner": "running||0\n",
+		"docker inspect --type container --format " + containerStateFormat + " stack_geth":      "exited||137\n",
+	}}
+	ctx := WithCommandRunner(newTestContext(), runner)
+
+	assert.NoError(t, ExecInteractive(ctx, "stack_ethsigner", "sh"))
+	err := ExecInteractive(ctx, "stack_geth", "sh")
+	assert.ErrorIs(t, err, ErrContainerNotRunning)
+	assert.Regexp(t, "'stack_geth' is exited", err)
+	assert.Equal(t, []string{
+		"docker inspect --type container --format " + containerStateFormat + " stack_ethsigner",
+		"docker exec -it stack_ethsigner sh",
+		"docker inspect --type container --format " + containerStateFormat + " stack_geth",
+	}, runner.Commands)
+
+	// Dry run mode prints the command without checking the container
+	out := &strings.Builder{}
+	assert.NoError(t, ExecInteractive(WithDryRun(newTestContext(), out), "stack_ethsigner", "sh", "-l"))
+	assert.Equal(t, "docker exec -it stack_ethsigner sh -l\n", out.String())
+}
+
+func TestMarshalAnchored(t *testing.T) {
+	env := func() map[string]interface{} { return map[string]interface{}{"LOG_LEVEL": "info"} }
+	compose := &DockerComposeConfig{
+		Version: "2.2",
+		Services: map[string]*Service{
+			"dataexchange_0": {Image: "dx", Logging: StandardLogOptions, Restart: "unless-stopped", Environment: env()},
+			"dataexchange_1": {Image: "dx", Logging: StandardLogOptions, Restart: "unless-stopped", Environment: env()},
+			"ethsigner":      {Image: "signer", Logging: StandardLogOptions, Restart: "on-failure:3"},
+			"postgres_0":     {Image: "postgres", Restart: "unless-stopped", Environment: map[string]interface{}{"PGDATA": "/data"}},
+		},
+		Volumes: map[string]*Volume{"postgres_0": {}},
+	}
+
+	expanded, err := yaml.Marshal(compose)
+	assert.NoError(t, err)
+	anchored, err := compose.MarshalAnchored()
+	assert.NoError(t, err)
+	assert.Contains(t, string(anchored), "x-logging: &logging\n")
+	assert.Contains(t, string(anchored), "x-restart: &restart unless-stopped\n")
+	assert.Contains(t, string(anchored), "x-environment: &environment\n")
+	assert.Contains(t, string(anchored), "logging: *logging\n")
+	// A value that no other service shares is left as it is
+	assert.Contains(t, string(anchored), "restart: on-failure:3\n")
+	assert.Less(t, len(anchored), len(expanded))
+
+	// Once the aliases are expanded and the extension fields dropped, both files are the same
+	var expandedDoc, anchoredDoc map[string]interface{}
+	assert.NoError(t, yaml.Unmarshal(expanded, &expandedDoc))
+	assert.NoError(t, yaml.Unmarshal(anchored, &anchoredDoc))
+	for _, field := range anchoredServiceFields {
+		assert.Contains(t, anchoredDoc, "x-"+field)
+		delete(anchoredDoc, "x-"+field)
+	}
+	assert.Equal(t, expandedDoc, anchoredDoc)
+
+	// The compose definition itself reads back the same way
+	var decoded DockerComposeConfig
+	assert.NoError(t, yaml.Unmarshal(anchored, &decoded))
+	assert.Equal(t, "on-failure:3", decoded.Services["ethsigner"].Restart)
+	assert.Equal(t, StandardLogOptions, decoded.Services["dataexchange_1"].Logging)
+}
+
+const psRunning = `{"Command":"\"/bin/sh -c '/firefly…\"","CreatedAt":"2024-05-02 10:15:04 +0100 BST","ID":"3f4e1b2a9c8d","Image":"ghcr.io/hyperledger/firefly:v1.3.0","Labels":"com.docker.compose.project=stack_a,com.docker.compose.service=firefly_core_0","LocalVolumes":"1","Mounts":"stack_a_fire…","Names":"stack_a_firefly_core_0","Networks":"stack_a_default","Ports":"0.0.0.0:5000->5000/tcp, :::5000->5000/tcp","RunningFor":"2 minutes ago","Size":"0B","State":"running","Status":"Up 2 minutes (healthy)"}`
+
+func TestParsePSContainer(t *testing.T) {
+	container, project, err := parsePSContainer(psRunning)
+	assert.NoError(t, err)
+	assert.Equal(t, "stack_a", project)
+	assert.Equal(t, ContainerInfo{
+		ID:     "3f4e1b2a9c8d",
+		Name:   "stack_a_firefly_core_0",
+		Image:  "ghcr.io/hyperledger/firefly:v1.3.0",
+		State:  "running",
+		Status: "Up 2 minutes (healthy)",
+		Ports:  []string{"0.0.0.0:5000->5000/tcp", ":::5000->5000/tcp"},
+		Health: HealthHealthy,
+	}, container)
+
+	container, project, err = parsePSContainer(`{"ID":"a1","Image":"alpine","Names":"stack_a_tmp","Ports":"","State":"exited","Status":"Exited (137) 5 seconds ago"}`)
+	assert.NoError(t, err)
+	assert.Empty(t, project)
+	assert.Equal(t, []string{}, container.Ports)
+	assert.Empty(t, container.Health)
+
+	container, _, err = parsePSContainer(`{"Names":"stack_a_ethsigner","State":"running","Status":"Up 3 seconds (health: starting)"}`)
+	assert.NoError(t, err)
+	assert.Equal(t, HealthStarting, container.Health)
+
+	container, _, err = parsePSContainer(`{"Names":"stack_a_ethsigner","State":"running","Status":"Up 15 minutes (unhealthy)"}`)
+	assert.NoError(t, err)
+	assert.Equal(t, HealthUnhealthy, container.Health)
+
+	_, _, err = parsePSContainer(`stack_a_firefly_core_0`)
+	assert.Regexp(t, "unexpected docker ps output 'stack_a_firefly_core_0'", err)
+	_, _, err = parsePSContainer(`{"ID":"a1"}`)
+	assert.Regexp(t, "no container name", err)
+}
+
+func TestListStackContainers(t *testing.T) {
+	command := "docker ps --all --filter name=^stack_a_ --format {{json .}}"
+
+	runner := &dockertest.Runner{Outputs: map[string]string{command: ""}}
+	containers, err := ListStackContainers(WithCommandRunner(newTestContext(), runner), "stack_a")
+	assert.NoError(t, err)
+	assert.Equal(t, []ContainerInfo{}, containers)
+
+	runner = &dockertest.Runner{Outputs: map[string]string{command: strings.Join([]string{
+		psRunning,
+		`not json`,
+		`{"ID":"b2","Image":"postgres","Labels":"com.docker.compose.project=stack_a_2","Names":"stack_a_2_postgres_0","State":"running","Status":"Up 1 minute"}`,
+		`{"ID":"c3","Image":"postgres","Labels":"com.docker.compose.project=stack_a","Names":"stack_a_postgres_0","State":"exited","Status":"Exited (0) 1 minute ago"}`,
+		``,
+	}, "\n")}}
+	containers, err = ListStackContainers(WithCommandRunner(newTestContext(), runner), "stack_a")
+	assert.NoError(t, err)
+	assert.Len(t, containers, 2)
+	assert.Equal(t, "stack_a_firefly_core_0", containers[0].Name)
+	assert.Equal(t, "stack_a_postgres_0", containers[1].Name)
+	assert.Equal(t, "exited", containers[1].State)
+
+	runner = &dockertest.Runner{Errors: map[string]error{command: fmt.Errorf("Cannot connect to the Docker daemon")}}
+	_, err = ListStackContainers(WithCommandRunner(newTestContext(), runner), "stack_a")
+	assert.Regexp(t, "Cannot connect to the Docker daemon", err)
+}
+
+// fakeHost is a HostInspector with the given free disk space and ports in use
+type fakeHost struct {
+	free    uint64
+	freeErr error
+	inUse   map[int]bool
+}
+
+func (h *fakeHost) FreeDiskSpace(path string) (uint64, error) {
+	return h.free, h.freeErr
+}
+
+func (h *fakeHost) IsPortAvailable(port int) bool {
+	return !h.inUse[port]
+}
+
+func TestDoctor(t *testing.T) {
+	runner := &dockertest.Runner{Outputs: map[string]string{
+		"docker --version":                        "Docker version 25.0.3, build 4debf41\n",
+		"docker info --format {{.DockerRootDir}}": "/var/lib/docker\n",
+	}}
+	ctx := WithCommandRunner(newTestContext(), runner)
+	ctx = WithHostInspector(ctx, &fakeHost{free: 2 << 30, inUse: map[int]bool{5001: true, 5100: true}})
+	ctx = context.WithValue(ctx, CtxComposeVersionKey{}, ComposeV1)
+
+	report, err := Doctor(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, []CheckResult{
+		{Name: "docker installed", Status: CheckPassed, Detail: "Docker version 25.0.3, build 4debf41"},
+		{Name: "docker daemon reachable", Status: CheckPassed},
+		{
+			Name:        "docker compose installed",
+			Status:      CheckWarning,
+			Detail:      "docker-compose (v1)",
+			Remediation: "The standalone docker-compose is no longer maintained, and some stack options need the Docker Compose plugin. Install it by following https://docs.docker.com/compose/install/",
+		},
+		{
+			Name:        "disk space for images",
+			Status:      CheckFailed,
+			Detail:      "2.0 GiB free in '/var/lib/docker'",
+			Remediation: "Free up at least 10.0 GiB for docker, for example by removing unused images with 'docker image prune'",
+		},
+		{
+			Name:        "ports free",
+			Status:      CheckFailed,
+			Detail:      "in use: 5001, 5100",
+			Remediation: "Stop whatever is listening on them, or pass other base ports to 'ff init' with --firefly-base-port and --services-base-port",
+		},
+	}, report.Checks)
+	assert.Len(t, report.Failed(), 2)
+}
+
+func TestDoctorDockerUnavailable(t *testing.T) {
+	defer stubComposeVersionProbe(func(name string, args ...string) error { return exec.ErrNotFound })()
+	runner := &dockertest.Runner{Errors: map[string]error{
+		"docker --version": fmt.Errorf("exec: \"docker\": %w", exec.ErrNotFound),
+	}}
+	ctx := WithHostInspector(WithCommandRunner(newTestContext(), runner), &fakeHost{free: 100 << 30})
+
+	report, err := Doctor(ctx)
+	assert.NoError(t, err)
+	statuses := map[string]CheckStatus{}
+	for _, check := range report.Checks {
+		statuses[check.Name] = check.Status
+	}
+	assert.Equal(t, map[string]CheckStatus{
+		"docker installed":         CheckFailed,
+		"docker daemon reachable":  CheckFailed,
+		"docker compose installed": CheckFailed,
+		"disk space for images":    CheckFailed,
+		"ports free":               CheckPassed,
+	}, statuses)
+	assert.Equal(t, "Install Docker by following https://docs.docker.com/get-docker/", report.Checks[0].Remediation)
+	// Nothing that needs docker is run once it is known not to be installed
+	assert.Equal(t, []string{"docker --version"}, runner.Commands)
+
+	// A daemon that is not running is reported with how to start it
+	runner = &dockertest.Runner{Errors: map[string]error{
+		"docker info": fmt.Errorf("docker info [1] Cannot connect to the Docker daemon at unix:///var/run/docker.sock. Is the docker daemon running?"),
+	}}
+	report, err = Doctor(WithHostInspector(WithCommandRunner(newTestContext(), runner), &fakeHost{}))
+	assert.NoError(t, err)
+	assert.Equal(t, CheckResult{
+		Name:        "docker daemon reachable",
+		Status:      CheckFailed,
+		Detail:      "the Docker daemon is not running",
+		Remediation: "Start Docker, or Docker Desktop, and try again",
+	}, report.Checks[1])
+
+	cancelled, cancel := context.WithCancel(newTestContext())
+	cancel()
+	_, err = Doctor(cancelled)
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestFormatBytes(t *testing.T) {
+	assert.Equal(t, "512 B", formatBytes(512))
+	assert.Equal(t, "1.5 KiB", formatBytes(1536))
+	assert.Equal(t, "10.0 GiB", formatBytes(MinFreeDiskSpace))
+}
+
+func TestSaveAndLoadImages(t *testing.T) {
+	runner := &dockertest.Runner{}
+	ctx := WithCommandRunner(newTestContext(), runner)
+
+	err := SaveImages(ctx, []string{"alpine", "ghcr.io/hyperledger/firefly-signer:v1.1.0"}, "/tmp/bundle.tar")
+	assert.NoError(t, err)
+	assert.Regexp(t, "no images to save to '/tmp/bundle.tar'", SaveImages(ctx, nil, "/tmp/bundle.tar"))
+
+	bundle := filepath.Join(t.TempDir(), "bundle.tar")
+	assert.Regexp(t, "failed to load images from '.*bundle.tar'", LoadImages(ctx, bundle))
+	assert.NoError(t, os.WriteFile(bundle, []byte("archive"), 0644))
+	assert.NoError(t, LoadImages(ctx, bundle))
+
+	// The archive is piped to docker rather than passed by path
+	assert.Equal(t, []string{
+		"docker save --output /tmp/bundle.tar alpine ghcr.io/hyperledger/firefly-signer:v1.1.0",
+		"docker load",
+	}, runner.Commands)
+	assert.Equal(t, []string{"archive"}, runner.Inputs)
+
+	runner.Errors = map[string]error{"docker load": fmt.Errorf("invalid tar header")}
+	assert.Regexp(t, "failed to load images from '.*bundle.tar': invalid tar header", LoadImages(ctx, bundle))
+}
+
+func TestMissingImages(t *testing.T) {
+	runner := &dockertest.Runner{Errors: map[string]error{
+		"docker image inspect --format {{.Id}} ghcr.io/hyperledger/firefly-signer": fmt.Errorf("No such image"),
+	}}
+	ctx := WithCommandRunner(newTestContext(), runner)
+	assert.Equal(t, []string{"ghcr.io/hyperledger/firefly-signer"}, MissingImages(ctx, []string{"alpine", "ghcr.io/hyperledger/firefly-signer"}))
+	assert.Equal(t, []string{}, MissingImages(ctx, []string{"alpine"}))
+}
+
+func TestSnapshotAndRestoreVolume(t *testing.T) {
+	dir := t.TempDir()
+	snapshot := filepath.Join(dir, "backups", "stack_ethsigner.tar.gz")
+	runner := &dockertest.Runner{Outputs: map[string]string{}}
+	ctx := WithCommandRunner(newTestContext(), runner)
+
+	assert.NoError(t, SnapshotVolume(ctx, "stack_ethsigner", snapshot))
+	assert.DirExists(t, filepath.Dir(snapshot))
+	assert.Regexp(t, "failed to restore volume 'stack_ethsigner' from '.*stack_ethsigner.tar.gz'", RestoreVolume(ctx, "stack_ethsigner", snapshot))
+	assert.NoError(t, os.WriteFile(snapshot, []byte{}, 0600))
+	runner.Outputs["docker volume ls --quiet --filter name=^stack_restored$"] = "stack_restored\n"
+	assert.NoError(t, RestoreVolume(ctx, "stack_restored", snapshot))
+
+	assert.Equal(t, []string{
+		fmt.Sprintf("docker run --rm -v stack_ethsigner:/source:ro --mount type=bind,source=%s,target=/backup alpine /bin/sh -c "+
+			"tar --numeric-owner -czf /backup/.stack_ethsigner.tar.gz.partial -C /source . && chmod 600 /backup/.stack_ethsigner.tar.gz.partial && "+
+			"chown %d:%d /backup/.stack_ethsigner.tar.gz.partial && "+
+			"mv /backup/.stack_ethsigner.tar.gz.partial /backup/stack_ethsigner.tar.gz || { rm -f /backup/.stack_ethsigner.tar.gz.partial; exit 1; }", filepath.Dir(snapshot), os.Getuid(), os.Getgid()),
+		"docker volume ls --quiet --filter name=^stack_restored$",
+		"docker run --rm -v stack_restored:/dest alpine /bin/sh -c if [ -d /dest ]; then ls -1 /dest; fi",
+		fmt.Sprintf("docker run --rm --mount type=bind,source=%s,target=/backup/stack_ethsigner.tar.gz,readonly -v stack_restored:/dest alpine /bin/sh -c "+
+			"tar --numeric-owner -xzpf /backup/stack_ethsigner.tar.gz -C /dest", snapshot),
+	}, runner.Commands)
+
+	// A volume that already holds files is left alone
+	runner.Outputs["docker run --rm -v stack_restored:/dest alpine /bin/sh -c if [ -d /dest ]; then ls -1 /dest; fi"] = "keystore\n"
+	assert.Regexp(t, "the volume is not empty", RestoreVolume(ctx, "stack_restored", snapshot))
+
+	runner.Errors = map[string]error{runner.Commands[0]: fmt.Errorf("exit status 1")}
+	assert.Regexp(t, "failed to snapshot volume 'stack_ethsigner' to '.*': exit status 1", SnapshotVolume(ctx, "stack_ethsigner", snapshot))
+}
+
+func TestSnapshotVolumeRoundTrip(t *testing.T) {
+	// Run the commands of the helper container with the local tar, with the paths of its mounts rewritten to
+	// directories on the host, to check that a snapshot restores the files with their modes
+	if _, err := exec.LookPath("tar"); err != nil {
+		t.Skip("tar is not installed")
+	}
+	dir := t.TempDir()
+	source, backup, dest := filepath.Join(dir, "source"), filepath.Join(dir, "backup"), filepath.Join(dir, "dest")
+	assert.NoError(t, os.MkdirAll(filepath.Join(source, "keystore"), 0700))
+	assert.NoError(t, os.MkdirAll(dest, 0755))
+	assert.NoError(t, os.WriteFile(filepath.Join(source, "keystore", "key"), []byte("secret"), 0600))
+	assert.NoError(t, os.WriteFile(filepath.Join(source, "firefly.ffsigner"), []byte("chainId: 2021"), 0644))
+
+	runner := &dockertest.Runner{}
+	ctx := WithCommandRunner(newTestContext(), runner)
+	assert.NoError(t, SnapshotVolume(ctx, "stack_ethsigner", filepath.Join(backup, "stack_ethsigner.tar.gz")))
+	runShell := func(command string) {
+		script := command[strings.Index(command, "/bin/sh -c ")+len("/bin/sh -c "):]
+		script = strings.NewReplacer("/source", source, "/backup/", backup+"/", "/dest", dest).Replace(script)
+		output, err := exec.Command("/bin/sh", "-c", script).CombinedOutput()
+		assert.NoError(t, err, string(output))
+	}
+	runShell(runner.Commands[0])
+	info, err := os.Stat(filepath.Join(backup, "stack_ethsigner.tar.gz"))
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	assert.NoFileExists(t, filepath.Join(backup, ".stack_ethsigner.tar.gz.partial"))
+
+	runner.Outputs = map[string]string{"docker volume ls --quiet --filter name=^stack_restored$": "stack_restored\n"}
+	assert.NoError(t, RestoreVolume(ctx, "stack_restored", filepath.Join(backup, "stack_ethsigner.tar.gz")))
+	runShell(runner.Commands[len(runner.Commands)-1])
+	content, err := os.ReadFile(filepath.Join(dest, "keystore", "key"))
+	assert.NoError(t, err)
+	assert.Equal(t, "secret", string(content))
+	for file, mode := range map[string]os.FileMode{"keystore": 0700, "keystore/key": 0600, "firefly.ffsigner": 0644} {
+		info, err := os.Stat(filepath.Join(dest, file))
+		assert.NoError(t, err)
+		assert.Equal(t, mode, info.Mode().Perm(), file)
+	}
+}
@@ -0,0 +1,80 @@
+// Copyright © 2024 Kaleido, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package dockertest has a fake docker.CommandRunner, so that tests can check the docker commands that code
+// runs, and answer them, without a docker daemon
+package dockertest
+
+import (
+	"context"
+	"io"
+	"strings"
+	"sync"
+)
+
+// Runner is a docker.CommandRunner that records the commands it is asked to run, and answers them from a table
+// of outputs and errors keyed by the full command line. Commands that are not in either table are answered by
+// Answer, if it is set, and otherwise succeed with no output. Every method answers like Run does, so a fake that
+// needs to behave differently for one kind of command can embed a Runner and override only that method.
+type Runner struct {
+	sync.Mutex
+	// Commands are the command lines run, in order
+	Commands []string
+	// Inputs is the stdin of each command run with RunWithInput
+	Inputs  []string
+	Outputs map[string]string
+	Errors  map[string]error
+	Answer  func(name string, args ...string) (string, error)
+}
+
+func (r *Runner) Run(ctx context.Context, workingDir string, name string, args ...string) (string, error) {
+	command := strings.Join(append([]string{name}, args...), " ")
+	r.Lock()
+	r.Commands = append(r.Commands, command)
+	output, hasOutput := r.Outputs[command]
+	err, hasErr := r.Errors[command]
+	answer := r.Answer
+	r.Unlock()
+	if !hasOutput && !hasErr && answer != nil {
+		return answer(name, args...)
+	}
+	return output, err
+}
+
+func (r *Runner) Output(ctx context.Context, workingDir string, name string, args ...string) (string, error) {
+	return r.Run(ctx, workingDir, name, args...)
+}
+
+func (r *Runner) RunWithInput(ctx context.Context, workingDir string, input io.Reader, name string, args ...string) (string, error) {
+	b, err := io.ReadAll(input)
+	if err != nil {
+		return "", err
+	}
+	r.Lock()
+	r.Inputs = append(r.Inputs, string(b))
+	r.Unlock()
+	return r.Run(ctx, workingDir, name, args...)
+}
+
+func (r *Runner) Follow(ctx context.Context, w io.Writer, name string, args ...string) error {
+	_, err := r.Run(ctx, "", name, args...)
+	return err
+}
+
+func (r *Runner) Interactive(ctx context.Context, name string, args ...string) error {
+	_, err := r.Run(ctx, "", name, args...)
+	return err
+}
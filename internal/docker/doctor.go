@@ -0,0 +1,259 @@
+// Copyright © 2024 Kaleido, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package docker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// CheckStatus is the outcome of one of the checks of Doctor
+type CheckStatus string
+
+const (
+	CheckPassed CheckStatus = "pass"
+	// CheckWarning is a problem that does not stop a stack from running, or a check that could not be made
+	CheckWarning CheckStatus = "warn"
+	CheckFailed  CheckStatus = "fail"
+)
+
+// CheckResult is the outcome of one of the checks of Doctor, with how to fix it if it did not pass
+type CheckResult struct {
+	Name        string      `json:"name"`
+	Status      CheckStatus `json:"status"`
+	Detail      string      `json:"detail,omitempty"`
+	Remediation string      `json:"remediation,omitempty"`
+}
+
+// Report is the outcome of every check of Doctor, in the order they were made
+type Report struct {
+	Checks []CheckResult `json:"checks"`
+}
+
+// Failed returns the checks that failed
+func (r Report) Failed() []CheckResult {
+	failed := []CheckResult{}
+	for _, check := range r.Checks {
+		if check.Status == CheckFailed {
+			failed = append(failed, check)
+		}
+	}
+	return failed
+}
+
+// HostInspector reports on the host that the CLI runs on, for the checks of Doctor that do not go through docker
+type HostInspector interface {
+	// FreeDiskSpace returns the bytes available to unprivileged users on the filesystem that holds path
+	FreeDiskSpace(path string) (uint64, error)
+	// IsPortAvailable returns whether nothing is listening on the given TCP port
+	IsPortAvailable(port int) bool
+}
+
+// LocalHost is the HostInspector of the host the CLI runs on
+type LocalHost struct{}
+
+func (LocalHost) IsPortAvailable(port int) bool {
+	return IsPortAvailable(port)
+}
+
+type ctxHostInspectorKey struct{}
+
+// WithHostInspector returns a context in which Doctor inspects the host with inspector
+func WithHostInspector(ctx context.Context, inspector HostInspector) context.Context {
+	return context.WithValue(ctx, ctxHostInspectorKey{}, inspector)
+}
+
+func hostInspector(ctx context.Context) HostInspector {
+	if inspector, ok := ctx.Value(ctxHostInspectorKey{}).(HostInspector); ok && inspector != nil {
+		return inspector
+	}
+	return LocalHost{}
+}
+
+// MinFreeDiskSpace is the space that Doctor expects to be free for docker, which is about what the images of a
+// stack take up once they are pulled
+const MinFreeDiskSpace = 10 << 30
+
+// DoctorPorts are the ports Doctor checks are free, which are the first ones a stack with the default base ports
+// publishes
+var DoctorPorts = []int{5000, 5001, 5100, 5101}
+
+// Doctor checks that docker, compose and the host are ready to run a stack, reporting each problem found with how
+// to fix it. Every check is made, even once one has failed, unless ctx is cancelled.
+func Doctor(ctx context.Context) (Report, error) {
+	report := Report{}
+	checks := []func(context.Context, *Report) CheckResult{
+		checkDockerInstalled,
+		checkDockerDaemon,
+		checkComposeInstalled,
+		checkDiskSpace,
+		checkPortsFree,
+	}
+	for _, check := range checks {
+		if err := ctx.Err(); err != nil {
+			return report, err
+		}
+		report.Checks = append(report.Checks, check(ctx, &report))
+	}
+	return report, nil
+}
+
+// passed returns whether the check with the given name passed, so that the checks which depend on it are skipped
+func (r Report) passed(name string) bool {
+	for _, check := range r.Checks {
+		if check.Name == name {
+			return check.Status != CheckFailed
+		}
+	}
+	return false
+}
+
+const (
+	checkNameDockerInstalled = "docker installed"
+	checkNameDockerDaemon    = "docker daemon reachable"
+)
+
+func checkDockerInstalled(ctx context.Context, _ *Report) CheckResult {
+	result := CheckResult{Name: checkNameDockerInstalled}
+	output, err := commandRunner(ctx).Run(ctx, "", "docker", "--version")
+	switch {
+	case err == nil:
+		result.Status = CheckPassed
+		result.Detail = strings.TrimSpace(output)
+	case errors.Is(err, exec.ErrNotFound):
+		result.Status = CheckFailed
+		result.Detail = "docker was not found on your PATH"
+		result.Remediation = "Install Docker by following https://docs.docker.com/get-docker/"
+	default:
+		result.Status = CheckFailed
+		result.Detail = err.Error()
+		result.Remediation = "Check that your Docker installation is not broken, reinstalling it if needed"
+	}
+	return result
+}
+
+func checkDockerDaemon(ctx context.Context, report *Report) CheckResult {
+	result := CheckResult{Name: checkNameDockerDaemon}
+	if !report.passed(checkNameDockerInstalled) {
+		result.Status = CheckFailed
+		result.Detail = "skipped, as docker is not installed"
+		return result
+	}
+	err := CheckDockerAvailable(ctx)
+	switch {
+	case err == nil:
+		result.Status = CheckPassed
+	case errors.Is(err, errDockerNotRunning):
+		result.Status = CheckFailed
+		result.Detail = "the Docker daemon is not running"
+		result.Remediation = "Start Docker, or Docker Desktop, and try again"
+	case errors.Is(err, errDockerPermissionDenied):
+		result.Status = CheckFailed
+		result.Detail = "permission denied while connecting to the Docker daemon"
+		result.Remediation = "Add your user to the 'docker' group with 'sudo usermod -aG docker $USER', then log out and back in"
+	default:
+		result.Status = CheckFailed
+		result.Detail = err.Error()
+	}
+	return result
+}
+
+func checkComposeInstalled(ctx context.Context, _ *Report) CheckResult {
+	result := CheckResult{Name: "docker compose installed"}
+	version, err := DetectComposeVersion(ctx)
+	switch {
+	case err != nil:
+		result.Status = CheckFailed
+		result.Detail = "neither the compose plugin nor the standalone docker-compose was found"
+		result.Remediation = "Install the Docker Compose plugin by following https://docs.docker.com/compose/install/"
+	case version == ComposeV1:
+		result.Status = CheckWarning
+		result.Detail = version.String()
+		result.Remediation = "The standalone docker-compose is no longer maintained, and some stack options need the Docker Compose plugin. Install it by following https://docs.docker.com/compose/install/"
+	default:
+		result.Status = CheckPassed
+		result.Detail = version.String()
+	}
+	return result
+}
+
+func checkDiskSpace(ctx context.Context, report *Report) CheckResult {
+	result := CheckResult{Name: "disk space for images"}
+	if !report.passed(checkNameDockerDaemon) {
+		result.Status = CheckFailed
+		result.Detail = "skipped, as the Docker daemon is not reachable"
+		return result
+	}
+	output, err := commandRunner(ctx).Run(ctx, "", "docker", "info", "--format", "{{.DockerRootDir}}")
+	rootDir := strings.TrimSpace(output)
+	if err != nil || rootDir == "" {
+		result.Status = CheckWarning
+		result.Detail = "could not find where docker stores its images"
+		return result
+	}
+	free, err := hostInspector(ctx).FreeDiskSpace(rootDir)
+	if err != nil {
+		// Docker Desktop stores images inside a virtual machine, so its root directory is not on this host
+		result.Status = CheckWarning
+		result.Detail = fmt.Sprintf("could not check the free space of '%s': %s", rootDir, err)
+		result.Remediation = "Check that the disk of your Docker Desktop virtual machine has space for the images of a stack"
+		return result
+	}
+	result.Detail = fmt.Sprintf("%s free in '%s'", formatBytes(free), rootDir)
+	if free < MinFreeDiskSpace {
+		result.Status = CheckFailed
+		result.Remediation = fmt.Sprintf("Free up at least %s for docker, for example by removing unused images with 'docker image prune'", formatBytes(MinFreeDiskSpace))
+		return result
+	}
+	result.Status = CheckPassed
+	return result
+}
+
+func checkPortsFree(ctx context.Context, _ *Report) CheckResult {
+	result := CheckResult{Name: "ports free"}
+	inUse := []string{}
+	for _, port := range DoctorPorts {
+		if !hostInspector(ctx).IsPortAvailable(port) {
+			inUse = append(inUse, fmt.Sprint(port))
+		}
+	}
+	if len(inUse) > 0 {
+		result.Status = CheckFailed
+		result.Detail = fmt.Sprintf("in use: %s", strings.Join(inUse, ", "))
+		result.Remediation = "Stop whatever is listening on them, or pass other base ports to 'ff init' with --firefly-base-port and --services-base-port"
+		return result
+	}
+	result.Status = CheckPassed
+	return result
+}
+
+// formatBytes formats a size in bytes in the largest binary unit that keeps it above one
+func formatBytes(b uint64) string {
+	const unit = 1024
+	if b < unit {
+		return fmt.Sprintf("%d B", b)
+	}
+	div, exp := uint64(unit), 0
+	for n := b / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(b)/float64(div), "KMGTPE"[exp])
+}
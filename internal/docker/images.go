@@ -0,0 +1,175 @@
+// Copyright © 2024 Kaleido, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package docker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/hyperledger/firefly-cli/internal/constants"
+	"github.com/hyperledger/firefly-cli/internal/log"
+)
+
+// stackImagesFile is the file in the directory of each stack that records the images the stack has referenced
+const stackImagesFile = "images.json"
+
+// HelperImage is the image of the short lived containers that read and write the files in docker volumes
+const HelperImage = "alpine"
+
+// TrackStackImages records that the stack references the given images, in addition to any it referenced
+// before. A stack that has been upgraded keeps the images of its earlier versions until it is removed.
+func TrackStackImages(stackName string, images ...string) error {
+	tracked, err := StackImages(stackName)
+	if err != nil {
+		return err
+	}
+	seen := make(map[string]bool)
+	for _, image := range tracked {
+		seen[image] = true
+	}
+	for _, image := range images {
+		if image != "" && !seen[image] {
+			seen[image] = true
+			tracked = append(tracked, image)
+		}
+	}
+	sort.Strings(tracked)
+	b, err := json.MarshalIndent(tracked, "", " ")
+	if err != nil {
+		return err
+	}
+	filename := filepath.Join(constants.StacksDir, stackName, stackImagesFile)
+	if err := os.WriteFile(filename, b, 0644); err != nil {
+		return err
+	}
+	// The mode is only set when the file is created, so also fix the mode of an existing file
+	return os.Chmod(filename, 0644)
+}
+
+// StackImages returns the images recorded for the stack, which is empty if none have been recorded
+func StackImages(stackName string) ([]string, error) {
+	b, err := os.ReadFile(filepath.Join(constants.StacksDir, stackName, stackImagesFile))
+	if os.IsNotExist(err) {
+		return []string{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	images := []string{}
+	if err := json.Unmarshal(b, &images); err != nil {
+		return nil, fmt.Errorf("invalid image list for stack '%s': %s", stackName, err)
+	}
+	return images, nil
+}
+
+// RemoveUnusedStackImages removes the images recorded for the stack that no other stack on this host has
+// recorded. It must be called before the directory of the stack is removed, and after its containers have
+// gone. Images that are still used by a container, or that have already been removed, are left alone.
+func RemoveUnusedStackImages(ctx context.Context, stackName string) error {
+	images, err := StackImages(stackName)
+	if err != nil {
+		return err
+	}
+	inUse, err := otherStackImages(stackName)
+	if err != nil {
+		return err
+	}
+	for _, image := range images {
+		if inUse[image] {
+			continue
+		}
+		if err := RunDockerCommand(ctx, ".", "image", "rm", image); err != nil {
+			msg := strings.ToLower(err.Error())
+			switch {
+			case strings.Contains(msg, "no such image"):
+			case strings.Contains(msg, "image is being used"), strings.Contains(msg, "conflict"):
+				log.LoggerFromContext(ctx).Warn(fmt.Sprintf("not removing image '%s', which is still in use", image))
+			default:
+				return fmt.Errorf("failed to remove image '%s': %s", image, err)
+			}
+		}
+	}
+	return nil
+}
+
+// otherStackImages returns the set of images recorded for every stack other than the given one
+func otherStackImages(stackName string) (map[string]bool, error) {
+	files, err := os.ReadDir(constants.StacksDir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	inUse := make(map[string]bool)
+	for _, f := range files {
+		if !f.IsDir() || f.Name() == stackName {
+			continue
+		}
+		images, err := StackImages(f.Name())
+		if err != nil {
+			return nil, err
+		}
+		for _, image := range images {
+			inUse[image] = true
+		}
+	}
+	return inUse, nil
+}
+
+// SaveImages writes the given local images to a single tar archive at outPath, which LoadImages loads into the
+// docker engine of another host, such as one without access to a registry
+func SaveImages(ctx context.Context, images []string, outPath string) error {
+	if len(images) == 0 {
+		return fmt.Errorf("no images to save to '%s'", outPath)
+	}
+	if err := RunDockerCommand(ctx, ".", append([]string{"save", "--output", outPath}, images...)...); err != nil {
+		return fmt.Errorf("failed to save images to '%s': %s", outPath, err)
+	}
+	return nil
+}
+
+// LoadImages loads every image in a tar archive written by SaveImages into the local docker engine. The archive
+// is streamed to 'docker load' on its stdin, so that the engine need not be able to read the path on the host.
+func LoadImages(ctx context.Context, tarPath string) error {
+	f, err := os.Open(tarPath)
+	if err != nil {
+		return fmt.Errorf("failed to load images from '%s': %s", tarPath, err)
+	}
+	defer f.Close()
+	if output, err := RunDockerCommandWithInput(ctx, ".", f, "load"); err != nil {
+		if output != "" {
+			err = fmt.Errorf("%s", strings.TrimSpace(output))
+		}
+		return fmt.Errorf("failed to load images from '%s': %s", tarPath, err)
+	}
+	return nil
+}
+
+// MissingImages returns the given images that are not in the local docker engine, in the order they were given
+func MissingImages(ctx context.Context, images []string) []string {
+	missing := []string{}
+	for _, image := range images {
+		if !ImageExistsLocally(ctx, image) {
+			missing = append(missing, image)
+		}
+	}
+	return missing
+}
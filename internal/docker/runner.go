@@ -0,0 +1,208 @@
+// Copyright © 2024 Kaleido, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package docker
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hyperledger/firefly-cli/internal/log"
+)
+
+// CommandRunner runs the docker and compose commands of this package, so that tests can check the commands
+// that would be run without a docker daemon
+type CommandRunner interface {
+	// Run runs a command to completion in workingDir, returning its combined stdout and stderr
+	Run(ctx context.Context, workingDir string, name string, args ...string) (string, error)
+	// Output runs a command to completion like Run, but returns only its stdout, for commands whose output is data
+	// that docker must not mix its own messages into. The stderr of the command is the error if it fails.
+	Output(ctx context.Context, workingDir string, name string, args ...string) (string, error)
+	// RunWithInput runs a command like Run, with input as its stdin
+	RunWithInput(ctx context.Context, workingDir string, input io.Reader, name string, args ...string) (string, error)
+	// Follow copies the output of a long running command to w, until it exits or ctx is cancelled
+	Follow(ctx context.Context, w io.Writer, name string, args ...string) error
+	// Interactive runs a command attached to the stdin, stdout and stderr of this process, so that it can
+	// use the terminal
+	Interactive(ctx context.Context, name string, args ...string) error
+}
+
+// ExecRunner is the CommandRunner that runs commands as child processes
+type ExecRunner struct{}
+
+func (ExecRunner) Run(ctx context.Context, workingDir string, name string, args ...string) (string, error) {
+	//nolint:gosec
+	cmd := exec.Command(name, args...)
+	if cmd.Err != nil {
+		// The command could not be found, so report that rather than the failure to start it
+		return "", cmd.Err
+	}
+	cmd.Dir = workingDir
+	return runCommand(ctx, cmd)
+}
+
+func (ExecRunner) Output(ctx context.Context, workingDir string, name string, args ...string) (_ string, err error) {
+	//nolint:gosec
+	cmd := exec.CommandContext(ctx, name, args...)
+	if cmd.Err != nil {
+		return "", cmd.Err
+	}
+	cmd.Dir = workingDir
+	if log.DockerVerbosityFromContext(ctx) {
+		fmt.Println(cmd.String())
+	}
+	stdout := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	if log.EventsEnabled(ctx) {
+		startTime := time.Now()
+		defer func() {
+			emitCommandEvent(ctx, cmd, startTime, stdout.String()+stderr.String(), err)
+		}()
+	}
+	if err := cmd.Run(); err != nil {
+		if message := strings.TrimSpace(stderr.String()); message != "" {
+			return stdout.String(), fmt.Errorf("%s", message)
+		}
+		return stdout.String(), err
+	}
+	return stdout.String(), nil
+}
+
+func (ExecRunner) RunWithInput(ctx context.Context, workingDir string, input io.Reader, name string, args ...string) (string, error) {
+	//nolint:gosec
+	cmd := exec.Command(name, args...)
+	if cmd.Err != nil {
+		return "", cmd.Err
+	}
+	cmd.Dir = workingDir
+	cmd.Stdin = input
+	return runCommand(ctx, cmd)
+}
+
+func (ExecRunner) Follow(ctx context.Context, w io.Writer, name string, args ...string) error {
+	//nolint:gosec
+	cmd := exec.Command(name, args...)
+	if cmd.Err != nil {
+		return cmd.Err
+	}
+	return followCommand(ctx, cmd, w)
+}
+
+func (ExecRunner) Interactive(ctx context.Context, name string, args ...string) error {
+	//nolint:gosec
+	cmd := exec.CommandContext(ctx, name, args...)
+	if cmd.Err != nil {
+		return cmd.Err
+	}
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+type ctxCommandRunnerKey struct{}
+
+var defaultRunner = struct {
+	sync.Mutex
+	runner CommandRunner
+}{runner: ExecRunner{}}
+
+// WithCommandRunner returns a context in which the commands of this package are run by runner
+func WithCommandRunner(ctx context.Context, runner CommandRunner) context.Context {
+	return context.WithValue(ctx, ctxCommandRunnerKey{}, runner)
+}
+
+// SetCommandRunner replaces the runner used when the context does not have one, returning the previous
+// runner so that it can be restored
+func SetCommandRunner(runner CommandRunner) CommandRunner {
+	defaultRunner.Lock()
+	defer defaultRunner.Unlock()
+	previous := defaultRunner.runner
+	defaultRunner.runner = runner
+	return previous
+}
+
+func commandRunner(ctx context.Context) CommandRunner {
+	if runner, ok := ctx.Value(ctxCommandRunnerKey{}).(CommandRunner); ok && runner != nil {
+		return runner
+	}
+	defaultRunner.Lock()
+	defer defaultRunner.Unlock()
+	return defaultRunner.runner
+}
+
+// run runs a command with the runner of the context, or prints it in dry run mode
+func run(ctx context.Context, workingDir string, name string, args ...string) (string, error) {
+	if IsOffline(ctx) {
+		args = offlineArgs(name, args)
+	}
+	if w, ok := ctx.Value(CtxDryRunKey{}).(io.Writer); ok && w != nil {
+		_, err := fmt.Fprintf(w, "%s\n", strings.Join(append([]string{name}, args...), " "))
+		return "", err
+	}
+	return commandRunner(ctx).Run(ctx, workingDir, name, args...)
+}
+
+// runOutput runs a command with the runner of the context and returns only its stdout, or prints it in dry run mode
+func runOutput(ctx context.Context, workingDir string, name string, args ...string) (string, error) {
+	if IsOffline(ctx) {
+		args = offlineArgs(name, args)
+	}
+	if w, ok := ctx.Value(CtxDryRunKey{}).(io.Writer); ok && w != nil {
+		_, err := fmt.Fprintf(w, "%s\n", strings.Join(append([]string{name}, args...), " "))
+		return "", err
+	}
+	return commandRunner(ctx).Output(ctx, workingDir, name, args...)
+}
+
+// runWithInput runs a command with input as its stdin, or prints it and the size of the input in dry run mode
+func runWithInput(ctx context.Context, workingDir string, input []byte, name string, args ...string) (string, error) {
+	return runWithReader(ctx, workingDir, bytes.NewReader(input), name, args...)
+}
+
+// runWithReader runs a command with the content of input as its stdin, or prints it and the size of the input in
+// dry run mode. The input is read to the end in dry run mode, as it would have been by the command.
+func runWithReader(ctx context.Context, workingDir string, input io.Reader, name string, args ...string) (string, error) {
+	if IsOffline(ctx) {
+		args = offlineArgs(name, args)
+	}
+	if w, ok := ctx.Value(CtxDryRunKey{}).(io.Writer); ok && w != nil {
+		size, err := io.Copy(io.Discard, input)
+		if err != nil {
+			return "", err
+		}
+		_, err = fmt.Fprintf(w, "%s < (%d bytes)\n", strings.Join(append([]string{name}, args...), " "), size)
+		return "", err
+	}
+	return commandRunner(ctx).RunWithInput(ctx, workingDir, input, name, args...)
+}
+
+// offlineArgs stops 'docker run' and 'docker create' from implicitly pulling an image that is missing locally
+func offlineArgs(name string, args []string) []string {
+	if name != "docker" || len(args) == 0 || (args[0] != "run" && args[0] != "create") {
+		return args
+	}
+	return append([]string{args[0], "--pull=never"}, args[1:]...)
+}
@@ -0,0 +1,79 @@
+// Copyright © 2024 Kaleido, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package log
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+)
+
+// MaxEventOutputLength is the number of bytes of command output included in an event. Anything longer
+// is truncated, keeping the end of the output where errors are usually reported.
+const MaxEventOutputLength = 4096
+
+// Event describes a command run by the CLI, for consumption by tools that wrap it
+type Event struct {
+	Command   []string  `json:"command"`
+	StartTime time.Time `json:"startTime"`
+	EndTime   time.Time `json:"endTime"`
+	ExitCode  int       `json:"exitCode"`
+	Output    string    `json:"output,omitempty"`
+	Truncated bool      `json:"truncated,omitempty"`
+	Error     string    `json:"error,omitempty"`
+}
+
+type ctxEventSinkKey struct{}
+
+type eventSink struct {
+	mux sync.Mutex
+	w   io.Writer
+}
+
+// WithEventSink returns a context in which every command that is run is also reported to w, as a single
+// line of JSON per command
+func WithEventSink(ctx context.Context, w io.Writer) context.Context {
+	return context.WithValue(ctx, ctxEventSinkKey{}, &eventSink{w: w})
+}
+
+// EventsEnabled returns whether events should be emitted for the commands run with the given context
+func EventsEnabled(ctx context.Context) bool {
+	_, ok := ctx.Value(ctxEventSinkKey{}).(*eventSink)
+	return ok
+}
+
+// EmitEvent writes an event to the sink of the context, if it has one
+func EmitEvent(ctx context.Context, event *Event) error {
+	sink, ok := ctx.Value(ctxEventSinkKey{}).(*eventSink)
+	if !ok {
+		return nil
+	}
+	if len(event.Output) > MaxEventOutputLength {
+		event.Output = event.Output[len(event.Output)-MaxEventOutputLength:]
+		event.Truncated = true
+	}
+	b, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	sink.mux.Lock()
+	defer sink.mux.Unlock()
+	_, err = sink.w.Write(append(b, '\n'))
+	return err
+}
@@ -40,8 +40,9 @@ type Logger interface {
 }
 
 type (
-	ctxLogKey       struct{}
-	ctxVerbosityKey struct{}
+	ctxLogKey             struct{}
+	ctxVerbosityKey       struct{}
+	ctxDockerVerbosityKey struct{}
 )
 
 func WithLogger(ctx context.Context, log Logger) context.Context {
@@ -59,3 +60,15 @@ func WithVerbosity(ctx context.Context, verbose bool) context.Context {
 func VerbosityFromContext(ctx context.Context) bool {
 	return ctx.Value(ctxVerbosityKey{}).(bool)
 }
+
+// WithDockerVerbosity controls whether the docker commands run by the CLI, and their output, are printed.
+// It is separate from the verbosity of the CLI itself, so that each can be debugged without the other.
+func WithDockerVerbosity(ctx context.Context, verbose bool) context.Context {
+	return context.WithValue(ctx, ctxDockerVerbosityKey{}, verbose)
+}
+
+// DockerVerbosityFromContext returns whether docker commands are printed, which they are not by default
+func DockerVerbosityFromContext(ctx context.Context) bool {
+	verbose, _ := ctx.Value(ctxDockerVerbosityKey{}).(bool)
+	return verbose
+}
@@ -0,0 +1,74 @@
+// Copyright © 2024 Kaleido, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package log
+
+import (
+	"context"
+	"fmt"
+)
+
+// Progress is a step of a long running operation, which is reported once when it starts and again when it is done
+type Progress struct {
+	// Step says what the step does, such as "importing account"
+	Step string
+	// Current and Total count the steps of a kind that are repeated, such as one per account, and are zero otherwise
+	Current int
+	Total   int
+	Done    bool
+}
+
+// String formats the step for display, such as "importing account 3/10"
+func (p Progress) String() string {
+	s := p.Step
+	if p.Total > 0 {
+		s = fmt.Sprintf("%s %d/%d", s, p.Current, p.Total)
+	}
+	if p.Done {
+		s += " done"
+	}
+	return s
+}
+
+// ProgressFunc is called with each step of a long running operation as it starts and completes
+type ProgressFunc func(Progress)
+
+type ctxProgressKey struct{}
+
+// WithProgress returns a context in which the steps of long running operations are reported to fn
+func WithProgress(ctx context.Context, fn ProgressFunc) context.Context {
+	return context.WithValue(ctx, ctxProgressKey{}, fn)
+}
+
+// ReportProgress calls the progress function of the context, if it has one
+func ReportProgress(ctx context.Context, progress Progress) {
+	if ctx == nil {
+		return
+	}
+	if fn, ok := ctx.Value(ctxProgressKey{}).(ProgressFunc); ok && fn != nil {
+		fn(progress)
+	}
+}
+
+// ProgressStep reports that a step has started, and returns a function that reports it is done
+func ProgressStep(ctx context.Context, step string, current, total int) (done func()) {
+	progress := Progress{Step: step, Current: current, Total: total}
+	ReportProgress(ctx, progress)
+	return func() {
+		progress.Done = true
+		ReportProgress(ctx, progress)
+	}
+}
@@ -0,0 +1,80 @@
+// Copyright © 2024 Kaleido, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stacks
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/hyperledger/firefly-cli/internal/docker"
+)
+
+// BundleImages returns every image the stack needs to run, sorted, for copying into an air-gapped
+// environment. Mirrored images are returned by their upstream names, which the stack tags with their
+// mirrored names when it starts, and the helper image that reads and writes the volumes is included.
+func (s *StackManager) BundleImages() ([]string, error) {
+	compose, upstreams, err := s.buildMirroredDockerCompose()
+	if err != nil {
+		return nil, err
+	}
+	seen := map[string]bool{docker.HelperImage: true}
+	images := []string{docker.HelperImage}
+	for _, service := range compose.Services {
+		image := service.Image
+		if upstream, ok := upstreams[image]; ok {
+			image = upstream
+		}
+		if image != "" && !seen[image] {
+			seen[image] = true
+			images = append(images, image)
+		}
+	}
+	sort.Strings(images)
+	return images, nil
+}
+
+// ExportImages writes every image the stack needs to a tar archive at path, pulling any that are not local
+func (s *StackManager) ExportImages(path string) error {
+	images, err := s.BundleImages()
+	if err != nil {
+		return err
+	}
+	if missing := docker.MissingImages(s.ctx, images); len(missing) > 0 {
+		if err := docker.PullImages(s.ctx, missing, s.PullConcurrency); err != nil {
+			return err
+		}
+	}
+	s.Log.Info(fmt.Sprintf("saving %d images to '%s'", len(images), path))
+	return docker.SaveImages(s.ctx, images, path)
+}
+
+// ImportImages loads a tar archive written by ExportImages, and checks that it held every image the stack needs
+func (s *StackManager) ImportImages(path string) error {
+	s.Log.Info(fmt.Sprintf("loading images from '%s'", path))
+	if err := docker.LoadImages(s.ctx, path); err != nil {
+		return err
+	}
+	images, err := s.BundleImages()
+	if err != nil {
+		return err
+	}
+	if missing := docker.MissingImages(s.ctx, images); len(missing) > 0 {
+		return fmt.Errorf("the bundle '%s' did not contain the images: %s", path, strings.Join(missing, ", "))
+	}
+	return nil
+}
@@ -27,6 +27,11 @@ func (s *StackManager) registerFireflyIdentities() error {
 	emptyObject := make(map[string]interface{})
 
 	for _, member := range s.Stack.Members {
+		if member.NonSigning {
+			// Registering the org is a transaction, which a member without a signing account cannot send
+			s.Log.Info(fmt.Sprintf("not registering org and node for member %s, which does not sign", member.ID))
+			continue
+		}
 		ffURL := fmt.Sprintf("http://127.0.0.1:%d/api/v1", member.ExposedFireflyPort)
 		s.Log.Info(fmt.Sprintf("registering org and node for member %s", member.ID))
 
@@ -0,0 +1,99 @@
+// Copyright © 2024 Kaleido, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stacks
+
+import (
+	"fmt"
+
+	"github.com/hyperledger/firefly-cli/internal/docker"
+	"github.com/hyperledger/firefly-cli/pkg/types"
+)
+
+const maxPort = 65535
+
+// isPortAvailable is used to check whether a port is free on this host, and is replaced in tests
+var isPortAvailable = docker.IsPortAvailable
+
+// portAllocator hands out the host ports for the services of a new stack. No port is handed out twice, and
+// ports that are already in use on this host, or that are recorded for another stack, are skipped.
+type portAllocator struct {
+	allocated map[int]bool
+}
+
+func newPortAllocator(reserved []int) *portAllocator {
+	a := &portAllocator{allocated: make(map[int]bool)}
+	for _, port := range reserved {
+		a.allocated[port] = true
+	}
+	return a
+}
+
+// allocate returns the preferred port if it is free, otherwise the next free port above it
+func (a *portAllocator) allocate(preferred int) (int, error) {
+	for port := preferred; port <= maxPort; port++ {
+		if !a.allocated[port] && isPortAvailable(port) {
+			a.allocated[port] = true
+			return port, nil
+		}
+	}
+	return 0, fmt.Errorf("no free port is available at or above %d", preferred)
+}
+
+// stackPorts returns every host port that the stack publishes
+func stackPorts(stack *types.Stack) []int {
+	ports := []int{stack.ExposedBlockchainPort}
+	for _, member := range stack.Members {
+		ports = append(ports, member.ExposedConnectorPort)
+		ports = append(ports, member.ExposedDatabasePort)
+		ports = append(ports, member.ExposedUIPort)
+		ports = append(ports, member.ExposedTokensPorts...)
+
+		if !member.External {
+			ports = append(ports, member.ExposedFireflyAdminSPIPort)
+			ports = append(ports, member.ExposedFireflyPort)
+			ports = append(ports, member.ExposedFireflyMetricsPort)
+		}
+		ports = append(ports, member.ExposedDataexchangePort)
+		ports = append(ports, member.ExposedIPFSApiPort)
+		ports = append(ports, member.ExposedIPFSGWPort)
+		if stack.SandboxEnabled {
+			ports = append(ports, member.ExposedSandboxPort)
+		}
+	}
+
+	if stack.PrometheusEnabled {
+		ports = append(ports, stack.ExposedPrometheusPort)
+	}
+	if stack.SignerMetricsEnabled {
+		ports = append(ports, stack.ExposedSignerMetricsPort)
+	}
+	return ports
+}
+
+// otherStackPorts returns the ports recorded for all the other stacks on this host. Those stacks may not be
+// running right now, so probing the ports is not enough to keep the stacks from colliding when they are.
+func otherStackPorts(stackName string) ([]int, error) {
+	stacks, err := otherStacks(stackName)
+	if err != nil {
+		return nil, err
+	}
+	ports := []int{}
+	for _, stack := range stacks {
+		ports = append(ports, stackPorts(stack)...)
+	}
+	return ports, nil
+}
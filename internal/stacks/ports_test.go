@@ -0,0 +1,127 @@
+package stacks
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hyperledger/firefly-cli/internal/blockchain"
+	"github.com/hyperledger/firefly-cli/internal/constants"
+	"github.com/hyperledger/firefly-cli/pkg/types"
+	"github.com/stretchr/testify/assert"
+)
+
+type accountProviderStub struct {
+	blockchain.IBlockchainProvider
+}
+
+func (p *accountProviderStub) CreateAccount(args []string) (interface{}, error) {
+	return args[0], nil
+}
+
+func stubPortsInUse(t *testing.T, inUse ...int) {
+	original := isPortAvailable
+	t.Cleanup(func() { isPortAvailable = original })
+	isPortAvailable = func(port int) bool {
+		for _, p := range inUse {
+			if p == port {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+func TestPortAllocatorSkipsPortsInUse(t *testing.T) {
+	stubPortsInUse(t, 5100, 5102)
+	ports := newPortAllocator(nil)
+
+	port, err := ports.allocate(5100)
+	assert.NoError(t, err)
+	assert.Equal(t, 5101, port)
+
+	// 5101 has already been handed out and 5102 is in use
+	port, err = ports.allocate(5101)
+	assert.NoError(t, err)
+	assert.Equal(t, 5103, port)
+
+	port, err = ports.allocate(6000)
+	assert.NoError(t, err)
+	assert.Equal(t, 6000, port)
+}
+
+func TestPortAllocatorSkipsReservedPorts(t *testing.T) {
+	stubPortsInUse(t)
+	ports := newPortAllocator([]int{8545, 8546})
+
+	port, err := ports.allocate(8545)
+	assert.NoError(t, err)
+	assert.Equal(t, 8547, port)
+}
+
+func TestPortAllocatorExhausted(t *testing.T) {
+	stubPortsInUse(t, maxPort)
+	ports := newPortAllocator(nil)
+
+	_, err := ports.allocate(maxPort)
+	assert.Regexp(t, "no free port is available at or above 65535", err)
+}
+
+func TestCreateMemberPortsDoNotCollide(t *testing.T) {
+	// The second member's preferred UI port is in use, and the FireFly base port is already taken by another stack
+	stubPortsInUse(t, 5203)
+	s := &StackManager{ports: newPortAllocator([]int{5000}), blockchainProvider: &accountProviderStub{}}
+	options := &types.InitOptions{
+		FireFlyBasePort:  5000,
+		ServicesBasePort: 5100,
+		OrgNames:         []string{"org_0", "org_1"},
+		NodeNames:        []string{"node_0", "node_1"},
+		TokenProviders:   []string{"erc20_erc721"},
+		SandboxEnabled:   true,
+	}
+
+	member0, err := s.createMember("0", 0, options, false)
+	assert.NoError(t, err)
+	member1, err := s.createMember("1", 1, options, false)
+	assert.NoError(t, err)
+
+	assert.Equal(t, 5001, member0.ExposedFireflyPort)
+	assert.Equal(t, 5002, member1.ExposedFireflyPort)
+	assert.Equal(t, 5204, member1.ExposedUIPort)
+	assert.Equal(t, 5205, member1.ExposedDatabasePort)
+
+	seen := map[int]bool{5000: true, 5203: true}
+	stack := &types.Stack{SandboxEnabled: true, Members: []*types.Organization{member0, member1}}
+	for _, port := range stackPorts(stack) {
+		if port == 0 {
+			continue
+		}
+		assert.False(t, seen[port], "port %d allocated twice", port)
+		seen[port] = true
+	}
+}
+
+func TestOtherStackPorts(t *testing.T) {
+	stacksDir := constants.StacksDir
+	defer func() { constants.StacksDir = stacksDir }()
+	constants.StacksDir = t.TempDir()
+
+	ports, err := otherStackPorts("new")
+	assert.NoError(t, err)
+	assert.Empty(t, ports)
+
+	for name, port := range map[string]int{"existing": 5100, "new": 6100} {
+		stack := &types.Stack{Name: name, ExposedBlockchainPort: port, Members: []*types.Organization{{ExposedFireflyPort: port + 1}}}
+		b, err := json.Marshal(stack)
+		assert.NoError(t, err)
+		assert.NoError(t, os.MkdirAll(filepath.Join(constants.StacksDir, name), 0755))
+		assert.NoError(t, os.WriteFile(filepath.Join(constants.StacksDir, name, "stack.json"), b, 0600))
+	}
+
+	ports, err = otherStackPorts("new")
+	assert.NoError(t, err)
+	assert.Contains(t, ports, 5100)
+	assert.Contains(t, ports, 5101)
+	assert.NotContains(t, ports, 6100)
+}
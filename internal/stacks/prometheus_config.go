@@ -16,7 +16,11 @@
 
 package stacks
 
-import "fmt"
+import (
+	"fmt"
+
+	"github.com/hyperledger/firefly-cli/internal/blockchain/ethereum/ethsigner"
+)
 
 type GlobalConfig struct {
 	ScrapeInterval string `yaml:"scrape_interval,omitempty"`
@@ -65,5 +69,13 @@ func (s *StackManager) GeneratePrometheusConfig() *PrometheusConfig {
 		}
 	}
 
+	if s.Stack.SignerMetricsEnabled {
+		config.ScrapeConfigs = append(config.ScrapeConfigs, &ScrapeConfig{
+			JobName:       "ethsigner",
+			MetricsPath:   "/metrics",
+			StaticConfigs: []*StaticConfig{{Targets: []string{fmt.Sprintf("ethsigner:%d", ethsigner.MetricsPort)}}},
+		})
+	}
+
 	return config
 }
@@ -0,0 +1,126 @@
+// Copyright © 2024 Kaleido, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stacks
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/firefly-cli/internal/constants"
+	"github.com/hyperledger/firefly-cli/internal/docker"
+	"github.com/otiai10/copy"
+)
+
+// DefaultBackupRetention is how many snapshots of a stack are kept in the backups directory, older ones being
+// removed when a new one is taken
+const DefaultBackupRetention = 5
+
+// snapshotTimeFormat is the UTC timestamp that follows the stack name in the name of a snapshot directory, which
+// sorts in the order the snapshots were taken
+const snapshotTimeFormat = "20060102T150405Z"
+
+// SnapshotStack takes a snapshot of the stack before it is removed, in a new directory under the backups
+// directory next to the stacks directory, and returns the path of that directory. The snapshot holds a copy of
+// the stack directory, in "stack", and an archive of each volume of the stack, in "volumes", written by
+// docker.SnapshotVolume and named after the volume. Between them these hold the config and the keystore, so the
+// stack can be recreated by copying the stack directory back and restoring each volume with docker.RestoreVolume.
+// Once the snapshot is taken, only the newest BackupRetention snapshots of the stack are kept, unless it is 0.
+func (s *StackManager) SnapshotStack() (string, error) {
+	backupsDir := filepath.Join(filepath.Dir(constants.StacksDir), "backups")
+	dir := filepath.Join(backupsDir, fmt.Sprintf("%s-%s", s.Stack.Name, time.Now().UTC().Format(snapshotTimeFormat)))
+	if err := s.snapshotStack(dir); err != nil {
+		return "", err
+	}
+	if err := s.pruneSnapshots(backupsDir, s.BackupRetention); err != nil {
+		return "", err
+	}
+	return dir, nil
+}
+
+// pruneSnapshots removes all but the newest keep snapshots of the stack from the backups directory. A directory is
+// only taken to be a snapshot of the stack if the rest of its name is a snapshot timestamp, so the snapshots of a
+// stack whose name starts with the name of this one are left alone.
+func (s *StackManager) pruneSnapshots(backupsDir string, keep int) error {
+	if keep <= 0 {
+		return nil
+	}
+	entries, err := os.ReadDir(backupsDir)
+	if err != nil {
+		return err
+	}
+	snapshots := []string{}
+	for _, entry := range entries {
+		timestamp, ok := strings.CutPrefix(entry.Name(), s.Stack.Name+"-")
+		if !ok || !entry.IsDir() {
+			continue
+		}
+		if _, err := time.Parse(snapshotTimeFormat, timestamp); err == nil {
+			snapshots = append(snapshots, entry.Name())
+		}
+	}
+	sort.Strings(snapshots)
+	for len(snapshots) > keep {
+		s.Log.Info(fmt.Sprintf("removing old snapshot '%s'", snapshots[0]))
+		if err := os.RemoveAll(filepath.Join(backupsDir, snapshots[0])); err != nil {
+			return fmt.Errorf("failed to remove old snapshot '%s': %s", snapshots[0], err)
+		}
+		snapshots = snapshots[1:]
+	}
+	return nil
+}
+
+func (s *StackManager) snapshotStack(dir string) error {
+	if err := os.MkdirAll(dir, constants.KeyDirectoryMode); err != nil {
+		return err
+	}
+	if err := copy.Copy(s.Stack.StackDir, filepath.Join(dir, "stack")); err != nil {
+		return fmt.Errorf("failed to copy the stack directory to '%s': %s", dir, err)
+	}
+	stackVolumes, err := s.stackVolumes()
+	if err != nil {
+		return err
+	}
+	seen := map[string]bool{}
+	volumes := []string{}
+	for _, volumeName := range stackVolumes {
+		volumeName = fmt.Sprintf("%s_%s", s.Stack.Name, volumeName)
+		if !seen[volumeName] {
+			seen[volumeName] = true
+			volumes = append(volumes, volumeName)
+		}
+	}
+	sort.Strings(volumes)
+	for _, volumeName := range volumes {
+		// A volume is only created when the stack first starts, so a stack that never ran has none
+		exists, err := docker.VolumeExists(s.ctx, volumeName)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			continue
+		}
+		s.Log.Info(fmt.Sprintf("taking a snapshot of volume '%s'", volumeName))
+		if err := docker.SnapshotVolume(s.ctx, volumeName, filepath.Join(dir, "volumes", volumeName+".tar.gz")); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -18,13 +18,16 @@ package stacks
 
 import (
 	"context"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net"
 	"os"
 	"os/exec"
 	"path"
 	"path/filepath"
+	"regexp"
 	"strings"
 	"sync"
 	"syscall"
@@ -32,7 +35,9 @@ import (
 	"time"
 
 	"github.com/hyperledger/firefly-cli/internal/blockchain"
+	"github.com/hyperledger/firefly-cli/internal/blockchain/ethereum"
 	"github.com/hyperledger/firefly-cli/internal/blockchain/ethereum/besu"
+	"github.com/hyperledger/firefly-cli/internal/blockchain/ethereum/ethsigner"
 	"github.com/hyperledger/firefly-cli/internal/blockchain/ethereum/geth"
 	ethremoterpc "github.com/hyperledger/firefly-cli/internal/blockchain/ethereum/remoterpc"
 	"github.com/hyperledger/firefly-cli/internal/blockchain/fabric"
@@ -53,14 +58,30 @@ import (
 	"github.com/otiai10/copy"
 )
 
+// DefaultShutdownTimeout is how long services are given to shut down cleanly when a stack is stopped, which
+// is longer than the compose default so that blockchain nodes have time to flush their state
+const DefaultShutdownTimeout = 30 * time.Second
+
+// dockerNetworkNameRegex matches the names docker accepts for a network
+var dockerNetworkNameRegex = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
+var imageMirrorRegex = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9.-]*(:[0-9]+)?(/[a-z0-9][a-z0-9._-]*)*/?$`)
+
 type StackManager struct {
 	ctx                context.Context
 	Log                log.Logger
+	ShutdownTimeout    time.Duration
+	PullConcurrency    int
+	RemoveImages       bool
+	BackupRetention    int
 	Stack              *types.Stack
 	blockchainProvider blockchain.IBlockchainProvider
 	tokenProviders     []tokens.ITokensProvider
+	ports              *portAllocator
 	IsOldFileStructure bool
 	once               sync.Once
+	// reusedAccounts are the accounts of an existing keystore that the members are given, in order, on init
+	reusedAccounts []*ethereum.Account
 }
 
 func ListStacks() ([]string, error) {
@@ -80,10 +101,39 @@ func ListStacks() ([]string, error) {
 	return stacks, nil
 }
 
+// otherStacks returns the config of every stack on this host other than the given one
+func otherStacks(stackName string) ([]*types.Stack, error) {
+	stackNames, err := ListStacks()
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	stacks := []*types.Stack{}
+	for _, name := range stackNames {
+		if name == stackName {
+			continue
+		}
+		d, err := os.ReadFile(filepath.Join(constants.StacksDir, name, "stack.json"))
+		if err != nil {
+			return nil, err
+		}
+		var stack *types.Stack
+		if err := json.Unmarshal(d, &stack); err != nil {
+			return nil, fmt.Errorf("invalid stack config for '%s': %s", name, err)
+		}
+		stacks = append(stacks, stack)
+	}
+	return stacks, nil
+}
+
 func NewStackManager(ctx context.Context) *StackManager {
 	return &StackManager{
-		ctx: ctx,
-		Log: log.LoggerFromContext(ctx),
+		ctx:             ctx,
+		Log:             log.LoggerFromContext(ctx),
+		ShutdownTimeout: DefaultShutdownTimeout,
+		PullConcurrency: docker.DefaultPullConcurrency,
+		BackupRetention: DefaultBackupRetention,
 	}
 }
 
@@ -91,7 +141,6 @@ func (s *StackManager) InitStack(options *types.InitOptions) (err error) {
 	s.Stack = &types.Stack{
 		Name:                   options.StackName,
 		Members:                make([]*types.Organization, options.MemberCount),
-		ExposedBlockchainPort:  options.ServicesBasePort,
 		Database:               fftypes.FFEnum(options.DatabaseProvider),
 		BlockchainProvider:     fftypes.FFEnum(options.BlockchainProvider),
 		BlockchainNodeProvider: fftypes.FFEnum(options.BlockchainNodeProvider),
@@ -116,6 +165,344 @@ func (s *StackManager) InitStack(options *types.InitOptions) (err error) {
 		RemoteNodeDeploy:  options.RemoteNodeDeploy,
 	}
 
+	reservedPorts, err := otherStackPorts(options.StackName)
+	if err != nil {
+		return err
+	}
+	s.ports = newPortAllocator(reservedPorts)
+	if s.Stack.ExposedBlockchainPort, err = s.ports.allocate(options.ServicesBasePort); err != nil {
+		return err
+	}
+
+	if options.HealthCheckInterval != "" || options.HealthCheckTimeout != "" || options.HealthCheckStartPeriod != "" || options.HealthCheckRetries > 0 {
+		s.Stack.HealthCheck = &types.HealthCheckConfig{
+			Interval:    options.HealthCheckInterval,
+			Timeout:     options.HealthCheckTimeout,
+			StartPeriod: options.HealthCheckStartPeriod,
+		}
+		if options.HealthCheckRetries > 0 {
+			s.Stack.HealthCheck.Retries = options.HealthCheckRetries
+		}
+		if err := s.Stack.HealthCheck.Validate(); err != nil {
+			return err
+		}
+	}
+
+	if options.GasOracleMode != "" || options.MaxFeePerGas != "" || options.MaxPriorityFeePerGas != "" {
+		if !s.Stack.BlockchainConnector.Equals(types.BlockchainConnectorEvmconnect) {
+			return fmt.Errorf("gas configuration is only supported by the '%s' blockchain connector", types.BlockchainConnectorEvmconnect)
+		}
+		s.Stack.Gas = &types.GasConfig{
+			OracleMode:           options.GasOracleMode,
+			MaxFeePerGas:         options.MaxFeePerGas,
+			MaxPriorityFeePerGas: options.MaxPriorityFeePerGas,
+		}
+		if err := s.Stack.Gas.Validate(); err != nil {
+			return err
+		}
+	}
+
+	if options.PrefundBalance != "" {
+		if !fftypes.FFEnum(options.BlockchainNodeProvider).Equals(types.BlockchainNodeProviderGeth) {
+			return fmt.Errorf("a prefund balance can only be set for the '%s' blockchain node provider", types.BlockchainNodeProviderGeth)
+		}
+		if _, err := geth.ParsePrefundBalance(options.PrefundBalance); err != nil {
+			return err
+		}
+		s.Stack.PrefundBalance = options.PrefundBalance
+	}
+
+	if options.GasLimit != 0 {
+		if !fftypes.FFEnum(options.BlockchainNodeProvider).Equals(types.BlockchainNodeProviderGeth) {
+			return fmt.Errorf("a gas limit can only be set for the '%s' blockchain node provider", types.BlockchainNodeProviderGeth)
+		}
+		if err := geth.ValidateGasLimit(options.GasLimit); err != nil {
+			return err
+		}
+		s.Stack.GethGasLimit = options.GasLimit
+	}
+
+	// A block period of 0, like the default of -1, has geth mine a block as soon as there is a transaction to put
+	// in it, rather than at a set interval
+	if fftypes.FFEnum(options.BlockchainNodeProvider).Equals(types.BlockchainNodeProviderGeth) && options.BlockPeriod < -1 {
+		return fmt.Errorf("invalid block period %d: must be zero or greater", options.BlockPeriod)
+	}
+
+	if fftypes.FFEnum(options.SignerType).Equals(types.SignerTypeJava) {
+		if !runsLocalSigner(options) {
+			return fmt.Errorf("the '%s' signer can only be used with the '%s' or '%s' ethereum blockchain node providers", types.SignerTypeJava, types.BlockchainNodeProviderBesu, types.BlockchainNodeProviderRemoteRPC)
+		}
+		s.Stack.SignerType = types.SignerTypeJava
+	}
+
+	if options.SignerKeystoreDirectory != "" {
+		if err := ethsigner.ValidateKeystoreDirectory(options.SignerKeystoreDirectory); err != nil {
+			return err
+		}
+		s.Stack.SignerKeystoreDirectory = options.SignerKeystoreDirectory
+	}
+
+	if options.SignerBindAddress != "" {
+		if err := ethsigner.ValidateBindAddress(options.SignerBindAddress); err != nil {
+			return err
+		}
+		s.Stack.SignerBindAddress = options.SignerBindAddress
+	}
+
+	if options.SignerLogLevel != "" || options.SignerLogFormat != "" {
+		if err := requiresLocalSigner(options, "signer log settings"); err != nil {
+			return err
+		}
+		if options.SignerLogFormat == "json" && s.Stack.SignerType.Equals(types.SignerTypeJava) {
+			return fmt.Errorf("the '%s' signer can only log text", types.SignerTypeJava)
+		}
+		s.Stack.SignerLog = &types.SignerLogConfig{
+			Level:  options.SignerLogLevel,
+			Format: options.SignerLogFormat,
+		}
+		if err := s.Stack.SignerLog.Validate(); err != nil {
+			return err
+		}
+	}
+
+	if options.SignerEntrypoint != "" || len(options.SignerExtraArgs) > 0 {
+		if err := requiresLocalSigner(options, "a signer entrypoint or extra signer arguments"); err != nil {
+			return err
+		}
+		s.Stack.SignerEntrypoint = strings.Fields(options.SignerEntrypoint)
+		s.Stack.SignerExtraArgs = options.SignerExtraArgs
+	}
+
+	if options.SignerMetricsEnabled {
+		// firefly-signer does not serve metrics, so only the Java signer can publish them
+		if !s.Stack.SignerType.Equals(types.SignerTypeJava) {
+			return fmt.Errorf("signer metrics can only be used with the '%s' signer, as firefly-signer does not serve metrics", types.SignerTypeJava)
+		}
+		s.Stack.SignerMetricsEnabled = true
+		if s.Stack.ExposedSignerMetricsPort, err = s.ports.allocate(options.SignerMetricsPort); err != nil {
+			return err
+		}
+	}
+
+	if options.KeystoreKDF != "" {
+		if !fftypes.FFEnum(options.BlockchainProvider).Equals(types.BlockchainProviderEthereum) {
+			return fmt.Errorf("a keystore KDF can only be used with the '%s' blockchain provider", types.BlockchainProviderEthereum)
+		}
+		if err := types.ValidateKeystoreKDF(options.KeystoreKDF); err != nil {
+			return err
+		}
+		s.Stack.KeystoreKDF = options.KeystoreKDF
+	}
+
+	if options.KeystorePasswordSource != "" || options.KeystorePasswordEnv != "" || options.KeystorePasswordFile != "" {
+		if err := requiresLocalSigner(options, "a keystore password source"); err != nil {
+			return err
+		}
+		source := &types.PasswordSource{
+			Source: options.KeystorePasswordSource,
+			Env:    options.KeystorePasswordEnv,
+			File:   options.KeystorePasswordFile,
+		}
+		if source.Source == "" {
+			source.Source = types.KeystorePasswordPlaintext
+		}
+		if source.File != "" {
+			if source.File, err = filepath.Abs(source.File); err != nil {
+				return err
+			}
+		}
+		if err := source.Validate(); err != nil {
+			return err
+		}
+		if source.Source != types.KeystorePasswordPlaintext {
+			// The password is passed to the signer as a docker secret, which the standalone docker-compose does not support
+			if version, ok := s.ctx.Value(docker.CtxComposeVersionKey{}).(docker.DockerComposeVersion); ok && version == docker.ComposeV1 {
+				return fmt.Errorf("the '%s' keystore password source needs the docker compose plugin, as docker-compose v1 does not support secrets", source.Source)
+			}
+			if options.SharedSigner != "" {
+				return fmt.Errorf("a shared signer can only be used with the '%s' keystore password source", types.KeystorePasswordPlaintext)
+			}
+		}
+		s.Stack.KeystorePassword = source
+	}
+
+	if options.VerifySigner {
+		if !fftypes.FFEnum(options.BlockchainProvider).Equals(types.BlockchainProviderEthereum) ||
+			fftypes.FFEnum(options.BlockchainNodeProvider).Equals(types.BlockchainNodeProviderGeth) {
+			return fmt.Errorf("signer verification can only be used with the signer of the '%s' or '%s' ethereum blockchain node providers", types.BlockchainNodeProviderBesu, types.BlockchainNodeProviderRemoteRPC)
+		}
+		s.Stack.VerifySigner = true
+	}
+
+	if options.SignerHealthCheck != "" {
+		if err := requiresLocalSigner(options, "a signer health check"); err != nil {
+			return err
+		}
+		if err := types.ValidateSignerHealthCheck(options.SignerHealthCheck); err != nil {
+			return err
+		}
+		s.Stack.SignerHealthCheck = options.SignerHealthCheck
+	}
+
+	if options.RPCUsername != "" || options.RPCPassword != "" || options.RPCBearerToken != "" {
+		if err := requiresLocalSigner(options, "downstream RPC credentials"); err != nil {
+			return err
+		}
+		if s.Stack.SignerType.Equals(types.SignerTypeJava) {
+			return fmt.Errorf("downstream RPC credentials cannot be used with the '%s' signer, which has no options for them", types.SignerTypeJava)
+		}
+		s.Stack.DownstreamRPCAuth = &types.RPCAuthConfig{
+			Username:    options.RPCUsername,
+			Password:    options.RPCPassword,
+			BearerToken: options.RPCBearerToken,
+		}
+		if err := s.Stack.DownstreamRPCAuth.Validate(); err != nil {
+			return err
+		}
+	}
+
+	if options.RPCConnectionTimeout != "" || options.RPCRetries != 0 {
+		if err := requiresLocalSigner(options, "downstream RPC connection settings"); err != nil {
+			return err
+		}
+		if s.Stack.SignerType.Equals(types.SignerTypeJava) {
+			return fmt.Errorf("downstream RPC connection settings cannot be used with the '%s' signer, which has no options for them", types.SignerTypeJava)
+		}
+		s.Stack.DownstreamRPCConnection = &types.RPCConnectionConfig{
+			ConnectionTimeout: options.RPCConnectionTimeout,
+			Retries:           options.RPCRetries,
+		}
+		if err := s.Stack.DownstreamRPCConnection.Validate(); err != nil {
+			return err
+		}
+	}
+
+	if options.RPCCACert != "" {
+		// Only a remote node is reached over https, the local nodes are always plain http inside the stack
+		if !fftypes.FFEnum(options.BlockchainProvider).Equals(types.BlockchainProviderEthereum) ||
+			!fftypes.FFEnum(options.BlockchainNodeProvider).Equals(types.BlockchainNodeProviderRemoteRPC) ||
+			options.RemoteSignerURL != "" || !strings.HasPrefix(options.RemoteNodeURL, "https://") {
+			return fmt.Errorf("a downstream RPC CA certificate can only be used with the signer of the '%s' ethereum blockchain node provider and an https remote node URL", types.BlockchainNodeProviderRemoteRPC)
+		}
+		if err := ethsigner.ValidateCACertificate(options.RPCCACert); err != nil {
+			return err
+		}
+		caCert, err := filepath.Abs(options.RPCCACert)
+		if err != nil {
+			return err
+		}
+		s.Stack.DownstreamRPCCACert = caCert
+	}
+
+	if options.Mnemonic != "" {
+		if !fftypes.FFEnum(options.BlockchainProvider).Equals(types.BlockchainProviderEthereum) || options.RemoteSignerURL != "" {
+			return fmt.Errorf("member accounts can only be derived from a mnemonic for ethereum stacks without a remote signer")
+		}
+		if err := ethereum.ValidateMnemonic(options.Mnemonic); err != nil {
+			return err
+		}
+	}
+
+	if options.ReuseKeystore {
+		if !runsLocalSigner(options) {
+			return fmt.Errorf("an existing keystore can only be reused by the signer of the '%s' or '%s' ethereum blockchain node providers", types.BlockchainNodeProviderBesu, types.BlockchainNodeProviderRemoteRPC)
+		}
+		if options.Mnemonic != "" {
+			return fmt.Errorf("an existing keystore cannot be reused when the member accounts are derived from a mnemonic")
+		}
+		// The keystore of a shared signer holds the accounts of every stack that shares it
+		if options.SharedSigner != "" {
+			return fmt.Errorf("an existing keystore cannot be reused by a stack with a shared signer")
+		}
+	}
+
+	if len(options.NonSigningMembers) > 0 {
+		if err := requiresLocalSigner(options, "non-signing members"); err != nil {
+			return err
+		}
+		if err := validateNonSigningMembers(options); err != nil {
+			return err
+		}
+	}
+
+	if options.RemoteSignerURL != "" {
+		if err := validateRemoteSigner(options); err != nil {
+			return err
+		}
+		s.Stack.RemoteSignerURL = options.RemoteSignerURL
+	}
+
+	if options.CPULimit != "" || options.MemoryLimit != "" {
+		s.Stack.ResourceLimits = &types.ResourceLimitsConfig{
+			CPUs:   options.CPULimit,
+			Memory: options.MemoryLimit,
+		}
+		if err := s.Stack.ResourceLimits.Validate(); err != nil {
+			return err
+		}
+	}
+
+	for _, spec := range options.Mounts {
+		mount, err := types.ParseMountConfig(spec)
+		if err != nil {
+			return err
+		}
+		// Compose resolves relative paths against the stack directory, rather than the one ff init is run in
+		if mount.HostPath, err = filepath.Abs(mount.HostPath); err != nil {
+			return err
+		}
+		s.Stack.Mounts = append(s.Stack.Mounts, mount)
+	}
+
+	if options.RestartPolicy != "" {
+		if err := types.ValidateRestartPolicy(options.RestartPolicy); err != nil {
+			return err
+		}
+		s.Stack.RestartPolicy = options.RestartPolicy
+	}
+
+	if options.ImageMirror != "" {
+		if !imageMirrorRegex.MatchString(options.ImageMirror) {
+			return fmt.Errorf("invalid image mirror '%s': must be a registry host optionally followed by a path, such as registry.internal/mirror", options.ImageMirror)
+		}
+		s.Stack.ImageMirror = strings.TrimSuffix(options.ImageMirror, "/")
+	}
+
+	if options.Offline {
+		if options.ManifestPath == "" {
+			return fmt.Errorf("--manifest must be set in offline mode, as the manifest for a FireFly release cannot be downloaded")
+		}
+		s.Stack.Offline = true
+		s.ctx = docker.WithOffline(s.ctx)
+	}
+
+	if options.ExternalNetwork != "" {
+		if !dockerNetworkNameRegex.MatchString(options.ExternalNetwork) {
+			return fmt.Errorf("invalid docker network name '%s'", options.ExternalNetwork)
+		}
+		s.Stack.ExternalNetwork = options.ExternalNetwork
+	}
+
+	if options.SharedSigner != "" {
+		if err := requiresLocalSigner(options, "a shared signer"); err != nil {
+			return err
+		}
+		if err := types.ValidateSharedSignerName(options.SharedSigner); err != nil {
+			return err
+		}
+		if options.ExternalNetwork == "" {
+			return fmt.Errorf("a shared signer can only be used with an external network, which the stacks that share the signer reach it on")
+		}
+		others, err := otherStacks(options.StackName)
+		if err != nil {
+			return err
+		}
+		s.Stack.SharedSigner = options.SharedSigner
+		if s.Stack.SharedSignerOwner, err = ethsigner.SharedSignerOwner(s.Stack, others); err != nil {
+			return err
+		}
+	}
+
 	tokenProviders, err := types.FFEnumArray(s.ctx, options.TokenProviders)
 	if err != nil {
 		return err
@@ -131,7 +518,9 @@ func (s *StackManager) InitStack(options *types.InitOptions) (err error) {
 
 	if options.PrometheusEnabled {
 		s.Stack.PrometheusEnabled = true
-		s.Stack.ExposedPrometheusPort = options.PrometheusPort
+		if s.Stack.ExposedPrometheusPort, err = s.ports.allocate(options.PrometheusPort); err != nil {
+			return err
+		}
 	}
 
 	if len(options.CCPYAMLPaths) != 0 && len(options.MSPPaths) != 0 {
@@ -152,22 +541,41 @@ func (s *StackManager) InitStack(options *types.InitOptions) (err error) {
 	} else {
 		// Otherwise, fetch the manifest file from GitHub for the specified version
 		if options.FireFlyVersion == "" || strings.ToLower(options.FireFlyVersion) == "latest" {
-			manifest, err = core.GetManifestForChannel(fftypes.FFEnum(options.ReleaseChannel))
+			manifest, err = core.GetManifestForChannel(s.ctx, fftypes.FFEnum(options.ReleaseChannel))
 			if err != nil {
 				return err
 			}
 		} else {
-			manifest, err = core.GetManifestForRelease(options.FireFlyVersion)
+			manifest, err = core.GetManifestForRelease(s.ctx, options.FireFlyVersion)
 			if err != nil {
 				return err
 			}
 		}
 	}
 
+	if options.SignerImage != "" {
+		manifest.Signer = types.ParseManifestEntry(options.SignerImage)
+	} else if s.Stack.SignerType.Equals(types.SignerTypeJava) {
+		manifest.Signer = types.ParseManifestEntry(ethsigner.JavaSignerImage)
+	}
+
 	s.Stack.VersionManifest = manifest
 	s.blockchainProvider = s.getBlockchainProvider()
 	s.tokenProviders = s.getITokenProviders()
 
+	if err := s.pinImages(options); err != nil {
+		return err
+	}
+	if err := s.validateImageVersions(); err != nil {
+		return err
+	}
+
+	if options.ReuseKeystore {
+		if err := s.loadReusedAccounts(options.MemberCount); err != nil {
+			return err
+		}
+	}
+
 	for i := 0; i < options.MemberCount; i++ {
 		externalProcess := i < options.ExternalProcesses
 		member, err := s.createMember(fmt.Sprint(i), i, options, externalProcess)
@@ -184,7 +592,10 @@ func (s *StackManager) InitStack(options *types.InitOptions) (err error) {
 		return err
 	}
 
-	compose := s.buildDockerCompose()
+	compose, err := s.buildDockerCompose()
+	if err != nil {
+		return err
+	}
 	if err := s.writeDockerCompose(compose); err != nil {
 		return fmt.Errorf("failed to write docker-compose.yml: %s", err)
 	}
@@ -194,7 +605,125 @@ func (s *StackManager) InitStack(options *types.InitOptions) (err error) {
 	return s.writeConfig(options)
 }
 
+// loadReusedAccounts reads the accounts in the keystore of the signer volume of an earlier stack with the same
+// name, for the members to be given in place of new accounts
+func (s *StackManager) loadReusedAccounts(memberCount int) (err error) {
+	if s.reusedAccounts, err = ethsigner.ExistingAccounts(s.ctx, s.Stack); err != nil {
+		return err
+	}
+	switch {
+	case len(s.reusedAccounts) == 0:
+		s.Log.Info(fmt.Sprintf("no existing keystore was found for stack '%s', so new member accounts will be generated", s.Stack.Name))
+	case len(s.reusedAccounts) < memberCount:
+		s.Log.Info(fmt.Sprintf("reusing %d existing accounts for stack '%s', and generating new accounts for the other %d members", len(s.reusedAccounts), s.Stack.Name, memberCount-len(s.reusedAccounts)))
+	case len(s.reusedAccounts) > memberCount:
+		s.Log.Warn(fmt.Sprintf("the existing keystore of stack '%s' holds %d accounts, so only the first %d are reused by its members", s.Stack.Name, len(s.reusedAccounts), memberCount))
+	default:
+		s.Log.Info(fmt.Sprintf("reusing the %d existing accounts of stack '%s'", len(s.reusedAccounts), s.Stack.Name))
+	}
+	return nil
+}
+
+// pinImages resolves the floating tags of the blockchain node and signer images to immutable digests at
+// init time, and stores them in the stack config so that every subsequent start uses exactly the same images
+func (s *StackManager) pinImages(options *types.InitOptions) error {
+	if !s.Stack.BlockchainProvider.Equals(types.BlockchainProviderEthereum) {
+		return nil
+	}
+	if s.Stack.BlockchainNodeProvider.Equals(types.BlockchainNodeProviderGeth) {
+		image := options.GethImage
+		if image == "" {
+			image = geth.DefaultImage
+		}
+		entry := types.ParseManifestEntry(image)
+		if err := pinManifestEntry(s.ctx, entry, s.Stack.ImageMirror); err != nil {
+			return err
+		}
+		s.Stack.GethImage = entry.GetDockerImageString()
+		return nil
+	}
+	return pinManifestEntry(s.ctx, s.Stack.VersionManifest.Signer, s.Stack.ImageMirror)
+}
+
+// validateImageVersions checks that the blockchain node and signer images are not older than the oldest versions
+// supported by the CLI. Images that do not advertise their version are allowed, with a warning.
+func (s *StackManager) validateImageVersions() error {
+	if !s.Stack.BlockchainProvider.Equals(types.BlockchainProviderEthereum) {
+		return nil
+	}
+	if s.Stack.BlockchainNodeProvider.Equals(types.BlockchainNodeProviderGeth) {
+		return s.validateImageVersion(types.MirrorImage(s.Stack.ImageMirror, s.Stack.GethImage), geth.MinimumImageVersion)
+	}
+	signer := s.Stack.VersionManifest.Signer
+	if s.Stack.RemoteSignerURL != "" || signer == nil || signer.Local || s.Stack.SignerType.Equals(types.SignerTypeJava) {
+		// The minimum version only applies to firefly-signer, which versions independently of the Java signer
+		return nil
+	}
+	return s.validateImageVersion(types.MirrorImage(s.Stack.ImageMirror, signer.GetDockerImageString()), ethsigner.MinimumImageVersion)
+}
+
+func (s *StackManager) validateImageVersion(image, minimumVersion string) error {
+	version, err := docker.GetImageLabel(s.ctx, image, core.ImageVersionLabel)
+	if err != nil {
+		return fmt.Errorf("failed to read the labels of image '%s': %s", image, err)
+	}
+	if version == "" {
+		s.Log.Warn(fmt.Sprintf("image '%s' does not have a '%s' label - unable to check that it is compatible", image, core.ImageVersionLabel))
+		return nil
+	}
+	result, err := core.CompareVersions(version, minimumVersion)
+	if err != nil {
+		s.Log.Warn(fmt.Sprintf("image '%s' has an unrecognized version '%s' - unable to check that it is compatible", image, version))
+		return nil
+	}
+	if result < 0 {
+		return fmt.Errorf("image '%s' is version %s, but the oldest version supported by this CLI is %s", image, version, minimumVersion)
+	}
+	return nil
+}
+
+// pinManifestEntry resolves the digest of an image in the registry it will be pulled from, which is the
+// image mirror if one is set. A mirror holds copies of the upstream images, so the digest is the same.
+func pinManifestEntry(ctx context.Context, entry *types.ManifestEntry, imageMirror string) error {
+	if entry == nil || entry.Local || entry.SHA != "" {
+		return nil
+	}
+	image := types.MirrorImage(imageMirror, entry.GetDockerImageString())
+	digest, err := docker.GetImageDigest(ctx, image)
+	if err != nil {
+		return fmt.Errorf("failed to resolve digest for image '%s': %s", image, err)
+	}
+	if digest == "" {
+		// Only possible offline, for an image that was loaded rather than pulled from a registry
+		log.LoggerFromContext(ctx).Warn(fmt.Sprintf("image '%s' has no registry digest locally - its tag will not be pinned", image))
+		return nil
+	}
+	entry.SHA = strings.TrimPrefix(digest, "sha256:")
+	return nil
+}
+
+// composeDown removes the containers and volumes of the stack. The volumes that are not declared in the
+// compose file are removed separately, by removeVolumes.
+func (s *StackManager) composeDown() error {
+	if err := s.ensureComposeFile(); err != nil {
+		return err
+	}
+	return docker.ComposeDown(s.composeContext(), s.Stack.StackDir, true, s.ShutdownTimeout)
+}
+
 func (s *StackManager) runDockerComposeCommand(command ...string) error {
+	if err := s.ensureComposeFile(); err != nil {
+		return err
+	}
+	return docker.RunDockerComposeCommand(s.composeContext(), s.Stack.StackDir, command...)
+}
+
+// composeContext returns the context for compose commands that run against the compose project of the stack
+func (s *StackManager) composeContext() context.Context {
+	return docker.WithComposeProject(s.ctx, s.Stack.Name)
+}
+
+func (s *StackManager) ensureComposeFile() error {
 	baseCompose := filepath.Join(s.Stack.StackDir, "docker-compose.yml")
 	runtimeCompose := filepath.Join(s.Stack.RuntimeDir, "docker-compose.yml")
 	if _, err := os.Stat(baseCompose); os.IsNotExist(err) {
@@ -205,22 +734,44 @@ func (s *StackManager) runDockerComposeCommand(command ...string) error {
 			}
 		}
 	}
-	return docker.RunDockerComposeCommand(s.ctx, s.Stack.StackDir, command...)
+	return nil
+}
+
+func (s *StackManager) buildDockerCompose() (*docker.DockerComposeConfig, error) {
+	compose, _, err := s.buildMirroredDockerCompose()
+	return compose, err
 }
 
-func (s *StackManager) buildDockerCompose() *docker.DockerComposeConfig {
+// buildMirroredDockerCompose builds the docker compose definition of the stack, with every image pointing at
+// the image mirror of the stack if it has one. The original image of each mirrored one is also returned,
+// keyed by the mirrored name.
+func (s *StackManager) buildMirroredDockerCompose() (*docker.DockerComposeConfig, map[string]string, error) {
 	compose := docker.CreateDockerCompose(s.Stack)
-	extraServices := s.blockchainProvider.GetDockerServiceDefinitions()
+	extraServices, err := s.blockchainProvider.GetDockerServiceDefinitions()
+	if err != nil {
+		return nil, nil, err
+	}
 	for i, tp := range s.tokenProviders {
 		extraServices = append(extraServices, tp.GetDockerServiceDefinitions(i)...)
 	}
 
+	defaultLimits := make(map[string]*docker.ResourceLimits)
 	for _, serviceDefinition := range extraServices {
 		// Add each service definition to the docker compose file
 		compose.Services[serviceDefinition.ServiceName] = serviceDefinition.Service
+		defaultLimits[serviceDefinition.ServiceName] = serviceDefinition.ResourceLimits
 		// Add the volume name for each volume used by this service
 		for _, volumeName := range serviceDefinition.VolumeNames {
-			compose.Volumes[volumeName] = struct{}{}
+			compose.Volumes[volumeName] = &docker.Volume{}
+		}
+		for _, volumeName := range serviceDefinition.ExternalVolumeNames {
+			compose.Volumes[volumeName] = &docker.Volume{External: true}
+		}
+		for secretName, secret := range serviceDefinition.Secrets {
+			if compose.Secrets == nil {
+				compose.Secrets = make(map[string]*docker.Secret)
+			}
+			compose.Secrets[secretName] = secret
 		}
 
 		// Add a dependency so each firefly core container won't start up until dependencies are up
@@ -234,7 +785,114 @@ func (s *StackManager) buildDockerCompose() *docker.DockerComposeConfig {
 			}
 		}
 	}
-	return compose
+	if s.Stack.ExternalNetwork != "" {
+		compose.AttachExternalNetwork(s.Stack.ExternalNetwork)
+	}
+	s.setResourceLimits(compose, defaultLimits)
+	s.setRestartPolicy(compose)
+	s.addMounts(compose)
+	return compose, compose.MirrorImages(s.Stack.ImageMirror), nil
+}
+
+// addMounts adds the extra mounts of the stack to the volumes of their services. Mounts of services that are not
+// in the stack are reported by checkMounts.
+func (s *StackManager) addMounts(compose *docker.DockerComposeConfig) {
+	for _, mount := range s.Stack.Mounts {
+		if service, ok := compose.Services[mount.Service]; ok {
+			service.Volumes = append(service.Volumes, mount.Volume())
+		}
+	}
+}
+
+// checkMounts makes sure that the extra mounts of the stack can be made before starting it. Each host path must
+// exist, as docker would otherwise create an empty directory owned by root in its place, and each target must
+// not clash with a mount the service already has.
+func (s *StackManager) checkMounts(compose *docker.DockerComposeConfig) error {
+	extraVolumes := make(map[string]bool)
+	for _, mount := range s.Stack.Mounts {
+		extraVolumes[mount.Service+"/"+mount.Volume()] = true
+	}
+	for i, mount := range s.Stack.Mounts {
+		service, ok := compose.Services[mount.Service]
+		if !ok {
+			return fmt.Errorf("cannot mount '%s' into service '%s', which is not in stack '%s'", mount.HostPath, mount.Service, s.Stack.Name)
+		}
+		if _, err := os.Stat(mount.HostPath); err != nil {
+			return fmt.Errorf("cannot mount '%s' into service '%s': %s", mount.HostPath, mount.Service, err)
+		}
+		target := path.Clean(mount.ContainerPath)
+		for _, other := range s.Stack.Mounts[:i] {
+			if other.Service == mount.Service && path.Clean(other.ContainerPath) == target {
+				return fmt.Errorf("'%s' is mounted more than once in service '%s'", target, mount.Service)
+			}
+		}
+		for _, volume := range service.Volumes {
+			existing, ok := volumeTarget(volume)
+			if !ok || extraVolumes[mount.Service+"/"+volume] {
+				continue
+			}
+			if existing == target || strings.HasPrefix(existing, strings.TrimSuffix(target, "/")+"/") {
+				return fmt.Errorf("cannot mount '%s' over '%s' in service '%s', which hides its mount of '%s'", mount.HostPath, target, mount.Service, existing)
+			}
+		}
+	}
+	return nil
+}
+
+// volumeTarget returns the path in the container of a volume in the short syntax of docker compose
+func volumeTarget(volume string) (string, bool) {
+	parts := strings.Split(volume, ":")
+	if len(parts) < 2 {
+		return "", false
+	}
+	return path.Clean(parts[1]), true
+}
+
+// setRestartPolicy gives every service of the stack its restart policy, which is DefaultRestartPolicy
+// unless the stack overrides it. The services are all long running.
+func (s *StackManager) setRestartPolicy(compose *docker.DockerComposeConfig) {
+	policy := s.Stack.RestartPolicy
+	if policy == "" {
+		policy = types.DefaultRestartPolicy
+	}
+	for _, service := range compose.Services {
+		service.Restart = policy
+	}
+}
+
+func (s *StackManager) setResourceLimits(compose *docker.DockerComposeConfig, defaultLimits map[string]*docker.ResourceLimits) {
+	hasLimits := s.Stack.ResourceLimits != nil
+	for _, limits := range defaultLimits {
+		hasLimits = hasLimits || limits != nil
+	}
+	if !hasLimits {
+		return
+	}
+	// The syntax for limits differs between compose versions, so fall back to that of the compose plugin if
+	// the installed version cannot be detected
+	version, err := docker.DetectComposeVersion(s.ctx)
+	if err != nil {
+		version = docker.ComposeV2
+	}
+	for name, service := range compose.Services {
+		service.SetResourceLimits(defaultLimits[name].WithConfig(s.Stack.ResourceLimits), version)
+	}
+}
+
+// checkExternalNetwork makes sure that the external network the stack was initialized with exists, as compose
+// will not create it
+func (s *StackManager) checkExternalNetwork() error {
+	if s.Stack.ExternalNetwork == "" {
+		return nil
+	}
+	exists, err := docker.NetworkExists(s.ctx, s.Stack.ExternalNetwork)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("stack '%s' uses the external docker network '%s', which does not exist. Create it with 'docker network create %s', or start the project that owns it first", s.Stack.Name, s.Stack.ExternalNetwork, s.Stack.ExternalNetwork)
+	}
+	return nil
 }
 
 func CheckExists(stackName string) (bool, error) {
@@ -266,8 +924,40 @@ func (s *StackManager) LoadStack(stackName string) error {
 	if err := json.Unmarshal(d, &stack); err != nil {
 		return err
 	}
+	if err := stack.HealthCheck.Validate(); err != nil {
+		return fmt.Errorf("invalid stack config for '%s': %s", stackName, err)
+	}
+	if err := stack.ResourceLimits.Validate(); err != nil {
+		return fmt.Errorf("invalid stack config for '%s': %s", stackName, err)
+	}
+	for _, mount := range stack.Mounts {
+		if err := mount.Validate(); err != nil {
+			return fmt.Errorf("invalid stack config for '%s': %s", stackName, err)
+		}
+	}
+	if stack.RestartPolicy != "" {
+		if err := types.ValidateRestartPolicy(stack.RestartPolicy); err != nil {
+			return fmt.Errorf("invalid stack config for '%s': %s", stackName, err)
+		}
+	}
+	if stack.KeystoreKDF != "" {
+		if err := types.ValidateKeystoreKDF(stack.KeystoreKDF); err != nil {
+			return fmt.Errorf("invalid stack config for '%s': %s", stackName, err)
+		}
+	}
+	if err := stack.KeystorePassword.Validate(); err != nil {
+		return fmt.Errorf("invalid stack config for '%s': %s", stackName, err)
+	}
+	if stack.SharedSigner != "" {
+		if err := types.ValidateSharedSignerName(stack.SharedSigner); err != nil {
+			return fmt.Errorf("invalid stack config for '%s': %s", stackName, err)
+		}
+	}
 	s.Stack = stack
 	s.Stack.StackDir = stackDir
+	if s.Stack.Offline && !docker.IsOffline(s.ctx) {
+		s.ctx = docker.WithOffline(s.ctx)
+	}
 	s.blockchainProvider = s.getBlockchainProvider()
 	s.tokenProviders = s.getITokenProviders()
 
@@ -293,6 +983,9 @@ func (s *StackManager) LoadStack(stackName string) error {
 		if member.Account != nil {
 			member.Account = s.blockchainProvider.ParseAccount(member.Account)
 		}
+		for i, account := range member.AdditionalAccounts {
+			member.AdditionalAccounts[i] = s.blockchainProvider.ParseAccount(account)
+		}
 	}
 
 	// For backwards compatibility, add a "default" VersionManifest
@@ -403,12 +1096,21 @@ func (s *StackManager) ensureInitDirectories() error {
 func (s *StackManager) writeDockerCompose(compose *docker.DockerComposeConfig) error {
 	comments := "# This file is generated - DO NOT EDIT!\n# To override config, edit docker-compose.override.yml\n"
 	bytes := []byte(comments)
-	yamlBytes, err := yaml.Marshal(compose)
+	marshal := func() ([]byte, error) { return yaml.Marshal(compose) }
+	// Only the compose plugin is relied on to expand the anchors of the shared service config
+	if version, err := docker.DetectComposeVersion(s.ctx); err == nil && version == docker.ComposeV2 {
+		marshal = compose.MarshalAnchored
+	}
+	yamlBytes, err := marshal()
 	if err != nil {
 		return err
 	}
 	bytes = append(bytes, yamlBytes...)
-	return os.WriteFile(filepath.Join(s.Stack.StackDir, "docker-compose.yml"), bytes, 0755)
+	if err := os.WriteFile(filepath.Join(s.Stack.StackDir, "docker-compose.yml"), bytes, 0755); err != nil {
+		return err
+	}
+	// Every image the stack runs is recorded, so that the images can be removed with the stack
+	return docker.TrackStackImages(s.Stack.Name, compose.Images()...)
 }
 
 func (s *StackManager) writeDockerComposeOverride(compose *docker.DockerComposeConfig) error {
@@ -545,49 +1247,130 @@ func (s *StackManager) copyDataExchangeConfigToVolumes() error {
 	return nil
 }
 
-func (s *StackManager) createMember(id string, index int, options *types.InitOptions, external bool) (*types.Organization, error) {
+// runsLocalSigner returns whether the stack runs its own signer, which holds keys generated by the CLI. Only the
+// besu and remote-rpc ethereum blockchain node providers do, unless the stack uses a remote signer.
+func runsLocalSigner(options *types.InitOptions) bool {
+	return fftypes.FFEnum(options.BlockchainProvider).Equals(types.BlockchainProviderEthereum) &&
+		!fftypes.FFEnum(options.BlockchainNodeProvider).Equals(types.BlockchainNodeProviderGeth) && options.RemoteSignerURL == ""
+}
+
+// requiresLocalSigner returns an error if the feature, which configures the signer of the stack, is used by a
+// stack that does not run its own signer
+func requiresLocalSigner(options *types.InitOptions, feature string) error {
+	if !runsLocalSigner(options) {
+		return fmt.Errorf("%s can only be used with the signer of the '%s' or '%s' ethereum blockchain node providers", feature, types.BlockchainNodeProviderBesu, types.BlockchainNodeProviderRemoteRPC)
+	}
+	return nil
+}
+
+// validateRemoteSigner checks the options for stacks that use an externally provided signer, rather than
+// deploying ethsigner with keys generated by the CLI
+func validateRemoteSigner(options *types.InitOptions) error {
+	if !fftypes.FFEnum(options.BlockchainProvider).Equals(types.BlockchainProviderEthereum) ||
+		fftypes.FFEnum(options.BlockchainNodeProvider).Equals(types.BlockchainNodeProviderGeth) {
+		return fmt.Errorf("a remote signer can only be used with the '%s' or '%s' ethereum blockchain node providers", types.BlockchainNodeProviderBesu, types.BlockchainNodeProviderRemoteRPC)
+	}
+	if err := ethsigner.ValidateRemoteSignerURL(options.RemoteSignerURL); err != nil {
+		return err
+	}
+	if len(options.RemoteSignerAddresses) != options.MemberCount {
+		return fmt.Errorf("the address of a key held by the remote signer must be provided for each of the %d members, but %d were provided", options.MemberCount, len(options.RemoteSignerAddresses))
+	}
+	return nil
+}
+
+// validateNonSigningMembers checks that the non-signing members are members of the stack, and that at least one
+// member is left to sign the transactions that deploy the contracts of the stack
+func validateNonSigningMembers(options *types.InitOptions) error {
+	nonSigning := map[int]bool{}
+	for _, index := range options.NonSigningMembers {
+		if index < 0 || index >= options.MemberCount {
+			return fmt.Errorf("non-signing member %d is not a member of the stack, which has members 0 to %d", index, options.MemberCount-1)
+		}
+		nonSigning[index] = true
+	}
+	if len(nonSigning) == options.MemberCount {
+		return fmt.Errorf("at least one member of the stack must sign, to deploy the contracts of the stack")
+	}
+	return nil
+}
+
+func (s *StackManager) createMember(id string, index int, options *types.InitOptions, external bool) (member *types.Organization, err error) {
 	serviceBase := options.ServicesBasePort + (index * 100)
-	member := &types.Organization{
-		ID:                         id,
-		Index:                      &index,
-		ExposedFireflyPort:         options.FireFlyBasePort + index,
-		ExposedFireflyAdminSPIPort: serviceBase + 1, // note shared blockchain node is on zero
-		ExposedConnectorPort:       serviceBase + 2,
-		ExposedUIPort:              serviceBase + 3,
-		ExposedDatabasePort:        serviceBase + 4,
-		External:                   external,
-		OrgName:                    options.OrgNames[index],
-		NodeName:                   options.NodeNames[index],
+	member = &types.Organization{
+		ID:       id,
+		Index:    &index,
+		External: external,
+		OrgName:  options.OrgNames[index],
+		NodeName: options.NodeNames[index],
 	}
 
+	// Each port is allocated from its preferred value, so a port that is taken moves on to the next free one
+	// rather than colliding with another member, another stack, or another process on this host
+	allocate := func(port *int, preferred int) {
+		if err == nil {
+			*port, err = s.ports.allocate(preferred)
+		}
+	}
+	allocate(&member.ExposedFireflyPort, options.FireFlyBasePort+index)
+	allocate(&member.ExposedFireflyAdminSPIPort, serviceBase+1) // note shared blockchain node is on zero
+	allocate(&member.ExposedConnectorPort, serviceBase+2)
+	allocate(&member.ExposedUIPort, serviceBase+3)
+	allocate(&member.ExposedDatabasePort, serviceBase+4)
+
 	nextPort := serviceBase + 5
-	member.ExposedDataexchangePort = serviceBase + nextPort
+	allocate(&member.ExposedDataexchangePort, serviceBase+nextPort)
 	nextPort++
-	member.ExposedIPFSApiPort = serviceBase + nextPort
+	allocate(&member.ExposedIPFSApiPort, serviceBase+nextPort)
 	nextPort++
-	member.ExposedIPFSGWPort = serviceBase + nextPort
+	allocate(&member.ExposedIPFSGWPort, serviceBase+nextPort)
 	nextPort++
 
 	if options.PrometheusEnabled {
-		member.ExposedFireflyMetricsPort = nextPort
+		allocate(&member.ExposedFireflyMetricsPort, nextPort)
 		nextPort++
-		member.ExposedConnectorMetricsPort = nextPort
+		allocate(&member.ExposedConnectorMetricsPort, nextPort)
 		nextPort++
 	}
-	for range options.TokenProviders {
-		member.ExposedTokensPorts = append(member.ExposedTokensPorts, nextPort)
+	member.ExposedTokensPorts = make([]int, len(options.TokenProviders))
+	for i := range options.TokenProviders {
+		allocate(&member.ExposedTokensPorts[i], nextPort)
 		nextPort++
 	}
-
-	account, err := s.blockchainProvider.CreateAccount([]string{member.OrgName, member.OrgName})
+	if options.SandboxEnabled {
+		allocate(&member.ExposedSandboxPort, nextPort)
+	}
 	if err != nil {
 		return nil, err
 	}
-	member.Account = account
 
-	if options.SandboxEnabled {
-		member.ExposedSandboxPort = nextPort
+	for _, nonSigning := range options.NonSigningMembers {
+		if nonSigning == index {
+			// A member that only queries the chain has no key to be generated, written or imported into the signer
+			member.NonSigning = true
+			return member, nil
+		}
 	}
+
+	args := []string{member.OrgName, member.OrgName}
+	if options.RemoteSignerURL != "" {
+		// The remote signer already holds the key for each member
+		args = append(args, fmt.Sprintf("address=%s", options.RemoteSignerAddresses[index]))
+	} else if index < len(s.reusedAccounts) {
+		args = append(args, fmt.Sprintf("privateKey=%s", s.reusedAccounts[index].PrivateKey))
+	} else if options.Mnemonic != "" {
+		// Each member gets the account at its own index, so that the same mnemonic always gives the same stack
+		keyPair, err := ethereum.DeriveKeyPair(options.Mnemonic, index)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, fmt.Sprintf("privateKey=%s", hex.EncodeToString(keyPair.PrivateKeyBytes())))
+	}
+	account, err := s.blockchainProvider.CreateAccount(args)
+	if err != nil {
+		return nil, err
+	}
+	member.Account = account
 	return member, nil
 }
 
@@ -598,10 +1381,32 @@ func (s *StackManager) StartStack(options *types.StartOptions) (messages []strin
 	if err != nil {
 		return messages, err
 	}
+	if err := s.checkExternalNetwork(); err != nil {
+		return messages, err
+	}
+	if err := s.checkSharedSignerRunning(); err != nil {
+		return messages, err
+	}
+	compose, err := s.buildDockerCompose()
+	if err != nil {
+		return messages, err
+	}
+	if err := s.checkMounts(compose); err != nil {
+		return messages, err
+	}
+	if err := s.ensureComposeFile(); err != nil {
+		return messages, err
+	}
+	if err := docker.ValidateCompose(s.composeContext(), s.Stack.StackDir); err != nil {
+		return messages, err
+	}
 	hasBeenRun, err := s.Stack.HasRunBefore()
 	if err != nil {
 		return messages, err
 	}
+	if err := s.prePullImages(); err != nil {
+		return messages, err
+	}
 	if !hasBeenRun {
 		setupMessages, err := s.runFirstTimeSetup(options)
 		messages = append(messages, setupMessages...)
@@ -631,9 +1436,29 @@ func (s *StackManager) StartStack(options *types.StartOptions) (messages []strin
 			return messages, err
 		}
 	}
+	if options.VerifySigner || s.Stack.VerifySigner {
+		if err := s.verifySigner(); err != nil {
+			return messages, err
+		}
+	}
 	return messages, s.ensureFireflyNodesUp(true)
 }
 
+// signerVerifier is implemented by the blockchain providers whose signer can be checked with a test signature
+type signerVerifier interface {
+	VerifySigner() error
+}
+
+// verifySigner checks that the signer of the running stack can sign for each member of the stack
+func (s *StackManager) verifySigner() error {
+	verifier, ok := s.blockchainProvider.(signerVerifier)
+	if !ok {
+		return fmt.Errorf("signer verification is not supported by the '%s' blockchain node provider", s.Stack.BlockchainNodeProvider)
+	}
+	s.Log.Info("verifying the signer")
+	return verifier.VerifySigner()
+}
+
 func (s *StackManager) PullStack(options *types.PullOptions) error {
 	var images []string
 	manifestImages := make(map[string]bool)
@@ -664,7 +1489,11 @@ func (s *StackManager) PullStack(options *types.PullOptions) error {
 	}
 
 	// Iterate over all images used by the blockchain provider
-	for _, service := range s.blockchainProvider.GetDockerServiceDefinitions() {
+	serviceDefinitions, err := s.blockchainProvider.GetDockerServiceDefinitions()
+	if err != nil {
+		return err
+	}
+	for _, service := range serviceDefinitions {
 		if !manifestImages[service.Service.Image] {
 			images = append(images, service.Service.Image)
 		}
@@ -681,17 +1510,69 @@ func (s *StackManager) PullStack(options *types.PullOptions) error {
 
 	// Use docker to pull every image - retry on failure
 	for _, image := range images {
+		image = types.MirrorImage(s.Stack.ImageMirror, image)
+		if docker.IsOffline(s.ctx) {
+			// Nothing can be pulled, but every image must already be present locally
+			if err := docker.PullImage(s.ctx, image); err != nil {
+				return err
+			}
+			continue
+		}
 		s.Log.Info(fmt.Sprintf("pulling '%s'", image))
-		if err := docker.RunDockerCommandRetry(s.ctx, s.Stack.InitDir, options.Retries, "pull", image); err != nil {
+		if err := docker.RunDockerCommandRetry(s.ctx, s.Stack.InitDir, options.Retries, docker.DefaultRetryDelay, "pull", image); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
-func (s *StackManager) removeVolumes() error {
+// prePullRetries is how many times prePullImages retries the images that failed to pull
+const prePullRetries = 2
+
+// prePullImages pulls every image referenced by the stack's docker compose definition before starting it,
+// so that download progress is visible and a missing image fails early with the image name
+func (s *StackManager) prePullImages() error {
+	compose, upstreams, err := s.buildMirroredDockerCompose()
+	if err != nil {
+		return err
+	}
+	images := compose.Images()
+	if err := docker.TrackStackImages(s.Stack.Name, images...); err != nil {
+		return err
+	}
+	pulls := make([]string, 0, len(images))
+	for _, image := range images {
+		// In an air-gapped environment the upstream images may have been loaded locally instead of being
+		// pushed to the mirror, in which case they only need to be tagged with their mirrored names
+		if upstream, ok := upstreams[image]; ok && !docker.ImageExistsLocally(s.ctx, image) && docker.ImageExistsLocally(s.ctx, upstream) {
+			s.Log.Info(fmt.Sprintf("tagging local image '%s' as '%s'", upstream, image))
+			if err := docker.TagImage(s.ctx, upstream, image); err != nil {
+				return err
+			}
+			if err := docker.TrackStackImages(s.Stack.Name, upstream); err != nil {
+				return err
+			}
+			continue
+		}
+		pulls = append(pulls, image)
+	}
+	retries := prePullRetries
+	if docker.IsOffline(s.ctx) {
+		// Nothing is pulled offline, so a missing image will not appear on a retry
+		retries = 0
+	}
+	return docker.PullImagesRetry(s.ctx, pulls, s.PullConcurrency, retries, docker.DefaultRetryDelay)
+}
+
+// stackVolumes returns the names of the volumes the stack owns, without the prefix of the stack name. The
+// external volumes of a shared signer belong to every stack that uses it, so are not included.
+func (s *StackManager) stackVolumes() ([]string, error) {
+	serviceDefinitions, err := s.blockchainProvider.GetDockerServiceDefinitions()
+	if err != nil {
+		return nil, err
+	}
 	var volumes []string
-	for _, service := range s.blockchainProvider.GetDockerServiceDefinitions() {
+	for _, service := range serviceDefinitions {
 		volumes = append(volumes, service.VolumeNames...)
 	}
 	for iTok, tp := range s.tokenProviders {
@@ -702,6 +1583,14 @@ func (s *StackManager) removeVolumes() error {
 	for volumeName := range docker.CreateDockerCompose(s.Stack).Volumes {
 		volumes = append(volumes, volumeName)
 	}
+	return volumes, nil
+}
+
+func (s *StackManager) removeVolumes() error {
+	volumes, err := s.stackVolumes()
+	if err != nil {
+		return err
+	}
 	for _, volumeName := range volumes {
 		if err := docker.RunDockerCommand(s.ctx, "", "volume", "remove", fmt.Sprintf("%s_%s", s.Stack.Name, volumeName)); err != nil {
 			if !strings.Contains(strings.ToLower(err.Error()), "no such volume") {
@@ -718,7 +1607,10 @@ func (s *StackManager) runStartupSequence(firstTimeSetup bool) error {
 	}
 
 	s.Log.Info("starting FireFly dependencies")
-	if err := s.runDockerComposeCommand("up", "-d"); err != nil {
+	if err := s.ensureComposeFile(); err != nil {
+		return err
+	}
+	if err := docker.ComposeUp(s.composeContext(), s.Stack.StackDir); err != nil {
 		return err
 	}
 
@@ -730,11 +1622,14 @@ func (s *StackManager) runStartupSequence(firstTimeSetup bool) error {
 }
 
 func (s *StackManager) StopStack() error {
-	return s.runDockerComposeCommand("stop")
+	if err := s.ensureComposeFile(); err != nil {
+		return err
+	}
+	return docker.ComposeStop(s.composeContext(), s.Stack.StackDir, s.ShutdownTimeout)
 }
 
 func (s *StackManager) ResetStack() error {
-	if err := s.runDockerComposeCommand("down"); err != nil {
+	if err := s.composeDown(); err != nil {
 		return err
 	}
 	if err := os.RemoveAll(s.Stack.RuntimeDir); err != nil {
@@ -750,43 +1645,86 @@ func (s *StackManager) ResetStack() error {
 }
 
 func (s *StackManager) RemoveStack() error {
-	if err := s.runDockerComposeCommand("down"); err != nil {
+	if err := s.CheckSharedSignerUsers(); err != nil {
 		return err
 	}
-	if err := s.removeVolumes(); err != nil {
+	if err := s.composeDown(); err != nil {
+		return err
+	}
+	// Catch anything left behind by compose, or created for the stack under names that are no longer generated
+	otherStacks, err := s.stacksSharingPrefix()
+	if err != nil {
 		return err
 	}
+	if err := docker.PruneStack(s.ctx, s.Stack.Name, otherStacks...); err != nil {
+		return err
+	}
+	if s.RemoveImages {
+		if err := docker.RemoveUnusedStackImages(s.ctx, s.Stack.Name); err != nil {
+			return err
+		}
+	}
 	return os.RemoveAll(s.Stack.StackDir)
 }
 
-func (s *StackManager) checkPortsAvailable() error {
-	ports := make([]int, 1)
-	ports[0] = s.Stack.ExposedBlockchainPort
-	for _, member := range s.Stack.Members {
-
-		ports = append(ports, member.ExposedConnectorPort)
-		ports = append(ports, member.ExposedDatabasePort)
-		ports = append(ports, member.ExposedUIPort)
-		ports = append(ports, member.ExposedTokensPorts...)
+// checkSharedSignerRunning makes sure that a stack that uses a shared signer run by another stack only starts once
+// that signer is running, as the stack does not run it itself and its connectors cannot start without it
+func (s *StackManager) checkSharedSignerRunning() error {
+	if s.Stack.SharedSigner == "" || s.Stack.SharedSignerOwner {
+		return nil
+	}
+	others, err := otherStacks(s.Stack.Name)
+	if err != nil {
+		return err
+	}
+	runner := ethsigner.SharedSignerRunner(s.Stack, others)
+	if runner == "" {
+		return fmt.Errorf("no stack runs shared signer '%s', so stack '%s' cannot start - the stack that ran it has been removed, and stack '%s' must be recreated to run the signer itself", s.Stack.SharedSigner, s.Stack.Name, s.Stack.Name)
+	}
+	state, err := docker.InspectContainerState(s.ctx, ethsigner.SharedSignerContainerName(s.Stack.SharedSigner))
+	if err != nil && !errors.Is(err, docker.ErrContainerNotFound) {
+		return err
+	}
+	if state.Status != "running" {
+		return fmt.Errorf("shared signer '%s' is run by stack '%s', which must be started before stack '%s'", s.Stack.SharedSigner, runner, s.Stack.Name)
+	}
+	return nil
+}
 
-		if !member.External {
-			ports = append(ports, member.ExposedFireflyAdminSPIPort)
-			ports = append(ports, member.ExposedFireflyPort)
-			ports = append(ports, member.ExposedFireflyMetricsPort)
-		}
-		ports = append(ports, member.ExposedDataexchangePort)
-		ports = append(ports, member.ExposedIPFSApiPort)
-		ports = append(ports, member.ExposedIPFSGWPort)
-		if s.Stack.SandboxEnabled {
-			ports = append(ports, member.ExposedSandboxPort)
-		}
+// CheckSharedSignerUsers returns an error if the stack runs a shared signer that other stacks still use, which
+// would lose their signer if the stack was removed
+func (s *StackManager) CheckSharedSignerUsers() error {
+	if s.Stack.SharedSigner == "" || !s.Stack.SharedSignerOwner {
+		return nil
 	}
+	others, err := otherStacks(s.Stack.Name)
+	if err != nil {
+		return err
+	}
+	if users := ethsigner.SharedSignerUsers(s.Stack, others); len(users) > 0 {
+		return fmt.Errorf("stack '%s' runs shared signer '%s', which is still used by stacks: %s - remove them first", s.Stack.Name, s.Stack.SharedSigner, strings.Join(users, ", "))
+	}
+	return nil
+}
 
-	if s.Stack.PrometheusEnabled {
-		ports = append(ports, s.Stack.ExposedPrometheusPort)
+// stacksSharingPrefix returns the other stacks whose names start with the name of this stack followed by
+// an underscore, and so whose docker resources look as though they belong to this one
+func (s *StackManager) stacksSharingPrefix() ([]string, error) {
+	stackNames, err := ListStacks()
+	if err != nil {
+		return nil, err
 	}
+	others := []string{}
+	for _, stackName := range stackNames {
+		if strings.HasPrefix(stackName, s.Stack.Name+"_") {
+			others = append(others, stackName)
+		}
+	}
+	return others, nil
+}
 
-	for _, port := range ports {
+func (s *StackManager) checkPortsAvailable() error {
+	for _, port := range stackPorts(s.Stack) {
 		available, err := checkPortAvailable(port)
 		if err != nil {
 			return err
@@ -871,13 +1809,6 @@ func (s *StackManager) runFirstTimeSetup(options *types.StartOptions) (messages
 		return messages, err
 	}
 
-	pullOptions := &types.PullOptions{
-		Retries: 2,
-	}
-	if err := s.PullStack(pullOptions); err != nil {
-		return messages, err
-	}
-
 	if err := s.runStartupSequence(true); err != nil {
 		return messages, err
 	}
@@ -970,7 +1901,7 @@ func (s *StackManager) runFirstTimeSetup(options *types.StartOptions) (messages
 
 		// Create data directory with correct permissions inside volume
 		dataVolumeName := fmt.Sprintf("%s_firefly_core_data_%s", s.Stack.Name, member.ID)
-		if err := docker.CreateVolume(s.ctx, dataVolumeName); err != nil {
+		if err := docker.CreateVolume(s.ctx, dataVolumeName, docker.StackLabel(s.Stack.Name)); err != nil {
 			return messages, err
 		}
 		if err := docker.MkdirInVolume(s.ctx, dataVolumeName, "db"); err != nil {
@@ -980,7 +1911,10 @@ func (s *StackManager) runFirstTimeSetup(options *types.StartOptions) (messages
 	}
 
 	// Re-write the docker-compose config again, in case new values have been added
-	compose := s.buildDockerCompose()
+	compose, err := s.buildDockerCompose()
+	if err != nil {
+		return messages, err
+	}
 	if err := s.writeDockerCompose(compose); err != nil {
 		return messages, err
 	}
@@ -1071,7 +2005,7 @@ func (s *StackManager) UpgradeStack(version string, forceUpgrade bool) error {
 		return err
 	}
 	oldManifest := s.Stack.VersionManifest
-	oldVersion, err := docker.GetImageLabel(fmt.Sprintf("%s@sha256:%s", oldManifest.FireFly.Image, oldManifest.FireFly.SHA), "tag")
+	oldVersion, err := docker.GetImageLabel(s.ctx, fmt.Sprintf("%s@sha256:%s", oldManifest.FireFly.Image, oldManifest.FireFly.SHA), "tag")
 	if err != nil {
 		return err
 	}
@@ -1083,7 +2017,7 @@ func (s *StackManager) UpgradeStack(version string, forceUpgrade bool) error {
 	}
 
 	// get the version manifest for the new version
-	newManifest, err := core.GetManifestForRelease(version)
+	newManifest, err := core.GetManifestForRelease(s.ctx, version)
 	if err != nil {
 		return err
 	}
@@ -1168,7 +2102,7 @@ func (s *StackManager) PrintStackInfo() error {
 
 // IsRunning prints to the stdout, the stack name and it status as "running" or "not_running".
 func (s *StackManager) IsRunning() error {
-	output, err := docker.RunDockerComposeCommandReturnsStdout(s.Stack.StackDir, "ps")
+	output, err := docker.RunDockerComposeCommandBuffered(s.composeContext(), s.Stack.StackDir, "ps")
 	if err != nil {
 		return err
 	}
@@ -1183,7 +2117,7 @@ func (s *StackManager) IsRunning() error {
 		fmt.Fprintf(w, formatHeader, "STACK", "STATUS")
 	})
 
-	if strings.Contains(string(output), s.Stack.Name) { // if the output contains the stack name, it means the container is running.
+	if strings.Contains(output, s.Stack.Name) { // if the output contains the stack name, it means the container is running.
 		fmt.Fprintf(w, formatBody, s.Stack.Name, "running")
 	} else {
 		fmt.Fprintf(w, formatBody, s.Stack.Name, "not_running")
@@ -1194,11 +2128,16 @@ func (s *StackManager) IsRunning() error {
 }
 
 func (s *StackManager) disableFireflyCoreContainers() error {
-	compose := s.buildDockerCompose()
+	compose, err := s.buildDockerCompose()
+	if err != nil {
+		return err
+	}
 	for _, member := range s.Stack.Members {
 		if !member.External {
-			// Temporarily set the entrypoint to not run anything
-			compose.Services[fmt.Sprintf("firefly_core_%v", *member.Index)].EntryPoint = []string{"/bin/sh", "-c", "exit", "0"}
+			// Temporarily set the entrypoint to not run anything, and so not restart it when it exits
+			service := compose.Services[fmt.Sprintf("firefly_core_%v", *member.Index)]
+			service.EntryPoint = []string{"/bin/sh", "-c", "exit", "0"}
+			service.Restart = "no"
 		}
 	}
 	return s.writeDockerCompose(compose)
@@ -1268,6 +2207,15 @@ func (s *StackManager) CreateAccount(args []string) (string, error) {
 	if err = s.writeStackStateJSON(s.Stack.RuntimeDir); err != nil {
 		return "", err
 	}
+	for _, arg := range args {
+		if strings.HasPrefix(arg, "member=") {
+			// The account was added to a member, which is recorded in the stack config
+			if err := s.writeStackConfig(); err != nil {
+				return "", err
+			}
+			break
+		}
+	}
 
 	// Serialize the account to JSON to print on the command line
 	b, err := json.MarshalIndent(newAccount, "", "  ")
@@ -0,0 +1,344 @@
+package stacks
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/hyperledger/firefly-cli/internal/blockchain"
+	"github.com/hyperledger/firefly-cli/internal/blockchain/ethereum"
+	"github.com/hyperledger/firefly-cli/internal/constants"
+	"github.com/hyperledger/firefly-cli/internal/docker"
+	"github.com/hyperledger/firefly-cli/internal/docker/dockertest"
+	"github.com/hyperledger/firefly-cli/internal/log"
+	"github.com/hyperledger/firefly-cli/pkg/types"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v3"
+)
+
+type serviceProviderStub struct {
+	blockchain.IBlockchainProvider
+}
+
+func (p *serviceProviderStub) GetDockerServiceDefinitions() ([]*docker.ServiceDefinition, error) {
+	return []*docker.ServiceDefinition{
+		{ServiceName: "ethsigner", Service: &docker.Service{
+			Image:   "ghcr.io/hyperledger/firefly-signer",
+			Volumes: []string{"ethsigner:/data", "ethsigner_config:/etc/firefly"},
+		}},
+	}, nil
+}
+
+// newTestStackManager returns a stack manager for a stack with one member, whose blockchain provider only
+// defines an ethsigner service
+func newTestStackManager(stack *types.Stack) *StackManager {
+	index := 0
+	stack.Name = "stack"
+	stack.Database = "postgres"
+	stack.Members = []*types.Organization{{ID: "0", Index: &index}}
+	stack.VersionManifest = &types.VersionManifest{
+		FireFly:      &types.ManifestEntry{Image: "ghcr.io/hyperledger/firefly"},
+		DataExchange: &types.ManifestEntry{Image: "ghcr.io/hyperledger/firefly-dataexchange-https"},
+	}
+	return &StackManager{ctx: context.Background(), blockchainProvider: &serviceProviderStub{}, Stack: stack}
+}
+
+func TestRestartPolicy(t *testing.T) {
+	testcases := []struct {
+		Name     string
+		Policy   string
+		Expected string
+	}{
+		{Name: "default", Policy: "", Expected: "unless-stopped"},
+		{Name: "no", Policy: "no", Expected: "no"},
+		{Name: "always", Policy: "always", Expected: "always"},
+		{Name: "unless-stopped", Policy: "unless-stopped", Expected: "unless-stopped"},
+		{Name: "on-failure", Policy: "on-failure", Expected: "on-failure"},
+		{Name: "on-failure with retries", Policy: "on-failure:5", Expected: "on-failure:5"},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.Name, func(t *testing.T) {
+			assert.NoError(t, types.ValidateRestartPolicy(tc.Expected))
+			s := newTestStackManager(&types.Stack{RestartPolicy: tc.Policy})
+
+			config, err := s.buildDockerCompose()
+			assert.NoError(t, err)
+			b, err := yaml.Marshal(config)
+			assert.NoError(t, err)
+			var compose struct {
+				Services map[string]map[string]interface{} `yaml:"services"`
+			}
+			assert.NoError(t, yaml.Unmarshal(b, &compose))
+			assert.Contains(t, compose.Services, "ethsigner")
+			assert.Contains(t, compose.Services, "firefly_core_0")
+			for name, service := range compose.Services {
+				assert.Equal(t, tc.Expected, service["restart"], name)
+			}
+		})
+	}
+}
+
+func TestMounts(t *testing.T) {
+	hostDir := t.TempDir()
+	keys := filepath.Join(hostDir, "keys")
+	assert.NoError(t, os.Mkdir(keys, 0755))
+	s := newTestStackManager(&types.Stack{Mounts: []*types.MountConfig{
+		{Service: "ethsigner", HostPath: keys, ContainerPath: "/keys", ReadOnly: true},
+		{Service: "ethsigner", HostPath: hostDir, ContainerPath: "/data/extra"},
+	}})
+
+	compose, err := s.buildDockerCompose()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"ethsigner:/data", "ethsigner_config:/etc/firefly", keys + ":/keys:ro", hostDir + ":/data/extra"}, compose.Services["ethsigner"].Volumes)
+	assert.NoError(t, s.checkMounts(compose))
+
+	testcases := []struct {
+		Name  string
+		Mount *types.MountConfig
+		Error string
+	}{
+		{Name: "missing host path", Mount: &types.MountConfig{Service: "ethsigner", HostPath: filepath.Join(hostDir, "missing"), ContainerPath: "/missing"}, Error: "cannot mount '.*missing' into service 'ethsigner': .*no such file or directory"},
+		{Name: "unknown service", Mount: &types.MountConfig{Service: "geth", HostPath: keys, ContainerPath: "/keys"}, Error: "cannot mount '.*' into service 'geth', which is not in stack 'stack'"},
+		{Name: "hides a mount", Mount: &types.MountConfig{Service: "ethsigner", HostPath: keys, ContainerPath: "/etc"}, Error: "cannot mount '.*' over '/etc' in service 'ethsigner', which hides its mount of '/etc/firefly'"},
+		{Name: "duplicate target", Mount: &types.MountConfig{Service: "ethsigner", HostPath: hostDir, ContainerPath: "/keys/"}, Error: "'/keys' is mounted more than once in service 'ethsigner'"},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.Name, func(t *testing.T) {
+			s := newTestStackManager(&types.Stack{Mounts: []*types.MountConfig{
+				{Service: "ethsigner", HostPath: keys, ContainerPath: "/keys", ReadOnly: true},
+				tc.Mount,
+			}})
+			compose, err := s.buildDockerCompose()
+			assert.NoError(t, err)
+			assert.Regexp(t, tc.Error, s.checkMounts(compose))
+		})
+	}
+}
+
+type accountArgsStub struct {
+	blockchain.IBlockchainProvider
+}
+
+func (p *accountArgsStub) CreateAccount(args []string) (interface{}, error) {
+	return args, nil
+}
+
+func TestRequiresLocalSigner(t *testing.T) {
+	testcases := []struct {
+		Name    string
+		Options *types.InitOptions
+		Local   bool
+	}{
+		{Name: "besu", Options: &types.InitOptions{BlockchainProvider: "ethereum", BlockchainNodeProvider: "besu"}, Local: true},
+		{Name: "remote-rpc", Options: &types.InitOptions{BlockchainProvider: "ethereum", BlockchainNodeProvider: "remote-rpc"}, Local: true},
+		{Name: "geth", Options: &types.InitOptions{BlockchainProvider: "ethereum", BlockchainNodeProvider: "geth"}},
+		{Name: "remote signer", Options: &types.InitOptions{BlockchainProvider: "ethereum", BlockchainNodeProvider: "besu", RemoteSignerURL: "http://signer:8545"}},
+		{Name: "fabric", Options: &types.InitOptions{BlockchainProvider: "fabric"}},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.Name, func(t *testing.T) {
+			assert.Equal(t, tc.Local, runsLocalSigner(tc.Options))
+			err := requiresLocalSigner(tc.Options, "a signer health check")
+			if tc.Local {
+				assert.NoError(t, err)
+			} else {
+				assert.Regexp(t, "^a signer health check can only be used with the signer of the 'besu' or 'remote-rpc' ethereum blockchain node providers$", err)
+			}
+		})
+	}
+}
+
+func TestCreateMemberReusedAccounts(t *testing.T) {
+	stubPortsInUse(t)
+	s := &StackManager{
+		ports:              newPortAllocator(nil),
+		blockchainProvider: &accountArgsStub{},
+		reusedAccounts:     []*ethereum.Account{{Address: "0x1234", PrivateKey: "abcd"}},
+	}
+	options := &types.InitOptions{
+		FireFlyBasePort:  5000,
+		ServicesBasePort: 5100,
+		OrgNames:         []string{"org_0", "org_1"},
+		NodeNames:        []string{"node_0", "node_1"},
+		ReuseKeystore:    true,
+	}
+
+	// The first member gets the existing account, and the second one a new account as there are no more
+	member0, err := s.createMember("0", 0, options, false)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"org_0", "org_0", "privateKey=abcd"}, member0.Account)
+	member1, err := s.createMember("1", 1, options, false)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"org_1", "org_1"}, member1.Account)
+}
+
+func TestCreateMemberNonSigning(t *testing.T) {
+	stubPortsInUse(t)
+	s := &StackManager{ports: newPortAllocator(nil), blockchainProvider: &accountArgsStub{}}
+	options := &types.InitOptions{
+		MemberCount:       3,
+		FireFlyBasePort:   5000,
+		ServicesBasePort:  5100,
+		OrgNames:          []string{"org_0", "org_1", "org_2"},
+		NodeNames:         []string{"node_0", "node_1", "node_2"},
+		NonSigningMembers: []int{1},
+	}
+	assert.NoError(t, validateNonSigningMembers(options))
+
+	members := make([]*types.Organization, options.MemberCount)
+	for i := range members {
+		member, err := s.createMember(fmt.Sprint(i), i, options, false)
+		assert.NoError(t, err)
+		members[i] = member
+	}
+	assert.False(t, members[0].NonSigning)
+	assert.NotNil(t, members[0].Account)
+	assert.True(t, members[1].NonSigning)
+	assert.Nil(t, members[1].Account)
+	assert.False(t, members[2].NonSigning)
+	assert.NotNil(t, members[2].Account)
+
+	stack := &types.Stack{Members: []*types.Organization{members[1], members[2]}}
+	assert.Equal(t, members[2], stack.SigningMember())
+
+	options.NonSigningMembers = []int{0, 1, 2, 1}
+	assert.Regexp(t, "at least one member of the stack must sign", validateNonSigningMembers(options))
+	options.NonSigningMembers = []int{3}
+	assert.Regexp(t, "non-signing member 3 is not a member of the stack, which has members 0 to 2", validateNonSigningMembers(options))
+}
+
+func TestBundleImages(t *testing.T) {
+	s := newTestStackManager(&types.Stack{})
+	images, err := s.BundleImages()
+	assert.NoError(t, err)
+	assert.Contains(t, images, "alpine")
+	assert.Contains(t, images, "ghcr.io/hyperledger/firefly-signer")
+	assert.True(t, sort.StringsAreSorted(images))
+	seen := map[string]bool{}
+	for _, image := range images {
+		assert.False(t, seen[image], image)
+		seen[image] = true
+	}
+
+	// Mirrored images are bundled by their upstream names, which are tagged with the mirror when the stack starts
+	s = newTestStackManager(&types.Stack{ImageMirror: "registry.internal:5000"})
+	mirrored, err := s.BundleImages()
+	assert.NoError(t, err)
+	assert.Equal(t, images, mirrored)
+}
+
+// existingVolumes returns a docker.CommandRunner with only the given volumes existing, which records the
+// commands it runs
+func existingVolumes(volumes ...string) *dockertest.Runner {
+	return &dockertest.Runner{Answer: func(name string, args ...string) (string, error) {
+		command := strings.Join(append([]string{name}, args...), " ")
+		for _, volumeName := range volumes {
+			if strings.HasPrefix(command, fmt.Sprintf("docker volume ls --quiet --filter name=^%s$", volumeName)) {
+				return volumeName + "\n", nil
+			}
+		}
+		return "", nil
+	}}
+}
+
+func TestSnapshotStack(t *testing.T) {
+	dir := t.TempDir()
+	s := newTestStackManager(&types.Stack{StackDir: filepath.Join(dir, "stacks", "stack")})
+	assert.NoError(t, os.MkdirAll(s.Stack.StackDir, 0755))
+	assert.NoError(t, os.WriteFile(filepath.Join(s.Stack.StackDir, "stack.json"), []byte("{}"), 0644))
+	runner := existingVolumes("stack_postgres_0", "stack_dataexchange_0")
+	s.ctx = docker.WithCommandRunner(log.WithLogger(context.Background(), &log.StdoutLogger{}), runner)
+	s.Log = log.LoggerFromContext(s.ctx)
+
+	backup := filepath.Join(dir, "backups", "stack")
+	assert.NoError(t, s.snapshotStack(backup))
+	assert.FileExists(t, filepath.Join(backup, "stack", "stack.json"))
+
+	// Only the volumes that exist are snapshotted, in order of name
+	snapshots := []string{}
+	for _, command := range runner.Commands {
+		if strings.Contains(command, "tar --numeric-owner -czf") {
+			snapshots = append(snapshots, command)
+		}
+	}
+	if assert.Len(t, snapshots, 2) {
+		assert.Contains(t, snapshots[0], "-v stack_dataexchange_0:/source:ro")
+		assert.Contains(t, snapshots[0], "/backup/stack_dataexchange_0.tar.gz")
+		assert.Contains(t, snapshots[1], "-v stack_postgres_0:/source:ro")
+		assert.Contains(t, snapshots[1], fmt.Sprintf("source=%s,target=/backup", filepath.Join(backup, "volumes")))
+	}
+}
+
+func TestPruneSnapshots(t *testing.T) {
+	dir := t.TempDir()
+	s := newTestStackManager(&types.Stack{Name: "stack"})
+	s.Log = &log.StdoutLogger{}
+	snapshots := []string{"stack-20240101T000000Z", "stack-20240102T000000Z", "stack-20240103T000000Z"}
+	others := []string{"stack-2-20240101T000000Z", "stack-backup", "other-20240101T000000Z"}
+	for _, name := range append(append([]string{}, snapshots...), others...) {
+		assert.NoError(t, os.MkdirAll(filepath.Join(dir, name, "volumes"), 0700))
+	}
+
+	t.Run("keep all", func(t *testing.T) {
+		assert.NoError(t, s.pruneSnapshots(dir, 0))
+		for _, name := range snapshots {
+			assert.DirExists(t, filepath.Join(dir, name))
+		}
+	})
+
+	t.Run("keep newest", func(t *testing.T) {
+		assert.NoError(t, s.pruneSnapshots(dir, 2))
+		assert.NoDirExists(t, filepath.Join(dir, snapshots[0]))
+		assert.DirExists(t, filepath.Join(dir, snapshots[1]))
+		assert.DirExists(t, filepath.Join(dir, snapshots[2]))
+		// The directories of other stacks, and those that are not snapshots, are left alone
+		for _, name := range others {
+			assert.DirExists(t, filepath.Join(dir, name))
+		}
+	})
+}
+
+func TestSharedSignerStacks(t *testing.T) {
+	stacksDir := constants.StacksDir
+	defer func() { constants.StacksDir = stacksDir }()
+	constants.StacksDir = t.TempDir()
+	chainID := int64(2021)
+	stacks := map[string]*types.Stack{}
+	for _, name := range []string{"owner", "user_a", "user_b", "isolated"} {
+		stack := &types.Stack{Name: name, ChainIDPtr: &chainID, ExternalNetwork: "ff_shared", SharedSigner: "dev", SharedSignerOwner: name == "owner"}
+		if name == "isolated" {
+			stack.SharedSigner = ""
+		}
+		stacks[name] = stack
+		b, err := json.Marshal(stack)
+		assert.NoError(t, err)
+		assert.NoError(t, os.MkdirAll(filepath.Join(constants.StacksDir, name), 0755))
+		assert.NoError(t, os.WriteFile(filepath.Join(constants.StacksDir, name, "stack.json"), b, 0600))
+	}
+	runner := &dockertest.Runner{Outputs: map[string]string{}}
+	newManager := func(name string) *StackManager {
+		return &StackManager{ctx: docker.WithCommandRunner(context.Background(), runner), Stack: stacks[name]}
+	}
+
+	// The owner cannot be removed while other stacks use its signer, but they can be
+	assert.Regexp(t, "stack 'owner' runs shared signer 'dev', which is still used by stacks: user_a, user_b - remove them first", newManager("owner").CheckSharedSignerUsers())
+	assert.NoError(t, newManager("user_a").CheckSharedSignerUsers())
+	assert.NoError(t, newManager("isolated").CheckSharedSignerUsers())
+
+	// A stack that uses the signer of another only starts while that signer is running
+	inspect := "docker inspect --type container --format " + "{{.State.Status}}|{{if .State.Health}}{{.State.Health.Status}}{{end}}|{{.State.ExitCode}}" + " ff_signer_dev"
+	runner.Outputs[inspect] = "exited||0\n"
+	assert.Regexp(t, "shared signer 'dev' is run by stack 'owner', which must be started before stack 'user_a'", newManager("user_a").checkSharedSignerRunning())
+	runner.Outputs[inspect] = "running|healthy|0\n"
+	assert.NoError(t, newManager("user_a").checkSharedSignerRunning())
+	assert.NoError(t, newManager("owner").checkSharedSignerRunning())
+	assert.NoError(t, newManager("isolated").checkSharedSignerRunning())
+
+	assert.NoError(t, os.RemoveAll(filepath.Join(constants.StacksDir, "owner")))
+	assert.Regexp(t, "no stack runs shared signer 'dev', so stack 'user_a' cannot start", newManager("user_a").checkSharedSignerRunning())
+}
@@ -65,7 +65,7 @@ func (p *ERC1155Provider) DeploySmartContracts(tokenIndex int) (*types.ContractD
 		return nil, err
 	}
 	constructorArgs := []string{"firefly://"}
-	return p.blockchainProvider.DeployContract(filepath.Join(p.stack.RuntimeDir, "contracts", "ERC1155MixedFungible.json"), contractName, contractName, p.stack.Members[0], constructorArgs)
+	return p.blockchainProvider.DeployContract(filepath.Join(p.stack.RuntimeDir, "contracts", "ERC1155MixedFungible.json"), contractName, contractName, p.stack.SigningMember(), constructorArgs)
 }
 
 func (p *ERC1155Provider) FirstTimeSetup(tokenIdx int) error {
@@ -16,7 +16,10 @@
 
 package types
 
-import "fmt"
+import (
+	"fmt"
+	"strings"
+)
 
 type GitHubRelease struct {
 	TagName string `json:"tag_name,omitempty"`
@@ -66,3 +69,37 @@ func (m *ManifestEntry) GetDockerImageString() string {
 	}
 	return m.Image
 }
+
+// ParseManifestEntry builds a manifest entry from a docker image reference, which may include a tag or a sha256 digest
+func ParseManifestEntry(image string) *ManifestEntry {
+	if i := strings.Index(image, "@sha256:"); i >= 0 {
+		return &ManifestEntry{Image: image[:i], SHA: image[i+len("@sha256:"):]}
+	}
+	if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+		return &ManifestEntry{Image: image[:i], Tag: image[i+1:]}
+	}
+	return &ManifestEntry{Image: image}
+}
+
+// MirrorImage rewrites a docker image reference to point at the same repository in a mirror registry, by
+// replacing the registry host with the prefix and keeping the full repository path, so that repositories with
+// the same name in different organizations stay apart. For example, with the prefix registry.internal/mirror,
+// ghcr.io/hyperledger/firefly-signer:v0.9.1 becomes registry.internal/mirror/hyperledger/firefly-signer:v0.9.1.
+// References already in the mirror are left unchanged.
+func MirrorImage(prefix, image string) string {
+	prefix = strings.TrimSuffix(prefix, "/")
+	if prefix == "" || image == "" || strings.HasPrefix(image, prefix+"/") {
+		return image
+	}
+	return fmt.Sprintf("%s/%s", prefix, repositoryPath(image))
+}
+
+// repositoryPath returns an image reference without its registry host. As with docker, the first element of the
+// reference is only a host if it has a dot or a port, or is localhost.
+func repositoryPath(image string) string {
+	host, rest, ok := strings.Cut(image, "/")
+	if ok && (strings.ContainsAny(host, ".:") || host == "localhost") {
+		return rest
+	}
+	return image
+}
@@ -27,7 +27,8 @@ type PullOptions struct {
 }
 
 type StartOptions struct {
-	NoRollback bool
+	NoRollback   bool
+	VerifySigner bool
 }
 
 type InitOptions struct {
@@ -53,6 +54,7 @@ type InitOptions struct {
 	BlockPeriod              int
 	ContractAddress          string
 	RemoteNodeURL            string
+	SkipRemoteNodeCheck      bool
 	ChainID                  int64
 	DisableTokenFactories    bool
 	RequestTimeout           int
@@ -65,6 +67,52 @@ type InitOptions struct {
 	ChaincodeName            string
 	CustomPinSupport         bool
 	RemoteNodeDeploy         bool
+	SignerImage              string
+	SignerType               string
+	GethImage                string
+	PrefundBalance           string
+	GasLimit                 uint64
+	RemoteSignerURL          string
+	RemoteSignerAddresses    []string
+	SignerKeystoreDirectory  string
+	RPCUsername              string
+	RPCPassword              string
+	RPCBearerToken           string
+	RPCConnectionTimeout     string
+	RPCRetries               int
+	RPCCACert                string
+	Mnemonic                 string
+	ReuseKeystore            bool
+	NonSigningMembers        []int
+	SignerBindAddress        string
+	PrintSignerConfig        bool
+	SignerLogLevel           string
+	SignerLogFormat          string
+	SignerEntrypoint         string
+	SignerExtraArgs          []string
+	SignerHealthCheck        string
+	KeystoreKDF              string
+	KeystorePasswordSource   string
+	KeystorePasswordEnv      string
+	KeystorePasswordFile     string
+	VerifySigner             bool
+	SharedSigner             string
+	SignerMetricsEnabled     bool
+	SignerMetricsPort        int
+	GasOracleMode            string
+	MaxFeePerGas             string
+	MaxPriorityFeePerGas     string
+	ExternalNetwork          string
+	ImageMirror              string
+	Offline                  bool
+	CPULimit                 string
+	RestartPolicy            string
+	Mounts                   []string
+	MemoryLimit              string
+	HealthCheckInterval      string
+	HealthCheckTimeout       string
+	HealthCheckStartPeriod   string
+	HealthCheckRetries       int
 }
 
 const IPFSMode = "ipfs_mode"
@@ -100,6 +148,13 @@ var (
 	BlockchainNodeProviderRemoteRPC = fftypes.FFEnumValue(BlockchainNodeProvider, "remote-rpc")
 )
 
+const SignerType = "signer_type"
+
+var (
+	SignerTypeFireFly = fftypes.FFEnumValue(SignerType, "firefly-signer")
+	SignerTypeJava    = fftypes.FFEnumValue(SignerType, "java-ethsigner")
+)
+
 const DatabaseSelection = "database_selection"
 
 var (
@@ -17,23 +17,43 @@
 package types
 
 type Organization struct {
-	ID                          string       `json:"id,omitempty"`
-	Index                       *int         `json:"index,omitempty"`
-	Account                     interface{}  `json:"account,omitempty"`
-	ExposedFireflyPort          int          `json:"exposedFireflyPort,omitempty"`
-	ExposedFireflyAdminSPIPort  int          `json:"exposedFireflyAdminPort,omitempty"` // stack.json still contains the word "Admin" (rather than SPI) for migration
-	ExposedFireflyMetricsPort   int          `json:"exposedFireflyMetricsPort,omitempty"`
-	ExposedConnectorPort        int          `json:"exposedConnectorPort,omitempty"`
-	ExposedConnectorMetricsPort int          `json:"exposedConnectorMetricsPort,omitempty"`
-	ExposedDatabasePort         int          `json:"exposedPostgresPort,omitempty"`
-	ExposedDataexchangePort     int          `json:"exposedDataexchangePort,omitempty"`
-	ExposedIPFSApiPort          int          `json:"exposedIPFSApiPort,omitempty"`
-	ExposedIPFSGWPort           int          `json:"exposedIPFSGWPort,omitempty"`
-	ExposedUIPort               int          `json:"exposedUiPort,omitempty"`
-	ExposedSandboxPort          int          `json:"exposedSandboxPort,omitempty"`
-	ExposedTokensPorts          []int        `json:"exposedTokensPorts,omitempty"`
-	External                    bool         `json:"external,omitempty"`
-	OrgName                     string       `json:"orgName,omitempty"`
-	NodeName                    string       `json:"nodeName,omitempty"`
-	Namespaces                  []*Namespace `json:"namespaces"`
+	ID                          string        `json:"id,omitempty"`
+	Index                       *int          `json:"index,omitempty"`
+	Account                     interface{}   `json:"account,omitempty"`
+	AdditionalAccounts          []interface{} `json:"additionalAccounts,omitempty"`
+	ExposedFireflyPort          int           `json:"exposedFireflyPort,omitempty"`
+	ExposedFireflyAdminSPIPort  int           `json:"exposedFireflyAdminPort,omitempty"` // stack.json still contains the word "Admin" (rather than SPI) for migration
+	ExposedFireflyMetricsPort   int           `json:"exposedFireflyMetricsPort,omitempty"`
+	ExposedConnectorPort        int           `json:"exposedConnectorPort,omitempty"`
+	ExposedConnectorMetricsPort int           `json:"exposedConnectorMetricsPort,omitempty"`
+	ExposedDatabasePort         int           `json:"exposedPostgresPort,omitempty"`
+	ExposedDataexchangePort     int           `json:"exposedDataexchangePort,omitempty"`
+	ExposedIPFSApiPort          int           `json:"exposedIPFSApiPort,omitempty"`
+	ExposedIPFSGWPort           int           `json:"exposedIPFSGWPort,omitempty"`
+	ExposedUIPort               int           `json:"exposedUiPort,omitempty"`
+	ExposedSandboxPort          int           `json:"exposedSandboxPort,omitempty"`
+	ExposedTokensPorts          []int         `json:"exposedTokensPorts,omitempty"`
+	External                    bool          `json:"external,omitempty"`
+	NonSigning                  bool          `json:"nonSigning,omitempty"`
+	OrgName                     string        `json:"orgName,omitempty"`
+	NodeName                    string        `json:"nodeName,omitempty"`
+	Namespaces                  []*Namespace  `json:"namespaces"`
+}
+
+// Accounts returns every account the member can sign with, starting with its primary account
+func (o *Organization) Accounts() []interface{} {
+	accounts := make([]interface{}, 0, 1+len(o.AdditionalAccounts))
+	if o.Account != nil {
+		accounts = append(accounts, o.Account)
+	}
+	return append(accounts, o.AdditionalAccounts...)
+}
+
+// SigningAccounts returns the accounts of the member that have keys in the signer, which is none for a member
+// that only queries the chain
+func (o *Organization) SigningAccounts() []interface{} {
+	if o.NonSigning {
+		return nil
+	}
+	return o.Accounts()
 }
@@ -17,43 +17,408 @@
 package types
 
 import (
+	"fmt"
+	"math/big"
 	"os"
+	"path"
 	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/hyperledger/firefly-cli/internal/constants"
 	"github.com/hyperledger/firefly-common/pkg/fftypes"
 )
 
 type Stack struct {
-	Name                   string           `json:"name,omitempty"`
-	Members                []*Organization  `json:"members,omitempty"`
-	SwarmKey               string           `json:"swarmKey,omitempty"`
-	ExposedBlockchainPort  int              `json:"exposedBlockchainPort,omitempty"`
-	Database               fftypes.FFEnum   `json:"database"`
-	BlockchainProvider     fftypes.FFEnum   `json:"blockchainProvider"`
-	BlockchainConnector    fftypes.FFEnum   `json:"blockchainConnector"`
-	BlockchainNodeProvider fftypes.FFEnum   `json:"blockchainNodeProvider"`
-	TokenProviders         []fftypes.FFEnum `json:"tokenProviders"`
-	VersionManifest        *VersionManifest `json:"versionManifest,omitempty"`
-	PrometheusEnabled      bool             `json:"prometheusEnabled,omitempty"`
-	SandboxEnabled         bool             `json:"sandboxEnabled,omitempty"`
-	MultipartyEnabled      bool             `json:"multiparty"`
-	ExposedPrometheusPort  int              `json:"exposedPrometheusPort,omitempty"`
-	ContractAddress        string           `json:"contractAddress,omitempty"`
-	ChainIDPtr             *int64           `json:"chainID,omitempty"`
-	RemoteNodeURL          string           `json:"remoteNodeURL,omitempty"`
-	DisableTokenFactories  bool             `json:"disableTokenFactories,omitempty"`
-	RequestTimeout         int              `json:"requestTimeout,omitempty"`
-	IPFSMode               fftypes.FFEnum   `json:"ipfsMode"`
-	RemoteFabricNetwork    bool             `json:"remoteFabricNetwork,omitempty"`
-	ChannelName            string           `json:"channelName,omitempty"`
-	ChaincodeName          string           `json:"chaincodeName,omitempty"`
-	CustomPinSupport       bool             `json:"customPinSupport,omitempty"`
-	RemoteNodeDeploy       bool             `json:"remoteNodeDeploy,omitempty"`
-	InitDir                string           `json:"-"`
-	RuntimeDir             string           `json:"-"`
-	StackDir               string           `json:"-"`
-	State                  *StackState      `json:"-"`
+	Name                     string                `json:"name,omitempty"`
+	Members                  []*Organization       `json:"members,omitempty"`
+	SwarmKey                 string                `json:"swarmKey,omitempty"`
+	ExposedBlockchainPort    int                   `json:"exposedBlockchainPort,omitempty"`
+	Database                 fftypes.FFEnum        `json:"database"`
+	BlockchainProvider       fftypes.FFEnum        `json:"blockchainProvider"`
+	BlockchainConnector      fftypes.FFEnum        `json:"blockchainConnector"`
+	BlockchainNodeProvider   fftypes.FFEnum        `json:"blockchainNodeProvider"`
+	TokenProviders           []fftypes.FFEnum      `json:"tokenProviders"`
+	VersionManifest          *VersionManifest      `json:"versionManifest,omitempty"`
+	PrometheusEnabled        bool                  `json:"prometheusEnabled,omitempty"`
+	SandboxEnabled           bool                  `json:"sandboxEnabled,omitempty"`
+	MultipartyEnabled        bool                  `json:"multiparty"`
+	ExposedPrometheusPort    int                   `json:"exposedPrometheusPort,omitempty"`
+	ContractAddress          string                `json:"contractAddress,omitempty"`
+	ChainIDPtr               *int64                `json:"chainID,omitempty"`
+	RemoteNodeURL            string                `json:"remoteNodeURL,omitempty"`
+	DisableTokenFactories    bool                  `json:"disableTokenFactories,omitempty"`
+	RequestTimeout           int                   `json:"requestTimeout,omitempty"`
+	IPFSMode                 fftypes.FFEnum        `json:"ipfsMode"`
+	RemoteFabricNetwork      bool                  `json:"remoteFabricNetwork,omitempty"`
+	ChannelName              string                `json:"channelName,omitempty"`
+	ChaincodeName            string                `json:"chaincodeName,omitempty"`
+	CustomPinSupport         bool                  `json:"customPinSupport,omitempty"`
+	RemoteNodeDeploy         bool                  `json:"remoteNodeDeploy,omitempty"`
+	GethImage                string                `json:"gethImage,omitempty"`
+	SignerType               fftypes.FFEnum        `json:"signerType,omitempty"`
+	PrefundBalance           string                `json:"prefundBalance,omitempty"`
+	GethGasLimit             uint64                `json:"gethGasLimit,omitempty"`
+	RemoteSignerURL          string                `json:"remoteSignerURL,omitempty"`
+	SignerKeystoreDirectory  string                `json:"signerKeystoreDirectory,omitempty"`
+	SignerBindAddress        string                `json:"signerBindAddress,omitempty"`
+	DownstreamRPCAuth        *RPCAuthConfig        `json:"downstreamRPCAuth,omitempty"`
+	DownstreamRPCConnection  *RPCConnectionConfig  `json:"downstreamRPCConnection,omitempty"`
+	DownstreamRPCCACert      string                `json:"downstreamRPCCACert,omitempty"`
+	SignerLog                *SignerLogConfig      `json:"signerLog,omitempty"`
+	SignerEntrypoint         []string              `json:"signerEntrypoint,omitempty"`
+	SignerHealthCheck        string                `json:"signerHealthCheck,omitempty"`
+	SignerMetricsEnabled     bool                  `json:"signerMetricsEnabled,omitempty"`
+	ExposedSignerMetricsPort int                   `json:"exposedSignerMetricsPort,omitempty"`
+	SignerExtraArgs          []string              `json:"signerExtraArgs,omitempty"`
+	KeystoreKDF              string                `json:"keystoreKDF,omitempty"`
+	KeystorePassword         *PasswordSource       `json:"keystorePassword,omitempty"`
+	VerifySigner             bool                  `json:"verifySigner,omitempty"`
+	SharedSigner             string                `json:"sharedSigner,omitempty"`
+	SharedSignerOwner        bool                  `json:"sharedSignerOwner,omitempty"`
+	HealthCheck              *HealthCheckConfig    `json:"healthCheck,omitempty"`
+	Gas                      *GasConfig            `json:"gas,omitempty"`
+	ExternalNetwork          string                `json:"externalNetwork,omitempty"`
+	ImageMirror              string                `json:"imageMirror,omitempty"`
+	Offline                  bool                  `json:"offline,omitempty"`
+	ResourceLimits           *ResourceLimitsConfig `json:"resourceLimits,omitempty"`
+	RestartPolicy            string                `json:"restartPolicy,omitempty"`
+	Mounts                   []*MountConfig        `json:"mounts,omitempty"`
+	InitDir                  string                `json:"-"`
+	RuntimeDir               string                `json:"-"`
+	StackDir                 string                `json:"-"`
+	State                    *StackState           `json:"-"`
+}
+
+// HealthCheckConfig overrides the health check parameters of the services in a stack. Any field that
+// is not set falls back to the default of the provider that defines the service.
+type HealthCheckConfig struct {
+	Interval    string `json:"interval,omitempty"`
+	Timeout     string `json:"timeout,omitempty"`
+	StartPeriod string `json:"startPeriod,omitempty"`
+	Retries     int    `json:"retries,omitempty"`
+}
+
+// maxHealthCheckDuration is the longest a service can take to become healthy before it is considered broken
+const maxHealthCheckDuration = 24 * time.Hour
+
+func (h *HealthCheckConfig) Validate() error {
+	if h == nil {
+		return nil
+	}
+	durations := make(map[string]time.Duration)
+	for name, value := range map[string]string{"interval": h.Interval, "timeout": h.Timeout, "start period": h.StartPeriod} {
+		if value == "" {
+			continue
+		}
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("invalid health check %s '%s': %s", name, value, err)
+		}
+		if d <= 0 {
+			return fmt.Errorf("health check %s must be greater than zero", name)
+		}
+		durations[name] = d
+	}
+	if h.Retries < 0 {
+		return fmt.Errorf("health check retries must be greater than zero")
+	}
+	if interval, ok := durations["interval"]; ok && h.Retries > 0 {
+		if total := durations["start period"] + interval*time.Duration(h.Retries); total > maxHealthCheckDuration {
+			return fmt.Errorf("health check would wait %s for a service to become healthy, which exceeds the maximum of %s", total, maxHealthCheckDuration)
+		}
+	}
+	return nil
+}
+
+// ResourceLimitsConfig caps the CPU and memory of each service in a stack, overriding any default limit
+// of the provider that defines the service
+type ResourceLimitsConfig struct {
+	// CPUs is the number of CPUs a service can use, which can be fractional, e.g. "0.5"
+	CPUs string `json:"cpus,omitempty"`
+	// Memory is a number of bytes with an optional b, k, m or g unit, e.g. "512m"
+	Memory string `json:"memory,omitempty"`
+}
+
+var memoryLimitRegex = regexp.MustCompile(`^[0-9]+[bkmgBKMG]?$`)
+
+func (r *ResourceLimitsConfig) Validate() error {
+	if r == nil {
+		return nil
+	}
+	if r.CPUs != "" {
+		cpus, err := strconv.ParseFloat(r.CPUs, 64)
+		if err != nil || cpus <= 0 {
+			return fmt.Errorf("invalid CPU limit '%s': must be a number of CPUs greater than zero", r.CPUs)
+		}
+	}
+	if r.Memory != "" && !memoryLimitRegex.MatchString(r.Memory) {
+		return fmt.Errorf("invalid memory limit '%s': must be a number of bytes with an optional b, k, m or g unit", r.Memory)
+	}
+	return nil
+}
+
+// DefaultRestartPolicy is the restart policy of the services of a stack, unless the stack overrides it. The
+// services are restarted after they crash, or docker restarts, but stay stopped after 'ff stop'.
+const DefaultRestartPolicy = "unless-stopped"
+
+var restartPolicyRegex = regexp.MustCompile(`^(no|always|unless-stopped|on-failure(:[1-9][0-9]*)?)$`)
+
+// ValidateRestartPolicy checks that a restart policy is one that docker compose accepts
+func ValidateRestartPolicy(policy string) error {
+	if !restartPolicyRegex.MatchString(policy) {
+		return fmt.Errorf("invalid restart policy '%s': must be one of no, always, unless-stopped, on-failure or on-failure:<max retries>", policy)
+	}
+	return nil
+}
+
+var sharedSignerNameRegex = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
+// ValidateSharedSignerName checks that the name of a shared signer can be used in the names of its container
+// and volumes
+func ValidateSharedSignerName(name string) error {
+	if !sharedSignerNameRegex.MatchString(name) {
+		return fmt.Errorf("invalid shared signer name '%s': must start with a letter or digit, followed by letters, digits, '_', '.' or '-'", name)
+	}
+	return nil
+}
+
+// MountConfig is a directory or file on the host that is bind mounted into a service of the stack, in addition to
+// the volumes the service already has
+type MountConfig struct {
+	Service       string `json:"service"`
+	HostPath      string `json:"hostPath"`
+	ContainerPath string `json:"containerPath"`
+	ReadOnly      bool   `json:"readOnly,omitempty"`
+}
+
+// unsafeMountTargets are the paths in a container that a mount must not hide, as the services keep their data and
+// config there, or the container cannot run without them
+var unsafeMountTargets = []string{"/", "/bin", "/data", "/dev", "/etc", "/etc/firefly", "/lib", "/proc", "/sbin", "/sys", "/usr"}
+
+// ParseMountConfig parses a mount in the form <service>:<host path>:<container path>[:ro|rw]. Mounts are read
+// write unless ro is given. The host path may itself contain colons, such as C:\data on Windows, so the container
+// path and mode are split from the right. Neither of them can contain a colon, as the container path is absolute
+// and docker does not allow one in it.
+func ParseMountConfig(spec string) (*MountConfig, error) {
+	formatErr := fmt.Errorf("invalid mount '%s': must be in the form <service>:<host path>:<container path>[:ro|rw]", spec)
+	parts := strings.Split(spec, ":")
+	if len(parts) < 3 {
+		return nil, formatErr
+	}
+	service, rest := parts[0], parts[1:]
+	mode := ""
+	if len(rest) >= 3 && !strings.HasPrefix(rest[len(rest)-1], "/") {
+		mode, rest = rest[len(rest)-1], rest[:len(rest)-1]
+		if !strings.HasPrefix(rest[len(rest)-1], "/") {
+			return nil, formatErr
+		}
+	}
+	m := &MountConfig{Service: service, HostPath: strings.Join(rest[:len(rest)-1], ":"), ContainerPath: rest[len(rest)-1]}
+	if mode != "" {
+		switch mode {
+		case "ro":
+			m.ReadOnly = true
+		case "rw":
+		default:
+			return nil, fmt.Errorf("invalid mount '%s': the mode must be ro or rw", spec)
+		}
+	}
+	if err := m.Validate(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (m *MountConfig) Validate() error {
+	if m.Service == "" || m.HostPath == "" || m.ContainerPath == "" {
+		return fmt.Errorf("invalid mount of '%s' into '%s' of service '%s': the service, host path and container path are all required", m.HostPath, m.ContainerPath, m.Service)
+	}
+	if !path.IsAbs(m.ContainerPath) {
+		return fmt.Errorf("invalid mount target '%s': must be an absolute path", m.ContainerPath)
+	}
+	if slices.Contains(unsafeMountTargets, path.Clean(m.ContainerPath)) {
+		return fmt.Errorf("cannot mount over '%s' in service '%s', which the service needs", path.Clean(m.ContainerPath), m.Service)
+	}
+	return nil
+}
+
+// Volume returns the mount in the volume syntax of docker compose
+func (m *MountConfig) Volume() string {
+	if m.ReadOnly {
+		return fmt.Sprintf("%s:%s:ro", m.HostPath, m.ContainerPath)
+	}
+	return fmt.Sprintf("%s:%s", m.HostPath, m.ContainerPath)
+}
+
+// RPCAuthConfig holds the credentials the signer presents to the downstream JSON/RPC endpoint, using either
+// HTTP basic auth or a bearer token
+type RPCAuthConfig struct {
+	Username    string `json:"username,omitempty"`
+	Password    string `json:"password,omitempty"`
+	BearerToken string `json:"bearerToken,omitempty"`
+}
+
+func (a *RPCAuthConfig) Validate() error {
+	if a == nil {
+		return nil
+	}
+	if (a.Username == "") != (a.Password == "") {
+		return fmt.Errorf("a username and password must both be set for basic auth to the downstream RPC endpoint")
+	}
+	if a.Username != "" && a.BearerToken != "" {
+		return fmt.Errorf("basic auth and a bearer token cannot both be used for the downstream RPC endpoint")
+	}
+	return nil
+}
+
+// RPCConnectionConfig controls how long the signer waits to connect to the downstream JSON/RPC endpoint,
+// and how many times it retries a request that fails, so that it can ride out the node starting up
+type RPCConnectionConfig struct {
+	ConnectionTimeout string `json:"connectionTimeout,omitempty"`
+	Retries           int    `json:"retries,omitempty"`
+}
+
+func (c *RPCConnectionConfig) Validate() error {
+	if c == nil {
+		return nil
+	}
+	if c.ConnectionTimeout != "" {
+		d, err := time.ParseDuration(c.ConnectionTimeout)
+		if err != nil || d <= 0 {
+			return fmt.Errorf("invalid downstream RPC connection timeout '%s': must be a positive duration such as 30s", c.ConnectionTimeout)
+		}
+	}
+	if c.Retries < 0 {
+		return fmt.Errorf("invalid downstream RPC retries %d: must not be negative", c.Retries)
+	}
+	return nil
+}
+
+// The levels and formats the signer of an Ethereum stack can log with
+var (
+	SignerLogLevels  = []string{"trace", "debug", "info", "warn", "error"}
+	SignerLogFormats = []string{"text", "json"}
+)
+
+// SignerLogConfig controls how verbosely the signer logs, and whether it logs JSON rather than text
+type SignerLogConfig struct {
+	Level  string `json:"level,omitempty"`
+	Format string `json:"format,omitempty"`
+}
+
+func (c *SignerLogConfig) Validate() error {
+	if c == nil {
+		return nil
+	}
+	if c.Level != "" && !slices.Contains(SignerLogLevels, c.Level) {
+		return fmt.Errorf("invalid signer log level '%s': must be one of %v", c.Level, SignerLogLevels)
+	}
+	if c.Format != "" && !slices.Contains(SignerLogFormats, c.Format) {
+		return fmt.Errorf("invalid signer log format '%s': must be one of %v", c.Format, SignerLogFormats)
+	}
+	return nil
+}
+
+// The probes the health check of the signer of an Ethereum stack can use. net_version only shows that the signer
+// can reach the blockchain node, whereas accounts also waits until the signer holds the key of every member.
+const (
+	SignerHealthCheckNetVersion = "net_version"
+	SignerHealthCheckAccounts   = "accounts"
+)
+
+var SignerHealthChecks = []string{SignerHealthCheckNetVersion, SignerHealthCheckAccounts}
+
+func ValidateSignerHealthCheck(probe string) error {
+	if !slices.Contains(SignerHealthChecks, probe) {
+		return fmt.Errorf("invalid signer health check '%s': must be one of %v", probe, SignerHealthChecks)
+	}
+	return nil
+}
+
+// The keystore KDF presets set the cost of the scrypt key derivation that the keys of an Ethereum stack are
+// encrypted with. A higher cost makes a stolen keystore file harder to brute force, but makes every key slower
+// to encrypt, and to decrypt when the signer starts. The fast preset is only suitable for throwaway keys.
+const (
+	KeystoreKDFFast     = "fast"
+	KeystoreKDFStandard = "standard"
+	KeystoreKDFStrong   = "strong"
+)
+
+var KeystoreKDFs = []string{KeystoreKDFFast, KeystoreKDFStandard, KeystoreKDFStrong}
+
+func ValidateKeystoreKDF(kdf string) error {
+	if !slices.Contains(KeystoreKDFs, kdf) {
+		return fmt.Errorf("invalid keystore KDF '%s': must be one of %v", kdf, KeystoreKDFs)
+	}
+	return nil
+}
+
+// The sources the password of the keystore of an Ethereum stack can come from. plaintext generates a random
+// password for each key and writes it next to the key, which is only suitable for throwaway stacks. The other
+// sources use a single password for every key of the stack, which the CLI never writes to disk, and which is
+// passed to the signer as a docker secret.
+const (
+	KeystorePasswordPlaintext = "plaintext"
+	KeystorePasswordEnv       = "env"
+	KeystorePasswordFile      = "file"
+	KeystorePasswordPrompt    = "prompt"
+)
+
+var KeystorePasswordSources = []string{KeystorePasswordPlaintext, KeystorePasswordEnv, KeystorePasswordFile, KeystorePasswordPrompt}
+
+// DefaultKeystorePasswordEnv is the environment variable that holds the keystore password in env mode, unless
+// another is configured, and that the password entered in prompt mode is passed to the signer in
+const DefaultKeystorePasswordEnv = "FF_KEYSTORE_PASSWORD"
+
+var envNameRegex = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// PasswordSource is where the password of the keystore of an Ethereum stack comes from
+type PasswordSource struct {
+	Source string `json:"source"`
+	// Env is the environment variable that holds the password, in env and prompt mode
+	Env string `json:"env,omitempty"`
+	// File is the absolute path of the file that holds the password, in file mode
+	File string `json:"file,omitempty"`
+}
+
+func (c *PasswordSource) Validate() error {
+	if c == nil {
+		return nil
+	}
+	if !slices.Contains(KeystorePasswordSources, c.Source) {
+		return fmt.Errorf("invalid keystore password source '%s': must be one of %v", c.Source, KeystorePasswordSources)
+	}
+	if c.Source == KeystorePasswordFile {
+		if c.File == "" {
+			return fmt.Errorf("a password file must be set for the '%s' keystore password source", KeystorePasswordFile)
+		}
+		if !filepath.IsAbs(c.File) {
+			return fmt.Errorf("keystore password file '%s' must be an absolute path", c.File)
+		}
+	} else if c.File != "" {
+		return fmt.Errorf("a password file can only be used with the '%s' keystore password source", KeystorePasswordFile)
+	}
+	if c.Env != "" {
+		if c.Source != KeystorePasswordEnv && c.Source != KeystorePasswordPrompt {
+			return fmt.Errorf("a password environment variable can only be used with the '%s' or '%s' keystore password sources", KeystorePasswordEnv, KeystorePasswordPrompt)
+		}
+		if !envNameRegex.MatchString(c.Env) {
+			return fmt.Errorf("invalid keystore password environment variable '%s'", c.Env)
+		}
+	}
+	return nil
+}
+
+// PasswordEnv returns the environment variable that holds the password, in env and prompt mode
+func (c *PasswordSource) PasswordEnv() string {
+	if c.Env != "" {
+		return c.Env
+	}
+	return DefaultKeystorePasswordEnv
 }
 
 func (s *Stack) ChainID() int64 {
@@ -63,6 +428,17 @@ func (s *Stack) ChainID() int64 {
 	return *s.ChainIDPtr
 }
 
+// SigningMember returns the first member of the stack that has a signing account, which deploys the contracts
+// of the stack, or nil if no member signs
+func (s *Stack) SigningMember() *Organization {
+	for _, member := range s.Members {
+		if !member.NonSigning {
+			return member
+		}
+	}
+	return nil
+}
+
 func (s *Stack) HasRunBefore() (bool, error) {
 	stackDir := filepath.Join(constants.StacksDir, s.Name)
 	isOldFileStructure, err := s.IsOldFileStructure()
@@ -106,3 +482,46 @@ func (s *Stack) IsOldFileStructure() (bool, error) {
 		return false, nil
 	}
 }
+
+const (
+	GasOracleModeFixed     = "fixed"
+	GasOracleModeConnector = "connector"
+)
+
+// GasConfig sets the gas policy of the blockchain connector, for chains that need more than the fixed gas
+// price of zero used by the local development chains. Setting both fees submits EIP-1559 transactions.
+type GasConfig struct {
+	OracleMode           string `json:"oracleMode,omitempty"`
+	MaxFeePerGas         string `json:"maxFeePerGas,omitempty"`
+	MaxPriorityFeePerGas string `json:"maxPriorityFeePerGas,omitempty"`
+}
+
+func (g *GasConfig) Validate() error {
+	if g == nil {
+		return nil
+	}
+	if g.OracleMode != "" && g.OracleMode != GasOracleModeFixed && g.OracleMode != GasOracleModeConnector {
+		return fmt.Errorf("invalid gas oracle mode '%s': must be one of [%s %s]", g.OracleMode, GasOracleModeFixed, GasOracleModeConnector)
+	}
+	if (g.MaxFeePerGas == "") != (g.MaxPriorityFeePerGas == "") {
+		return fmt.Errorf("max fee per gas and max priority fee per gas must be set together")
+	}
+	if g.MaxFeePerGas == "" {
+		return nil
+	}
+	if g.OracleMode == GasOracleModeConnector {
+		return fmt.Errorf("fixed gas fees cannot be used with the '%s' gas oracle mode", GasOracleModeConnector)
+	}
+	maxFee, ok := new(big.Int).SetString(g.MaxFeePerGas, 10)
+	if !ok || maxFee.Sign() < 0 {
+		return fmt.Errorf("invalid max fee per gas '%s': must be a whole number of wei", g.MaxFeePerGas)
+	}
+	priorityFee, ok := new(big.Int).SetString(g.MaxPriorityFeePerGas, 10)
+	if !ok || priorityFee.Sign() < 0 {
+		return fmt.Errorf("invalid max priority fee per gas '%s': must be a whole number of wei", g.MaxPriorityFeePerGas)
+	}
+	if priorityFee.Cmp(maxFee) > 0 {
+		return fmt.Errorf("max priority fee per gas %s cannot be greater than the max fee per gas %s", priorityFee, maxFee)
+	}
+	return nil
+}
@@ -0,0 +1,172 @@
+package types
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHealthCheckConfigValidate(t *testing.T) {
+	testcases := []struct {
+		Name        string
+		HealthCheck *HealthCheckConfig
+		Error       string
+	}{
+		{Name: "unset", HealthCheck: nil},
+		{Name: "defaults", HealthCheck: &HealthCheckConfig{}},
+		{Name: "valid", HealthCheck: &HealthCheckConfig{Interval: "15s", Timeout: "5s", StartPeriod: "1m", Retries: 60}},
+		{Name: "bad-interval", HealthCheck: &HealthCheckConfig{Interval: "often"}, Error: "invalid health check interval"},
+		{Name: "zero-timeout", HealthCheck: &HealthCheckConfig{Timeout: "0s"}, Error: "health check timeout must be greater than zero"},
+		{Name: "negative-retries", HealthCheck: &HealthCheckConfig{Retries: -1}, Error: "health check retries must be greater than zero"},
+		{Name: "too-long", HealthCheck: &HealthCheckConfig{Interval: "1h", Retries: 100}, Error: "exceeds the maximum"},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.Name, func(t *testing.T) {
+			err := tc.HealthCheck.Validate()
+			if tc.Error == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.Regexp(t, tc.Error, err)
+			}
+		})
+	}
+}
+
+func TestGasConfigValidate(t *testing.T) {
+	testcases := []struct {
+		Name  string
+		Gas   *GasConfig
+		Error string
+	}{
+		{Name: "unset", Gas: nil},
+		{Name: "fixed", Gas: &GasConfig{OracleMode: GasOracleModeFixed}},
+		{Name: "connector", Gas: &GasConfig{OracleMode: GasOracleModeConnector}},
+		{Name: "eip1559", Gas: &GasConfig{MaxFeePerGas: "30000000000", MaxPriorityFeePerGas: "1500000000"}},
+		{Name: "bad-mode", Gas: &GasConfig{OracleMode: "guess"}, Error: "invalid gas oracle mode 'guess'"},
+		{Name: "max-fee-only", Gas: &GasConfig{MaxFeePerGas: "30000000000"}, Error: "must be set together"},
+		{Name: "connector-with-fees", Gas: &GasConfig{OracleMode: GasOracleModeConnector, MaxFeePerGas: "2", MaxPriorityFeePerGas: "1"}, Error: "cannot be used with the 'connector' gas oracle mode"},
+		{Name: "bad-max-fee", Gas: &GasConfig{MaxFeePerGas: "30 gwei", MaxPriorityFeePerGas: "1"}, Error: "invalid max fee per gas"},
+		{Name: "negative-priority-fee", Gas: &GasConfig{MaxFeePerGas: "2", MaxPriorityFeePerGas: "-1"}, Error: "invalid max priority fee per gas"},
+		{Name: "priority-above-max", Gas: &GasConfig{MaxFeePerGas: "1", MaxPriorityFeePerGas: "2"}, Error: "cannot be greater than the max fee per gas"},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.Name, func(t *testing.T) {
+			err := tc.Gas.Validate()
+			if tc.Error == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.Regexp(t, tc.Error, err)
+			}
+		})
+	}
+}
+
+func TestResourceLimitsConfigValidate(t *testing.T) {
+	testcases := []struct {
+		Name   string
+		Limits *ResourceLimitsConfig
+		Error  string
+	}{
+		{Name: "unset", Limits: nil},
+		{Name: "valid", Limits: &ResourceLimitsConfig{CPUs: "1.5", Memory: "512m"}},
+		{Name: "bytes", Limits: &ResourceLimitsConfig{Memory: "1073741824"}},
+		{Name: "bad-cpus", Limits: &ResourceLimitsConfig{CPUs: "lots"}, Error: "invalid CPU limit 'lots'"},
+		{Name: "zero-cpus", Limits: &ResourceLimitsConfig{CPUs: "0"}, Error: "invalid CPU limit '0'"},
+		{Name: "bad-memory", Limits: &ResourceLimitsConfig{Memory: "512MB"}, Error: "invalid memory limit '512MB'"},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.Name, func(t *testing.T) {
+			err := tc.Limits.Validate()
+			if tc.Error == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.Regexp(t, tc.Error, err)
+			}
+		})
+	}
+}
+
+func TestRPCAuthConfigValidate(t *testing.T) {
+	assert.NoError(t, (*RPCAuthConfig)(nil).Validate())
+	assert.NoError(t, (&RPCAuthConfig{Username: "user", Password: "pass"}).Validate())
+	assert.NoError(t, (&RPCAuthConfig{BearerToken: "abcd"}).Validate())
+	assert.Regexp(t, "username and password must both be set", (&RPCAuthConfig{Username: "user"}).Validate())
+	assert.Regexp(t, "cannot both be used", (&RPCAuthConfig{Username: "user", Password: "pass", BearerToken: "abcd"}).Validate())
+}
+
+func TestRPCConnectionConfigValidate(t *testing.T) {
+	assert.NoError(t, (*RPCConnectionConfig)(nil).Validate())
+	assert.NoError(t, (&RPCConnectionConfig{ConnectionTimeout: "30s", Retries: 3}).Validate())
+	assert.Regexp(t, "invalid downstream RPC connection timeout 'soon'", (&RPCConnectionConfig{ConnectionTimeout: "soon"}).Validate())
+	assert.Regexp(t, "invalid downstream RPC connection timeout '0s'", (&RPCConnectionConfig{ConnectionTimeout: "0s"}).Validate())
+	assert.Regexp(t, "invalid downstream RPC retries -1", (&RPCConnectionConfig{Retries: -1}).Validate())
+}
+
+func TestPasswordSourceValidate(t *testing.T) {
+	assert.NoError(t, (*PasswordSource)(nil).Validate())
+	assert.NoError(t, (&PasswordSource{Source: KeystorePasswordPlaintext}).Validate())
+	assert.NoError(t, (&PasswordSource{Source: KeystorePasswordEnv, Env: "MY_PASSWORD"}).Validate())
+	assert.NoError(t, (&PasswordSource{Source: KeystorePasswordPrompt}).Validate())
+	assert.NoError(t, (&PasswordSource{Source: KeystorePasswordFile, File: "/etc/firefly/password"}).Validate())
+	assert.Regexp(t, "invalid keystore password source 'vault'", (&PasswordSource{Source: "vault"}).Validate())
+	assert.Regexp(t, "a password file must be set", (&PasswordSource{Source: KeystorePasswordFile}).Validate())
+	assert.Regexp(t, "must be an absolute path", (&PasswordSource{Source: KeystorePasswordFile, File: "password"}).Validate())
+	assert.Regexp(t, "a password file can only be used with the 'file'", (&PasswordSource{Source: KeystorePasswordEnv, File: "/etc/firefly/password"}).Validate())
+	assert.Regexp(t, "a password environment variable can only be used", (&PasswordSource{Source: KeystorePasswordPlaintext, Env: "MY_PASSWORD"}).Validate())
+	assert.Regexp(t, "invalid keystore password environment variable 'MY-PASSWORD'", (&PasswordSource{Source: KeystorePasswordEnv, Env: "MY-PASSWORD"}).Validate())
+	assert.Equal(t, DefaultKeystorePasswordEnv, (&PasswordSource{Source: KeystorePasswordPrompt}).PasswordEnv())
+}
+
+func TestMirrorImage(t *testing.T) {
+	signer := &ManifestEntry{Image: "ghcr.io/hyperledger/firefly-signer", Tag: "v0.9.1"}
+	assert.Equal(t, "registry.internal/mirror/hyperledger/firefly-signer:v0.9.1", MirrorImage("registry.internal/mirror", signer.GetDockerImageString()))
+	signer.SHA = "abcd"
+	assert.Equal(t, "registry.internal/mirror/hyperledger/firefly-signer@sha256:abcd", MirrorImage("registry.internal/mirror", signer.GetDockerImageString()))
+	assert.Equal(t, "registry.internal:5000/ethereum/client-go:release-1.10", MirrorImage("registry.internal:5000/", "ethereum/client-go:release-1.10"))
+	assert.Equal(t, "registry.internal:5000/dev/signer:v1", MirrorImage("registry.internal:5000", "localhost:5000/dev/signer:v1"))
+	// Repositories with the same name in different organizations are not mirrored to the same name
+	assert.NotEqual(t, MirrorImage("registry.internal", "consensys/ethsigner"), MirrorImage("registry.internal", "ghcr.io/hyperledger/ethsigner"))
+	assert.Equal(t, "registry.internal/mirror/postgres", MirrorImage("registry.internal/mirror", "registry.internal/mirror/postgres"))
+	assert.Equal(t, "postgres", MirrorImage("", "postgres"))
+	assert.Equal(t, "", MirrorImage("registry.internal/mirror", ""))
+}
+
+func TestValidateRestartPolicy(t *testing.T) {
+	for _, policy := range []string{"", "sometimes", "on-failure:", "on-failure:0", "on-failure:-1", "unless-stopped:3"} {
+		assert.Regexp(t, "invalid restart policy", ValidateRestartPolicy(policy), policy)
+	}
+}
+
+func TestParseMountConfig(t *testing.T) {
+	mount, err := ParseMountConfig("ethsigner:/home/dev/keys:/keys:ro")
+	assert.NoError(t, err)
+	assert.Equal(t, &MountConfig{Service: "ethsigner", HostPath: "/home/dev/keys", ContainerPath: "/keys", ReadOnly: true}, mount)
+	assert.Equal(t, "/home/dev/keys:/keys:ro", mount.Volume())
+	mount, err = ParseMountConfig("geth:/home/dev/contracts:/contracts")
+	assert.NoError(t, err)
+	assert.Equal(t, "/home/dev/contracts:/contracts", mount.Volume())
+
+	// Windows host paths have a colon after the drive letter
+	mount, err = ParseMountConfig(`ethsigner:C:\Users\dev\keys:/keys:ro`)
+	assert.NoError(t, err)
+	assert.Equal(t, &MountConfig{Service: "ethsigner", HostPath: `C:\Users\dev\keys`, ContainerPath: "/keys", ReadOnly: true}, mount)
+	mount, err = ParseMountConfig("geth:D:/contracts:/contracts")
+	assert.NoError(t, err)
+	assert.Equal(t, &MountConfig{Service: "geth", HostPath: "D:/contracts", ContainerPath: "/contracts"}, mount)
+
+	testcases := map[string]string{
+		"ethsigner:/keys":                "must be in the form",
+		"ethsigner:/keys:/keys:ro:extra": "must be in the form",
+		"ethsigner:/keys:/keys:readonly": "the mode must be ro or rw",
+		":/keys:/keys":                   "the service, host path and container path are all required",
+		"ethsigner:/keys:keys":           "invalid mount target 'keys': must be an absolute path",
+		"ethsigner:/keys:/data":          "cannot mount over '/data' in service 'ethsigner'",
+		"ethsigner:/keys:/data/":         "cannot mount over '/data' in service 'ethsigner'",
+		"ethsigner:/config:/etc/firefly": "cannot mount over '/etc/firefly'",
+		"ethsigner:/root:/":              "cannot mount over '/'",
+	}
+	for spec, expected := range testcases {
+		_, err := ParseMountConfig(spec)
+		assert.Regexp(t, expected, err, spec)
+	}
+}